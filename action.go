@@ -0,0 +1,317 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsedano/goupnp/scpd"
+	"github.com/fsedano/goupnp/soap"
+)
+
+// scpdCache holds the SCPD of a ServiceClient's service once it has been
+// fetched.
+type scpdCache struct {
+	mu    sync.Mutex
+	entry *scpdEntry
+}
+
+type scpdEntry struct {
+	once sync.Once
+	scpd *scpd.SCPD
+	err  error
+	// fetcherDone is set if the fetch failed after the context of the caller
+	// that made it was done.
+	fetcherDone bool
+}
+
+// get returns the cached SCPD, fetching it for srv with httpClient if required.
+// Concurrent callers share a single fetch, made with the first caller's ctx.
+// Errors are not cached, so a later call tries again, and callers whose ctx is
+// still live try again themselves if the fetch failed because the first
+// caller's ctx was done.
+func (c *scpdCache) get(ctx context.Context, srv *Service, httpClient *http.Client) (*scpd.SCPD, error) {
+	c.mu.Lock()
+	e := c.entry
+	if e == nil {
+		e = new(scpdEntry)
+		c.entry = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		e.scpd, e.err = srv.requestSCPD(ctx, httpClient)
+		if e.err == nil {
+			e.scpd.Clean()
+		} else if ctx.Err() != nil {
+			e.fetcherDone = true
+		}
+	})
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entry == e {
+			c.entry = nil
+		}
+		c.mu.Unlock()
+		if e.fetcherDone && ctx.Err() == nil {
+			return c.get(ctx, srv, httpClient)
+		}
+	}
+	return e.scpd, e.err
+}
+
+func (c *scpdCache) reset() {
+	c.mu.Lock()
+	c.entry = nil
+	c.mu.Unlock()
+}
+
+// SCPDCtx returns the SCPD of the client's service. It is requested from the
+// device on first use, over the same Transport as SOAP requests, and cached
+// for later calls. ServiceClients that were not created by this package (e.g
+// as a struct literal) do not cache the SCPD.
+func (client *ServiceClient) SCPDCtx(ctx context.Context) (*scpd.SCPD, error) {
+	if client.scpd == nil {
+		return client.Service.requestSCPD(ctx, client.httpClient())
+	}
+	return client.scpd.get(ctx, client.Service, client.httpClient())
+}
+
+// httpClient returns the client to make requests other than SOAP requests
+// with, which uses the SOAP client's Transport, if it has one.
+func (client *ServiceClient) httpClient() *http.Client {
+	transport := client.SOAPClient.HTTPClient.Transport
+	if transport == nil {
+		return HTTPClientDefault
+	}
+	httpClient := *HTTPClientDefault
+	httpClient.Transport = transport
+	return &httpClient
+}
+
+// RefreshSCPDCtx discards any cached SCPD, and requests it again from the
+// device. This can be used if the device's firmware may have changed.
+func (client *ServiceClient) RefreshSCPDCtx(ctx context.Context) (*scpd.SCPD, error) {
+	if client.scpd != nil {
+		client.scpd.reset()
+	}
+	return client.SCPDCtx(ctx)
+}
+
+// SupportsAction reports whether the client's service has the named action,
+// according to its SCPD (see SCPDCtx). An error is only returned if the SCPD
+// cannot be requested.
+func (client *ServiceClient) SupportsAction(ctx context.Context, actionName string) (bool, error) {
+	s, err := client.SCPDCtx(ctx)
+	if err != nil {
+		return false, ctxErrorf(err, "requesting SCPD for %s", client.Service)
+	}
+	return s.GetAction(actionName) != nil, nil
+}
+
+// ArgumentError is returned by ValidateActionCtx and CallActionCtx for an
+// argument value that is not allowed by the argument's related state variable.
+// Err is the *scpd.ConstraintError naming the constraint.
+type ArgumentError struct {
+	Action   string
+	Argument string
+	Err      error
+}
+
+func (err *ArgumentError) Error() string {
+	return fmt.Sprintf("goupnp: argument %q of action %q: %v", err.Argument, err.Action, err.Err)
+}
+
+func (err *ArgumentError) Unwrap() error {
+	return err.Err
+}
+
+// ValidateActionCtx checks that the client's service supports the named
+// action, and that args has exactly the action's input arguments, according to
+// the service's SCPD. Each value is also checked against the allowed value
+// list and length limits of the argument's related state variable (see
+// scpd.StateVariable.CheckValue), and an *ArgumentError is returned for the
+// first value that is not allowed.
+func (client *ServiceClient) ValidateActionCtx(ctx context.Context, actionName string, args map[string]string) error {
+	_, err := client.validateAction(ctx, actionName, args)
+	return err
+}
+
+func (client *ServiceClient) validateAction(ctx context.Context, actionName string, args map[string]string) (*scpd.Action, error) {
+	s, err := client.SCPDCtx(ctx)
+	if err != nil {
+		return nil, ctxErrorf(err, "requesting SCPD for %s", client.Service)
+	}
+	action := s.GetAction(actionName)
+	if action == nil {
+		return nil, fmt.Errorf("goupnp: action %q is not supported by %s", actionName, client.Service)
+	}
+
+	var missing []string
+	known := make(map[string]bool)
+	for _, arg := range action.InputArguments() {
+		known[arg.Name] = true
+		if _, ok := args[arg.Name]; !ok {
+			missing = append(missing, arg.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("goupnp: action %q is missing arguments: %s",
+			actionName, strings.Join(missing, ", "))
+	}
+	var unknown []string
+	for name := range args {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("goupnp: action %q has unknown arguments: %s",
+			actionName, strings.Join(unknown, ", "))
+	}
+	for _, arg := range action.InputArguments() {
+		v := s.GetStateVariable(arg.RelatedStateVariable)
+		if v == nil {
+			continue
+		}
+		if err := v.CheckValue(args[arg.Name]); err != nil {
+			return nil, &ArgumentError{Action: actionName, Argument: arg.Name, Err: err}
+		}
+	}
+	return action, nil
+}
+
+// CallActionCtx performs the named action with the given input arguments,
+// which are validated as by ValidateActionCtx. The output arguments are
+// returned by name. This is useful for services that have no generated client.
+func (client *ServiceClient) CallActionCtx(ctx context.Context, actionName string, args map[string]string) (map[string]string, error) {
+	action, err := client.validateAction(ctx, actionName, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SOAP client encodes and decodes structs, so build struct types with a
+	// string field for each argument, in the order given by the SCPD.
+	inArgs := action.InputArguments()
+	in := reflect.New(argsStructType(inArgs, "soap")).Elem()
+	for i, arg := range inArgs {
+		in.Field(i).SetString(args[arg.Name])
+	}
+	outArgs := action.OutputArguments()
+	out := reflect.New(argsStructType(outArgs, "xml"))
+
+	if err := client.SOAPClient.PerformActionCtx(ctx, client.Service.ServiceType, actionName,
+		in.Addr().Interface(), out.Interface()); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]string, len(outArgs))
+	for i, arg := range outArgs {
+		results[arg.Name] = out.Elem().Field(i).String()
+	}
+	return results, nil
+}
+
+// DiscoverAndCall discovers services of type searchTarget, and performs the
+// named action with the given input arguments on the first one found, as by
+// CallActionCtx. This is for scripts and command line tools that call a single
+// action. An error matching ErrDeviceNotFound is returned if no device with the
+// service responded, and a SOAP fault from the action is returned as a
+// *soap.SOAPFaultError, for use with errors.As.
+func DiscoverAndCall(ctx context.Context, searchTarget, actionName string, args map[string]string, opts ...DiscoveryOption) (map[string]string, error) {
+	clients, errs, err := NewServiceClientsCtx(ctx, searchTarget, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		return nil, sentinelError{ErrDeviceNotFound, fmt.Errorf("no devices with %q responded", searchTarget)}
+	}
+	results, err := clients[0].CallActionCtx(ctx, actionName, args)
+	if err != nil {
+		return nil, ctxErrorf(err, "calling %q at %q", actionName, clients[0].Location)
+	}
+	return results, nil
+}
+
+// CallActionRaw sends reqXML, which must be a complete SOAP envelope, as the
+// named action of the client's service, and returns the raw response body.
+// This is an escape hatch for vendor services that CallActionCtx and the
+// generated clients cannot handle. A SOAP fault is returned as a
+// *soap.SOAPFaultError.
+func (client *ServiceClient) CallActionRaw(ctx context.Context, action string, reqXML []byte) ([]byte, error) {
+	return client.SOAPClient.PerformRawActionCtx(ctx, client.Service.ServiceType, action, reqXML)
+}
+
+// probeActionName is the action sent by ServiceClient.Probe. It is in the form
+// of a vendor extension, so that no device should implement it.
+const probeActionName = "X_GoUPnPProbe"
+
+// ErrServiceUnreachable is returned by ServiceClient.Probe if the service did
+// not respond with a SOAP response. It wraps the underlying error.
+var ErrServiceUnreachable = errors.New("goupnp: service is unreachable")
+
+// Probe checks that the client's control URL is reachable and speaks SOAP,
+// without side effects. It performs an action that does not exist, and any
+// SOAP response to it, including a fault, is treated as success. Otherwise the
+// returned error matches ErrServiceUnreachable with errors.Is.
+func (client *ServiceClient) Probe(ctx context.Context) error {
+	err := client.SOAPClient.PerformActionCtx(ctx, client.Service.ServiceType, probeActionName, nil, nil)
+	var fault *soap.SOAPFaultError
+	if err == nil || errors.As(err, &fault) {
+		return nil
+	}
+	return sentinelError{ErrServiceUnreachable, err}
+}
+
+// ControlNamespace is the namespace of the QueryStateVariable action, which
+// is implemented by the control service rather than by each service type.
+const ControlNamespace = "urn:schemas-upnp-org:control-1-0"
+
+// ErrQueryStateVariableRejected is returned by ServiceClient.QueryStateVariable
+// if the device responded with a SOAP fault, which is the case for most
+// devices, as the action is deprecated. It wraps the *soap.SOAPFaultError.
+var ErrQueryStateVariableRejected = errors.New("goupnp: QueryStateVariable rejected by device")
+
+// QueryStateVariable returns the current value of the named state variable of
+// the client's service, using the deprecated QueryStateVariable action. This
+// should only be used for old devices that do not provide another way to read
+// the variable.
+func (client *ServiceClient) QueryStateVariable(ctx context.Context, name string) (string, error) {
+	request := &struct {
+		VarName string `soap:"varName"`
+	}{name}
+	response := &struct {
+		Return string `xml:"return"`
+	}{}
+	err := client.SOAPClient.PerformActionCtx(ctx, ControlNamespace, "QueryStateVariable", request, response)
+	var fault *soap.SOAPFaultError
+	if errors.As(err, &fault) {
+		return "", sentinelError{ErrQueryStateVariableRejected, err}
+	} else if err != nil {
+		return "", err
+	}
+	return response.Return, nil
+}
+
+// argsStructType returns a struct type with a string field for each argument,
+// with the argument name in the given struct tag.
+func argsStructType(args []*scpd.Argument, tag string) reflect.Type {
+	fields := make([]reflect.StructField, len(args))
+	for i, arg := range args {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Arg%d", i),
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf("%s:%q", tag, arg.Name)),
+		}
+	}
+	return reflect.StructOf(fields)
+}
@@ -0,0 +1,455 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp/soap"
+)
+
+const testL3FSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<actionList>
+		<action>
+			<name>SetDefaultConnectionService</name>
+			<argumentList>
+				<argument>
+					<name>NewDefaultConnectionService</name>
+					<direction>in</direction>
+					<relatedStateVariable>DefaultConnectionService</relatedStateVariable>
+				</argument>
+			</argumentList>
+		</action>
+		<action>
+			<name>GetDefaultConnectionService</name>
+			<argumentList>
+				<argument>
+					<name>NewDefaultConnectionService</name>
+					<direction>out</direction>
+					<relatedStateVariable>DefaultConnectionService</relatedStateVariable>
+				</argument>
+			</argumentList>
+		</action>
+	</actionList>
+	<serviceStateTable>
+		<stateVariable sendEvents="yes">
+			<name>DefaultConnectionService</name>
+			<dataType>string</dataType>
+			<maxLength>256</maxLength>
+		</stateVariable>
+	</serviceStateTable>
+</scpd>`
+
+func TestServiceClientCallAction(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var scpdFetches int
+	var gotBodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/l3f.xml":
+			scpdFetches++
+			w.Write([]byte(testL3FSCPD))
+		case "/ctl/L3F":
+			body, _ := ioutil.ReadAll(r.Body)
+			gotBodies = append(gotBodies, string(body))
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:GetDefaultConnectionServiceResponse xmlns:u="urn:schemas-upnp-org:service:Layer3Forwarding:1">` +
+				`<NewDefaultConnectionService>uuid:1:WANIPConn1</NewDefaultConnectionService>` +
+				`</u:GetDefaultConnectionServiceResponse></s:Body></s:Envelope>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &clients[0]
+	ctx := context.Background()
+
+	out, err := client.CallActionCtx(ctx, "GetDefaultConnectionService", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "uuid:1:WANIPConn1", out["NewDefaultConnectionService"]; want != got {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+
+	if _, err := client.CallActionCtx(ctx, "SetDefaultConnectionService", map[string]string{
+		"NewDefaultConnectionService": "uuid:1:WANPPPConn1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		name   string
+		action string
+		args   map[string]string
+	}{
+		{"unknown action", "Reboot", nil},
+		{"missing arg", "SetDefaultConnectionService", nil},
+		{"unknown arg", "GetDefaultConnectionService", map[string]string{"Foo": "bar"}},
+		{"too long", "SetDefaultConnectionService", map[string]string{
+			"NewDefaultConnectionService": strings.Repeat("x", 257),
+		}},
+	}
+	for _, test := range invalid {
+		if err := client.ValidateActionCtx(ctx, test.action, test.args); err == nil {
+			t.Errorf("%s: want error, got nil", test.name)
+		}
+	}
+	_, err = client.CallActionCtx(ctx, "SetDefaultConnectionService", map[string]string{
+		"NewDefaultConnectionService": strings.Repeat("x", 257),
+	})
+	var argErr *ArgumentError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("want *ArgumentError, got %v", err)
+	}
+	if argErr.Argument != "NewDefaultConnectionService" || !strings.Contains(err.Error(), "maxLength 256") {
+		t.Errorf("want error naming argument and maxLength 256, got %v", err)
+	}
+
+	// The cached SCPD is used, so this makes no further requests.
+	for action, want := range map[string]bool{"GetDefaultConnectionService": true, "Reboot": false} {
+		got, err := client.SupportsAction(ctx, action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: want supported %t, got %t", action, want, got)
+		}
+	}
+
+	if _, err := client.RefreshSCPDCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if scpdFetches != 2 {
+		t.Errorf("want SCPD fetched once, and once more on refresh, got %d fetches", scpdFetches)
+	}
+	if len(gotBodies) != 2 {
+		t.Fatalf("want 2 SOAP requests, got %d", len(gotBodies))
+	}
+	if want := "<NewDefaultConnectionService>uuid:1:WANPPPConn1</NewDefaultConnectionService>"; !strings.Contains(gotBodies[1], want) {
+		t.Errorf("want request body containing %q, got %q", want, gotBodies[1])
+	}
+}
+
+func TestServiceClientSCPDCallerCancelled(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/l3f.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			// Hold the first fetch until its caller gives up.
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(testL3FSCPD))
+	}))
+	defer srv.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &clients[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelledErr := make(chan error, 1)
+	go func() {
+		_, err := client.SupportsAction(ctx, "GetDefaultConnectionService")
+		cancelledErr <- err
+	}()
+	<-started
+	liveErr := make(chan error, 1)
+	go func() {
+		_, err := client.SupportsAction(context.Background(), "GetDefaultConnectionService")
+		liveErr <- err
+	}()
+	// Give the second caller time to wait on the first caller's fetch.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled for the cancelled caller, got %v", err)
+	}
+	if err := <-liveErr; err != nil {
+		t.Errorf("want no error for the live caller, got %v", err)
+	}
+}
+
+func TestDiscoverAndCall(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			w.Write([]byte(testDeviceXML))
+		case "/l3f.xml":
+			w.Write([]byte(testL3FSCPD))
+		case "/ctl/L3F":
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:GetDefaultConnectionServiceResponse xmlns:u="urn:schemas-upnp-org:service:Layer3Forwarding:1">` +
+				`<NewDefaultConnectionService>uuid:1:WANIPConn1</NewDefaultConnectionService>` +
+				`</u:GetDefaultConnectionServiceResponse></s:Body></s:Envelope>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:schemas-upnp-org:service:Layer3Forwarding:1"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 5), Port: 19005}
+	startTestResponder(t, group, st, srv.URL+"/rootDesc.xml")
+	ctx := context.Background()
+
+	out, err := DiscoverAndCall(ctx, st, "GetDefaultConnectionService", nil, WithMulticastGroup(group.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "uuid:1:WANIPConn1", out["NewDefaultConnectionService"]; want != got {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+
+	_, err = DiscoverAndCall(ctx, st, "NoSuchAction", nil, WithMulticastGroup(group.String()))
+	if err == nil || !strings.Contains(err.Error(), "NoSuchAction") {
+		t.Errorf("want error for unknown action, got %v", err)
+	}
+
+	_, err = DiscoverAndCall(ctx, "urn:goupnp-test:service:Missing:1", "GetDefaultConnectionService", nil,
+		WithMulticastGroup(group.String()))
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("want ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestServiceClientSupportsActionError(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients[0].SupportsAction(context.Background(), "GetDefaultConnectionService"); err == nil {
+		t.Error("want error when the SCPD cannot be requested, got nil")
+	}
+}
+
+func TestServiceClientProbe(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ctl/L3F":
+			if want, got := `"urn:schemas-upnp-org:service:Layer3Forwarding:1#X_GoUPnPProbe"`, r.Header.Get("SOAPACTION"); want != got {
+				t.Errorf("want SOAPACTION %s, got %s", want, got)
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>` +
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>` +
+				`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>401</errorCode>` +
+				`<errorDescription>Invalid Action</errorDescription></UPnPError></detail>` +
+				`</s:Fault></s:Body></s:Envelope>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	newClient := func(path string) *ServiceClient {
+		loc, err := url.Parse(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients, err := NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &clients[0]
+	}
+	ctx := context.Background()
+
+	if err := newClient("/rootDesc.xml").Probe(ctx); err != nil {
+		t.Errorf("want success for SOAP fault, got %v", err)
+	}
+
+	// A control URL that is not served gives an HTTP 404 without a SOAP body.
+	client := newClient("/rootDesc.xml")
+	client.SOAPClient.EndpointURL.Path = "/missing"
+	if err := client.Probe(ctx); !errors.Is(err, ErrServiceUnreachable) {
+		t.Errorf("want ErrServiceUnreachable for HTTP 404, got %v", err)
+	}
+
+	srv.Close()
+	if err := newClient("/rootDesc.xml").Probe(ctx); !errors.Is(err, ErrServiceUnreachable) {
+		t.Errorf("want ErrServiceUnreachable for closed server, got %v", err)
+	}
+}
+
+func TestServiceClientQueryStateVariable(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := `"urn:schemas-upnp-org:control-1-0#QueryStateVariable"`, r.Header.Get("SOAPACTION"); want != got {
+			t.Errorf("want SOAPACTION %s, got %s", want, got)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "<varName>DefaultConnectionService</varName>"):
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:QueryStateVariableResponse xmlns:u="urn:schemas-upnp-org:control-1-0">` +
+				`<return>uuid:1:WANIPConn1</return>` +
+				`</u:QueryStateVariableResponse></s:Body></s:Envelope>`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>` +
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>` +
+				`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>404</errorCode>` +
+				`<errorDescription>Invalid Var</errorDescription></UPnPError></detail>` +
+				`</s:Fault></s:Body></s:Envelope>`))
+		}
+	}))
+	defer srv.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	got, err := clients[0].QueryStateVariable(ctx, "DefaultConnectionService")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "uuid:1:WANIPConn1"; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	_, err = clients[0].QueryStateVariable(ctx, "Unknown")
+	if !errors.Is(err, ErrQueryStateVariableRejected) {
+		t.Errorf("want ErrQueryStateVariableRejected, got %v", err)
+	}
+	var fault *soap.SOAPFaultError
+	if !errors.As(err, &fault) {
+		t.Errorf("want error wrapping *soap.SOAPFaultError, got %v", err)
+	}
+}
+
+func TestServiceClientDialContext(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			w.Write([]byte(testDeviceXML))
+		case "/l3f.xml":
+			w.Write([]byte(testL3FSCPD))
+		case "/ctl/L3F":
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:GetDefaultConnectionServiceResponse xmlns:u="urn:schemas-upnp-org:service:Layer3Forwarding:1">` +
+				`<NewDefaultConnectionService>uuid:1:WANIPConn1</NewDefaultConnectionService>` +
+				`</u:GetDefaultConnectionServiceResponse></s:Body></s:Envelope>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// The device's host does not resolve, so requests only succeed if they are
+	// dialed by dial, which connects to the test server instead.
+	var mu sync.Mutex
+	var dialed []string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	o := newDiscoveryOptions([]DiscoveryOption{WithDialContext(dial)})
+	ctx := context.Background()
+
+	loc, err := url.Parse("http://device.invalid:49152/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := deviceByURL(ctx, o, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := newServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:Layer3Forwarding:1", nil, o.soapOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients[0].CallActionCtx(ctx, "GetDefaultConnectionService", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) == 0 {
+		t.Fatal("want requests dialed by dial, got none")
+	}
+	for _, addr := range dialed {
+		if want := "device.invalid:49152"; addr != want {
+			t.Errorf("want dial to %s, got %s", want, addr)
+		}
+	}
+}
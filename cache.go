@@ -0,0 +1,193 @@
+package goupnp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxAge is how long a DiscoveryCache keeps a device that did not
+// advertise a max-age. This is the minimum recommended by the UPnP Device
+// Architecture.
+const DefaultCacheMaxAge = 1800 * time.Second
+
+// DiscoveryCache holds the results of discovery, so that they can be reused
+// without searching the network again, including by later runs of a program
+// via SaveCache and LoadCache. Each search target holds the devices found for
+// it, keyed by USN, until their max-age expires.
+//
+// A DiscoveryCache is safe for concurrent use. See WithCache to use a cache
+// with DiscoverDevicesCtx.
+type DiscoveryCache struct {
+	mu      sync.Mutex
+	targets map[string]map[string]cachedDevice
+}
+
+// cachedDevice is the on-disk form of a MaybeRootDevice that was successfully
+// probed. Root is kept encoded, so that each Get decodes its own copy, which
+// callers can use and modify without affecting the cache or each other.
+type cachedDevice struct {
+	USN        string          `json:"usn"`
+	Location   string          `json:"location"`
+	LocalAddr  net.IP          `json:"localAddr,omitempty"`
+	SearchPort int             `json:"searchPort,omitempty"`
+	Root       json.RawMessage `json:"root"`
+	Expires    time.Time       `json:"expires"`
+}
+
+// cacheFile is the format of a file written by SaveCache.
+type cacheFile struct {
+	Targets map[string][]cachedDevice `json:"targets"`
+}
+
+// NewDiscoveryCache creates an empty DiscoveryCache.
+func NewDiscoveryCache() *DiscoveryCache {
+	return &DiscoveryCache{targets: make(map[string]map[string]cachedDevice)}
+}
+
+// LoadCache reads a DiscoveryCache from the file at path, as written by
+// SaveCache. An empty cache is returned if the file does not exist. Expired
+// devices are dropped.
+func LoadCache(path string) (*DiscoveryCache, error) {
+	c := NewDiscoveryCache()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, ctxErrorf(err, "reading discovery cache %q", path)
+	}
+
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, ctxErrorf(err, "decoding discovery cache %q", path)
+	}
+	now := time.Now()
+	for st, devices := range f.Targets {
+		for _, d := range devices {
+			if len(d.Root) == 0 || string(d.Root) == "null" || !now.Before(d.Expires) {
+				continue
+			}
+			c.put(st, d)
+		}
+	}
+	return c, nil
+}
+
+// SaveCache writes the unexpired devices in c to the file at path. The file is
+// written to a temporary file and renamed into place, so concurrent readers
+// never see a partially written file, and concurrent writers do not corrupt
+// it (the last to finish wins).
+func (c *DiscoveryCache) SaveCache(path string) error {
+	f := cacheFile{Targets: make(map[string][]cachedDevice)}
+	now := time.Now()
+	c.mu.Lock()
+	for st, devices := range c.targets {
+		for _, d := range devices {
+			if now.Before(d.Expires) {
+				f.Targets[st] = append(f.Targets[st], d)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(&f)
+	if err != nil {
+		return ctxError(err, "encoding discovery cache")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return ctxErrorf(err, "writing discovery cache %q", path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return ctxErrorf(err, "writing discovery cache %q", path)
+	}
+	if err := tmp.Close(); err != nil {
+		return ctxErrorf(err, "writing discovery cache %q", path)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return ctxErrorf(err, "writing discovery cache %q", path)
+	}
+	return nil
+}
+
+// Get returns the unexpired devices cached for searchTarget, or nil if there
+// are none. Each call returns new copies of the RootDevices.
+func (c *DiscoveryCache) Get(searchTarget string) []MaybeRootDevice {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var results []MaybeRootDevice
+	for usn, d := range c.targets[searchTarget] {
+		if !now.Before(d.Expires) {
+			delete(c.targets[searchTarget], usn)
+			continue
+		}
+		loc, err := url.Parse(d.Location)
+		if err != nil {
+			continue
+		}
+		root := new(RootDevice)
+		if err := json.Unmarshal(d.Root, root); err != nil {
+			continue
+		}
+		if err := root.Rehydrate(loc); err != nil {
+			continue
+		}
+		results = append(results, MaybeRootDevice{
+			USN:        d.USN,
+			Root:       root,
+			Location:   loc,
+			LocalAddr:  d.LocalAddr,
+			SearchPort: d.SearchPort,
+			MaxAge:     d.Expires.Sub(now),
+		})
+	}
+	return results
+}
+
+// Put adds the successfully probed devices in results to the cache for
+// searchTarget, replacing any cached devices with the same USN. Each expires
+// after its MaxAge, or DefaultCacheMaxAge if it has none. A copy of each
+// RootDevice is cached, so later changes to results do not affect the cache.
+func (c *DiscoveryCache) Put(searchTarget string, results []MaybeRootDevice) {
+	now := time.Now()
+	for _, maybe := range results {
+		if maybe.Err != nil || maybe.Root == nil || maybe.Location == nil {
+			continue
+		}
+		root, err := json.Marshal(maybe.Root)
+		if err != nil {
+			continue
+		}
+		maxAge := maybe.MaxAge
+		if maxAge <= 0 {
+			maxAge = DefaultCacheMaxAge
+		}
+		c.put(searchTarget, cachedDevice{
+			USN:        maybe.USN,
+			Location:   maybe.Location.String(),
+			LocalAddr:  maybe.LocalAddr,
+			SearchPort: maybe.SearchPort,
+			Root:       root,
+			Expires:    now.Add(maxAge),
+		})
+	}
+}
+
+func (c *DiscoveryCache) put(searchTarget string, d cachedDevice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	devices, ok := c.targets[searchTarget]
+	if !ok {
+		devices = make(map[string]cachedDevice)
+		c.targets[searchTarget] = devices
+	}
+	devices[d.USN] = d
+}
@@ -0,0 +1,135 @@
+package goupnp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Parallel()
+	const st = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []MaybeRootDevice{
+		{USN: "uuid:fresh::" + st, Root: testRootDevice(t), Location: loc, LocalAddr: net.ParseIP("192.168.1.10"), SearchPort: 1900, MaxAge: time.Hour},
+		{USN: "uuid:expired::" + st, Root: testRootDevice(t), Location: loc, MaxAge: time.Nanosecond},
+		{USN: "uuid:failed::" + st, Location: loc, Err: ErrProbeFailed},
+	}
+	c := NewDiscoveryCache()
+	c.Put(st, results)
+	time.Sleep(time.Millisecond)
+
+	path := filepath.Join(tempDir(t), "cache.json")
+	if err := c.SaveCache(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := loaded.Get(st)
+	if len(got) != 1 {
+		t.Fatalf("want 1 cached device, got %d: %+v", len(got), got)
+	}
+	want := results[0]
+	if got[0].USN != want.USN || got[0].Location.String() != want.Location.String() ||
+		!got[0].LocalAddr.Equal(want.LocalAddr) || got[0].SearchPort != want.SearchPort {
+		t.Errorf("want %+v, got %+v", want, got[0])
+	}
+	if got[0].MaxAge <= 0 || got[0].MaxAge > time.Hour {
+		t.Errorf("want remaining MaxAge in (0, 1h], got %v", got[0].MaxAge)
+	}
+	wantRoot := testRootDevice(t)
+	wantRoot.XMLName = got[0].Root.XMLName
+	if !reflect.DeepEqual(wantRoot, got[0].Root) {
+		t.Errorf("Bad cached root device\nwant: %+v\n got: %+v", wantRoot, got[0].Root)
+	}
+	// Each Get returns its own copy, which can be changed without affecting
+	// the cache.
+	got[0].Root.Device.FriendlyName = "changed"
+	got[0].Root.SetURLBase(&url.URL{Scheme: "http", Host: "192.0.2.1"})
+	again := loaded.Get(st)
+	if len(again) != 1 || again[0].Root == got[0].Root || !reflect.DeepEqual(wantRoot, again[0].Root) {
+		t.Errorf("want unchanged copy of cached root device, got %+v", again)
+	}
+	if other := loaded.Get("ssdp:all"); other != nil {
+		t.Errorf("want no devices for other search target, got %+v", other)
+	}
+
+	// A cache hit does not search the network.
+	devices, err := DiscoverDevicesCtx(context.Background(), st, WithCache(loaded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].USN != want.USN {
+		t.Errorf("want cached device from DiscoverDevicesCtx, got %+v", devices)
+	}
+
+	// Options are checked even when the cache would be used.
+	if _, err := DiscoverDevicesCtx(context.Background(), st, WithCache(loaded),
+		WithSourcePort(1900), WithUnicastTargets("192.0.2.1")); err == nil {
+		t.Error("want error for WithSourcePort with WithUnicastTargets, got nil")
+	}
+}
+
+func TestDiscoveryCacheConcurrentGet(t *testing.T) {
+	t.Parallel()
+	const st = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	loc, err := url.Parse("http://[fe80::1%25eth0]:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewDiscoveryCache()
+	c.Put(st, []MaybeRootDevice{{USN: "uuid:1::" + st, Root: testRootDevice(t), Location: loc}})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				got := c.Get(st)
+				if len(got) != 1 {
+					t.Errorf("want 1 cached device, got %d", len(got))
+					return
+				}
+				// Run with -race to check that callers do not share the
+				// RootDevice that Get rehydrates.
+				_ = got[0].Root.URLBase.String()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLoadCacheMissing(t *testing.T) {
+	t.Parallel()
+	c, err := LoadCache(filepath.Join(tempDir(t), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Get("ssdp:all"); got != nil {
+		t.Errorf("want empty cache, got %+v", got)
+	}
+}
+
+// tempDir creates a temporary directory that is removed when the test ends.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "goupnp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
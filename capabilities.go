@@ -0,0 +1,102 @@
+package goupnp
+
+import (
+	"context"
+	"sync"
+)
+
+// maxConcurrentSCPDRequests limits how many SCPDs are requested from a device
+// at once by Capabilities, as embedded web servers often handle few
+// connections.
+const maxConcurrentSCPDRequests = 4
+
+// ServiceCapabilities are the actions supported by a service, according to its
+// SCPD.
+type ServiceCapabilities struct {
+	// Device is the (root or embedded) device that has the service.
+	Device  *Device
+	Service *Service
+	// Actions are the names of the supported actions, sorted.
+	Actions []string
+	// Err is the error requesting the service's SCPD, if any, in which case
+	// Actions is empty.
+	Err error
+}
+
+// DeviceCapabilities summarises the actions supported by all of the services
+// of a root device and its embedded devices.
+type DeviceCapabilities struct {
+	// Services are in the order that Device.VisitServices visits them.
+	Services []ServiceCapabilities
+}
+
+// Supports reports whether any service of the given type supports the action.
+func (caps DeviceCapabilities) Supports(serviceType, action string) bool {
+	for _, sc := range caps.Services {
+		if sc.Service.ServiceType != serviceType {
+			continue
+		}
+		for _, a := range sc.Actions {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Errors returns the errors requesting SCPDs for the services whose
+// capabilities are unknown.
+func (caps DeviceCapabilities) Errors() []error {
+	var errs []error
+	for _, sc := range caps.Services {
+		if sc.Err != nil {
+			errs = append(errs, sc.Err)
+		}
+	}
+	return errs
+}
+
+// Capabilities requests the SCPDs of all of the services of the root device
+// and its embedded devices, several at a time, and summarises the actions
+// that they support. A service whose SCPD cannot be requested has its Err
+// set, and does not fail the whole summary; an error is only returned if ctx
+// is done before all of the SCPDs were requested.
+func (root *RootDevice) Capabilities(ctx context.Context) (DeviceCapabilities, error) {
+	var caps DeviceCapabilities
+	root.Device.VisitDevices(func(d *Device) {
+		for i := range d.Services {
+			caps.Services = append(caps.Services, ServiceCapabilities{Device: d, Service: &d.Services[i]})
+		}
+	})
+
+	sem := make(chan struct{}, maxConcurrentSCPDRequests)
+	var wg sync.WaitGroup
+	for i := range caps.Services {
+		sc := &caps.Services[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				sc.Err = ctx.Err()
+				return
+			}
+			s, err := sc.Service.RequestSCPDCtx(ctx)
+			if err != nil {
+				sc.Err = ctxErrorf(err, "requesting SCPD for %v", sc.Service)
+				return
+			}
+			for _, action := range s.OrderedActions() {
+				sc.Actions = append(sc.Actions, action.Name)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return caps, ctxError(err, "requesting device capabilities")
+	}
+	return caps, nil
+}
@@ -0,0 +1,61 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/l3f.xml" {
+			w.Write([]byte(testL3FSCPD))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps, err := root.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caps.Services) != 2 {
+		t.Fatalf("want 2 services, got %d", len(caps.Services))
+	}
+	l3f, ipConn := caps.Services[0], caps.Services[1]
+	if want := []string{"GetDefaultConnectionService", "SetDefaultConnectionService"}; l3f.Err != nil || !reflect.DeepEqual(want, l3f.Actions) {
+		t.Errorf("want Layer3Forwarding actions %v, got %v (err=%v)", want, l3f.Actions, l3f.Err)
+	}
+	if ipConn.Err == nil || ipConn.Device.UDN != "uuid:11111111-2222-3333-4444-666666666666" {
+		t.Errorf("want WANIPConnection of the WAN device with an error, got %+v", ipConn)
+	}
+	if errs := caps.Errors(); len(errs) != 1 {
+		t.Errorf("want 1 error, got %v", errs)
+	}
+	if !caps.Supports("urn:schemas-upnp-org:service:Layer3Forwarding:1", "GetDefaultConnectionService") {
+		t.Error("want GetDefaultConnectionService supported")
+	}
+	if caps.Supports("urn:schemas-upnp-org:service:WANIPConnection:1", "GetExternalIPAddress") {
+		t.Error("want no actions supported for a service without an SCPD")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := root.Capabilities(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
@@ -4,9 +4,12 @@ package goupnp
 
 import (
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
 
@@ -21,12 +24,92 @@ const (
 // RootDevice is the device description as described by section 2.3 "Device
 // description" in
 // http://upnp.org/specs/arch/UPnP-arch-DeviceArchitecture-v1.1.pdf
+//
+// RootDevice can also be encoded to and decoded from JSON, for example to cache
+// discovery results. Decoding from JSON restores URLBase, and so the resolved
+// URLs of the contained devices and services.
 type RootDevice struct {
-	XMLName     xml.Name    `xml:"root"`
-	SpecVersion SpecVersion `xml:"specVersion"`
-	URLBase     url.URL     `xml:"-"`
-	URLBaseStr  string      `xml:"URLBase"`
-	Device      Device      `xml:"device"`
+	XMLName     xml.Name    `xml:"root" json:"-"`
+	SpecVersion SpecVersion `xml:"specVersion" json:"specVersion"`
+	URLBase     url.URL     `xml:"-" json:"-"`
+	URLBaseStr  string      `xml:"URLBase" json:"URLBase,omitempty"`
+	Device      Device      `xml:"device" json:"device"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler, and defaults SpecVersion to 1.0 if
+// the description has none.
+func (root *RootDevice) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// Use a distinct type to avoid recursing into this method.
+	type rootDevice RootDevice
+	if err := d.DecodeElement((*rootDevice)(root), &start); err != nil {
+		return err
+	}
+	if root.SpecVersion == (SpecVersion{}) {
+		root.SpecVersion = SpecVersion{Major: 1, Minor: 0}
+	}
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, and restores URLBase from the
+// decoded URLBaseStr.
+func (root *RootDevice) UnmarshalJSON(data []byte) error {
+	// Use a distinct type to avoid recursing into this method.
+	type rootDevice RootDevice
+	if err := json.Unmarshal(data, (*rootDevice)(root)); err != nil {
+		return err
+	}
+	if root.URLBaseStr == "" {
+		return nil
+	}
+	return root.Rehydrate(nil)
+}
+
+// Rehydrate restores URLBase, and with it the resolved URLs of all the
+// devices, services and icons within the RootDevice. URLBaseStr is used if
+// set, otherwise loc (the location that the device description was fetched
+// from) is used. Calling Rehydrate more than once is harmless.
+//
+// This must be called after decoding a RootDevice by any means other than
+// DeviceByURLCtx or json.Unmarshal (which do so themselves), for example from
+// a cache, before using any of its URLs.
+func (root *RootDevice) Rehydrate(loc *url.URL) error {
+	if root.URLBaseStr == "" {
+		if loc == nil {
+			return errors.New("goupnp: no URLBase or location to rehydrate RootDevice from")
+		}
+		root.SetURLBase(loc)
+		return nil
+	}
+	urlBase, err := url.Parse(root.URLBaseStr)
+	if err != nil {
+		return fmt.Errorf("goupnp: error parsing URLBase %q: %v", root.URLBaseStr, err)
+	}
+	if loc != nil {
+		addLocationZone(urlBase, loc)
+	}
+	root.SetURLBase(urlBase)
+	return nil
+}
+
+// addLocationZone gives the host of urlBase the IPv6 zone of the host of loc,
+// if urlBase is a link-local address without a zone. Devices do not know the
+// zone that they are reached through, so a URLBase naming a link-local address
+// could not otherwise be dialed.
+func addLocationZone(urlBase, loc *url.URL) {
+	i := strings.LastIndex(loc.Hostname(), "%")
+	if i < 0 || strings.Contains(urlBase.Hostname(), "%") {
+		return
+	}
+	ip := net.ParseIP(urlBase.Hostname())
+	if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return
+	}
+	host := ip.String() + loc.Hostname()[i:]
+	if port := urlBase.Port(); port != "" {
+		urlBase.Host = net.JoinHostPort(host, port)
+	} else {
+		urlBase.Host = "[" + host + "]"
+	}
 }
 
 // SetURLBase sets the URLBase for the RootDevice and its underlying components.
@@ -36,33 +119,135 @@ func (root *RootDevice) SetURLBase(urlBase *url.URL) {
 	root.Device.SetURLBase(urlBase)
 }
 
+// ResolveURL resolves ref (for example a control URL path) against the
+// URLBase of the RootDevice, in the same way as the URLs within the device
+// description are resolved. Absolute references are returned as-is.
+func (root *RootDevice) ResolveURL(ref string) (*url.URL, error) {
+	u, err := resolveURL(&root.URLBase, ref)
+	if err != nil {
+		return nil, fmt.Errorf("goupnp: error resolving URL %q: %v", ref, err)
+	}
+	return u, nil
+}
+
 // SpecVersion is part of a RootDevice, describes the version of the
 // specification that the data adheres to.
 type SpecVersion struct {
-	Major int32 `xml:"major"`
-	Minor int32 `xml:"minor"`
+	Major int32 `xml:"major" json:"major"`
+	Minor int32 `xml:"minor" json:"minor"`
+}
+
+// AtLeast returns true if the version is major.minor or later, e.g. to check
+// for UPnP 1.1 features such as the SEARCHPORT header.
+func (v SpecVersion) AtLeast(major, minor int32) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
 }
 
 // Device is a UPnP device. It can have child devices.
 type Device struct {
-	DeviceType       string    `xml:"deviceType"`
-	FriendlyName     string    `xml:"friendlyName"`
-	Manufacturer     string    `xml:"manufacturer"`
-	ManufacturerURL  URLField  `xml:"manufacturerURL"`
-	ModelDescription string    `xml:"modelDescription"`
-	ModelName        string    `xml:"modelName"`
-	ModelNumber      string    `xml:"modelNumber"`
-	ModelType        string    `xml:"modelType"`
-	ModelURL         URLField  `xml:"modelURL"`
-	SerialNumber     string    `xml:"serialNumber"`
-	UDN              string    `xml:"UDN"`
-	UPC              string    `xml:"UPC,omitempty"`
-	Icons            []Icon    `xml:"iconList>icon,omitempty"`
-	Services         []Service `xml:"serviceList>service,omitempty"`
-	Devices          []Device  `xml:"deviceList>device,omitempty"`
+	DeviceType       string    `xml:"deviceType" json:"deviceType"`
+	FriendlyName     string    `xml:"friendlyName" json:"friendlyName"`
+	Manufacturer     string    `xml:"manufacturer" json:"manufacturer"`
+	ManufacturerURL  URLField  `xml:"manufacturerURL" json:"manufacturerURL"`
+	ModelDescription string    `xml:"modelDescription" json:"modelDescription"`
+	ModelName        string    `xml:"modelName" json:"modelName"`
+	ModelNumber      string    `xml:"modelNumber" json:"modelNumber"`
+	ModelType        string    `xml:"modelType" json:"modelType"`
+	ModelURL         URLField  `xml:"modelURL" json:"modelURL"`
+	SerialNumber     string    `xml:"serialNumber" json:"serialNumber"`
+	UDN              string    `xml:"UDN" json:"UDN"`
+	UPC              string    `xml:"UPC,omitempty" json:"UPC,omitempty"`
+	Icons            []Icon    `xml:"iconList>icon,omitempty" json:"icons,omitempty"`
+	Services         []Service `xml:"serviceList>service,omitempty" json:"services,omitempty"`
+	Devices          []Device  `xml:"deviceList>device,omitempty" json:"devices,omitempty"`
 
 	// Extra observed elements:
-	PresentationURL URLField `xml:"presentationURL"`
+	PresentationURL URLField `xml:"presentationURL" json:"presentationURL"`
+
+	// Extra holds elements of the device that are not otherwise decoded, such
+	// as vendor extensions, so that they are kept when the description is
+	// encoded again, e.g by a proxy or cache.
+	Extra []ExtraElement `xml:",any" json:"extra,omitempty"`
+}
+
+// ExtraElement is an element of a device description that is not otherwise
+// decoded. The content of the element is kept as raw XML, so namespace
+// prefixes used within it are only valid when encoded again if they are
+// declared by the element itself.
+type ExtraElement struct {
+	XMLName xml.Name `json:"name"`
+	// Attrs are the attributes of the element, including declarations of
+	// namespace prefixes, which are kept in the form {Local: "xmlns:prefix"}
+	// so that they are encoded as they were.
+	Attrs    []xml.Attr `xml:",any,attr" json:"attrs,omitempty"`
+	InnerXML string     `xml:",innerxml" json:"innerXML,omitempty"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler, keeping namespace declarations so
+// that they survive encoding the element again. The default namespace
+// declaration is dropped, as it is given by XMLName.
+func (el *ExtraElement) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// Use a distinct type to avoid recursing into this method.
+	type extraElement ExtraElement
+	if err := d.DecodeElement((*extraElement)(el), &start); err != nil {
+		return err
+	}
+	attrs := el.Attrs[:0]
+	for _, attr := range el.Attrs {
+		switch {
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			continue
+		case attr.Name.Space == "xmlns":
+			attr.Name = xml.Name{Local: "xmlns:" + attr.Name.Local}
+		}
+		attrs = append(attrs, attr)
+	}
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+	el.Attrs = attrs
+	return nil
+}
+
+// UnmarshalXML implements xml.Unmarshaler. The lists of icons, services and
+// embedded devices are decoded as pointers, and then copied once into slices
+// of the right size. Decoding into the slices directly grows them by append,
+// and each growth copies the (large) elements decoded so far, whereas only the
+// pointers are copied this way. This reduces the memory allocated for
+// descriptions with many devices.
+func (device *Device) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// Use a distinct type to avoid recursing into this method. The fields
+	// below take precedence over those of the same name in it.
+	type deviceFields Device
+	var v struct {
+		*deviceFields
+		Icons    []*Icon    `xml:"iconList>icon,omitempty"`
+		Services []*Service `xml:"serviceList>service,omitempty"`
+		Devices  []*Device  `xml:"deviceList>device,omitempty"`
+	}
+	v.deviceFields = (*deviceFields)(device)
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	if len(v.Icons) > 0 {
+		device.Icons = make([]Icon, len(v.Icons))
+		for i, icon := range v.Icons {
+			device.Icons[i] = *icon
+		}
+	}
+	if len(v.Services) > 0 {
+		device.Services = make([]Service, len(v.Services))
+		for i, srv := range v.Services {
+			device.Services[i] = *srv
+		}
+	}
+	if len(v.Devices) > 0 {
+		device.Devices = make([]Device, len(v.Devices))
+		for i, child := range v.Devices {
+			device.Devices[i] = *child
+		}
+	}
+	return nil
 }
 
 // VisitDevices calls visitor for the device, and all its descendent devices.
@@ -84,17 +269,57 @@ func (device *Device) VisitServices(visitor func(*Service)) {
 }
 
 // FindService finds all (if any) Services under the device and its descendents
-// that have the given ServiceType.
+// that have the given ServiceType. Embedded devices are searched at any depth,
+// with the device's own services first; use FindServiceDepth to limit this.
 func (device *Device) FindService(serviceType string) []*Service {
+	return device.FindServiceDepth(serviceType, -1)
+}
+
+// FindServiceDepth is like FindService, but only searches embedded devices
+// down to maxDepth levels below the device. A maxDepth of 0 only finds the
+// device's own services, and a negative maxDepth searches all descendents.
+// This avoids matching a service of an embedded device that the caller did not
+// expect, and so calling the wrong endpoint.
+func (device *Device) FindServiceDepth(serviceType string, maxDepth int) []*Service {
 	var services []*Service
-	device.VisitServices(func(s *Service) {
-		if s.ServiceType == serviceType {
-			services = append(services, s)
+	device.visitDevicesDepth(maxDepth, func(d *Device) {
+		for i := range d.Services {
+			if d.Services[i].ServiceType == serviceType {
+				services = append(services, &d.Services[i])
+			}
 		}
 	})
 	return services
 }
 
+// visitDevicesDepth calls visitor for the device, and its descendent devices
+// down to maxDepth levels below it, or all of them if maxDepth is negative.
+func (device *Device) visitDevicesDepth(maxDepth int, visitor func(*Device)) {
+	visitor(device)
+	if maxDepth == 0 {
+		return
+	}
+	for i := range device.Devices {
+		device.Devices[i].visitDevicesDepth(maxDepth-1, visitor)
+	}
+}
+
+// FindServiceByID finds the Service under the device and its descendents that
+// has the given ServiceId, or nil if there is none. This distinguishes between
+// several instances of the same service type, such as the WANIPConnection
+// services of a multi-WAN router. Service IDs are only required to be unique
+// within a device, so if several embedded devices use the same ID, the first
+// found (visiting the device before its descendents) is returned.
+func (device *Device) FindServiceByID(serviceId string) *Service {
+	var found *Service
+	device.VisitServices(func(s *Service) {
+		if found == nil && s.ServiceId == serviceId {
+			found = s
+		}
+	})
+	return found
+}
+
 // SetURLBase sets the URLBase for the Device and its underlying components.
 func (device *Device) SetURLBase(urlBase *url.URL) {
 	device.ManufacturerURL.SetURLBase(urlBase)
@@ -118,11 +343,11 @@ func (device *Device) String() string {
 // Icon is a representative image that a device might include in its
 // description.
 type Icon struct {
-	Mimetype string   `xml:"mimetype"`
-	Width    int32    `xml:"width"`
-	Height   int32    `xml:"height"`
-	Depth    int32    `xml:"depth"`
-	URL      URLField `xml:"url"`
+	Mimetype string   `xml:"mimetype" json:"mimetype"`
+	Width    int32    `xml:"width" json:"width"`
+	Height   int32    `xml:"height" json:"height"`
+	Depth    int32    `xml:"depth" json:"depth"`
+	URL      URLField `xml:"url" json:"url"`
 }
 
 // SetURLBase sets the URLBase for the Icon.
@@ -132,11 +357,11 @@ func (icon *Icon) SetURLBase(url *url.URL) {
 
 // Service is a service provided by a UPnP Device.
 type Service struct {
-	ServiceType string   `xml:"serviceType"`
-	ServiceId   string   `xml:"serviceId"`
-	SCPDURL     URLField `xml:"SCPDURL"`
-	ControlURL  URLField `xml:"controlURL"`
-	EventSubURL URLField `xml:"eventSubURL"`
+	ServiceType string   `xml:"serviceType" json:"serviceType"`
+	ServiceId   string   `xml:"serviceId" json:"serviceId"`
+	SCPDURL     URLField `xml:"SCPDURL" json:"SCPDURL"`
+	ControlURL  URLField `xml:"controlURL" json:"controlURL"`
+	EventSubURL URLField `xml:"eventSubURL" json:"eventSubURL"`
 }
 
 // SetURLBase sets the URLBase for the Service.
@@ -153,11 +378,15 @@ func (srv *Service) String() string {
 // RequestSCPDCtx requests the SCPD (soap actions and state variables description)
 // for the service.
 func (srv *Service) RequestSCPDCtx(ctx context.Context) (*scpd.SCPD, error) {
+	return srv.requestSCPD(ctx, HTTPClientDefault)
+}
+
+func (srv *Service) requestSCPD(ctx context.Context, client *http.Client) (*scpd.SCPD, error) {
 	if !srv.SCPDURL.Ok {
 		return nil, errors.New("bad/missing SCPD URL, or no URLBase has been set")
 	}
 	s := new(scpd.SCPD)
-	if err := requestXml(ctx, srv.SCPDURL.URL.String(), scpd.SCPDXMLNamespace, s); err != nil {
+	if err := requestXml(ctx, client, MaxXMLBytesDefault, srv.SCPDURL.URL.String(), scpd.SCPDXMLNamespace, s); err != nil {
 		return nil, err
 	}
 	return s, nil
@@ -175,30 +404,136 @@ func (srv *Service) RequestSCDP() (*scpd.SCPD, error) {
 	return srv.RequestSCPD()
 }
 
-func (srv *Service) NewSOAPClient() *soap.SOAPClient {
-	return soap.NewSOAPClient(srv.ControlURL.URL)
+// NewSOAPClient creates a SOAP client for the service's control URL, with the
+// given options applied, e.g soap.WithDefaultTimeout, soap.WithHTTPClient or
+// soap.WithLogger.
+func (srv *Service) NewSOAPClient(opts ...soap.Option) *soap.SOAPClient {
+	return soap.NewSOAPClient(srv.ControlURL.URL, opts...)
 }
 
 // URLField is a URL that is part of a device description.
+//
+// A URLField is encoded in JSON as its unresolved Str value. URL and Ok are
+// restored by SetURLBase, which RootDevice's UnmarshalJSON calls.
+//
+// The URLs of a RootDevice from DeviceByURLCtx, ParseRootDevice or Rehydrate
+// are already resolved against its URLBase; use Resolved to get them.
 type URLField struct {
 	URL url.URL `xml:"-"`
 	Ok  bool    `xml:"-"`
 	Str string  `xml:",chardata"`
 }
 
+// MarshalJSON implements json.Marshaler.
+func (uf URLField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(uf.Str)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (uf *URLField) UnmarshalJSON(data []byte) error {
+	*uf = URLField{}
+	return json.Unmarshal(data, &uf.Str)
+}
+
 func (uf *URLField) SetURLBase(urlBase *url.URL) {
-	str := uf.Str
-	if !strings.Contains(str, "://") && !strings.HasPrefix(str, "/") {
-		str = "/" + str
+	// An empty reference would resolve to the URLBase itself, but means that
+	// the URL is absent, e.g the eventSubURL of a service without events.
+	if strings.TrimSpace(uf.Str) == "" {
+		uf.URL = url.URL{}
+		uf.Ok = false
+		return
 	}
-
-	refUrl, err := url.Parse(str)
+	u, err := resolveURL(urlBase, uf.Str)
 	if err != nil {
 		uf.URL = url.URL{}
 		uf.Ok = false
 		return
 	}
 
-	uf.URL = *urlBase.ResolveReference(refUrl)
+	uf.URL = *u
 	uf.Ok = true
 }
+
+// Resolved returns a copy of the absolute URL, as resolved by SetURLBase, or
+// nil if the URL is missing, invalid, or has not been resolved.
+func (uf *URLField) Resolved() *url.URL {
+	if !uf.Ok {
+		return nil
+	}
+	u := uf.URL
+	return &u
+}
+
+// resolveURL resolves ref against urlBase. Relative references are treated as
+// relative to the root of urlBase, as devices commonly omit the leading "/".
+// ref is normalized first, see normalizeURLRef.
+//
+// Unlike url.URL.ResolveReference, the path of ref is kept exactly as given,
+// including any "." or ".." segments, as some devices reject requests to any
+// path other than the one that they advertised.
+func resolveURL(urlBase *url.URL, ref string) (*url.URL, error) {
+	ref = normalizeURLRef(ref)
+	if !strings.Contains(ref, "://") && !strings.HasPrefix(ref, "/") {
+		ref = "/" + ref
+	}
+
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if refUrl.Scheme == "" {
+		refUrl.Scheme = urlBase.Scheme
+		if refUrl.Host == "" && refUrl.User == nil {
+			refUrl.User = urlBase.User
+			refUrl.Host = urlBase.Host
+		}
+	}
+	return refUrl, nil
+}
+
+// normalizeURLRef makes a URL reference from a device description valid, so
+// that it is not rejected or mangled by url.Parse. Devices have been seen to
+// send references with surrounding whitespace (which is trimmed), and with
+// spaces, non-ASCII characters, or a '%' that does not begin an escape (which
+// are percent-encoded), e.g "/ctl/IP Conn".
+func normalizeURLRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	// Most references need no escaping, so avoid building a copy of them.
+	i := 0
+	for i < len(ref) && !needsURLEscape(ref, i) {
+		i++
+	}
+	if i == len(ref) {
+		return ref
+	}
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(ref) + 8)
+	b.WriteString(ref[:i])
+	for ; i < len(ref); i++ {
+		c := ref[i]
+		if needsURLEscape(ref, i) {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// needsURLEscape reports whether the byte at ref[i] must be percent-encoded
+// by normalizeURLRef.
+func needsURLEscape(ref string, i int) bool {
+	c := ref[i]
+	if c == '%' {
+		return i+2 >= len(ref) || !isHex(ref[i+1]) || !isHex(ref[i+2])
+	}
+	return c <= ' ' || c >= 0x7f || strings.IndexByte("\"<>\\^`{|}", c) >= 0
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,636 @@
+package goupnp
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDeviceXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion>
+		<major>1</major>
+		<minor>0</minor>
+	</specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<friendlyName>Test Router</friendlyName>
+		<manufacturer>Example</manufacturer>
+		<manufacturerURL>http://www.example.com/</manufacturerURL>
+		<modelName>Router 1</modelName>
+		<UDN>uuid:11111111-2222-3333-4444-555555555555</UDN>
+		<iconList>
+			<icon>
+				<mimetype>image/png</mimetype>
+				<width>32</width>
+				<height>32</height>
+				<depth>8</depth>
+				<url>/icon.png</url>
+			</icon>
+		</iconList>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:L3Forwarding1</serviceId>
+				<SCPDURL>/l3f.xml</SCPDURL>
+				<controlURL>/ctl/L3F</controlURL>
+				<eventSubURL>/evt/L3F</eventSubURL>
+			</service>
+		</serviceList>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<friendlyName>WAN Device</friendlyName>
+				<UDN>uuid:11111111-2222-3333-4444-666666666666</UDN>
+				<serviceList>
+					<service>
+						<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+						<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+						<SCPDURL>wanipc.xml</SCPDURL>
+						<controlURL>ctl/IPConn</controlURL>
+						<eventSubURL>evt/IPConn</eventSubURL>
+					</service>
+				</serviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+func testRootDevice(t *testing.T) *RootDevice {
+	t.Helper()
+	root := new(RootDevice)
+	if err := xml.Unmarshal([]byte(testDeviceXML), root); err != nil {
+		t.Fatal(err)
+	}
+	urlBase, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetURLBase(urlBase)
+	return root
+}
+
+func TestRootDeviceJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+	want := testRootDevice(t)
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(RootDevice)
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// XMLName is not encoded in JSON.
+	want.XMLName = xml.Name{}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Bad round trip\nwant: %+v\n got: %+v\njson: %s", want, got, data)
+	}
+
+	srvs := got.Device.FindService("urn:schemas-upnp-org:service:WANIPConnection:1")
+	if len(srvs) != 1 {
+		t.Fatalf("want 1 service, got %d", len(srvs))
+	}
+	if wantURL, gotURL := "http://192.168.1.1:5000/ctl/IPConn", srvs[0].ControlURL.URL.String(); !srvs[0].ControlURL.Ok || wantURL != gotURL {
+		t.Errorf("Bad control URL\nwant: %q\n got: %q (ok=%t)", wantURL, gotURL, srvs[0].ControlURL.Ok)
+	}
+}
+
+func TestDeviceExtraElementsRoundTrip(t *testing.T) {
+	t.Parallel()
+	const vendorXML = `<dlna:X_DLNADOC xmlns:dlna="urn:schemas-dlna-org:device-1-0">DMR-1.50</dlna:X_DLNADOC>` +
+		`<X_extension xmlns="urn:example:vendor" xmlns:v="urn:example:vendor"><v:feature/></X_extension>` +
+		`<X_vendorInfo version="2"><region>EU</region></X_vendorInfo>`
+	deviceXML := strings.Replace(testDeviceXML, "<modelName>Router 1</modelName>", "<modelName>Router 1</modelName>"+vendorXML, 1)
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(deviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExtraElement{
+		{
+			XMLName:  xml.Name{Space: "urn:schemas-dlna-org:device-1-0", Local: "X_DLNADOC"},
+			Attrs:    []xml.Attr{{Name: xml.Name{Local: "xmlns:dlna"}, Value: "urn:schemas-dlna-org:device-1-0"}},
+			InnerXML: "DMR-1.50",
+		},
+		{
+			XMLName:  xml.Name{Space: "urn:example:vendor", Local: "X_extension"},
+			Attrs:    []xml.Attr{{Name: xml.Name{Local: "xmlns:v"}, Value: "urn:example:vendor"}},
+			InnerXML: "<v:feature/>",
+		},
+		{
+			XMLName:  xml.Name{Space: DeviceXMLNamespace, Local: "X_vendorInfo"},
+			Attrs:    []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "2"}},
+			InnerXML: "<region>EU</region>",
+		},
+	}
+	if !reflect.DeepEqual(want, root.Device.Extra) {
+		t.Fatalf("want extra elements %+v, got %+v", want, root.Device.Extra)
+	}
+	if len(root.Device.Devices[0].Extra) != 0 {
+		t.Errorf("want no extra elements for embedded device, got %+v", root.Device.Devices[0].Extra)
+	}
+
+	data, err := xml.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := ParseRootDevice(data, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(root.Device.Extra, reparsed.Device.Extra) {
+		t.Errorf("Bad round trip\nwant: %+v\n got: %+v\nxml: %s", root.Device.Extra, reparsed.Device.Extra, data)
+	}
+
+	// Extra elements are also kept by caches, which encode devices as JSON.
+	if data, err = json.Marshal(root); err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := new(RootDevice)
+	if err := json.Unmarshal(data, fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(root.Device.Extra, fromJSON.Device.Extra) {
+		t.Errorf("Bad JSON round trip\nwant: %+v\n got: %+v\njson: %s", root.Device.Extra, fromJSON.Device.Extra, data)
+	}
+}
+
+func TestRootDeviceRehydrate(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := new(RootDevice)
+	if err := xml.Unmarshal([]byte(testDeviceXML), root); err != nil {
+		t.Fatal(err)
+	}
+	srv := &root.Device.Devices[0].Services[0]
+	if srv.ControlURL.Ok {
+		t.Fatal("control URL resolved before Rehydrate")
+	}
+
+	// Rehydrating twice must be idempotent.
+	for i := 0; i < 2; i++ {
+		if err := root.Rehydrate(loc); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "http://192.168.1.1:5000/ctl/IPConn", srv.ControlURL.URL.String(); !srv.ControlURL.Ok || want != got {
+			t.Errorf("Bad control URL after Rehydrate #%d\nwant: %q\n got: %q", i+1, want, got)
+		}
+	}
+
+	if err := new(RootDevice).Rehydrate(nil); err == nil {
+		t.Error("want error rehydrating without URLBase or location, got nil")
+	}
+}
+
+func TestRootDeviceResolveURL(t *testing.T) {
+	t.Parallel()
+	root := testRootDevice(t)
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"/ctl/IPConn", "http://192.168.1.1:5000/ctl/IPConn"},
+		{"ctl/IPConn", "http://192.168.1.1:5000/ctl/IPConn"},
+		{"http://10.0.0.1:80/ctl", "http://10.0.0.1:80/ctl"},
+		{"/ctl/IP Conn", "http://192.168.1.1:5000/ctl/IP%20Conn"},
+		{"\n\t\t/ctl/IPConn\n", "http://192.168.1.1:5000/ctl/IPConn"},
+		{"/ctl/100%", "http://192.168.1.1:5000/ctl/100%25"},
+		{"/ctl/IP%20Conn", "http://192.168.1.1:5000/ctl/IP%20Conn"},
+		{"/ctl/r\u00e9seau", "http://192.168.1.1:5000/ctl/r%C3%A9seau"},
+		{"/ctl?id=a b", "http://192.168.1.1:5000/ctl?id=a%20b"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.ref, func(t *testing.T) {
+			got, err := root.ResolveURL(test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("want %q, got %q", test.want, got.String())
+			}
+		})
+	}
+	if _, err := root.ResolveURL("http://[::1"); err == nil {
+		t.Error("want error for malformed URL, got nil")
+	}
+}
+
+func TestParseRootDevice(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testRootDevice(t); !reflect.DeepEqual(want, root) {
+		t.Errorf("Bad parse\nwant: %+v\n got: %+v", want, root)
+	}
+
+	if _, err := ParseRootDevice([]byte("<root>"), loc); err == nil {
+		t.Error("want error parsing truncated XML, got nil")
+	}
+}
+
+func TestParseSpecVersion(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		specVersion string
+		want        SpecVersion
+	}{
+		{"absent", "", SpecVersion{Major: 1, Minor: 0}},
+		{"1.1", "<specVersion><major>1</major><minor>1</minor></specVersion>", SpecVersion{Major: 1, Minor: 1}},
+		{"2.0", "<specVersion><major>2</major><minor>0</minor></specVersion>", SpecVersion{Major: 2, Minor: 0}},
+	}
+	for _, test := range tests {
+		data := `<root xmlns="urn:schemas-upnp-org:device-1-0">` + test.specVersion +
+			`<device><UDN>uuid:1</UDN></device></root>`
+		root, err := ParseRootDevice([]byte(data), loc)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if root.SpecVersion != test.want {
+			t.Errorf("%s: want %+v, got %+v", test.name, test.want, root.SpecVersion)
+		}
+	}
+}
+
+func TestSpecVersionAtLeast(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		v            SpecVersion
+		major, minor int32
+		want         bool
+	}{
+		{SpecVersion{1, 0}, 1, 0, true},
+		{SpecVersion{1, 0}, 1, 1, false},
+		{SpecVersion{1, 1}, 1, 1, true},
+		{SpecVersion{2, 0}, 1, 1, true},
+		{SpecVersion{1, 1}, 2, 0, false},
+	}
+	for _, test := range tests {
+		if got := test.v.AtLeast(test.major, test.minor); got != test.want {
+			t.Errorf("%+v.AtLeast(%d, %d): want %t, got %t", test.v, test.major, test.minor, test.want, got)
+		}
+	}
+}
+
+const testDualWANDeviceXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+		<UDN>uuid:11111111-2222-3333-4444-777777777777</UDN>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+				<SCPDURL>/wanipc.xml</SCPDURL>
+				<controlURL>/ctl/IPConn1</controlURL>
+			</service>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:WANIPConn2</serviceId>
+				<SCPDURL>/wanipc.xml</SCPDURL>
+				<controlURL>/ctl/IPConn2</controlURL>
+			</service>
+		</serviceList>
+	</device>
+</root>`
+
+func TestDeviceFindServiceByID(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDualWANDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(root.Device.FindService("urn:schemas-upnp-org:service:WANIPConnection:1")); n != 2 {
+		t.Errorf("want 2 services of the same type, got %d", n)
+	}
+
+	tests := []struct {
+		id             string
+		wantControlURL string
+	}{
+		{"urn:upnp-org:serviceId:WANIPConn1", "http://192.168.1.1:5000/ctl/IPConn1"},
+		{"urn:upnp-org:serviceId:WANIPConn2", "http://192.168.1.1:5000/ctl/IPConn2"},
+	}
+	for _, test := range tests {
+		srv := root.Device.FindServiceByID(test.id)
+		if srv == nil {
+			t.Errorf("%s: want service, got nil", test.id)
+			continue
+		}
+		if got := srv.ControlURL.URL.String(); got != test.wantControlURL {
+			t.Errorf("%s: want control URL %q, got %q", test.id, test.wantControlURL, got)
+		}
+	}
+	if srv := root.Device.FindServiceByID("urn:upnp-org:serviceId:WANIPConn3"); srv != nil {
+		t.Errorf("want nil for unknown service ID, got %v", srv)
+	}
+
+	// Embedded devices are searched too.
+	if srv := testRootDevice(t).Device.FindServiceByID("urn:upnp-org:serviceId:WANIPConn1"); srv == nil {
+		t.Error("want service from embedded device, got nil")
+	}
+}
+
+func TestDeviceFindServiceDepth(t *testing.T) {
+	t.Parallel()
+	root := testRootDevice(t)
+	const (
+		l3f    = "urn:schemas-upnp-org:service:Layer3Forwarding:1"
+		ipConn = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	)
+	tests := []struct {
+		serviceType string
+		maxDepth    int
+		want        int
+	}{
+		{l3f, 0, 1},
+		{ipConn, 0, 0},
+		{ipConn, 1, 1},
+		{ipConn, -1, 1},
+	}
+	for _, test := range tests {
+		if got := len(root.Device.FindServiceDepth(test.serviceType, test.maxDepth)); got != test.want {
+			t.Errorf("FindServiceDepth(%q, %d): want %d services, got %d", test.serviceType, test.maxDepth, test.want, got)
+		}
+	}
+	// By default, embedded devices are searched.
+	if got := len(root.Device.FindService(ipConn)); got != 1 {
+		t.Errorf("FindService(%q): want 1 service from embedded device, got %d", ipConn, got)
+	}
+}
+
+func TestServiceResolvedURLs(t *testing.T) {
+	t.Parallel()
+	const desc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<URLBase>http://192.168.1.1:5000/base/</URLBase>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<UDN>uuid:11111111-2222-3333-4444-555555555555</UDN>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+				<serviceId>relative</serviceId>
+				<SCPDURL>l3f.xml</SCPDURL>
+				<controlURL>/ctl/L3F</controlURL>
+				<eventSubURL>evt/L3F</eventSubURL>
+			</service>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+				<serviceId>absolute</serviceId>
+				<SCPDURL>http://192.168.1.1:5001/wanipc.xml</SCPDURL>
+				<controlURL>http://192.168.1.1:5001/ctl/IPConn</controlURL>
+				<eventSubURL>http://192.168.1.1:5002/evt/IPConn</eventSubURL>
+			</service>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+				<serviceId>no-events</serviceId>
+				<SCPDURL>/wancic.xml</SCPDURL>
+				<controlURL>/ctl/CmnIfCfg</controlURL>
+				<eventSubURL></eventSubURL>
+			</service>
+		</serviceList>
+	</device>
+</root>`
+	loc, err := url.Parse("http://192.168.1.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(desc), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := func(uf URLField) string {
+		if u := uf.Resolved(); u != nil {
+			return u.String()
+		}
+		return ""
+	}
+	tests := []struct {
+		serviceID               string
+		scpd, control, eventSub string
+	}{
+		{"relative", "http://192.168.1.1:5000/l3f.xml", "http://192.168.1.1:5000/ctl/L3F", "http://192.168.1.1:5000/evt/L3F"},
+		{"absolute", "http://192.168.1.1:5001/wanipc.xml", "http://192.168.1.1:5001/ctl/IPConn", "http://192.168.1.1:5002/evt/IPConn"},
+		{"no-events", "http://192.168.1.1:5000/wancic.xml", "http://192.168.1.1:5000/ctl/CmnIfCfg", ""},
+	}
+	for _, test := range tests {
+		srv := root.Device.FindServiceByID(test.serviceID)
+		if srv == nil {
+			t.Errorf("%s: service not found", test.serviceID)
+			continue
+		}
+		if got := resolved(srv.SCPDURL); got != test.scpd {
+			t.Errorf("%s: want SCPDURL %q, got %q", test.serviceID, test.scpd, got)
+		}
+		if got := resolved(srv.ControlURL); got != test.control {
+			t.Errorf("%s: want controlURL %q, got %q", test.serviceID, test.control, got)
+		}
+		if got := resolved(srv.EventSubURL); got != test.eventSub {
+			t.Errorf("%s: want eventSubURL %q, got %q", test.serviceID, test.eventSub, got)
+		}
+	}
+
+	// The resolved URL is a copy.
+	srv := root.Device.FindServiceByID("relative")
+	srv.EventSubURL.Resolved().Path = "/changed"
+	if got := srv.EventSubURL.URL.Path; got != "/evt/L3F" {
+		t.Errorf("want eventSubURL path unchanged, got %q", got)
+	}
+	if u := (&URLField{Str: "/evt"}).Resolved(); u != nil {
+		t.Errorf("want nil for unresolved URL, got %v", u)
+	}
+}
+
+func TestSpaceInControlURL(t *testing.T) {
+	t.Parallel()
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` +
+			`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(strings.Replace(testDeviceXML, "ctl/IPConn", "ctl/IP Conn", 1)), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipConn := root.Device.FindService("urn:schemas-upnp-org:service:WANIPConnection:1")[0]
+	err = ipConn.NewSOAPClient().PerformActionCtx(context.Background(),
+		"urn:schemas-upnp-org:service:WANIPConnection:1", "GetExternalIPAddress", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "/ctl/IP Conn", <-paths; want != got {
+		t.Errorf("want request to %q, got %q", want, got)
+	}
+}
+
+func TestControlURLPathPreserved(t *testing.T) {
+	t.Parallel()
+	requestURIs := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURIs <- r.RequestURI
+		w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` +
+			`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, controlURL := range []string{
+		"/ctl/IPConn",
+		"/ctl/IPConn/",
+		"/upnp/control//WANIPConn1",
+		"/ctl/./IPConn/.",
+		"/ctl/x/../IPConn",
+		"/ctl/IPConn%2F1",
+		"/ctl/IPConn?service=1",
+	} {
+		desc := strings.Replace(testDeviceXML, "<controlURL>ctl/IPConn</controlURL>",
+			"<controlURL>"+controlURL+"</controlURL>", 1)
+		root, err := ParseRootDevice([]byte(desc), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipConn := root.Device.FindService("urn:schemas-upnp-org:service:WANIPConnection:1")[0]
+		err = ipConn.NewSOAPClient().PerformActionCtx(context.Background(),
+			"urn:schemas-upnp-org:service:WANIPConnection:1", "GetExternalIPAddress", nil, nil)
+		if err != nil {
+			t.Errorf("%s: %v", controlURL, err)
+			continue
+		}
+		if got := <-requestURIs; got != controlURL {
+			t.Errorf("want request to %q, got %q", controlURL, got)
+		}
+	}
+}
+
+// largeDeviceXML returns a description of a root device with numDevices
+// embedded devices, each with numServices services, like those of some AV
+// receivers.
+func largeDeviceXML(numDevices, numServices int) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+		<friendlyName>Receiver</friendlyName>
+		<UDN>uuid:00000000-0000-0000-0000-000000000000</UDN>
+		<deviceList>`)
+	for d := 0; d < numDevices; d++ {
+		fmt.Fprintf(&b, `
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+				<friendlyName>Zone %d</friendlyName>
+				<manufacturer>Example</manufacturer>
+				<manufacturerURL>http://www.example.com/</manufacturerURL>
+				<modelName>Receiver</modelName>
+				<modelURL>http://www.example.com/receiver</modelURL>
+				<UDN>uuid:00000000-0000-0000-0000-%012d</UDN>
+				<iconList><icon><mimetype>image/png</mimetype><width>48</width><height>48</height><depth>24</depth><url>/icons/%d.png</url></icon></iconList>
+				<presentationURL>/zones/%d/</presentationURL>
+				<serviceList>`, d, d, d, d)
+		for s := 0; s < numServices; s++ {
+			fmt.Fprintf(&b, `
+					<service>
+						<serviceType>urn:schemas-upnp-org:service:Service%d:1</serviceType>
+						<serviceId>urn:upnp-org:serviceId:Service%d</serviceId>
+						<SCPDURL>/zones/%d/scpd/%d.xml</SCPDURL>
+						<controlURL>/zones/%d/control/%d</controlURL>
+						<eventSubURL>/zones/%d/event/%d</eventSubURL>
+					</service>`, s, s, d, s, d, s, d, s)
+		}
+		b.WriteString(`
+				</serviceList>
+			</device>`)
+	}
+	b.WriteString(`
+		</deviceList>
+	</device>
+</root>`)
+	return []byte(b.String())
+}
+
+func TestParseLargeRootDevice(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.168.1.20:8080/description.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice(largeDeviceXML(20, 5), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Device.Devices) != 20 {
+		t.Fatalf("want 20 embedded devices, got %d", len(root.Device.Devices))
+	}
+	zone := &root.Device.Devices[19]
+	if want, got := "Zone 19", zone.FriendlyName; want != got {
+		t.Errorf("want device %q, got %q", want, got)
+	}
+	if len(zone.Icons) != 1 || len(zone.Services) != 5 {
+		t.Fatalf("want 1 icon and 5 services, got %d and %d", len(zone.Icons), len(zone.Services))
+	}
+	if want, got := "http://192.168.1.20:8080/zones/19/control/4", zone.Services[4].ControlURL.URL.String(); want != got {
+		t.Errorf("want control URL %q, got %q", want, got)
+	}
+}
+
+func BenchmarkParseRootDevice(b *testing.B) {
+	data := largeDeviceXML(50, 10)
+	loc, err := url.Parse("http://192.168.1.20:8080/description.xml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseRootDevice(data, loc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,659 @@
+package goupnp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp/ssdp"
+	"go.uber.org/goleak"
+)
+
+// startTestResponder starts an SSDP responder on the multicast group, which
+// answers searches for searchTarget with location. The test is skipped if the
+// host cannot join the group.
+func startTestResponder(t *testing.T, group *net.UDPAddr, searchTarget, location string) {
+	t.Helper()
+	conn, err := listenTestGroup(group)
+	if err != nil {
+		t.Skipf("cannot join multicast group %v: %v", group, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go serveTestSearches(conn, searchTarget, location)
+}
+
+// startUnicastTestResponder starts an SSDP responder on a loopback port, which
+// answers searches for searchTarget with location, and returns its address.
+func startUnicastTestResponder(t *testing.T, searchTarget, location string) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go serveTestSearches(conn, searchTarget, location)
+	return conn.LocalAddr().String()
+}
+
+// serveTestSearches answers searches for searchTarget received on conn with
+// location, until conn is closed.
+func serveTestSearches(conn *net.UDPConn, searchTarget, location string) {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("ST") != searchTarget {
+			continue
+		}
+		resp := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=1800\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: %s\r\n"+
+			"ST: %s\r\n"+
+			"USN: uuid:test::%s\r\n\r\n", location, searchTarget, searchTarget)
+		conn.WriteToUDP([]byte(resp), from)
+	}
+}
+
+// listenTestGroup joins group on the first multicast-capable interface that
+// discovery would use.
+func listenTestGroup(group *net.UDPAddr) (*net.UDPConn, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		iface := iface
+		if conn, err := net.ListenMulticastUDP("udp4", &iface, group); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no multicast interface could join")
+}
+
+func TestDiscoverLocalResponder(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:LocalResponder:1"
+	startTestResponder(t, &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}, st, srv.URL+"/rootDesc.xml")
+
+	t.Run("loopback", func(t *testing.T) {
+		t.Parallel()
+		devices, err := DiscoverDevicesCtx(context.Background(), st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 1 {
+			t.Fatalf("want 1 device, got %d: %+v", len(devices), devices)
+		}
+		if err := devices[0].Err; err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "Test Router", devices[0].Root.Device.FriendlyName; want != got {
+			t.Errorf("want device %q, got %q", want, got)
+		}
+	})
+	t.Run("no loopback", func(t *testing.T) {
+		t.Parallel()
+		devices, err := DiscoverDevicesCtx(context.Background(), st, WithMulticastLoopback(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 0 {
+			t.Errorf("want no devices without loopback, got %+v", devices)
+		}
+	})
+}
+
+func TestDiscoverPrivateGroup(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:PrivateGroup:1"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 1), Port: 19001}
+	startTestResponder(t, group, st, srv.URL+"/rootDesc.xml")
+
+	devices, err := DiscoverDevicesCtx(context.Background(), st, WithMulticastGroup(group.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Err != nil {
+		t.Fatalf("want 1 device, got %+v", devices)
+	}
+
+	for _, addr := range []string{"192.0.2.1:1900", "239.255.255.250", "[ff02::c]:1900", "239.255.255.250:0"} {
+		if _, err := DiscoverDevicesCtx(context.Background(), st, WithMulticastGroup(addr)); err == nil {
+			t.Errorf("%s: want error for invalid multicast group, got nil", addr)
+		}
+	}
+}
+
+func TestDiscoverMulti(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const (
+		stA = "urn:goupnp-test:device:MultiA:1"
+		stB = "urn:goupnp-test:device:MultiB:1"
+	)
+	// One device that answers both targets.
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 8), Port: 19008}
+	startTestResponder(t, group, stA, srv.URL+"/rootDesc.xml")
+	startTestResponder(t, group, stB, srv.URL+"/rootDesc.xml")
+
+	start := time.Now()
+	byTarget, err := DiscoverMultiCtx(context.Background(), []string{stA, stB}, WithMulticastGroup(group.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both targets share the one two second wait for responses.
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("want a single wait for responses, took %v", elapsed)
+	}
+	for _, st := range []string{stA, stB} {
+		devices := byTarget[st]
+		if len(devices) != 1 || devices[0].Err != nil {
+			t.Fatalf("%s: want 1 device, got %+v", st, devices)
+		}
+		if want := "uuid:test::" + st; devices[0].USN != want {
+			t.Errorf("%s: want USN %q, got %q", st, want, devices[0].USN)
+		}
+	}
+	if byTarget[stA][0].Root != byTarget[stB][0].Root {
+		t.Error("want the same root device under both targets")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 1 {
+		t.Errorf("want device probed once, got %d fetches", fetches)
+	}
+}
+
+func TestDiscoverByUDN(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const udn = "uuid:11111111-2222-3333-4444-555555555555"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 2), Port: 19002}
+	startTestResponder(t, group, udn, srv.URL+"/rootDesc.xml")
+
+	for _, search := range []string{udn, "11111111-2222-3333-4444-555555555555", "UUID:11111111-2222-3333-4444-555555555555"} {
+		search := search
+		t.Run(search, func(t *testing.T) {
+			t.Parallel()
+			device, err := DiscoverByUDNCtx(context.Background(), search, WithMulticastGroup(group.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := udn, device.Root.Device.UDN; want != got {
+				t.Errorf("want UDN %q, got %q", want, got)
+			}
+		})
+	}
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+		_, err := DiscoverByUDNCtx(context.Background(), "uuid:00000000-0000-0000-0000-000000000000", WithMulticastGroup(group.String()))
+		if !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("want ErrDeviceNotFound, got %v", err)
+		}
+	})
+}
+
+func TestDiscoverExpected(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:Expected:1"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 4), Port: 19004}
+	startTestResponder(t, group, st, srv.URL+"/rootDesc.xml")
+
+	// The test responder answers with the USN uuid:test.
+	expected := []string{"uuid:missing-1", "test", "uuid:missing-2"}
+	found, missing, err := DiscoverExpectedCtx(context.Background(), st, expected,
+		WithMulticastGroup(group.String()))
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("want ErrDeviceNotFound, got %v", err)
+	}
+	if want := []string{"uuid:missing-1", "uuid:missing-2"}; !reflect.DeepEqual(want, missing) {
+		t.Errorf("want missing %q, got %q", want, missing)
+	}
+	if len(found) != 1 {
+		t.Fatalf("want 1 device found, got %d", len(found))
+	}
+	if maybe, ok := found["test"]; !ok || maybe.Err != nil {
+		t.Errorf("want device found for %q, got %+v", "test", maybe)
+	}
+
+	found, missing, err = DiscoverExpectedCtx(context.Background(), st, []string{"uuid:test"},
+		WithMulticastGroup(group.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || len(missing) != 0 {
+		t.Errorf("want 1 found and none missing, got %d found and %q missing", len(found), missing)
+	}
+}
+
+// TestDiscoverCancelled is not parallel, so that other tests' goroutines are
+// not mistaken for leaks.
+func TestDiscoverCancelled(t *testing.T) {
+	ignore := goleak.IgnoreCurrent()
+	// A unicast target that never responds, so that its search only ends
+	// when the discovery is cancelled.
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 6), Port: 19006}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = DiscoverDevicesCtx(ctx, "urn:goupnp-test:device:Cancelled:1",
+		WithMulticastGroup(group.String()), WithUnicastTargets(silent.LocalAddr().String()))
+	if errors.Is(err, ErrNoMulticastInterface) {
+		t.Skipf("cannot search: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want discovery to return promptly when cancelled, took %v", elapsed)
+	}
+	goleak.VerifyNone(t, ignore)
+}
+
+func TestUSNHasUDN(t *testing.T) {
+	t.Parallel()
+	const udn = "uuid:abc"
+	tests := []struct {
+		usn  string
+		want bool
+	}{
+		{"uuid:abc", true},
+		{"UUID:ABC", true},
+		{"uuid:abc::upnp:rootdevice", true},
+		{"uuid:abc::urn:schemas-upnp-org:device:InternetGatewayDevice:1", true},
+		{"uuid:abcd", false},
+		{"uuid:ab", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := usnHasUDN(test.usn, udn); got != test.want {
+			t.Errorf("usnHasUDN(%q, %q): want %t, got %t", test.usn, udn, test.want, got)
+		}
+	}
+}
+
+func TestFilterSearchTarget(t *testing.T) {
+	t.Parallel()
+	root := testRootDevice(t)
+	tests := []struct {
+		searchTarget string
+		want         bool
+	}{
+		{"ssdp:all", true},
+		{"upnp:rootdevice", true},
+		{"urn:schemas-upnp-org:device:InternetGatewayDevice:1", true},
+		{"urn:schemas-upnp-org:device:WANDevice:1", true},
+		{"urn:schemas-upnp-org:device:WANDevice:2", false},
+		{"urn:schemas-upnp-org:device:MediaServer:1", false},
+		{"urn:schemas-upnp-org:service:WANIPConnection:1", true},
+		{"urn:example-com:service:Vendor:1", false},
+		{"uuid:11111111-2222-3333-4444-666666666666", true},
+		{"uuid:11111111-2222-3333-4444-777777777777", false},
+	}
+	for _, test := range tests {
+		var reported []bool
+		o := newDiscoveryOptions([]DiscoveryOption{WithSearchTargetValidation(func(device *MaybeRootDevice, ok bool) {
+			if device.Root != root {
+				t.Errorf("%s: reported unexpected device %+v", test.searchTarget, device)
+			}
+			reported = append(reported, ok)
+		})})
+		results := o.filterSearchTarget([]MaybeRootDevice{
+			{USN: "probed", Root: root},
+			{USN: "failed", Err: ErrProbeFailed},
+		}, test.searchTarget)
+
+		var got []string
+		for _, maybe := range results {
+			got = append(got, maybe.USN)
+		}
+		want := "failed"
+		if test.want {
+			want = "probed,failed"
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: want results %s, got %s", test.searchTarget, want, strings.Join(got, ","))
+		}
+		if len(reported) != 1 || reported[0] != test.want {
+			t.Errorf("%s: want reported [%t], got %v", test.searchTarget, test.want, reported)
+		}
+	}
+}
+
+func TestUnicastSearch(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:UnicastSearch:1"
+	_, port, err := net.SplitHostPort(startUnicastTestResponder(t, st, srv.URL+"/rootDesc.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("SEARCHPORT.UPNP.ORG", port)
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &MaybeRootDevice{Location: loc, SearchPort: ssdp.SearchPort(header)}
+
+	devices, err := UnicastSearchCtx(context.Background(), device, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Err != nil {
+		t.Fatalf("want 1 device, got %+v", devices)
+	}
+	if want := "uuid:test::" + st; devices[0].USN != want {
+		t.Errorf("want USN %q, got %q", want, devices[0].USN)
+	}
+
+	if _, err := UnicastSearchCtx(context.Background(), &MaybeRootDevice{}, st); err == nil {
+		t.Error("want error for device without location, got nil")
+	}
+}
+
+func TestSourcePort(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:SourcePort:1"
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { responder.Close() })
+	from := make(chan int, 16)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, addr, err := responder.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			from <- addr.Port
+		}
+	}()
+
+	// Pick a free port to send from.
+	free, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourcePort := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &MaybeRootDevice{Location: loc, SearchPort: responder.LocalAddr().(*net.UDPAddr).Port}
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	if _, err := UnicastSearchCtx(ctx, device, st, WithSourcePort(sourcePort), WithSearchSends(1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case port := <-from:
+		if port != sourcePort {
+			t.Errorf("want M-SEARCH from port %d, got %d", sourcePort, port)
+		}
+	default:
+		t.Fatal("want M-SEARCH received, got none")
+	}
+
+	if _, err := DiscoverDevicesCtx(context.Background(), st,
+		WithSourcePort(sourcePort), WithUnicastTargets(responder.LocalAddr().String())); err == nil {
+		t.Error("want error for WithSourcePort with WithUnicastTargets, got nil")
+	}
+}
+
+func TestSearchSends(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		numSends int
+		want     int
+	}{
+		{1, 1},
+		{5, 5},
+		{0, 3},
+		{-1, 3},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprint(test.numSends), func(t *testing.T) {
+			t.Parallel()
+			responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { responder.Close() })
+			searches := make(chan struct{}, 16)
+			go func() {
+				buf := make([]byte, 2048)
+				for {
+					n, _, err := responder.ReadFromUDP(buf)
+					if err != nil {
+						return
+					}
+					if bytes.HasPrefix(buf[:n], []byte("M-SEARCH ")) {
+						searches <- struct{}{}
+					}
+				}
+			}()
+
+			loc, err := url.Parse("http://127.0.0.1/rootDesc.xml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			device := &MaybeRootDevice{Location: loc, SearchPort: responder.LocalAddr().(*net.UDPAddr).Port}
+			ctx, cancel := context.WithTimeout(context.Background(), 1200*time.Millisecond)
+			defer cancel()
+			if _, err := UnicastSearchCtx(ctx, device, ssdp.SSDPAll, WithSearchSends(test.numSends)); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(searches); got != test.want {
+				t.Errorf("want %d M-SEARCH datagrams, got %d", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDiscoverUnicastTargets(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:UnicastTargets:1"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 3), Port: 19003}
+	startTestResponder(t, group, st, srv.URL+"/rootDesc.xml")
+	// One device answers both searches, and one only the unicast search.
+	both := startUnicastTestResponder(t, st, srv.URL+"/rootDesc.xml")
+	unicastOnly := startUnicastTestResponder(t, st, srv.URL+"/other.xml")
+
+	devices, err := DiscoverDevicesCtx(context.Background(), st,
+		WithMulticastGroup(group.String()), WithUnicastTargets(both, unicastOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locations []string
+	for _, device := range devices {
+		if device.Err != nil {
+			t.Error(device.Err)
+			continue
+		}
+		locations = append(locations, device.Location.String())
+	}
+	if len(locations) != 2 || locations[0] != srv.URL+"/rootDesc.xml" || locations[1] != srv.URL+"/other.xml" {
+		t.Errorf("want each device once, got %v", locations)
+	}
+}
+
+func TestDiscoverMultiOptions(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const (
+		stA = "urn:goupnp-test:device:MultiOptionsA:1"
+		stB = "urn:goupnp-test:device:MultiOptionsB:1"
+	)
+	// Nothing answers on the group, so devices are only found by the unicast
+	// search, or from the cache.
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 9), Port: 19009}
+	unicast := startUnicastTestResponder(t, stA, srv.URL+"/rootDesc.xml")
+	cachedLoc, err := url.Parse("http://192.0.2.1:5000/cached.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cache and unicast targets", func(t *testing.T) {
+		t.Parallel()
+		cache := NewDiscoveryCache()
+		cache.Put(stB, []MaybeRootDevice{{USN: "uuid:cached::" + stB, Root: testRootDevice(t), Location: cachedLoc}})
+		byTarget, err := DiscoverMultiCtx(context.Background(), []string{stA, stB},
+			WithMulticastGroup(group.String()), WithUnicastTargets(unicast), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if devices := byTarget[stA]; len(devices) != 1 || devices[0].Err != nil || devices[0].Location.String() != srv.URL+"/rootDesc.xml" {
+			t.Errorf("%s: want device found by unicast search, got %+v", stA, devices)
+		}
+		if devices := byTarget[stB]; len(devices) != 1 || devices[0].USN != "uuid:cached::"+stB {
+			t.Errorf("%s: want cached device, got %+v", stB, devices)
+		}
+		if got := cache.Get(stA); len(got) != 1 {
+			t.Errorf("%s: want device found added to the cache, got %+v", stA, got)
+		}
+	})
+	t.Run("search target validation", func(t *testing.T) {
+		t.Parallel()
+		var reported []bool
+		byTarget, err := DiscoverMultiCtx(context.Background(), []string{stA},
+			WithMulticastGroup(group.String()), WithUnicastTargets(unicast),
+			WithSearchTargetValidation(func(device *MaybeRootDevice, ok bool) {
+				reported = append(reported, ok)
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The device description does not have the device type.
+		if devices := byTarget[stA]; len(devices) != 0 {
+			t.Errorf("want device removed by validation, got %+v", devices)
+		}
+		if len(reported) != 1 || reported[0] {
+			t.Errorf("want device reported without the search target, got %v", reported)
+		}
+	})
+	t.Run("source port with unicast targets", func(t *testing.T) {
+		t.Parallel()
+		if _, err := DiscoverMultiCtx(context.Background(), []string{stA},
+			WithSourcePort(1900), WithUnicastTargets(unicast)); err == nil {
+			t.Error("want error for WithSourcePort with WithUnicastTargets, got nil")
+		}
+	})
+}
+
+func TestDiscoverByUDNOptions(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const udn = "uuid:11111111-2222-3333-4444-555555555555"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 9), Port: 19009}
+	unicast := startUnicastTestResponder(t, udn, srv.URL+"/rootDesc.xml")
+
+	t.Run("unicast targets", func(t *testing.T) {
+		t.Parallel()
+		cache := NewDiscoveryCache()
+		device, err := DiscoverByUDNCtx(context.Background(), udn,
+			WithMulticastGroup(group.String()), WithUnicastTargets(unicast), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := srv.URL+"/rootDesc.xml", device.Location.String(); want != got {
+			t.Errorf("want device at %q, got %q", want, got)
+		}
+		if got := cache.Get(udn); len(got) != 1 {
+			t.Errorf("want device found added to the cache, got %+v", got)
+		}
+	})
+	t.Run("cache", func(t *testing.T) {
+		t.Parallel()
+		cachedLoc, err := url.Parse("http://192.0.2.1:5000/cached.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache := NewDiscoveryCache()
+		cache.Put(udn, []MaybeRootDevice{{USN: udn, Root: testRootDevice(t), Location: cachedLoc}})
+		start := time.Now()
+		device, err := DiscoverByUDNCtx(context.Background(), udn, WithMulticastGroup(group.String()), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if device.Location.String() != cachedLoc.String() {
+			t.Errorf("want cached device at %q, got %q", cachedLoc, device.Location)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("want no search for a cached device, took %v", elapsed)
+		}
+	})
+	t.Run("source port with unicast targets", func(t *testing.T) {
+		t.Parallel()
+		if _, err := DiscoverByUDNCtx(context.Background(), udn,
+			WithSourcePort(1900), WithUnicastTargets(unicast)); err == nil {
+			t.Error("want error for WithSourcePort with WithUnicastTargets, got nil")
+		}
+	})
+}
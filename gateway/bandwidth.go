@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// CommonInterfaceConfig is the set of WANCommonInterfaceConfig1 methods used
+// to take a BandwidthSnapshot.
+type CommonInterfaceConfig interface {
+	GetTotalBytesSentCtx(ctx context.Context) (NewTotalBytesSent uint64, err error)
+	GetTotalBytesReceivedCtx(ctx context.Context) (NewTotalBytesReceived uint64, err error)
+	GetCommonLinkPropertiesCtx(ctx context.Context) (
+		NewWANAccessType string,
+		NewLayer1UpstreamMaxBitRate uint32,
+		NewLayer1DownstreamMaxBitRate uint32,
+		NewPhysicalLinkStatus string,
+		err error,
+	)
+}
+
+var _ CommonInterfaceConfig = &internetgateway2.WANCommonInterfaceConfig1{}
+
+// BandwidthSnapshot holds the WAN traffic counters and link rates of a gateway
+// at a point in time.
+type BandwidthSnapshot struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// Maximum link rates in bits per second.
+	Layer1UpstreamMaxBitRate   uint32
+	Layer1DownstreamMaxBitRate uint32
+}
+
+// BandwidthSnapshotCtx queries the traffic counters and link properties of a
+// WAN interface.
+func BandwidthSnapshotCtx(ctx context.Context, config CommonInterfaceConfig) (BandwidthSnapshot, error) {
+	var snap BandwidthSnapshot
+	var err error
+	if snap.BytesSent, err = config.GetTotalBytesSentCtx(ctx); err != nil {
+		return BandwidthSnapshot{}, ClassifyFault(err)
+	}
+	if snap.BytesReceived, err = config.GetTotalBytesReceivedCtx(ctx); err != nil {
+		return BandwidthSnapshot{}, ClassifyFault(err)
+	}
+	if _, snap.Layer1UpstreamMaxBitRate, snap.Layer1DownstreamMaxBitRate, _, err = config.GetCommonLinkPropertiesCtx(ctx); err != nil {
+		return BandwidthSnapshot{}, ClassifyFault(err)
+	}
+	snap.Time = time.Now()
+	return snap, nil
+}
+
+// BandwidthRate is the average throughput between two BandwidthSnapshots.
+type BandwidthRate struct {
+	SentBytesPerSecond     float64
+	ReceivedBytesPerSecond float64
+}
+
+// RateSince returns the average throughput from prev until s. It returns a zero
+// rate if s was not taken after prev.
+//
+// Many gateways implement the byte counters as 32-bit values, as in version 1
+// of the specification, which wrap around after 4GiB. A counter that has
+// decreased from a value that fits in 32 bits is assumed to have wrapped once.
+// A counter that decreased from a larger value is assumed to have been reset.
+func (s BandwidthSnapshot) RateSince(prev BandwidthSnapshot) BandwidthRate {
+	elapsed := s.Time.Sub(prev.Time).Seconds()
+	if elapsed <= 0 {
+		return BandwidthRate{}
+	}
+	return BandwidthRate{
+		SentBytesPerSecond:     float64(counterDelta(prev.BytesSent, s.BytesSent)) / elapsed,
+		ReceivedBytesPerSecond: float64(counterDelta(prev.BytesReceived, s.BytesReceived)) / elapsed,
+	}
+}
+
+// counterDelta returns the increase of a byte counter from prev to cur,
+// allowing for wraparound of 32-bit counters.
+func counterDelta(prev, cur uint64) uint64 {
+	switch {
+	case cur >= prev:
+		return cur - prev
+	case prev <= math.MaxUint32:
+		return cur + (math.MaxUint32 + 1) - prev
+	default:
+		return cur
+	}
+}
@@ -0,0 +1,37 @@
+package gateway
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateSince(t *testing.T) {
+	t.Parallel()
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name                   string
+		prevSent, prevReceived uint64
+		curSent, curReceived   uint64
+		elapsed                time.Duration
+		want                   BandwidthRate
+	}{
+		{"steady", 1000, 2000, 3000, 6000, 2 * time.Second, BandwidthRate{1000, 2000}},
+		{"idle", 1000, 2000, 1000, 2000, time.Second, BandwidthRate{0, 0}},
+		{"32-bit wrap", math.MaxUint32 - 99, 0, 100, 0, 10 * time.Second, BandwidthRate{20, 0}},
+		{"64-bit reset", 1 << 40, 0, 500, 0, 5 * time.Second, BandwidthRate{100, 0}},
+		{"no time elapsed", 0, 0, 1000, 1000, 0, BandwidthRate{}},
+		{"clock went backwards", 0, 0, 1000, 1000, -time.Second, BandwidthRate{}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			prev := BandwidthSnapshot{Time: start, BytesSent: test.prevSent, BytesReceived: test.prevReceived}
+			cur := BandwidthSnapshot{Time: start.Add(test.elapsed), BytesSent: test.curSent, BytesReceived: test.curReceived}
+			if got := cur.RateSince(prev); got != test.want {
+				t.Errorf("want %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// ConnectionControl is a WANConnection that can also report and control the
+// state of the WAN link. All of the clients that implement WANConnection
+// implement ConnectionControl.
+type ConnectionControl interface {
+	WANConnection
+
+	GetConnectionTypeInfoCtx(ctx context.Context) (
+		NewConnectionType string,
+		NewPossibleConnectionTypes string,
+		err error,
+	)
+
+	RequestConnectionCtx(ctx context.Context) (err error)
+
+	ForceTerminationCtx(ctx context.Context) (err error)
+
+	GetStatusInfoCtx(ctx context.Context) (
+		NewConnectionStatus string,
+		NewLastConnectionError string,
+		NewUptime uint32,
+		err error,
+	)
+}
+
+var (
+	_ ConnectionControl = &internetgateway2.WANIPConnection1{}
+	_ ConnectionControl = &internetgateway2.WANIPConnection2{}
+	_ ConnectionControl = &internetgateway2.WANPPPConnection1{}
+)
+
+// ConnectionTypeInfo describes the type of a WAN connection.
+type ConnectionTypeInfo struct {
+	// Type is the current connection type, e.g "IP_Routed", "IP_Bridged" or,
+	// for PPP connections, "PPPoE_Bridged".
+	Type string
+	// PossibleTypes are the types that the connection can be configured as.
+	PossibleTypes []string
+}
+
+// GetConnectionTypeInfoCtx returns the type of the WAN connection.
+func GetConnectionTypeInfoCtx(ctx context.Context, conn ConnectionControl) (ConnectionTypeInfo, error) {
+	connType, possible, err := conn.GetConnectionTypeInfoCtx(ctx)
+	if err != nil {
+		return ConnectionTypeInfo{}, ClassifyFault(err)
+	}
+	info := ConnectionTypeInfo{Type: connType}
+	for _, t := range strings.Split(possible, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			info.PossibleTypes = append(info.PossibleTypes, t)
+		}
+	}
+	return info, nil
+}
+
+// SupportsActionCtx reports whether the service of conn lists the named action
+// in its service description (SCPD). Many gateways omit the connection
+// control actions, or list them but return ErrActionNotAuthorized.
+func SupportsActionCtx(ctx context.Context, conn WANConnection, actionName string) (bool, error) {
+	s, err := conn.GetServiceClient().SCPDCtx(ctx)
+	if err != nil {
+		return false, err
+	}
+	return s.GetAction(actionName) != nil, nil
+}
+
+// RequestConnectionCtx asks the gateway to connect the WAN link, if it is not
+// already connected. Afterwards, the link may take some time to come up.
+//
+// ErrInvalidAction is returned without contacting the service if its
+// description does not list the action. The action is attempted if the
+// description could not be fetched. ErrActionNotAuthorized is returned by
+// gateways that do not allow the connection to be controlled.
+func RequestConnectionCtx(ctx context.Context, conn ConnectionControl) error {
+	if err := checkSupportsAction(ctx, conn, "RequestConnection"); err != nil {
+		return err
+	}
+	return ClassifyFault(conn.RequestConnectionCtx(ctx))
+}
+
+// ForceTerminationCtx asks the gateway to disconnect the WAN link immediately.
+// Together with RequestConnectionCtx, this can be used to reconnect, which
+// commonly gets a new external IP address from PPPoE providers. Errors are as
+// for RequestConnectionCtx.
+func ForceTerminationCtx(ctx context.Context, conn ConnectionControl) error {
+	if err := checkSupportsAction(ctx, conn, "ForceTermination"); err != nil {
+		return err
+	}
+	return ClassifyFault(conn.ForceTerminationCtx(ctx))
+}
+
+// WaitForConnected polls the status of conn every poll interval until it is
+// "Connected", or until ctx is done, e.g after RequestConnectionCtx. Errors
+// from polling do not stop the wait, as some gateways fail requests while the
+// link is changing state. If ctx is done first, the returned error wraps the
+// context's error, and reports the last status and any error from polling.
+// poll must be positive.
+func WaitForConnected(ctx context.Context, conn ConnectionControl, poll time.Duration) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	var status string
+	var pollErr error
+	for {
+		s, _, _, err := conn.GetStatusInfoCtx(ctx)
+		if err != nil {
+			pollErr = ClassifyFault(err)
+		} else if status, pollErr = s, nil; status == "Connected" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if pollErr != nil {
+				return fmt.Errorf("gateway: connection is not up, last status %q, last error %v: %w", status, pollErr, ctx.Err())
+			}
+			return fmt.Errorf("gateway: connection is not up, last status %q: %w", status, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkSupportsAction returns ErrInvalidAction if the service description of
+// conn is known to not list the named action.
+func checkSupportsAction(ctx context.Context, conn WANConnection, actionName string) error {
+	if ok, err := SupportsActionCtx(ctx, conn, actionName); err == nil && !ok {
+		return ErrInvalidAction
+	}
+	return nil
+}
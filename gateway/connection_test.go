@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp"
+)
+
+const testConnDeviceXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:WANPPPConn1</serviceId>
+				<SCPDURL>/pppc.xml</SCPDURL>
+				<controlURL>/ctl/PPPConn</controlURL>
+			</service>
+		</serviceList>
+	</device>
+</root>`
+
+const testConnSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+	<actionList>
+		<action><name>GetConnectionTypeInfo</name></action>
+		<action><name>RequestConnection</name></action>
+	</actionList>
+</scpd>`
+
+type fakeConnectionControl struct {
+	*fakeWANConnection
+	connType, possibleTypes string
+	requested, terminated   int
+	requestErr              error
+	statuses                []string
+	statusErr               error
+}
+
+func (c *fakeConnectionControl) GetConnectionTypeInfoCtx(ctx context.Context) (string, string, error) {
+	return c.connType, c.possibleTypes, nil
+}
+
+func (c *fakeConnectionControl) RequestConnectionCtx(ctx context.Context) error {
+	c.requested++
+	return c.requestErr
+}
+
+func (c *fakeConnectionControl) ForceTerminationCtx(ctx context.Context) error {
+	c.terminated++
+	return nil
+}
+
+// GetStatusInfoCtx returns each of statuses in turn, then the last one
+// repeatedly, or statusErr if set.
+func (c *fakeConnectionControl) GetStatusInfoCtx(ctx context.Context) (string, string, uint32, error) {
+	if c.statusErr != nil {
+		return "", "", 0, c.statusErr
+	}
+	status := c.statuses[0]
+	if len(c.statuses) > 1 {
+		c.statuses = c.statuses[1:]
+	}
+	return status, "ERROR_NONE", 0, nil
+}
+
+func newFakeConnectionControl(t *testing.T) *fakeConnectionControl {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testConnSCPD))
+	}))
+	t.Cleanup(srv.Close)
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := goupnp.ParseRootDevice([]byte(testConnDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := goupnp.NewServiceClientsFromRootDevice(root, loc, "urn:schemas-upnp-org:service:WANPPPConnection:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeConnectionControl{fakeWANConnection: &fakeWANConnection{client: &clients[0]}}
+}
+
+func TestConnectionControl(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	conn := newFakeConnectionControl(t)
+	conn.connType = "IP_Routed"
+	conn.possibleTypes = "IP_Routed, PPPoE_Bridged"
+
+	info, err := GetConnectionTypeInfoCtx(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ConnectionTypeInfo{"IP_Routed", []string{"IP_Routed", "PPPoE_Bridged"}}); !reflect.DeepEqual(want, info) {
+		t.Errorf("want %+v, got %+v", want, info)
+	}
+
+	conn.requestErr = upnpFault(606, "Action not authorized")
+	if err := RequestConnectionCtx(ctx, conn); !errors.Is(err, ErrActionNotAuthorized) {
+		t.Errorf("RequestConnection: want ErrActionNotAuthorized, got %v", err)
+	}
+	if conn.requested != 1 {
+		t.Errorf("want RequestConnection sent once, got %d", conn.requested)
+	}
+
+	// ForceTermination is not listed in the SCPD.
+	if err := ForceTerminationCtx(ctx, conn); !errors.Is(err, ErrInvalidAction) {
+		t.Errorf("ForceTermination: want ErrInvalidAction, got %v", err)
+	}
+	if conn.terminated != 0 {
+		t.Errorf("want ForceTermination not sent, got %d", conn.terminated)
+	}
+}
+
+func TestWaitForConnected(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := newFakeConnectionControl(t)
+	conn.statuses = []string{"Disconnected", "Connecting", "Connected"}
+	if err := WaitForConnected(ctx, conn, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.statuses = []string{"PendingDisconnect", "Disconnected"}
+	shortCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := WaitForConnected(shortCtx, conn, time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, got %v", err)
+	}
+	if want := `last status "Disconnected"`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("want error containing %q, got %v", want, err)
+	}
+}
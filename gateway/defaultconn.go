@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// ParseDefaultConnectionService splits the value returned by the
+// GetDefaultConnectionService action of the Layer3Forwarding service, which
+// is in the form "UDN,serviceId", e.g
+// "uuid:00000000-0000-0000-0000-000000000000,urn:upnp-org:serviceId:WANIPConn1".
+func ParseDefaultConnectionService(s string) (udn, serviceID string, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("gateway: default connection service %q is not in the form UDN,serviceId", s)
+	}
+	udn, serviceID = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if udn == "" || serviceID == "" {
+		return "", "", fmt.Errorf("gateway: default connection service %q is not in the form UDN,serviceId", s)
+	}
+	return udn, serviceID, nil
+}
+
+// DefaultConnectionCtx returns a client for the WAN connection service that
+// the gateway routes internet traffic over, as reported by its
+// Layer3Forwarding service. On gateways with several WAN connections, this is
+// the one to map ports on.
+func DefaultConnectionCtx(ctx context.Context, l3f *internetgateway2.Layer3Forwarding1) (WANConnection, error) {
+	value, err := l3f.GetDefaultConnectionServiceCtx(ctx)
+	if err != nil {
+		return nil, ClassifyFault(err)
+	}
+	udn, serviceID, err := ParseDefaultConnectionService(value)
+	if err != nil {
+		return nil, err
+	}
+	return connectionByID(l3f.ServiceClient, udn, serviceID)
+}
+
+// connectionByID returns a client for the WAN connection service with
+// serviceID, in the device with udn under the root device of sc. Some gateways
+// report a UDN that does not match any of their devices, so the service is
+// then looked for in the whole root device.
+func connectionByID(sc goupnp.ServiceClient, udn, serviceID string) (WANConnection, error) {
+	root := &sc.RootDevice.Device
+	var srv *goupnp.Service
+	root.VisitDevices(func(d *goupnp.Device) {
+		if srv == nil && d.UDN == udn {
+			srv = d.FindServiceByID(serviceID)
+		}
+	})
+	if srv == nil {
+		srv = root.FindServiceByID(serviceID)
+	}
+	if srv == nil {
+		return nil, fmt.Errorf("gateway: default connection service %s,%s not found in %v", udn, serviceID, root)
+	}
+
+	maybe := &goupnp.MaybeRootDevice{
+		Root:      sc.RootDevice,
+		Location:  sc.Location,
+		LocalAddr: sc.LocalAddr(),
+	}
+	conns, err := newWANConnections(maybe, srv.ServiceType)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range conns {
+		if conn == nil || conn.GetServiceClient().Service != srv {
+			continue
+		}
+		// Use the same SOAP settings, such as the transport, as the
+		// Layer3Forwarding client.
+		if sc.SOAPClient != nil {
+			connSC := conn.GetServiceClient()
+			soapClient := *sc.SOAPClient
+			soapClient.EndpointURL = connSC.SOAPClient.EndpointURL
+			connSC.SOAPClient = &soapClient
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("gateway: default connection service %v is not a WAN connection", srv)
+}
@@ -0,0 +1,155 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+const testMultiWANDeviceXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<UDN>uuid:igd</UDN>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:L3Forwarding1</serviceId>
+				<controlURL>/ctl/L3F</controlURL>
+			</service>
+		</serviceList>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<UDN>uuid:wan1</UDN>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<UDN>uuid:wanconn1</UDN>
+						<serviceList>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+								<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+								<controlURL>/ctl/IPConn1</controlURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<UDN>uuid:wan2</UDN>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<UDN>uuid:wanconn2</UDN>
+						<serviceList>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+								<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+								<controlURL>/ctl/PPPConn1</controlURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+func TestParseDefaultConnectionService(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value         string
+		wantUDN, want string
+		wantErr       bool
+	}{
+		{"uuid:wanconn1,urn:upnp-org:serviceId:WANIPConn1", "uuid:wanconn1", "urn:upnp-org:serviceId:WANIPConn1", false},
+		{" uuid:wanconn1 , urn:upnp-org:serviceId:WANIPConn1 ", "uuid:wanconn1", "urn:upnp-org:serviceId:WANIPConn1", false},
+		{"urn:upnp-org:serviceId:WANIPConn1", "", "", true},
+		{"uuid:wanconn1,", "", "", true},
+		{"a,b,c", "", "", true},
+	}
+	for _, test := range tests {
+		udn, serviceID, err := ParseDefaultConnectionService(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: want error, got nil", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if udn != test.wantUDN || serviceID != test.want {
+			t.Errorf("%q: want %q, %q, got %q, %q", test.value, test.wantUDN, test.want, udn, serviceID)
+		}
+	}
+}
+
+func TestDefaultConnection(t *testing.T) {
+	t.Parallel()
+	var defaultService string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<u:GetDefaultConnectionServiceResponse xmlns:u="urn:schemas-upnp-org:service:Layer3Forwarding:1">` +
+			`<NewDefaultConnectionService>` + defaultService + `</NewDefaultConnectionService>` +
+			`</u:GetDefaultConnectionServiceResponse></s:Body></s:Envelope>`))
+	}))
+	defer srv.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := goupnp.ParseRootDevice([]byte(testMultiWANDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := new(countingTransport)
+	clients, err := goupnp.NewServiceClientsFromMaybeRootDevice(&goupnp.MaybeRootDevice{Root: root, Location: loc},
+		internetgateway2.URN_Layer3Forwarding_1, goupnp.WithTransport(transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l3f := &internetgateway2.Layer3Forwarding1{ServiceClient: clients[0]}
+
+	tests := []struct {
+		value    string
+		wantPath string
+	}{
+		// Both connections have the same service ID, in different devices.
+		{"uuid:wanconn2,urn:upnp-org:serviceId:WANIPConn1", "/ctl/PPPConn1"},
+		{"uuid:wanconn1,urn:upnp-org:serviceId:WANIPConn1", "/ctl/IPConn1"},
+		// An unknown UDN falls back to the first service with the ID.
+		{"uuid:wrong,urn:upnp-org:serviceId:WANIPConn1", "/ctl/IPConn1"},
+	}
+	for _, test := range tests {
+		defaultService = test.value
+		conn, err := DefaultConnectionCtx(context.Background(), l3f)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if got := conn.GetServiceClient().Service.ControlURL.URL.Path; got != test.wantPath {
+			t.Errorf("%s: want control URL %s, got %s", test.value, test.wantPath, got)
+		}
+		sc := conn.GetServiceClient()
+		if got := sc.SOAPClient.EndpointURL.Path; got != test.wantPath {
+			t.Errorf("%s: want SOAP endpoint %s, got %s", test.value, test.wantPath, got)
+		}
+		if sc.SOAPClient.HTTPClient.Transport != transport {
+			t.Errorf("%s: want the Layer3Forwarding client's transport, got %v", test.value, sc.SOAPClient.HTTPClient.Transport)
+		}
+	}
+
+	defaultService = "uuid:wanconn1,urn:upnp-org:serviceId:Missing"
+	if _, err := DefaultConnectionCtx(context.Background(), l3f); err == nil {
+		t.Error("want error for unknown service ID, got nil")
+	}
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fsedano/goupnp/dcps/internetgateway1"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// DSLLinkConfig is the set of WANDSLLinkConfig1 methods used to take a
+// DSLLinkStatus. The WANDSLLinkConfig1 clients in both internetgateway1 and
+// internetgateway2 implement it.
+type DSLLinkConfig interface {
+	GetDSLLinkInfoCtx(ctx context.Context) (NewLinkType string, NewLinkStatus string, err error)
+	GetAutoConfigCtx(ctx context.Context) (NewAutoConfig bool, err error)
+	GetModulationTypeCtx(ctx context.Context) (NewModulationType string, err error)
+	GetDestinationAddressCtx(ctx context.Context) (NewDestinationAddress string, err error)
+}
+
+var (
+	_ DSLLinkConfig = &internetgateway1.WANDSLLinkConfig1{}
+	_ DSLLinkConfig = &internetgateway2.WANDSLLinkConfig1{}
+)
+
+// DSLLinkStatus describes the DSL link of a modem, as reported by its
+// WANDSLLinkConfig service.
+type DSLLinkStatus struct {
+	// LinkType is the encapsulation of the link, such as "EoA", "PPPoA",
+	// "IPoA" or "Unconfigured".
+	LinkType string
+	// LinkStatus is "Up", "Down", "Initializing" or "Unavailable".
+	LinkStatus string
+	// AutoConfig is whether the link type is detected automatically.
+	AutoConfig bool
+	// ModulationType is the DSL modulation in use, such as "ADSL_G.dmt".
+	// Empty if the service does not report it.
+	ModulationType string
+	// DestinationAddress is the ATM virtual circuit of the link, typically of
+	// the form "PVC: VPI/VCI". Empty if the service does not report it.
+	DestinationAddress string
+	// VPI and VCI are parsed from DestinationAddress, and are zero if it is
+	// not a PVC.
+	VPI uint8
+	VCI uint16
+}
+
+// Up returns true if the DSL link is up.
+func (s DSLLinkStatus) Up() bool {
+	return s.LinkStatus == "Up"
+}
+
+// DSLLinkStatusCtx queries the status of the DSL link. The modulation type and
+// destination address are optional in the specification, and are left empty
+// if the service does not support them.
+func DSLLinkStatusCtx(ctx context.Context, config DSLLinkConfig) (DSLLinkStatus, error) {
+	var s DSLLinkStatus
+	var err error
+	if s.LinkType, s.LinkStatus, err = config.GetDSLLinkInfoCtx(ctx); err != nil {
+		return DSLLinkStatus{}, ClassifyFault(err)
+	}
+	if s.AutoConfig, err = config.GetAutoConfigCtx(ctx); err != nil {
+		return DSLLinkStatus{}, ClassifyFault(err)
+	}
+	if s.ModulationType, err = config.GetModulationTypeCtx(ctx); err != nil {
+		if err = ClassifyFault(err); !errors.Is(err, ErrInvalidAction) {
+			return DSLLinkStatus{}, err
+		}
+	}
+	if s.DestinationAddress, err = config.GetDestinationAddressCtx(ctx); err != nil {
+		if err = ClassifyFault(err); !errors.Is(err, ErrInvalidAction) {
+			return DSLLinkStatus{}, err
+		}
+	}
+	if vpi, vci, err := ParsePVC(s.DestinationAddress); err == nil {
+		s.VPI, s.VCI = vpi, vci
+	}
+	return s, nil
+}
+
+// ParsePVC parses an ATM permanent virtual circuit address of the form
+// "PVC: VPI/VCI", as given by the DestinationAddress of a WANDSLLinkConfig
+// service. The space after the colon is optional.
+func ParsePVC(addr string) (vpi uint8, vci uint16, err error) {
+	circuit := strings.TrimSpace(addr)
+	if !strings.HasPrefix(strings.ToUpper(circuit), "PVC:") {
+		return 0, 0, fmt.Errorf("gateway: %q is not a PVC address", addr)
+	}
+	circuit = strings.TrimSpace(circuit[len("PVC:"):])
+	i := strings.IndexByte(circuit, '/')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("gateway: PVC address %q has no VCI", addr)
+	}
+	vpi64, err := strconv.ParseUint(strings.TrimSpace(circuit[:i]), 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("gateway: PVC address %q has invalid VPI", addr)
+	}
+	vci64, err := strconv.ParseUint(strings.TrimSpace(circuit[i+1:]), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("gateway: PVC address %q has invalid VCI", addr)
+	}
+	return uint8(vpi64), uint16(vci64), nil
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeDSLLinkConfig struct {
+	modulation    string
+	modulationErr error
+	dest          string
+	destErr       error
+}
+
+func (c *fakeDSLLinkConfig) GetDSLLinkInfoCtx(ctx context.Context) (string, string, error) {
+	return "PPPoA", "Up", nil
+}
+
+func (c *fakeDSLLinkConfig) GetAutoConfigCtx(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (c *fakeDSLLinkConfig) GetModulationTypeCtx(ctx context.Context) (string, error) {
+	return c.modulation, c.modulationErr
+}
+
+func (c *fakeDSLLinkConfig) GetDestinationAddressCtx(ctx context.Context) (string, error) {
+	return c.dest, c.destErr
+}
+
+func TestDSLLinkStatus(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		config  fakeDSLLinkConfig
+		want    DSLLinkStatus
+		wantErr error
+	}{
+		{
+			"full",
+			fakeDSLLinkConfig{modulation: "ADSL_G.dmt", dest: "PVC: 8/35"},
+			DSLLinkStatus{LinkType: "PPPoA", LinkStatus: "Up", AutoConfig: true,
+				ModulationType: "ADSL_G.dmt", DestinationAddress: "PVC: 8/35", VPI: 8, VCI: 35},
+			nil,
+		},
+		{
+			"optional actions unsupported",
+			fakeDSLLinkConfig{modulationErr: upnpFault(401, ""), destErr: upnpFault(401, "")},
+			DSLLinkStatus{LinkType: "PPPoA", LinkStatus: "Up", AutoConfig: true},
+			nil,
+		},
+		{
+			"action failed",
+			fakeDSLLinkConfig{modulationErr: upnpFault(501, "")},
+			DSLLinkStatus{},
+			ErrActionFailed,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := DSLLinkStatusCtx(context.Background(), &test.config)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("want error %v, got %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("want %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePVC(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		addr    string
+		vpi     uint8
+		vci     uint16
+		wantErr bool
+	}{
+		{"PVC: 8/35", 8, 35, false},
+		{"PVC:0/38", 0, 38, false},
+		{" pvc: 1 / 32 ", 1, 32, false},
+		{"SVC: 8/35", 0, 0, true},
+		{"PVC: 8", 0, 0, true},
+		{"PVC: 256/35", 0, 0, true},
+		{"PVC: 8/65536", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, test := range tests {
+		vpi, vci, err := ParsePVC(test.addr)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParsePVC(%q): want error %t, got %v", test.addr, test.wantErr, err)
+			continue
+		}
+		if vpi != test.vpi || vci != test.vci {
+			t.Errorf("ParsePVC(%q): want %d/%d, got %d/%d", test.addr, test.vpi, test.vci, vpi, vci)
+		}
+	}
+}
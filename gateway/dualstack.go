@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// ExternalAddresses are the external addresses of a gateway, by IP version.
+// Either may be nil.
+type ExternalAddresses struct {
+	// IPv4 is the public IPv4 address of the gateway, as reported by its WAN
+	// connection services.
+	IPv4 net.IP
+	// IPv6 is the public IPv6 address reported by a WAN connection service,
+	// which IGD2 gateways may do. Otherwise, if the gateway has the
+	// WANIPv6FirewallControl service, and so routes IPv6 without NAT, it is
+	// the public IPv6 address of this host on the interface that the gateway
+	// was discovered from.
+	IPv6 net.IP
+}
+
+// interfaceAddrs returns the addresses of the host interface with the given
+// address. It is a variable for testing.
+var interfaceAddrs = func(ip net.IP) ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		netAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		var ips []net.IP
+		found := false
+		for _, netAddr := range netAddrs {
+			if addr, ok := netAddr.(*net.IPNet); ok {
+				ips = append(ips, addr.IP)
+				found = found || addr.IP.Equal(ip)
+			}
+		}
+		if found {
+			return ips, nil
+		}
+	}
+	return nil, nil
+}
+
+// ExternalAddressesCtx returns the external addresses of the gateway for both
+// IPv4 and IPv6, for dual-stack hosts. Addresses which are not public are
+// ignored, and an error is only returned if neither address was found.
+func (gw *Gateway) ExternalAddressesCtx(ctx context.Context) (ExternalAddresses, error) {
+	var addrs ExternalAddresses
+	var errs []error
+	for _, conn := range gw.Connections {
+		if addrs.IPv4 != nil && addrs.IPv6 != nil {
+			break
+		}
+		ip, err := externalIP(ctx, conn)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ip.To4() != nil {
+			if addrs.IPv4 == nil {
+				addrs.IPv4 = ip
+			}
+		} else if addrs.IPv6 == nil {
+			addrs.IPv6 = ip
+		}
+	}
+
+	if addrs.IPv6 == nil && gw.Root != nil && gw.LocalAddr != nil &&
+		len(gw.Root.Device.FindService(internetgateway2.URN_WANIPv6FirewallControl_1)) > 0 {
+		ips, err := interfaceAddrs(gw.LocalAddr)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, ip := range ips {
+			if ip.To4() == nil && ip.IsGlobalUnicast() && isPublicIP(ip) {
+				addrs.IPv6 = ip
+				break
+			}
+		}
+	}
+
+	if addrs.IPv4 == nil && addrs.IPv6 == nil {
+		if len(errs) == 0 {
+			return addrs, errors.New("gateway: no external addresses found")
+		}
+		return addrs, joinErrors(errs)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+func TestExternalAddresses(t *testing.T) {
+	// Not parallel, as it replaces interfaceAddrs.
+	oldInterfaceAddrs := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = oldInterfaceAddrs })
+	interfaceAddrs = func(ip net.IP) ([]net.IP, error) {
+		return []net.IP{ip, net.ParseIP("fe80::1"), net.ParseIP("fd00::1"), net.ParseIP("2001:db8::10")}, nil
+	}
+
+	newConn := func(ip string) WANConnection {
+		conn := newFakeWANConnection(t)
+		conn.extIP = ip
+		return conn
+	}
+	newGateway := func(firewall bool, conns ...WANConnection) *Gateway {
+		root := &goupnp.RootDevice{}
+		if firewall {
+			root.Device.Services = []goupnp.Service{{ServiceType: internetgateway2.URN_WANIPv6FirewallControl_1}}
+		}
+		return &Gateway{Root: root, LocalAddr: net.ParseIP("192.168.1.10"), Connections: conns}
+	}
+	tests := []struct {
+		name     string
+		gw       *Gateway
+		wantIPv4 string
+		wantIPv6 string
+		wantErr  bool
+	}{
+		{"ipv4 only", newGateway(false, newConn("203.0.113.7")), "203.0.113.7", "", false},
+		{"reported ipv6", newGateway(true, newConn("203.0.113.7"), newConn("2001:db8::1")), "203.0.113.7", "2001:db8::1", false},
+		{"firewall ipv6", newGateway(true, newConn("203.0.113.7")), "203.0.113.7", "2001:db8::10", false},
+		{"ipv6 only", newGateway(true, newConn("10.0.0.2")), "", "2001:db8::10", false},
+		{"none", newGateway(false, newConn("10.0.0.2")), "", "", true},
+	}
+	for _, test := range tests {
+		got, err := test.gw.ExternalAddressesCtx(context.Background())
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got %+v", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want := net.ParseIP(test.wantIPv4); !want.Equal(got.IPv4) {
+			t.Errorf("%s: want IPv4 %v, got %v", test.name, want, got.IPv4)
+		}
+		if want := net.ParseIP(test.wantIPv6); !want.Equal(got.IPv6) {
+			t.Errorf("%s: want IPv6 %v, got %v", test.name, want, got.IPv6)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/fsedano/goupnp"
+)
+
+// ErrNoGateway is returned when no suitable gateway service was discovered.
+var ErrNoGateway = errors.New("gateway: no internet gateway found")
+
+// nonPublicNets are address ranges which are not reachable from the internet,
+// and so cannot be a gateway's real external address.
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10", // Carrier-grade NAT.
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// isPublicIP reports whether ip is a unicast address reachable from the
+// internet.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExternalIP discovers the internet gateway, and returns its external
+// (internet-facing) IP address. WANIPConnection2, WANIPConnection1 and
+// WANPPPConnection1 services are tried in that order, and the first valid
+// public address is returned.
+//
+// An error is returned if no gateway was found, or if gateways only reported
+// an empty or non-public address - the latter typically means that the gateway
+// is itself behind another NAT, and mapped ports will not be reachable from
+// the internet.
+func ExternalIP(ctx context.Context, opts ...goupnp.DiscoveryOption) (net.IP, error) {
+	conns, discoveryErrs, err := DiscoverWANConnectionsCtx(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(conns) == 0 {
+		if len(discoveryErrs) > 0 {
+			return nil, fmt.Errorf("%w: %v", ErrNoGateway, joinErrors(discoveryErrs))
+		}
+		return nil, ErrNoGateway
+	}
+	return externalIPFrom(ctx, conns)
+}
+
+// ExternalIPByURL returns the external IP address of the gateway whose root
+// device description is at loc, e.g as previously found by ExternalIP or
+// saved from discovery. This is a fast path for clients that poll the address,
+// such as dynamic DNS updaters: only the WAN connection service is decoded
+// from the description (see goupnp.LocateServiceClientCtx), and then only
+// GetExternalIPAddress is called. The service is chosen, and the address
+// checked, as for ExternalIP. An error matching ErrNoGateway is returned if
+// the device has no WAN connection service.
+func ExternalIPByURL(ctx context.Context, loc *url.URL, opts ...goupnp.DiscoveryOption) (net.IP, error) {
+	sc, err := goupnp.LocateServiceClientCtx(ctx, loc, wanConnectionURNs, opts...)
+	if errors.Is(err, goupnp.ErrDeviceNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrNoGateway, err)
+	} else if err != nil {
+		return nil, err
+	}
+	return externalIP(ctx, newWANConnection(*sc))
+}
+
+// externalIPFrom returns the first valid public external address reported by
+// conns.
+func externalIPFrom(ctx context.Context, conns []WANConnection) (net.IP, error) {
+	var errs []error
+	for _, conn := range conns {
+		ip, err := externalIP(ctx, conn)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, joinErrors(errs)
+}
+
+// externalIP returns the external address reported by conn, if it is a valid
+// public address.
+func externalIP(ctx context.Context, conn WANConnection) (net.IP, error) {
+	ip, err := reportedExternalIP(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if !isPublicIP(ip) {
+		return nil, fmt.Errorf("gateway: %v: reported a non-public external IP address %v, it may be behind another NAT",
+			conn.GetServiceClient().Service, ip)
+	}
+	return ip, nil
+}
+
+// reportedExternalIP returns the external address reported by conn, if it is
+// a valid address.
+func reportedExternalIP(ctx context.Context, conn WANConnection) (net.IP, error) {
+	srv := conn.GetServiceClient().Service
+	addr, err := conn.GetExternalIPAddressCtx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gateway: %v: error requesting external IP address: %w", srv, ClassifyFault(err))
+	}
+	return parseExternalIP(srv, addr)
+}
+
+// parseExternalIP parses an external address reported by srv.
+func parseExternalIP(srv *goupnp.Service, addr string) (net.IP, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil, fmt.Errorf("gateway: %v: reported an empty external IP address", srv)
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("gateway: %v: reported an invalid external IP address %q", srv, addr)
+	}
+	return ip, nil
+}
+
+// joinErrors combines errs into a single error. The first error is wrapped.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, len(errs)-1)
+	for i, err := range errs[1:] {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%w; %s", errs[0], strings.Join(msgs, "; "))
+}
@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+)
+
+func TestExternalIPFrom(t *testing.T) {
+	t.Parallel()
+	newConn := func(ip string, err error) WANConnection {
+		conn := newFakeWANConnection(t)
+		conn.extIP = ip
+		conn.extIPErr = err
+		return conn
+	}
+	tests := []struct {
+		name    string
+		conns   []WANConnection
+		want    net.IP
+		wantErr bool
+	}{
+		{"public", []WANConnection{newConn("203.0.113.7", nil)}, net.ParseIP("203.0.113.7"), false},
+		{"public ipv6", []WANConnection{newConn("2001:db8::1", nil)}, net.ParseIP("2001:db8::1"), false},
+		{"private", []WANConnection{newConn("192.168.0.2", nil)}, nil, true},
+		{"cgnat", []WANConnection{newConn("100.64.1.1", nil)}, nil, true},
+		{"empty", []WANConnection{newConn("", nil)}, nil, true},
+		{"garbage", []WANConnection{newConn("not-an-ip", nil)}, nil, true},
+		{"fallback", []WANConnection{
+			newConn("", upnpFault(501, "")),
+			newConn("10.1.1.1", nil),
+			newConn(" 203.0.113.8 ", nil),
+		}, net.ParseIP("203.0.113.8"), false},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := externalIPFrom(context.Background(), test.conns)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("want error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.want.Equal(got) {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+
+	_, err := externalIPFrom(context.Background(), []WANConnection{newConn("", upnpFault(606, ""))})
+	if !errors.Is(err, ErrActionNotAuthorized) {
+		t.Errorf("want ErrActionNotAuthorized, got %v", err)
+	}
+}
+
+// testIGDXML is a gateway description with a WAN connection service in an
+// embedded device, among other devices, services and icons.
+const testIGDXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<friendlyName>Test Router</friendlyName>
+		<UDN>uuid:11111111-2222-3333-4444-555555555555</UDN>
+		<iconList>
+			<icon><mimetype>image/png</mimetype><width>32</width><height>32</height><depth>8</depth><url>/icon32.png</url></icon>
+			<icon><mimetype>image/png</mimetype><width>64</width><height>64</height><depth>8</depth><url>/icon64.png</url></icon>
+		</iconList>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:L3Forwarding1</serviceId>
+				<SCPDURL>/l3f.xml</SCPDURL>
+				<controlURL>/ctl/L3F</controlURL>
+				<eventSubURL>/evt/L3F</eventSubURL>
+			</service>
+		</serviceList>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<UDN>uuid:11111111-2222-3333-4444-666666666666</UDN>
+				<serviceList>
+					<service>
+						<serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+						<serviceId>urn:upnp-org:serviceId:WANCommonIFC1</serviceId>
+						<SCPDURL>/wancic.xml</SCPDURL>
+						<controlURL>/ctl/CmnIfCfg</controlURL>
+						<eventSubURL>/evt/CmnIfCfg</eventSubURL>
+					</service>
+				</serviceList>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<UDN>uuid:11111111-2222-3333-4444-777777777777</UDN>
+						<serviceList>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+								<serviceId>urn:upnp-org:serviceId:WANPPPConn1</serviceId>
+								<SCPDURL>/wanpppc.xml</SCPDURL>
+								<controlURL>/ctl/PPPConn</controlURL>
+								<eventSubURL>/evt/PPPConn</eventSubURL>
+							</service>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+								<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+								<SCPDURL>/wanipc.xml</SCPDURL>
+								<controlURL>/ctl/IPConn</controlURL>
+								<eventSubURL>/evt/IPConn</eventSubURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+// newTestIGD starts a gateway that serves desc, and answers
+// GetExternalIPAddress on /ctl/IPConn with ip. It returns the description
+// location, and a function that returns the number of actions sent to each
+// control URL.
+func newTestIGD(tb testing.TB, desc, ip string) (*url.URL, func() map[string]int) {
+	actions := make(map[string]int)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(desc))
+			return
+		}
+		mu.Lock()
+		actions[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path != "/ctl/IPConn" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` +
+			`<NewExternalIPAddress>` + ip + `</NewExternalIPAddress>` +
+			`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+	}))
+	tb.Cleanup(srv.Close)
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return loc, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		counts := make(map[string]int, len(actions))
+		for path, n := range actions {
+			counts[path] = n
+		}
+		return counts
+	}
+}
+
+func TestExternalIPByURL(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	loc, actions := newTestIGD(t, testIGDXML, "203.0.113.7")
+	ip, err := ExternalIPByURL(ctx, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := net.ParseIP("203.0.113.7"); !want.Equal(ip) {
+		t.Errorf("want %v, got %v", want, ip)
+	}
+	// WANIPConnection1 is preferred to WANPPPConnection1, which comes first.
+	if want := map[string]int{"/ctl/IPConn": 1}; !reflect.DeepEqual(want, actions()) {
+		t.Errorf("want actions %v, got %v", want, actions())
+	}
+
+	privateLoc, _ := newTestIGD(t, testIGDXML, "192.168.0.2")
+	if ip, err := ExternalIPByURL(ctx, privateLoc); err == nil {
+		t.Errorf("want error for private address, got %v", ip)
+	}
+
+	noWANXML := strings.Replace(testIGDXML, "WANIPConnection:1", "WANIPv6FirewallControl:1", 1)
+	noWANXML = strings.Replace(noWANXML, "WANPPPConnection:1", "WANCableLinkConfig:1", 1)
+	noWANLoc, _ := newTestIGD(t, noWANXML, "203.0.113.7")
+	if _, err := ExternalIPByURL(ctx, noWANLoc); !errors.Is(err, ErrNoGateway) {
+		t.Errorf("want ErrNoGateway, got %v", err)
+	}
+}
+
+// externalIPFullPath gets the external IP address of the gateway at loc by
+// fetching the whole description, as ExternalIP does after discovery.
+func externalIPFullPath(ctx context.Context, loc *url.URL) (net.IP, error) {
+	root, err := goupnp.DeviceByURLCtx(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	maybe := &goupnp.MaybeRootDevice{Root: root, Location: loc}
+	for _, urn := range wanConnectionURNs {
+		if conns, err := newWANConnections(maybe, urn); err == nil {
+			return externalIPFrom(ctx, conns)
+		}
+	}
+	return nil, ErrNoGateway
+}
+
+func BenchmarkExternalIP(b *testing.B) {
+	ctx := context.Background()
+	loc, _ := newTestIGD(b, testIGDXML, "203.0.113.7")
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := externalIPFullPath(ctx, loc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("by URL", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ExternalIPByURL(ctx, loc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fsedano/goupnp/soap"
+)
+
+// Fault is a UPnP error returned by an Internet Gateway Device in response to
+// an action. Use errors.Is with the Err* values in this package to check for a
+// specific fault, or errors.As to retrieve the Fault (and the underlying
+// *soap.SOAPFaultError via Unwrap).
+type Fault struct {
+	// Code is the UPnP error code, e.g 718.
+	Code int
+	// Name is the name given to Code by the specification, e.g
+	// "ConflictInMappingEntry".
+	Name string
+	// Description is a human readable explanation of the fault.
+	Description string
+
+	soapErr *soap.SOAPFaultError
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("gateway: UPnP error %d (%s): %s", f.Code, f.Name, f.Description)
+}
+
+// Is reports whether target is a *Fault with the same Code.
+func (f *Fault) Is(target error) bool {
+	t, ok := target.(*Fault)
+	return ok && t.Code == f.Code
+}
+
+func (f *Fault) Unwrap() error {
+	if f.soapErr == nil {
+		return nil
+	}
+	return f.soapErr
+}
+
+// Known UPnP faults returned by Internet Gateway Devices.
+var (
+	ErrInvalidAction                     = &Fault{Code: 401, Name: "InvalidAction", Description: "the service does not support this action"}
+	ErrInvalidArgs                       = &Fault{Code: 402, Name: "InvalidArgs", Description: "invalid arguments"}
+	ErrActionFailed                      = &Fault{Code: 501, Name: "ActionFailed", Description: "the action failed"}
+	ErrActionNotAuthorized               = &Fault{Code: 606, Name: "ActionNotAuthorized", Description: "the gateway does not allow this action, it may be in a read-only mode"}
+	ErrPinholeSpaceExhausted             = &Fault{Code: 701, Name: "PinholeSpaceExhausted", Description: "the gateway has no pinholes available"}
+	ErrFirewallDisabled                  = &Fault{Code: 702, Name: "FirewallDisabled", Description: "the IPv6 firewall is disabled"}
+	ErrInboundPinholeNotAllowed          = &Fault{Code: 703, Name: "InboundPinholeNotAllowed", Description: "the gateway does not allow inbound pinholes"}
+	ErrNoSuchEntry                       = &Fault{Code: 704, Name: "NoSuchEntry", Description: "the specified pinhole does not exist"}
+	ErrProtocolNotSupported              = &Fault{Code: 705, Name: "ProtocolNotSupported", Description: "the protocol is not supported"}
+	ErrInternalPortWildcardingNotAllowed = &Fault{Code: 706, Name: "InternalPortWildcardingNotAllowed", Description: "the internal port must be specified"}
+	ErrProtocolWildcardingNotAllowed     = &Fault{Code: 707, Name: "ProtocolWildcardingNotAllowed", Description: "the protocol must be specified"}
+	ErrInvalidLayer2Address              = &Fault{Code: 708, Name: "InvalidLayer2Address", Description: "the pinhole applies to an invalid layer 2 address"}
+	ErrNoPacketSent                      = &Fault{Code: 709, Name: "NoPacketSent", Description: "no traffic has been sent through the pinhole"}
+	ErrInvalidConnectionType             = &Fault{Code: 710, Name: "InvalidConnectionType", Description: "the connection type does not allow this action"}
+	ErrConnectionAlreadyTerminated       = &Fault{Code: 711, Name: "ConnectionAlreadyTerminated", Description: "the connection is already disconnected"}
+	ErrSpecifiedArrayIndexInvalid        = &Fault{Code: 713, Name: "SpecifiedArrayIndexInvalid", Description: "the specified array index is out of bounds"}
+	ErrNoSuchEntryInArray                = &Fault{Code: 714, Name: "NoSuchEntryInArray", Description: "the specified entry does not exist"}
+	ErrWildCardNotPermittedInSrcIP       = &Fault{Code: 715, Name: "WildCardNotPermittedInSrcIP", Description: "the remote host must be specified"}
+	ErrWildCardNotPermittedInExtPort     = &Fault{Code: 716, Name: "WildCardNotPermittedInExtPort", Description: "the external port must be specified"}
+	ErrConflictInMappingEntry            = &Fault{Code: 718, Name: "ConflictInMappingEntry", Description: "the port mapping conflicts with an existing mapping"}
+	ErrSamePortValuesRequired            = &Fault{Code: 724, Name: "SamePortValuesRequired", Description: "the internal and external ports must be the same"}
+	ErrOnlyPermanentLeasesSupported      = &Fault{Code: 725, Name: "OnlyPermanentLeasesSupported", Description: "the gateway only supports a lease duration of 0"}
+	ErrRemoteHostOnlySupportsWildcard    = &Fault{Code: 726, Name: "RemoteHostOnlySupportsWildcard", Description: "the remote host must be empty"}
+	ErrExternalPortOnlySupportsWildcard  = &Fault{Code: 727, Name: "ExternalPortOnlySupportsWildcard", Description: "the external port must be 0"}
+	ErrNoPortMapsAvailable               = &Fault{Code: 728, Name: "NoPortMapsAvailable", Description: "the gateway has no port mappings available"}
+	ErrConflictWithOtherMechanisms       = &Fault{Code: 729, Name: "ConflictWithOtherMechanisms", Description: "the port mapping conflicts with another mechanism"}
+)
+
+var knownFaults = map[int]*Fault{}
+
+func init() {
+	for _, f := range []*Fault{
+		ErrInvalidAction,
+		ErrInvalidArgs,
+		ErrActionFailed,
+		ErrActionNotAuthorized,
+		ErrPinholeSpaceExhausted,
+		ErrFirewallDisabled,
+		ErrInboundPinholeNotAllowed,
+		ErrNoSuchEntry,
+		ErrProtocolNotSupported,
+		ErrInternalPortWildcardingNotAllowed,
+		ErrProtocolWildcardingNotAllowed,
+		ErrInvalidLayer2Address,
+		ErrNoPacketSent,
+		ErrInvalidConnectionType,
+		ErrConnectionAlreadyTerminated,
+		ErrSpecifiedArrayIndexInvalid,
+		ErrNoSuchEntryInArray,
+		ErrWildCardNotPermittedInSrcIP,
+		ErrWildCardNotPermittedInExtPort,
+		ErrConflictInMappingEntry,
+		ErrSamePortValuesRequired,
+		ErrOnlyPermanentLeasesSupported,
+		ErrRemoteHostOnlySupportsWildcard,
+		ErrExternalPortOnlySupportsWildcard,
+		ErrNoPortMapsAvailable,
+		ErrConflictWithOtherMechanisms,
+	} {
+		knownFaults[f.Code] = f
+	}
+}
+
+// ClassifyFault converts a SOAP fault within err that carries a known UPnP
+// error code into a *Fault. Other errors are returned unchanged.
+func ClassifyFault(err error) error {
+	var soapErr *soap.SOAPFaultError
+	if !errors.As(err, &soapErr) {
+		return err
+	}
+	known, ok := knownFaults[soapErr.Detail.UPnPError.Errorcode]
+	if !ok {
+		return err
+	}
+	f := *known
+	if desc := soapErr.Detail.UPnPError.ErrorDescription; desc != "" && desc != f.Name {
+		f.Description = f.Description + ": " + desc
+	}
+	f.soapErr = soapErr
+	return &f
+}
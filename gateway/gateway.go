@@ -0,0 +1,184 @@
+// Package gateway provides helpers for common tasks with Internet Gateway
+// Devices (typically home routers), built on the clients in
+// github.com/fsedano/goupnp/dcps/internetgateway2.
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway1"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// WANConnection is the set of methods common to the WANIPConnection1,
+// WANIPConnection2 and WANPPPConnection1 clients in internetgateway2, and to
+// the WANIPConnection1 and WANPPPConnection1 clients in internetgateway1. Any
+// of those clients can be used where a WANConnection is required, so that code
+// written against one version of the clients works with the other.
+type WANConnection interface {
+	AddPortMappingCtx(
+		ctx context.Context,
+		NewRemoteHost string,
+		NewExternalPort uint16,
+		NewProtocol string,
+		NewInternalPort uint16,
+		NewInternalClient string,
+		NewEnabled bool,
+		NewPortMappingDescription string,
+		NewLeaseDuration uint32,
+	) (err error)
+
+	DeletePortMappingCtx(
+		ctx context.Context,
+		NewRemoteHost string,
+		NewExternalPort uint16,
+		NewProtocol string,
+	) (err error)
+
+	GetExternalIPAddressCtx(ctx context.Context) (
+		NewExternalIPAddress string,
+		err error,
+	)
+
+	GetServiceClient() *goupnp.ServiceClient
+}
+
+var (
+	_ WANConnection = &internetgateway2.WANIPConnection1{}
+	_ WANConnection = &internetgateway2.WANIPConnection2{}
+	_ WANConnection = &internetgateway2.WANPPPConnection1{}
+	_ WANConnection = &internetgateway1.WANIPConnection1{}
+	_ WANConnection = &internetgateway1.WANPPPConnection1{}
+)
+
+// wanConnectionURNs are the services that implement WANConnection, in order of
+// preference.
+var wanConnectionURNs = []string{
+	internetgateway2.URN_WANIPConnection_2,
+	internetgateway2.URN_WANIPConnection_1,
+	internetgateway2.URN_WANPPPConnection_1,
+}
+
+// DiscoverWANConnectionsCtx discovers all WAN connection services on the
+// network in a single search, and returns clients for them. Clients are
+// ordered by service type: WANIPConnection2, then WANIPConnection1, then
+// WANPPPConnection1. errors will contain an error for any devices that replied
+// but which could not be queried, and err will be set if the discovery process
+// failed outright.
+func DiscoverWANConnectionsCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (clients []WANConnection, errors []error, err error) {
+	var byURN map[string][]goupnp.MaybeRootDevice
+	if byURN, err = goupnp.DiscoverMultiCtx(ctx, wanConnectionURNs, opts...); err != nil {
+		return
+	}
+
+	for _, urn := range wanConnectionURNs {
+		for _, maybe := range byURN[urn] {
+			urnClients, err := newWANConnections(&maybe, urn, opts...)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+			clients = append(clients, urnClients...)
+		}
+	}
+	return
+}
+
+// Gateway is a discovered Internet Gateway Device, with clients for its WAN
+// connection services.
+type Gateway struct {
+	Root     *goupnp.RootDevice
+	Location *url.URL
+	// LocalAddr is the local address that the gateway was discovered from, as
+	// for goupnp.MaybeRootDevice.
+	LocalAddr net.IP
+	// Connections are the WAN connection services of the gateway, ordered as
+	// for DiscoverWANConnectionsCtx.
+	Connections []WANConnection
+}
+
+// DiscoverAllGatewaysCtx discovers all gateways on the network in a single
+// search, for callers that want to choose between them rather than using the
+// first suitable one (as ExternalIP does). Gateways are returned in the order
+// that their most preferred connection service would be returned by
+// DiscoverWANConnectionsCtx. errors will contain an error for any devices that
+// replied but which could not be queried, and err will be set if the
+// discovery process failed outright.
+func DiscoverAllGatewaysCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (gateways []Gateway, errors []error, err error) {
+	var byURN map[string][]goupnp.MaybeRootDevice
+	if byURN, err = goupnp.DiscoverMultiCtx(ctx, wanConnectionURNs, opts...); err != nil {
+		return
+	}
+	gateways, errors = gatewaysFrom(byURN, opts...)
+	return
+}
+
+// gatewaysFrom groups the discovered WAN connection services in byURN by the
+// location of their root device. The clients are configured by opts as for
+// newWANConnections.
+func gatewaysFrom(byURN map[string][]goupnp.MaybeRootDevice, opts ...goupnp.DiscoveryOption) (gateways []Gateway, errors []error) {
+	byLocation := make(map[string]int)
+	failed := make(map[string]bool)
+	for _, urn := range wanConnectionURNs {
+		for _, maybe := range byURN[urn] {
+			var loc string
+			if maybe.Location != nil {
+				loc = maybe.Location.String()
+			}
+			if failed[loc] {
+				continue
+			}
+			conns, err := newWANConnections(&maybe, urn, opts...)
+			if err != nil {
+				// Devices are probed once, so only report each failure once.
+				failed[loc] = true
+				errors = append(errors, err)
+				continue
+			}
+			i, ok := byLocation[loc]
+			if !ok {
+				i = len(gateways)
+				byLocation[loc] = i
+				gateways = append(gateways, Gateway{
+					Root:      maybe.Root,
+					Location:  maybe.Location,
+					LocalAddr: maybe.LocalAddr,
+				})
+			}
+			gateways[i].Connections = append(gateways[i].Connections, conns...)
+		}
+	}
+	return gateways, errors
+}
+
+// newWANConnections creates clients for the services of type urn within the
+// discovered device, with the SOAP settings of opts (see
+// goupnp.NewServiceClientsFromMaybeRootDevice).
+func newWANConnections(maybe *goupnp.MaybeRootDevice, urn string, opts ...goupnp.DiscoveryOption) ([]WANConnection, error) {
+	genericClients, err := goupnp.NewServiceClientsFromMaybeRootDevice(maybe, urn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]WANConnection, len(genericClients))
+	for i, gc := range genericClients {
+		clients[i] = newWANConnection(gc)
+	}
+	return clients, nil
+}
+
+// newWANConnection wraps gc in the internetgateway2 client for its service,
+// which must be one of wanConnectionURNs.
+func newWANConnection(gc goupnp.ServiceClient) WANConnection {
+	switch gc.Service.ServiceType {
+	case internetgateway2.URN_WANIPConnection_2:
+		return &internetgateway2.WANIPConnection2{ServiceClient: gc}
+	case internetgateway2.URN_WANIPConnection_1:
+		return &internetgateway2.WANIPConnection1{ServiceClient: gc}
+	case internetgateway2.URN_WANPPPConnection_1:
+		return &internetgateway2.WANPPPConnection1{ServiceClient: gc}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway1"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+func TestClientsArePortMappingListers(t *testing.T) {
+	t.Parallel()
+	clients := map[string]interface{}{
+		"internetgateway1.WANIPConnection1":  &internetgateway1.WANIPConnection1{},
+		"internetgateway1.WANPPPConnection1": &internetgateway1.WANPPPConnection1{},
+		"internetgateway2.WANIPConnection1":  &internetgateway2.WANIPConnection1{},
+		"internetgateway2.WANIPConnection2":  &internetgateway2.WANIPConnection2{},
+		"internetgateway2.WANPPPConnection1": &internetgateway2.WANPPPConnection1{},
+	}
+	for name, client := range clients {
+		if _, ok := client.(PortMappingLister); !ok {
+			t.Errorf("want %s to implement PortMappingLister", name)
+		}
+	}
+}
+
+func TestGatewaysFrom(t *testing.T) {
+	t.Parallel()
+	newMaybe := func(loc string, localAddr string, urns ...string) goupnp.MaybeRootDevice {
+		u, err := url.Parse(loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srvs []goupnp.Service
+		for _, urn := range urns {
+			srvs = append(srvs, goupnp.Service{ServiceType: urn})
+		}
+		return goupnp.MaybeRootDevice{
+			Root:      &goupnp.RootDevice{Device: goupnp.Device{Services: srvs}},
+			Location:  u,
+			LocalAddr: net.ParseIP(localAddr),
+		}
+	}
+	const (
+		ip1  = internetgateway2.URN_WANIPConnection_1
+		ip2  = internetgateway2.URN_WANIPConnection_2
+		ppp1 = internetgateway2.URN_WANPPPConnection_1
+	)
+	dual := newMaybe("http://192.168.1.1:5000/rootDesc.xml", "192.168.1.10", ip1, ppp1)
+	igd2 := newMaybe("http://10.0.0.1/igd.xml", "10.0.0.5", ip2)
+	broken := newMaybe("http://192.168.2.1/desc.xml", "192.168.2.10")
+	broken.Root, broken.Err = nil, errors.New("probe failed")
+
+	gateways, errs := gatewaysFrom(map[string][]goupnp.MaybeRootDevice{
+		ip1:  {dual, broken},
+		ip2:  {igd2},
+		ppp1: {dual, broken},
+	})
+
+	if len(errs) != 1 {
+		t.Errorf("want 1 error for the broken device, got %v", errs)
+	}
+	if len(gateways) != 2 {
+		t.Fatalf("want 2 gateways, got %d", len(gateways))
+	}
+	tests := []struct {
+		loc       string
+		localAddr string
+		urns      []string
+	}{
+		{"http://10.0.0.1/igd.xml", "10.0.0.5", []string{ip2}},
+		{"http://192.168.1.1:5000/rootDesc.xml", "192.168.1.10", []string{ip1, ppp1}},
+	}
+	for i, test := range tests {
+		gw := gateways[i]
+		if gw.Location.String() != test.loc {
+			t.Errorf("gateway #%d: want location %s, got %s", i, test.loc, gw.Location)
+		}
+		if !gw.LocalAddr.Equal(net.ParseIP(test.localAddr)) {
+			t.Errorf("gateway #%d: want LocalAddr %s, got %v", i, test.localAddr, gw.LocalAddr)
+		}
+		if len(gw.Connections) != len(test.urns) {
+			t.Errorf("gateway #%d: want %d connections, got %d", i, len(test.urns), len(gw.Connections))
+			continue
+		}
+		for j, conn := range gw.Connections {
+			sc := conn.GetServiceClient()
+			if sc.Service.ServiceType != test.urns[j] {
+				t.Errorf("gateway #%d connection #%d: want %s, got %s", i, j, test.urns[j], sc.Service.ServiceType)
+			}
+			if !sc.LocalAddr().Equal(net.ParseIP(test.localAddr)) {
+				t.Errorf("gateway #%d connection #%d: want LocalAddr %s, got %v", i, j, test.localAddr, sc.LocalAddr())
+			}
+		}
+	}
+}
+
+// countingTransport counts the SOAP requests made over http.DefaultTransport.
+type countingTransport struct {
+	mu    sync.Mutex
+	posts int
+}
+
+func (rt *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost {
+		rt.mu.Lock()
+		rt.posts++
+		rt.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (rt *countingTransport) count() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.posts
+}
+
+func TestGatewaysFromTransport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	loc, _ := newTestIGD(t, testIGDXML, "203.0.113.7")
+	root, err := goupnp.DeviceByURLCtx(ctx, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maybe := goupnp.MaybeRootDevice{Root: root, Location: loc}
+	transport := new(countingTransport)
+	gateways, errs := gatewaysFrom(map[string][]goupnp.MaybeRootDevice{
+		internetgateway2.URN_WANIPConnection_1: {maybe},
+	}, goupnp.WithTransport(transport))
+	if len(errs) > 0 || len(gateways) != 1 {
+		t.Fatalf("want 1 gateway, got %d (errors: %v)", len(gateways), errs)
+	}
+	if _, err := externalIPFrom(ctx, gateways[0].Connections); err != nil {
+		t.Fatal(err)
+	}
+	if got := transport.count(); got != 1 {
+		t.Errorf("want 1 SOAP request over the transport, got %d", got)
+	}
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// Protocol is an IANA protocol number, as used for IPv6 firewall pinholes.
+type Protocol uint16
+
+const (
+	ProtocolTCP     Protocol = 6
+	ProtocolUDP     Protocol = 17
+	ProtocolUDPLite Protocol = 136
+	// ProtocolAny matches all protocols, if the gateway allows wildcarding
+	// the protocol.
+	ProtocolAny Protocol = 65535
+)
+
+// maxPinholeLease is the longest lease allowed by WANIPv6FirewallControl.
+const maxPinholeLease = 86400 * time.Second
+
+// Pinhole describes an inbound IPv6 firewall pinhole.
+type Pinhole struct {
+	Protocol Protocol
+	// RemoteHost restricts the pinhole to traffic from the given host. nil
+	// allows traffic from any host.
+	RemoteHost net.IP
+	// RemotePort restricts the pinhole to traffic from the given port. 0
+	// allows traffic from any port.
+	RemotePort uint16
+	// InternalClient is the host to allow traffic to.
+	InternalClient net.IP
+	// InternalPort is the port to allow traffic to.
+	InternalPort uint16
+	// LeaseTime is how long the pinhole lasts for, between 1 second and 24
+	// hours. It is rounded down to a whole number of seconds.
+	LeaseTime time.Duration
+}
+
+// IPv6Firewall manages pinholes in the IPv6 firewall of a gateway, with the
+// WANIPv6FirewallControl service.
+type IPv6Firewall struct {
+	Client *internetgateway2.WANIPv6FirewallControl1
+}
+
+// DiscoverIPv6FirewallsCtx discovers gateways with the WANIPv6FirewallControl
+// service. errors will contain an error for any devices that replied but
+// which could not be queried, and err will be set if the discovery process
+// failed outright.
+func DiscoverIPv6FirewallsCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (firewalls []*IPv6Firewall, errors []error, err error) {
+	var genericClients []goupnp.ServiceClient
+	if genericClients, errors, err = goupnp.NewServiceClientsCtx(ctx, internetgateway2.URN_WANIPv6FirewallControl_1, opts...); err != nil {
+		return
+	}
+	for _, gc := range genericClients {
+		firewalls = append(firewalls, &IPv6Firewall{
+			Client: &internetgateway2.WANIPv6FirewallControl1{ServiceClient: gc},
+		})
+	}
+	return
+}
+
+// AddPinholeCtx opens a pinhole in the firewall, and returns its ID for use
+// with the other methods.
+func (fw *IPv6Firewall) AddPinholeCtx(ctx context.Context, p Pinhole) (id uint16, err error) {
+	if p.InternalClient == nil {
+		return 0, errors.New("gateway: pinhole has no internal client")
+	}
+	if p.LeaseTime < time.Second || p.LeaseTime > maxPinholeLease {
+		return 0, fmt.Errorf("gateway: pinhole lease time %v is outside of the allowed range 1s-24h", p.LeaseTime)
+	}
+	var remoteHost string
+	if p.RemoteHost != nil {
+		remoteHost = p.RemoteHost.String()
+	}
+	id, err = fw.Client.AddPinholeCtx(ctx,
+		remoteHost, p.RemotePort,
+		p.InternalClient.String(), p.InternalPort,
+		uint16(p.Protocol), uint32(p.LeaseTime/time.Second),
+	)
+	return id, ClassifyFault(err)
+}
+
+// DeletePinholeCtx closes the pinhole with the given ID.
+func (fw *IPv6Firewall) DeletePinholeCtx(ctx context.Context, id uint16) error {
+	return ClassifyFault(fw.Client.DeletePinholeCtx(ctx, id))
+}
+
+// GetPinholePacketsCtx returns the number of packets that have gone through
+// the pinhole with the given ID.
+func (fw *IPv6Firewall) GetPinholePacketsCtx(ctx context.Context, id uint16) (uint32, error) {
+	packets, err := fw.Client.GetPinholePacketsCtx(ctx, id)
+	return packets, ClassifyFault(err)
+}
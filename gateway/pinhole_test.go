@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// soapArgs returns the name and arguments of the action in a SOAP request body.
+func soapArgs(body []byte) (string, map[string]string, error) {
+	var envelope struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		return "", nil, err
+	}
+	args := make(map[string]string)
+	for _, arg := range envelope.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+	return envelope.Body.Action.XMLName.Local, args, nil
+}
+
+// newTestFirewall returns an IPv6Firewall for a fake WANIPv6FirewallControl
+// service, which responds to each action with status and response. It also
+// returns a function that returns the arguments of each AddPinhole request.
+func newTestFirewall(t *testing.T, status int, response string) (*IPv6Firewall, func() []map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if action, args, err := soapArgs(body); err == nil && action == "AddPinhole" {
+			mu.Lock()
+			requests = append(requests, args)
+			mu.Unlock()
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &goupnp.RootDevice{Device: goupnp.Device{Services: []goupnp.Service{{
+		ServiceType: internetgateway2.URN_WANIPv6FirewallControl_1,
+		ControlURL:  goupnp.URLField{Str: "/ctl/IP6FCtl"},
+	}}}}
+	root.SetURLBase(loc)
+	clients, err := goupnp.NewServiceClientsFromMaybeRootDevice(&goupnp.MaybeRootDevice{Root: root, Location: loc},
+		internetgateway2.URN_WANIPv6FirewallControl_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := &IPv6Firewall{Client: &internetgateway2.WANIPv6FirewallControl1{ServiceClient: clients[0]}}
+	return fw, func() []map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]string(nil), requests...)
+	}
+}
+
+const testAddPinholeResponse = `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+	`<u:AddPinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">` +
+	`<UniqueID>42</UniqueID>` +
+	`</u:AddPinholeResponse></s:Body></s:Envelope>`
+
+func TestAddPinhole(t *testing.T) {
+	t.Parallel()
+	client := net.ParseIP("2001:db8::10")
+	tests := []struct {
+		name     string
+		pinhole  Pinhole
+		wantArgs map[string]string // nil if the pinhole is rejected.
+	}{
+		{
+			name: "tcp from host",
+			pinhole: Pinhole{
+				Protocol:       ProtocolTCP,
+				RemoteHost:     net.ParseIP("2001:db8::2"),
+				RemotePort:     443,
+				InternalClient: client,
+				InternalPort:   8443,
+				LeaseTime:      time.Hour,
+			},
+			wantArgs: map[string]string{
+				"RemoteHost":     "2001:db8::2",
+				"RemotePort":     "443",
+				"InternalClient": "2001:db8::10",
+				"InternalPort":   "8443",
+				"Protocol":       "6",
+				"LeaseTime":      "3600",
+			},
+		},
+		{
+			name:    "udp from anywhere",
+			pinhole: Pinhole{Protocol: ProtocolUDP, InternalClient: client, InternalPort: 5353, LeaseTime: 90500 * time.Millisecond},
+			wantArgs: map[string]string{
+				"RemoteHost":     "",
+				"RemotePort":     "0",
+				"InternalClient": "2001:db8::10",
+				"InternalPort":   "5353",
+				"Protocol":       "17",
+				"LeaseTime":      "90",
+			},
+		},
+		{
+			name:    "any protocol, longest lease",
+			pinhole: Pinhole{Protocol: ProtocolAny, InternalClient: client, InternalPort: 22, LeaseTime: 24 * time.Hour},
+			wantArgs: map[string]string{
+				"RemoteHost":     "",
+				"RemotePort":     "0",
+				"InternalClient": "2001:db8::10",
+				"InternalPort":   "22",
+				"Protocol":       "65535",
+				"LeaseTime":      "86400",
+			},
+		},
+		{name: "zero lease", pinhole: Pinhole{Protocol: ProtocolTCP, InternalClient: client, InternalPort: 22}},
+		{name: "sub-second lease", pinhole: Pinhole{Protocol: ProtocolTCP, InternalClient: client, InternalPort: 22, LeaseTime: 500 * time.Millisecond}},
+		{name: "lease over 24h", pinhole: Pinhole{Protocol: ProtocolTCP, InternalClient: client, InternalPort: 22, LeaseTime: 24*time.Hour + time.Second}},
+		{name: "no internal client", pinhole: Pinhole{Protocol: ProtocolTCP, InternalPort: 22, LeaseTime: time.Hour}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			fw, requests := newTestFirewall(t, http.StatusOK, testAddPinholeResponse)
+			id, err := fw.AddPinholeCtx(context.Background(), test.pinhole)
+			if test.wantArgs == nil {
+				if err == nil {
+					t.Error("want error, got nil")
+				}
+				if got := requests(); len(got) != 0 {
+					t.Errorf("want no request sent, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != 42 {
+				t.Errorf("want ID 42, got %d", id)
+			}
+			got := requests()
+			if len(got) != 1 {
+				t.Fatalf("want 1 request, got %d", len(got))
+			}
+			if !reflect.DeepEqual(test.wantArgs, got[0]) {
+				t.Errorf("want arguments %v, got %v", test.wantArgs, got[0])
+			}
+		})
+	}
+}
+
+func TestAddPinholeFault(t *testing.T) {
+	t.Parallel()
+	fw, _ := newTestFirewall(t, http.StatusInternalServerError,
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+			`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+			`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`+
+			`<errorCode>702</errorCode><errorDescription>FirewallDisabled</errorDescription>`+
+			`</UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+	_, err := fw.AddPinholeCtx(context.Background(), Pinhole{
+		Protocol:       ProtocolTCP,
+		InternalClient: net.ParseIP("2001:db8::10"),
+		InternalPort:   22,
+		LeaseTime:      time.Hour,
+	})
+	if !errors.Is(err, ErrFirewallDisabled) {
+		t.Errorf("want ErrFirewallDisabled, got %v", err)
+	}
+}
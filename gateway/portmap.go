@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+)
+
+const (
+	probeDescription   = "goupnp port mapping probe"
+	probeLeaseDuration = 60
+	// Probe ports are picked from the dynamic/private range.
+	probePortMin = 49152
+	probePortMax = 65535
+)
+
+// CanMapPorts checks whether the gateway allows port mappings to be added,
+// by adding a harmless short-lived TCP mapping to the host and then removing
+// it again. Many gateways advertise WAN connection services but reject all
+// mappings, for example with ErrActionNotAuthorized.
+//
+// It returns true if the test mapping was added. Otherwise it returns false
+// and an error describing why; faults with known UPnP error codes are
+// returned as a *Fault, for use with errors.Is. If the test mapping was added
+// but could not be removed, true is returned along with the error.
+func CanMapPorts(ctx context.Context, conn WANConnection) (bool, error) {
+	localAddr := conn.GetServiceClient().LocalAddr()
+	if localAddr == nil {
+		return false, errors.New("gateway: the local address to map ports to is unknown")
+	}
+	internalClient := localAddr.String()
+
+	var lease uint32 = probeLeaseDuration
+	var err error
+	// Try a second time with a different port in case the first conflicted
+	// with a real mapping, or with a permanent lease if the gateway requires
+	// it.
+	for attempt := 0; attempt < 2; attempt++ {
+		port := uint16(probePortMin + rand.Intn(probePortMax-probePortMin+1))
+		err = ClassifyFault(conn.AddPortMappingCtx(ctx, "", port, "TCP", port, internalClient, true, probeDescription, lease))
+		if err == nil {
+			if err := conn.DeletePortMappingCtx(ctx, "", port, "TCP"); err != nil {
+				return true, ClassifyFault(err)
+			}
+			return true, nil
+		}
+		switch {
+		case errors.Is(err, ErrOnlyPermanentLeasesSupported):
+			lease = 0
+		case errors.Is(err, ErrConflictInMappingEntry):
+		default:
+			return false, err
+		}
+	}
+	return false, err
+}
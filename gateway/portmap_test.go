@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/soap"
+)
+
+type fakeWANConnection struct {
+	WANConnection
+	client     *goupnp.ServiceClient
+	addErrs    []error
+	added      []uint16
+	addLeases  []uint32
+	deleted    []uint16
+	extIP      string
+	extIPErr   error
+	deleteErrs []error
+}
+
+func newFakeWANConnection(t *testing.T) *fakeWANConnection {
+	t.Helper()
+	const urn = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	maybe := &goupnp.MaybeRootDevice{
+		Root: &goupnp.RootDevice{
+			Device: goupnp.Device{Services: []goupnp.Service{{ServiceType: urn}}},
+		},
+		LocalAddr: net.ParseIP("192.168.1.10"),
+	}
+	clients, err := goupnp.NewServiceClientsFromMaybeRootDevice(maybe, urn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeWANConnection{client: &clients[0]}
+}
+
+func (c *fakeWANConnection) GetServiceClient() *goupnp.ServiceClient {
+	return c.client
+}
+
+func (c *fakeWANConnection) AddPortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string, internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32) error {
+	c.added = append(c.added, externalPort)
+	c.addLeases = append(c.addLeases, leaseDuration)
+	if len(c.addErrs) == 0 {
+		return nil
+	}
+	err := c.addErrs[0]
+	c.addErrs = c.addErrs[1:]
+	return err
+}
+
+func (c *fakeWANConnection) DeletePortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string) error {
+	c.deleted = append(c.deleted, externalPort)
+	if len(c.deleteErrs) == 0 {
+		return nil
+	}
+	err := c.deleteErrs[0]
+	c.deleteErrs = c.deleteErrs[1:]
+	return err
+}
+
+func (c *fakeWANConnection) GetExternalIPAddressCtx(ctx context.Context) (string, error) {
+	return c.extIP, c.extIPErr
+}
+
+func upnpFault(code int, desc string) error {
+	f := &soap.SOAPFaultError{FaultCode: "s:Client", FaultString: "UPnPError"}
+	f.Detail.UPnPError.Errorcode = code
+	f.Detail.UPnPError.ErrorDescription = desc
+	return f
+}
+
+func TestCanMapPorts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		addErrs    []error
+		want       bool
+		wantErr    error
+		wantLeases []uint32
+	}{
+		{"ok", nil, true, nil, []uint32{probeLeaseDuration}},
+		{"not authorized", []error{upnpFault(606, "Action not authorized")}, false, ErrActionNotAuthorized, []uint32{probeLeaseDuration}},
+		{"conflict then ok", []error{upnpFault(718, "ConflictInMappingEntry")}, true, nil, []uint32{probeLeaseDuration, probeLeaseDuration}},
+		{"always conflict", []error{upnpFault(718, ""), upnpFault(718, "")}, false, ErrConflictInMappingEntry, []uint32{probeLeaseDuration, probeLeaseDuration}},
+		{"permanent only", []error{upnpFault(725, "")}, true, nil, []uint32{probeLeaseDuration, 0}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			conn := newFakeWANConnection(t)
+			conn.addErrs = test.addErrs
+			got, err := CanMapPorts(context.Background(), conn)
+			if got != test.want {
+				t.Errorf("want %t, got %t", test.want, got)
+			}
+			if test.wantErr == nil && err != nil {
+				t.Errorf("want no error, got %v", err)
+			} else if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("want error %v, got %v", test.wantErr, err)
+			}
+			if len(conn.addLeases) != len(test.wantLeases) {
+				t.Fatalf("want %d add attempts, got %d", len(test.wantLeases), len(conn.addLeases))
+			}
+			for i := range test.wantLeases {
+				if conn.addLeases[i] != test.wantLeases[i] {
+					t.Errorf("attempt %d: want lease %d, got %d", i+1, test.wantLeases[i], conn.addLeases[i])
+				}
+			}
+			if got {
+				if len(conn.deleted) != 1 || conn.deleted[0] != conn.added[len(conn.added)-1] {
+					t.Errorf("want mapping %d deleted, got %v", conn.added[len(conn.added)-1], conn.deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyFault(t *testing.T) {
+	t.Parallel()
+	err := ClassifyFault(upnpFault(606, "Action not authorized"))
+	if !errors.Is(err, ErrActionNotAuthorized) {
+		t.Fatalf("want ErrActionNotAuthorized, got %v", err)
+	}
+	var soapErr *soap.SOAPFaultError
+	if !errors.As(err, &soapErr) {
+		t.Error("want classified fault to wrap *soap.SOAPFaultError")
+	}
+
+	unknown := upnpFault(899, "Vendor specific")
+	if got := ClassifyFault(unknown); got != unknown {
+		t.Errorf("want unknown fault returned unchanged, got %v", got)
+	}
+	other := errors.New("network down")
+	if got := ClassifyFault(other); got != other {
+		t.Errorf("want non-fault error returned unchanged, got %v", got)
+	}
+}
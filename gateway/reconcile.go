@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fsedano/goupnp/dcps/internetgateway1"
+	"github.com/fsedano/goupnp/dcps/internetgateway2"
+)
+
+// PortMappingLister is a WANConnection that can also enumerate its port
+// mappings. All of the clients that implement WANConnection implement
+// PortMappingLister, including those in internetgateway1, so it covers the
+// port mapping actions common to both versions of the clients.
+type PortMappingLister interface {
+	WANConnection
+
+	GetGenericPortMappingEntryCtx(
+		ctx context.Context,
+		NewPortMappingIndex uint16,
+	) (NewRemoteHost string, NewExternalPort uint16, NewProtocol string, NewInternalPort uint16, NewInternalClient string, NewEnabled bool, NewPortMappingDescription string, NewLeaseDuration uint32, err error)
+}
+
+var (
+	_ PortMappingLister = &internetgateway2.WANIPConnection1{}
+	_ PortMappingLister = &internetgateway2.WANIPConnection2{}
+	_ PortMappingLister = &internetgateway2.WANPPPConnection1{}
+	_ PortMappingLister = &internetgateway1.WANIPConnection1{}
+	_ PortMappingLister = &internetgateway1.WANPPPConnection1{}
+)
+
+// PortMapping describes a port mapping of a WAN connection.
+type PortMapping struct {
+	// RemoteHost restricts the mapping to traffic from the given host. Empty
+	// allows traffic from any host.
+	RemoteHost   string
+	ExternalPort uint16
+	// Protocol is "TCP" or "UDP".
+	Protocol       string
+	InternalPort   uint16
+	InternalClient string
+	Enabled        bool
+	Description    string
+	// LeaseDuration is how long the mapping lasts for, or (for existing
+	// mappings) how long it has left. Zero means it is permanent. It is
+	// rounded down to a whole number of seconds.
+	LeaseDuration time.Duration
+}
+
+// key identifies the mapping within a connection.
+func (m PortMapping) key() string {
+	return fmt.Sprintf("%s/%s/%d", strings.ToUpper(m.Protocol), m.RemoteHost, m.ExternalPort)
+}
+
+func (m PortMapping) String() string {
+	return fmt.Sprintf("%s %d -> %s:%d", strings.ToUpper(m.Protocol), m.ExternalPort, m.InternalClient, m.InternalPort)
+}
+
+// ListPortMappingsCtx returns all of the port mappings of the connection.
+func ListPortMappingsCtx(ctx context.Context, conn PortMappingLister) ([]PortMapping, error) {
+	var mappings []PortMapping
+	for i := 0; i <= 0xffff; i++ {
+		remoteHost, extPort, protocol, intPort, intClient, enabled, desc, lease, err := conn.GetGenericPortMappingEntryCtx(ctx, uint16(i))
+		if err != nil {
+			err = ClassifyFault(err)
+			// The end of the list is reported as an invalid index, although
+			// some gateways use other faults.
+			if errors.Is(err, ErrSpecifiedArrayIndexInvalid) || errors.Is(err, ErrNoSuchEntryInArray) ||
+				(i > 0 && (errors.Is(err, ErrInvalidArgs) || errors.Is(err, ErrActionFailed))) {
+				break
+			}
+			return nil, fmt.Errorf("gateway: error listing port mapping #%d: %w", i, err)
+		}
+		mappings = append(mappings, PortMapping{
+			RemoteHost:     remoteHost,
+			ExternalPort:   extPort,
+			Protocol:       protocol,
+			InternalPort:   intPort,
+			InternalClient: intClient,
+			Enabled:        enabled,
+			Description:    desc,
+			LeaseDuration:  time.Duration(lease) * time.Second,
+		})
+	}
+	return mappings, nil
+}
+
+// PortMappingError is an error applying a change to a port mapping.
+type PortMappingError struct {
+	// Op is "add" or "delete".
+	Op      string
+	Mapping PortMapping
+	Err     error
+}
+
+func (err *PortMappingError) Error() string {
+	return fmt.Sprintf("gateway: failed to %s port mapping %v: %v", err.Op, err.Mapping, err.Err)
+}
+
+func (err *PortMappingError) Unwrap() error {
+	return err.Err
+}
+
+// ReconcileError is returned by ReconcilePortMappings when some of the changes
+// failed. The first error is wrapped.
+type ReconcileError struct {
+	Errors []*PortMappingError
+}
+
+func (err *ReconcileError) Error() string {
+	msgs := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (err *ReconcileError) Unwrap() error {
+	return err.Errors[0]
+}
+
+// ReconcilePortMappings makes the port mappings of the connection match
+// desired, with the fewest changes: mappings that are missing are added,
+// mappings that differ are replaced, and stale mappings are deleted. Existing
+// mappings match if they differ only in their remaining LeaseDuration, unless
+// less than half of the desired LeaseDuration remains, or the desired mapping
+// is permanent and the existing one is not, in which case they are added again
+// to renew the lease. This lets applications that reconcile periodically keep
+// leased mappings from expiring.
+//
+// Only mappings to the internal clients of desired, or to the local address
+// of the connection (which is also used for desired mappings without an
+// InternalClient), are considered stale; mappings to other hosts are left
+// alone.
+//
+// Every change is attempted even if some fail, in which case a
+// *ReconcileError listing the failures is returned.
+func ReconcilePortMappings(ctx context.Context, conn PortMappingLister, desired []PortMapping) error {
+	existing, err := ListPortMappingsCtx(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	managed := make(map[string]bool)
+	var localAddr string
+	if ip := conn.GetServiceClient().LocalAddr(); ip != nil {
+		localAddr = ip.String()
+		managed[localAddr] = true
+	}
+	want := make(map[string]PortMapping, len(desired))
+	for _, m := range desired {
+		if m.InternalClient == "" {
+			if localAddr == "" {
+				return errors.New("gateway: the local address to map ports to is unknown")
+			}
+			m.InternalClient = localAddr
+		}
+		managed[m.InternalClient] = true
+		want[m.key()] = m
+	}
+
+	var failures []*PortMappingError
+	have := make(map[string]bool, len(existing))
+	for _, m := range existing {
+		w, ok := want[m.key()]
+		if ok && samePortMapping(w, m) {
+			// Adding the mapping again, in the loop below, renews its
+			// lease without deleting it first.
+			if !needsRenewal(w, m) {
+				have[m.key()] = true
+			}
+			continue
+		}
+		if !ok && !managed[m.InternalClient] {
+			continue
+		}
+		if err := conn.DeletePortMappingCtx(ctx, m.RemoteHost, m.ExternalPort, m.Protocol); err != nil {
+			failures = append(failures, &PortMappingError{Op: "delete", Mapping: m, Err: ClassifyFault(err)})
+		}
+	}
+	for _, m := range desired {
+		key := m.key()
+		if have[key] {
+			continue
+		}
+		m = want[key]
+		err := conn.AddPortMappingCtx(ctx, m.RemoteHost, m.ExternalPort, strings.ToUpper(m.Protocol),
+			m.InternalPort, m.InternalClient, m.Enabled, m.Description, uint32(m.LeaseDuration/time.Second))
+		if err != nil {
+			failures = append(failures, &PortMappingError{Op: "add", Mapping: m, Err: ClassifyFault(err)})
+		}
+		// Only add each mapping once, if desired has duplicates.
+		have[key] = true
+	}
+
+	if len(failures) > 0 {
+		return &ReconcileError{Errors: failures}
+	}
+	return nil
+}
+
+// samePortMapping reports whether the mappings are the same, other than their
+// lease durations.
+func samePortMapping(a, b PortMapping) bool {
+	return a.key() == b.key() &&
+		a.InternalPort == b.InternalPort &&
+		a.InternalClient == b.InternalClient &&
+		a.Enabled == b.Enabled &&
+		a.Description == b.Description
+}
+
+// needsRenewal reports whether the lease of the existing mapping should be
+// renewed to match the desired one: if less than half of the desired lease
+// remains, or if the desired mapping is permanent and the existing one is not.
+func needsRenewal(desired, existing PortMapping) bool {
+	if desired.LeaseDuration == 0 {
+		return existing.LeaseDuration != 0
+	}
+	return existing.LeaseDuration != 0 && existing.LeaseDuration < desired.LeaseDuration/2
+}
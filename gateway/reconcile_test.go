@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeMappingConnection is a PortMappingLister with a table of mappings.
+type fakeMappingConnection struct {
+	*fakeWANConnection
+	mappings []PortMapping
+	// failPorts are external ports for which changes fail.
+	failPorts map[uint16]bool
+	ops       []string
+}
+
+func (c *fakeMappingConnection) GetGenericPortMappingEntryCtx(ctx context.Context, index uint16) (string, uint16, string, uint16, string, bool, string, uint32, error) {
+	if int(index) >= len(c.mappings) {
+		return "", 0, "", 0, "", false, "", 0, upnpFault(713, "")
+	}
+	m := c.mappings[index]
+	return m.RemoteHost, m.ExternalPort, m.Protocol, m.InternalPort, m.InternalClient, m.Enabled, m.Description, uint32(m.LeaseDuration / time.Second), nil
+}
+
+func (c *fakeMappingConnection) AddPortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string, internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32) error {
+	c.ops = append(c.ops, "add "+PortMapping{ExternalPort: externalPort, Protocol: protocol, InternalClient: internalClient, InternalPort: internalPort}.String())
+	if c.failPorts[externalPort] {
+		return upnpFault(718, "")
+	}
+	m := PortMapping{remoteHost, externalPort, protocol, internalPort, internalClient, enabled, description, time.Duration(leaseDuration) * time.Second}
+	// Adding an existing mapping again replaces it, renewing its lease.
+	for i := range c.mappings {
+		if c.mappings[i].key() == m.key() {
+			c.mappings[i] = m
+			return nil
+		}
+	}
+	c.mappings = append(c.mappings, m)
+	return nil
+}
+
+func (c *fakeMappingConnection) DeletePortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string) error {
+	c.ops = append(c.ops, "delete "+PortMapping{ExternalPort: externalPort, Protocol: protocol}.key())
+	if c.failPorts[externalPort] {
+		return upnpFault(606, "")
+	}
+	for i, m := range c.mappings {
+		if m.key() == (PortMapping{RemoteHost: remoteHost, ExternalPort: externalPort, Protocol: protocol}).key() {
+			c.mappings = append(c.mappings[:i], c.mappings[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestListPortMappings(t *testing.T) {
+	t.Parallel()
+	conn := &fakeMappingConnection{fakeWANConnection: newFakeWANConnection(t), mappings: []PortMapping{
+		{"", 8080, "TCP", 80, "192.168.1.10", true, "web", time.Hour},
+		{"", 5000, "UDP", 5000, "192.168.1.20", false, "game", 0},
+	}}
+	got, err := ListPortMappingsCtx(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conn.mappings, got) {
+		t.Errorf("want %+v, got %+v", conn.mappings, got)
+	}
+}
+
+func TestReconcilePortMappings(t *testing.T) {
+	t.Parallel()
+	const local = "192.168.1.10"
+	conn := &fakeMappingConnection{
+		fakeWANConnection: newFakeWANConnection(t),
+		mappings: []PortMapping{
+			// Matches, but with some of its lease used.
+			{"", 8080, "TCP", 80, local, true, "web", 30 * time.Minute},
+			// Differs in its internal port.
+			{"", 2222, "TCP", 22, local, true, "ssh", 0},
+			// Stale.
+			{"", 6000, "UDP", 6000, local, true, "old", 0},
+			// Another host's mapping, which is left alone.
+			{"", 5000, "UDP", 5000, "192.168.1.20", true, "game", 0},
+			// Stale, but can't be deleted.
+			{"", 7000, "TCP", 7000, local, true, "stuck", 0},
+		},
+		failPorts: map[uint16]bool{7000: true, 9000: true},
+	}
+	desired := []PortMapping{
+		{ExternalPort: 8080, Protocol: "tcp", InternalPort: 80, Enabled: true, Description: "web", LeaseDuration: time.Hour},
+		{ExternalPort: 2222, Protocol: "TCP", InternalPort: 2222, Enabled: true, Description: "ssh"},
+		{ExternalPort: 4000, Protocol: "UDP", InternalPort: 4000, InternalClient: local, Enabled: true, Description: "new"},
+		{ExternalPort: 9000, Protocol: "TCP", InternalPort: 9000, Enabled: true, Description: "fails"},
+	}
+	err := ReconcilePortMappings(context.Background(), conn, desired)
+
+	var reconcileErr *ReconcileError
+	if !errors.As(err, &reconcileErr) {
+		t.Fatalf("want *ReconcileError, got %v", err)
+	}
+	var failed []string
+	for _, e := range reconcileErr.Errors {
+		failed = append(failed, e.Op+" "+e.Mapping.key())
+	}
+	if want := []string{"delete TCP//7000", "add TCP//9000"}; !reflect.DeepEqual(want, failed) {
+		t.Errorf("want failures %v, got %v", want, failed)
+	}
+	if !errors.Is(err, ErrActionNotAuthorized) {
+		t.Errorf("want first failure to be ErrActionNotAuthorized, got %v", err)
+	}
+
+	wantOps := []string{
+		"delete TCP//2222",
+		"delete UDP//6000",
+		"delete TCP//7000",
+		"add TCP 2222 -> 192.168.1.10:2222",
+		"add UDP 4000 -> 192.168.1.10:4000",
+		"add TCP 9000 -> 192.168.1.10:9000",
+	}
+	if !reflect.DeepEqual(wantOps, conn.ops) {
+		t.Errorf("want operations %q, got %q", wantOps, conn.ops)
+	}
+
+	var keys []string
+	for _, m := range conn.mappings {
+		keys = append(keys, m.key())
+	}
+	sort.Strings(keys)
+	if want := []string{"TCP//2222", "TCP//7000", "TCP//8080", "UDP//4000", "UDP//5000"}; !reflect.DeepEqual(want, keys) {
+		t.Errorf("want mappings %v, got %v", want, keys)
+	}
+}
+
+func TestReconcilePortMappingsRenewsLeases(t *testing.T) {
+	t.Parallel()
+	const local = "192.168.1.10"
+	tests := []struct {
+		name      string
+		desired   time.Duration
+		remaining time.Duration
+		renewed   bool
+	}{
+		{"most of lease left", time.Hour, 40 * time.Minute, false},
+		{"under half of lease left", time.Hour, 10 * time.Minute, true},
+		{"leased, but permanent desired", 0, time.Hour, true},
+		{"permanent, but leased desired", time.Hour, 0, false},
+		{"permanent", 0, 0, false},
+	}
+	for _, test := range tests {
+		conn := &fakeMappingConnection{
+			fakeWANConnection: newFakeWANConnection(t),
+			mappings:          []PortMapping{{"", 8080, "TCP", 80, local, true, "web", test.remaining}},
+		}
+		desired := []PortMapping{{ExternalPort: 8080, Protocol: "TCP", InternalPort: 80, Enabled: true, Description: "web", LeaseDuration: test.desired}}
+		if err := ReconcilePortMappings(context.Background(), conn, desired); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var wantOps []string
+		wantLease := test.remaining
+		if test.renewed {
+			wantOps = []string{"add TCP 8080 -> 192.168.1.10:80"}
+			wantLease = test.desired
+		}
+		if !reflect.DeepEqual(wantOps, conn.ops) {
+			t.Errorf("%s: want operations %q, got %q", test.name, wantOps, conn.ops)
+		}
+		if len(conn.mappings) != 1 || conn.mappings[0].LeaseDuration != wantLease {
+			t.Errorf("%s: want one mapping with lease %v, got %+v", test.name, wantLease, conn.mappings)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fsedano/goupnp"
+)
+
+// DefaultWatchPollInterval is how often WatchExternalIP polls the external
+// address of a connection that cannot send events for it.
+const DefaultWatchPollInterval = 5 * time.Minute
+
+// watchSubscriptionTimeout is the subscription duration requested by
+// WatchExternalIP. Subscriptions are renewed halfway through the duration
+// granted.
+const watchSubscriptionTimeout = 30 * time.Minute
+
+// WatchExternalIP watches the external IP address of the connection, as for a
+// dynamic DNS updater. The current address is sent on the returned channel,
+// followed by each new address as it changes. The channel is closed once ctx
+// is done.
+//
+// WatchExternalIP subscribes to events from the connection service, renewing
+// the subscription as needed, and receives them on the local address that the
+// gateway was discovered from. If the service does not event the
+// ExternalIPAddress variable, or the subscription fails or is lost, the
+// address is polled every poll instead (DefaultWatchPollInterval if zero).
+//
+// An error is returned if the current address cannot be requested. Later
+// errors are not reported, and empty or invalid addresses are ignored.
+// Unlike ExternalIP, non-public addresses are sent.
+func WatchExternalIP(ctx context.Context, conn WANConnection, poll time.Duration) (<-chan net.IP, error) {
+	if poll <= 0 {
+		poll = DefaultWatchPollInterval
+	}
+	ip, err := reportedExternalIP(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	w := &ipWatcher{
+		conn:   conn,
+		poll:   poll,
+		last:   ip,
+		out:    make(chan net.IP),
+		events: make(chan *goupnp.Event),
+	}
+	sub := w.subscribe(ctx)
+	go w.run(ctx, sub)
+	return w.out, nil
+}
+
+// ipWatcher is the state of WatchExternalIP.
+type ipWatcher struct {
+	conn WANConnection
+	poll time.Duration
+	last net.IP
+	out  chan net.IP
+
+	// server receives events at callback, and sends them on events. It is
+	// started by the first subscription attempt.
+	server   *http.Server
+	callback *url.URL
+	events   chan *goupnp.Event
+}
+
+// subscribe subscribes to events from the connection service, returning nil
+// if it cannot.
+func (w *ipWatcher) subscribe(ctx context.Context) *goupnp.Subscription {
+	if w.server == nil {
+		if err := w.listen(ctx); err != nil {
+			return nil
+		}
+	}
+	sub, err := w.conn.GetServiceClient().SubscribeCtx(ctx, []*url.URL{w.callback}, watchSubscriptionTimeout)
+	if err != nil {
+		return nil
+	}
+	return sub
+}
+
+// listen starts the server for events, if the connection service can send
+// them.
+func (w *ipWatcher) listen(ctx context.Context) error {
+	sc := w.conn.GetServiceClient()
+	if !sc.Service.EventSubURL.Ok {
+		return errors.New("gateway: service has no event subscription URL")
+	}
+	// The SCPD is optional, it only rules out services that are known not to
+	// event the variable.
+	if s, err := sc.SCPDCtx(ctx); err == nil {
+		if v := s.GetStateVariable("ExternalIPAddress"); v != nil && !v.IsEvented() {
+			return errors.New("gateway: service does not event ExternalIPAddress")
+		}
+	}
+	localAddr := sc.LocalAddr()
+	if localAddr == nil {
+		return errors.New("gateway: no local address to receive events on")
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(localAddr.String(), "0"))
+	if err != nil {
+		return err
+	}
+	w.callback = &url.URL{Scheme: "http", Host: ln.Addr().String(), Path: "/"}
+	w.server = &http.Server{Handler: http.HandlerFunc(w.serveEvent)}
+	go w.server.Serve(ln)
+	return nil
+}
+
+// serveEvent handles an event notification from the connection service.
+func (w *ipWatcher) serveEvent(rw http.ResponseWriter, req *http.Request) {
+	event, err := goupnp.ParseEvent(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	select {
+	case w.events <- event:
+	case <-req.Context().Done():
+	}
+}
+
+// run sends address changes to out until ctx is done, receiving events for
+// sub, or polling if sub is nil.
+func (w *ipWatcher) run(ctx context.Context, sub *goupnp.Subscription) {
+	defer close(w.out)
+	defer func() {
+		if sub != nil {
+			sub.UnsubscribeCtx(context.Background())
+		}
+		if w.server != nil {
+			w.server.Close()
+		}
+	}()
+	if !w.send(ctx, w.last) {
+		return
+	}
+
+	var pollTicker *time.Ticker
+	var renewTimer *time.Timer
+	defer func() {
+		if pollTicker != nil {
+			pollTicker.Stop()
+		}
+		if renewTimer != nil {
+			renewTimer.Stop()
+		}
+	}()
+	var pollC, renewC <-chan time.Time
+	schedule := func() {
+		renewC = nil
+		if sub == nil {
+			// Without a subscription, fall back to polling for good.
+			if w.server != nil {
+				w.server.Close()
+				w.server = nil
+			}
+			pollTicker = time.NewTicker(w.poll)
+			pollC = pollTicker.C
+		} else if sub.Timeout > 0 {
+			renewTimer = time.NewTimer(sub.Timeout / 2)
+			renewC = renewTimer.C
+		}
+	}
+	schedule()
+
+	for {
+		var addr string
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.events:
+			var ok bool
+			if addr, ok = event.Properties["ExternalIPAddress"]; !ok || sub == nil || event.SID != sub.SID {
+				continue
+			}
+		case <-pollC:
+			var err error
+			if addr, err = w.conn.GetExternalIPAddressCtx(ctx); err != nil {
+				continue
+			}
+		case <-renewC:
+			if err := sub.RenewCtx(ctx); err != nil {
+				// The service may have dropped the subscription, e.g. after
+				// rebooting, so subscribe afresh.
+				sub = w.subscribe(ctx)
+			}
+			schedule()
+			continue
+		}
+		ip, err := parseExternalIP(w.conn.GetServiceClient().Service, addr)
+		if err != nil || ip.Equal(w.last) {
+			continue
+		}
+		w.last = ip
+		if !w.send(ctx, ip) {
+			return
+		}
+	}
+}
+
+// send sends ip on out, reporting false if ctx was done first.
+func (w *ipWatcher) send(ctx context.Context, ip net.IP) bool {
+	select {
+	case w.out <- ip:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
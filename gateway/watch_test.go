@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp"
+)
+
+// watchedConnection is a WANConnection whose external address can be changed
+// while it is watched.
+type watchedConnection struct {
+	WANConnection
+	client *goupnp.ServiceClient
+
+	mu sync.Mutex
+	ip string
+}
+
+func newWatchedConnection(t *testing.T, eventSubURL string, ip string) *watchedConnection {
+	t.Helper()
+	const urn = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	root := &goupnp.RootDevice{Device: goupnp.Device{Services: []goupnp.Service{{
+		ServiceType: urn,
+		EventSubURL: goupnp.URLField{Str: eventSubURL},
+	}}}}
+	loc, err := url.Parse("http://127.0.0.1/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventSubURL != "" {
+		root.SetURLBase(loc)
+	}
+	clients, err := goupnp.NewServiceClientsFromMaybeRootDevice(&goupnp.MaybeRootDevice{
+		Root:      root,
+		Location:  loc,
+		LocalAddr: net.IPv4(127, 0, 0, 1),
+	}, urn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &watchedConnection{client: &clients[0], ip: ip}
+}
+
+func (c *watchedConnection) GetServiceClient() *goupnp.ServiceClient {
+	return c.client
+}
+
+func (c *watchedConnection) GetExternalIPAddressCtx(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ip, nil
+}
+
+func (c *watchedConnection) setIP(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ip = ip
+}
+
+func receiveIP(t *testing.T, ips <-chan net.IP, want string) {
+	t.Helper()
+	select {
+	case ip, ok := <-ips:
+		if !ok {
+			t.Fatalf("want %s, got closed channel", want)
+		}
+		if !ip.Equal(net.ParseIP(want)) {
+			t.Fatalf("want %s, got %v", want, ip)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", want)
+	}
+}
+
+func receiveClosed(t *testing.T, ips <-chan net.IP) {
+	t.Helper()
+	select {
+	case ip, ok := <-ips:
+		if ok {
+			t.Fatalf("want closed channel, got %v", ip)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for channel to close")
+	}
+}
+
+func TestWatchExternalIPEvents(t *testing.T) {
+	t.Parallel()
+	callbacks := make(chan string, 1)
+	unsubscribed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "SUBSCRIBE":
+			callbacks <- strings.Trim(r.Header.Get("CALLBACK"), "<>")
+			w.Header().Set("SID", "uuid:watch-1")
+			w.Header().Set("TIMEOUT", "Second-1800")
+		case "UNSUBSCRIBE":
+			close(unsubscribed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn := newWatchedConnection(t, srv.URL+"/evt/IPConn", "203.0.113.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ips, err := WatchExternalIP(ctx, conn, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveIP(t, ips, "203.0.113.1")
+
+	callback := <-callbacks
+	seq := 0
+	notify := func(sid, ip string) {
+		t.Helper()
+		body := fmt.Sprintf(`<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">`+
+			`<e:property><ExternalIPAddress>%s</ExternalIPAddress></e:property></e:propertyset>`, ip)
+		req, err := http.NewRequest("NOTIFY", callback, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", "upnp:propchange")
+		req.Header.Set("SID", sid)
+		req.Header.Set("SEQ", fmt.Sprint(seq))
+		seq++
+		// The watcher receives each event before replying, so deliver them
+		// concurrently with receiving addresses.
+		go func() {
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	notify("uuid:watch-1", "203.0.113.1") // Unchanged.
+	notify("uuid:other", "203.0.113.2")   // Another subscription.
+	notify("uuid:watch-1", "203.0.113.3")
+	receiveIP(t, ips, "203.0.113.3")
+
+	cancel()
+	receiveClosed(t, ips)
+	select {
+	case <-unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Error("want subscription cancelled when watching stops")
+	}
+}
+
+func TestWatchExternalIPPolling(t *testing.T) {
+	t.Parallel()
+	conn := newWatchedConnection(t, "", "203.0.113.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ips, err := WatchExternalIP(ctx, conn, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveIP(t, ips, "203.0.113.1")
+	conn.setIP("")
+	time.Sleep(30 * time.Millisecond)
+	conn.setIP("203.0.113.4")
+	receiveIP(t, ips, "203.0.113.4")
+	cancel()
+	receiveClosed(t, ips)
+}
@@ -0,0 +1,47 @@
+package goupnp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultGateway returns the IPv4 default gateway of the host.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if gateway := parseProcNetRoute(bufio.NewScanner(f)); gateway != nil {
+		return gateway, nil
+	}
+	return nil, errors.New("no IPv4 default route")
+}
+
+// parseProcNetRoute finds the default route in the contents of
+// /proc/net/route.
+func parseProcNetRoute(scanner *bufio.Scanner) net.IP {
+	// Skip the header line.
+	scanner.Scan()
+	for scanner.Scan() {
+		// Fields are: Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := hex.DecodeString(fields[2])
+		if err != nil || len(gw) != 4 {
+			continue
+		}
+		// The address is in host (little-endian) byte order.
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gw))
+		return ip
+	}
+	return nil
+}
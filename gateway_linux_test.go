@@ -0,0 +1,19 @@
+package goupnp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseProcNetRoute(t *testing.T) {
+	t.Parallel()
+	const routes = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n"
+	got := parseProcNetRoute(bufio.NewScanner(strings.NewReader(routes)))
+	if want := net.ParseIP("192.168.1.1"); !want.Equal(got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
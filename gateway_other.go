@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package goupnp
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+// defaultGateway returns the IPv4 default gateway of the host. It is not
+// implemented on this platform, and always returns an error.
+func defaultGateway() (net.IP, error) {
+	return nil, errors.New("reading the default gateway is not supported on " + runtime.GOOS)
+}
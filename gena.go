@@ -0,0 +1,243 @@
+package goupnp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsedano/goupnp/soap"
+)
+
+// DefaultGENATimeout is the time allowed for each GENA (eventing) request when
+// the given context has no deadline. Without it, a request to a device that
+// accepts the connection but never replies would hang indefinitely.
+const DefaultGENATimeout = 10 * time.Second
+
+// Subscription is a GENA event subscription held with a service.
+type Subscription struct {
+	// SID is the subscription identifier assigned by the service.
+	SID string
+
+	// Timeout is the duration of the subscription granted by the service,
+	// after which it expires unless renewed. Zero means infinite.
+	Timeout time.Duration
+
+	eventURL   url.URL
+	httpClient *http.Client
+}
+
+// SubscribeCtx subscribes to events from the service for the given duration.
+// The service sends events to the callback URLs, trying each in order until
+// one succeeds. The service may grant a different duration to the one
+// requested, which is given by Subscription.Timeout. Requests are made with
+// HTTPClientDefault; ServiceClient.SubscribeCtx uses the client's Transport.
+func (srv *Service) SubscribeCtx(ctx context.Context, callbacks []*url.URL, timeout time.Duration) (*Subscription, error) {
+	eventURL := srv.EventSubURL.Resolved()
+	if eventURL == nil {
+		return nil, errors.New("goupnp: service has no event subscription URL")
+	}
+	return SubscribeCtx(ctx, eventURL, callbacks, timeout)
+}
+
+// SubscribeCtx subscribes to events from the client's service, as
+// Service.SubscribeCtx does, but makes the subscription's requests over the
+// same Transport as the client's SOAP requests, as configured by options such
+// as WithTransport and WithDialContext.
+func (client *ServiceClient) SubscribeCtx(ctx context.Context, callbacks []*url.URL, timeout time.Duration) (*Subscription, error) {
+	eventURL := client.Service.EventSubURL.Resolved()
+	if eventURL == nil {
+		return nil, errors.New("goupnp: service has no event subscription URL")
+	}
+	return subscribe(ctx, client.httpClient(), eventURL, callbacks, timeout)
+}
+
+// SubscribeCtx subscribes to events from the service with the given event
+// subscription URL. See Service.SubscribeCtx.
+func SubscribeCtx(ctx context.Context, eventURL *url.URL, callbacks []*url.URL, timeout time.Duration) (*Subscription, error) {
+	return subscribe(ctx, HTTPClientDefault, eventURL, callbacks, timeout)
+}
+
+// subscribe subscribes to events as SubscribeCtx does, making this and later
+// requests for the subscription with httpClient.
+func subscribe(ctx context.Context, httpClient *http.Client, eventURL *url.URL, callbacks []*url.URL, timeout time.Duration) (*Subscription, error) {
+	if len(callbacks) == 0 {
+		return nil, errors.New("goupnp: subscription requires a callback URL")
+	}
+	var callback strings.Builder
+	for _, cb := range callbacks {
+		callback.WriteString("<" + cb.String() + ">")
+	}
+
+	sub := &Subscription{eventURL: *eventURL, httpClient: httpClient}
+	header := http.Header{
+		"CALLBACK": []string{callback.String()},
+		"NT":       []string{"upnp:event"},
+		"TIMEOUT":  []string{formatGENATimeout(timeout)},
+	}
+	if err := sub.request(ctx, "SUBSCRIBE", header); err != nil {
+		return nil, ctxErrorf(err, "subscribing to %q", eventURL)
+	}
+	return sub, nil
+}
+
+// RenewCtx renews the subscription for the duration it was last granted,
+// updating Timeout with the duration granted by the service.
+func (sub *Subscription) RenewCtx(ctx context.Context) error {
+	header := http.Header{
+		"SID":     []string{sub.SID},
+		"TIMEOUT": []string{formatGENATimeout(sub.Timeout)},
+	}
+	if err := sub.request(ctx, "SUBSCRIBE", header); err != nil {
+		return ctxErrorf(err, "renewing subscription %q", sub.SID)
+	}
+	return nil
+}
+
+// UnsubscribeCtx cancels the subscription.
+func (sub *Subscription) UnsubscribeCtx(ctx context.Context) error {
+	header := http.Header{
+		"SID": []string{sub.SID},
+	}
+	if err := sub.request(ctx, "UNSUBSCRIBE", header); err != nil {
+		return ctxErrorf(err, "unsubscribing %q", sub.SID)
+	}
+	return nil
+}
+
+// request makes a GENA request to the event URL, and updates sub from the
+// response to a SUBSCRIBE request.
+func (sub *Subscription) request(ctx context.Context, method string, header http.Header) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultGENATimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, sub.eventURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	soap.SetRequestID(req)
+
+	httpClient := sub.httpClient
+	if httpClient == nil {
+		httpClient = HTTPClientDefault
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("goupnp: got response status %s from %q",
+			resp.Status, sub.eventURL.String())
+	}
+	if method != "SUBSCRIBE" {
+		return nil
+	}
+
+	sid := resp.Header.Get("SID")
+	if sid == "" {
+		return errors.New("goupnp: subscription response has no SID")
+	}
+	timeout, err := parseGENATimeout(resp.Header.Get("TIMEOUT"))
+	if err != nil {
+		return err
+	}
+	sub.SID = sid
+	sub.Timeout = timeout
+	return nil
+}
+
+// EventXMLNamespace is the XML namespace of event notification bodies.
+const EventXMLNamespace = "urn:schemas-upnp-org:event-1-0"
+
+// Event is an event notification sent by a service to a subscriber's callback
+// URL.
+type Event struct {
+	// SID is the subscription that the event is for.
+	SID string
+	// Seq is the event key, which is 0 for the initial event sent when
+	// subscribing, and then increments with each event.
+	Seq uint32
+	// Properties are the new values of the evented state variables, by name.
+	Properties map[string]string
+}
+
+// ParseEvent parses an event notification (NOTIFY request), as received by
+// the http.Handler at a subscription's callback URL. The handler should reply
+// with status 412 (Precondition Failed) if an error is returned, or if the
+// event is for an unknown subscription.
+func ParseEvent(req *http.Request) (*Event, error) {
+	if req.Method != "NOTIFY" {
+		return nil, fmt.Errorf("goupnp: event has unexpected method %q", req.Method)
+	}
+	if nt, nts := req.Header.Get("NT"), req.Header.Get("NTS"); nt != "upnp:event" || nts != "upnp:propchange" {
+		return nil, fmt.Errorf("goupnp: event has unexpected NT %q or NTS %q", nt, nts)
+	}
+	event := &Event{SID: req.Header.Get("SID")}
+	if event.SID == "" {
+		return nil, errors.New("goupnp: event has no SID")
+	}
+	seq, err := strconv.ParseUint(req.Header.Get("SEQ"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("goupnp: event has invalid SEQ %q", req.Header.Get("SEQ"))
+	}
+	event.Seq = uint32(seq)
+
+	var propertySet struct {
+		XMLName    xml.Name `xml:"urn:schemas-upnp-org:event-1-0 propertyset"`
+		Properties []struct {
+			Variables []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"urn:schemas-upnp-org:event-1-0 property"`
+	}
+	body := &maxBytesReader{r: req.Body, n: MaxXMLBytesDefault}
+	if err := decodeXML(body, EventXMLNamespace, &propertySet); err != nil {
+		return nil, fmt.Errorf("goupnp: error decoding event: %v", err)
+	}
+	event.Properties = make(map[string]string)
+	for _, prop := range propertySet.Properties {
+		for _, v := range prop.Variables {
+			event.Properties[v.XMLName.Local] = v.Value
+		}
+	}
+	return event, nil
+}
+
+// formatGENATimeout formats a TIMEOUT header value. Zero means infinite.
+// Fractions of a second are rounded up, as "Second-0" is not valid.
+func formatGENATimeout(timeout time.Duration) string {
+	if timeout <= 0 {
+		return "infinite"
+	}
+	secs := (timeout + time.Second - 1) / time.Second
+	return "Second-" + strconv.FormatInt(int64(secs), 10)
+}
+
+// parseGENATimeout parses a TIMEOUT header value. Zero means infinite.
+func parseGENATimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "infinite") {
+		return 0, nil
+	}
+	const prefix = "second-"
+	if !strings.HasPrefix(strings.ToLower(s), prefix) {
+		return 0, fmt.Errorf("goupnp: invalid subscription TIMEOUT %q", s)
+	}
+	secs, err := strconv.Atoi(s[len(prefix):])
+	if err != nil || secs <= 0 {
+		return 0, fmt.Errorf("goupnp: invalid subscription TIMEOUT %q", s)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
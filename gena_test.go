@@ -0,0 +1,276 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscription(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var gotMethods []string
+	var gotHeaders []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotMethods = append(gotMethods, r.Method)
+		gotHeaders = append(gotHeaders, r.Header)
+		if r.Method == "SUBSCRIBE" {
+			w.Header().Set("SID", "uuid:sub-1")
+			w.Header().Set("TIMEOUT", "Second-300")
+		}
+	}))
+	defer srv.Close()
+
+	eventURL, err := url.Parse(srv.URL + "/evt/IPConn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := url.Parse("http://192.168.1.10:8058/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sub, err := SubscribeCtx(ctx, eventURL, []*url.URL{callback}, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.SID != "uuid:sub-1" || sub.Timeout != 300*time.Second {
+		t.Errorf("want SID=uuid:sub-1 Timeout=5m0s, got SID=%s Timeout=%v", sub.SID, sub.Timeout)
+	}
+	if err := sub.RenewCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.UnsubscribeCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		header map[string]string
+	}{
+		{"SUBSCRIBE", map[string]string{
+			"Callback": "<http://192.168.1.10:8058/events>",
+			"Nt":       "upnp:event",
+			"Timeout":  "Second-1800",
+			"Sid":      "",
+		}},
+		{"SUBSCRIBE", map[string]string{
+			"Callback": "",
+			"Nt":       "",
+			"Timeout":  "Second-300",
+			"Sid":      "uuid:sub-1",
+		}},
+		{"UNSUBSCRIBE", map[string]string{
+			"Timeout": "",
+			"Sid":     "uuid:sub-1",
+		}},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(gotMethods) != len(tests) {
+		t.Fatalf("want %d requests, got %d", len(tests), len(gotMethods))
+	}
+	for i, test := range tests {
+		if gotMethods[i] != test.method {
+			t.Errorf("request #%d: want method %s, got %s", i, test.method, gotMethods[i])
+		}
+		for k, want := range test.header {
+			if got := gotHeaders[i].Get(k); got != want {
+				t.Errorf("request #%d: want %s header %q, got %q", i, k, want, got)
+			}
+		}
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	eventURL, err := url.Parse(srv.URL + "/evt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = SubscribeCtx(ctx, eventURL, []*url.URL{eventURL}, time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+	var ctxErr ContextError
+	if !errors.As(err, &ctxErr) {
+		t.Errorf("want ContextError, got %T", err)
+	}
+}
+
+// countingTransport counts the requests made over http.DefaultTransport.
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (rt *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.requests++
+	rt.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestServiceClientSubscriptionTransport(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "SUBSCRIBE" {
+			w.Header().Set("SID", "uuid:sub-1")
+			w.Header().Set("TIMEOUT", "Second-300")
+		}
+	}))
+	defer srv.Close()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const urn = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	root := &RootDevice{Device: Device{Services: []Service{{
+		ServiceType: urn,
+		EventSubURL: URLField{Str: "/evt/IPConn"},
+	}}}}
+	root.SetURLBase(loc)
+	transport := new(countingTransport)
+	clients, err := NewServiceClientsFromMaybeRootDevice(&MaybeRootDevice{Root: root, Location: loc}, urn,
+		WithTransport(transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sub, err := clients[0].SubscribeCtx(ctx, []*url.URL{loc}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.RenewCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.UnsubscribeCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.requests != 3 {
+		t.Errorf("want 3 requests over the client's transport, got %d", transport.requests)
+	}
+}
+
+func TestFormatGENATimeout(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{0, "infinite"},
+		{-time.Second, "infinite"},
+		{time.Nanosecond, "Second-1"},
+		{500 * time.Millisecond, "Second-1"},
+		{time.Second, "Second-1"},
+		{1200 * time.Millisecond, "Second-2"},
+		{30 * time.Minute, "Second-1800"},
+	}
+	for _, test := range tests {
+		if got := formatGENATimeout(test.timeout); got != test.want {
+			t.Errorf("formatGENATimeout(%v): want %q, got %q", test.timeout, test.want, got)
+		}
+	}
+}
+
+func TestParseGENATimeout(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"Second-1800", 1800 * time.Second, false},
+		{"second-5", 5 * time.Second, false},
+		{"infinite", 0, false},
+		{"Second-0", 0, true},
+		{"Second-", 0, true},
+		{"1800", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseGENATimeout(test.s)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseGENATimeout(%q): want %v (err=%t), got %v (err=%v)", test.s, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	t.Parallel()
+	const body = `<?xml version="1.0"?>
+<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">
+	<e:property><ExternalIPAddress>203.0.113.7</ExternalIPAddress></e:property>
+	<e:property><ConnectionStatus>Connected</ConnectionStatus></e:property>
+</e:propertyset>`
+	newRequest := func(method, nts, sid, seq, body string) *http.Request {
+		req := httptest.NewRequest(method, "/events", strings.NewReader(body))
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", nts)
+		req.Header.Set("SID", sid)
+		req.Header.Set("SEQ", seq)
+		return req
+	}
+
+	event, err := ParseEvent(newRequest("NOTIFY", "upnp:propchange", "uuid:sub-1", "3", body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Event{
+		SID: "uuid:sub-1",
+		Seq: 3,
+		Properties: map[string]string{
+			"ExternalIPAddress": "203.0.113.7",
+			"ConnectionStatus":  "Connected",
+		},
+	}
+	if !reflect.DeepEqual(want, event) {
+		t.Errorf("want %+v, got %+v", want, event)
+	}
+
+	// Devices that omit the event namespace are decoded as if it were the
+	// default.
+	const bareBody = `<propertyset><property><ConnectionStatus>Connected</ConnectionStatus></property></propertyset>`
+	event, err = ParseEvent(newRequest("NOTIFY", "upnp:propchange", "uuid:sub-1", "4", bareBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Connected", event.Properties["ConnectionStatus"]; want != got {
+		t.Errorf("want ConnectionStatus %q, got %q", want, got)
+	}
+
+	for name, req := range map[string]*http.Request{
+		"method":   newRequest("POST", "upnp:propchange", "uuid:sub-1", "3", body),
+		"NTS":      newRequest("NOTIFY", "ssdp:alive", "uuid:sub-1", "3", body),
+		"SEQ":      newRequest("NOTIFY", "upnp:propchange", "uuid:sub-1", "x", body),
+		"body":     newRequest("NOTIFY", "upnp:propchange", "uuid:sub-1", "3", "<properties/>"),
+		"no SID":   newRequest("NOTIFY", "upnp:propchange", "", "3", body),
+		"no event": newRequest("NOTIFY", "upnp:propchange", "uuid:sub-1", "3", ""),
+	} {
+		if _, err := ParseEvent(req); err == nil {
+			t.Errorf("%s: want error, got nil", name)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module github.com/fsedano/goupnp
 
 go 1.14
 
-require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+require (
+	go.uber.org/goleak v1.1.12
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+)
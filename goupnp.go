@@ -15,16 +15,21 @@
 package goupnp
 
 import (
+	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fsedano/goupnp/httpu"
+	"github.com/fsedano/goupnp/soap"
 	"github.com/fsedano/goupnp/ssdp"
 )
 
@@ -52,6 +57,51 @@ func (err ContextError) Error() string {
 	return fmt.Sprintf("%s: %v", err.Context, err.Err)
 }
 
+// Unwrap returns the wrapped error, for use with errors.Is and errors.As.
+func (err ContextError) Unwrap() error {
+	return err.Err
+}
+
+// Errors that can be tested for with errors.Is, to distinguish the causes of
+// discovery failures. The errors returned wrap the underlying error, which can
+// also be tested for.
+var (
+	// ErrNoMulticastInterface is returned by discovery when the host has no
+	// network interfaces that multicast search requests can be sent on.
+	ErrNoMulticastInterface = errors.New("goupnp: no multicast-capable network interfaces")
+	// ErrSearchSendFailed is returned by discovery when sending search
+	// requests or receiving responses failed.
+	ErrSearchSendFailed = errors.New("goupnp: search failed")
+	// ErrProbeFailed is set as MaybeRootDevice.Err when a discovered device
+	// could not be queried for its description.
+	ErrProbeFailed = errors.New("goupnp: probing device failed")
+	// ErrDeviceNotFound is returned by DiscoverByUDNCtx when no device with
+	// the UDN responded to the search.
+	ErrDeviceNotFound = errors.New("goupnp: device not found")
+	// ErrDeviceUnreachable is returned when requesting a device description
+	// if the check enabled by WithReachabilityCheck fails.
+	ErrDeviceUnreachable = errors.New("goupnp: device unreachable")
+)
+
+// sentinelError is an error that matches sentinel with errors.Is, and wraps
+// err.
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+func (err sentinelError) Error() string {
+	return fmt.Sprintf("%v: %v", err.sentinel, err.err)
+}
+
+func (err sentinelError) Is(target error) bool {
+	return target == err.sentinel
+}
+
+func (err sentinelError) Unwrap() error {
+	return err.err
+}
+
 // MaybeRootDevice contains either a RootDevice or an error.
 type MaybeRootDevice struct {
 	// Identifier of the device. Note that this in combination with Location
@@ -69,21 +119,65 @@ type MaybeRootDevice struct {
 	// the discovery of a device, regardless of if there was an error probing it.
 	Location *url.URL
 
-	// The address from which the device was discovered (if known - otherwise nil).
+	// The address from which the device was discovered (if known - otherwise
+	// nil). See LocalAddrPolicy for how this is chosen.
 	LocalAddr net.IP
 
-	// Any error encountered probing a discovered device.
+	// The port that the device accepts unicast search requests on, as
+	// advertised by UPnP 1.1+ devices. This is ssdp.DefaultSearchPort if the
+	// device did not advertise one.
+	SearchPort int
+
+	// The duration for which the discovery remains valid, as advertised by the
+	// device. Zero if the device did not advertise one.
+	MaxAge time.Duration
+
+	// The time at which the device generated its search response, according to
+	// its own clock. Zero if the device did not send a valid DATE header.
+	Date time.Time
+
+	// Whether the search response included the EXT header.
+	Ext bool
+
+	// The network location signature of UPnP 1.1+ devices, which changes when
+	// the device reboots. See ssdp.NLS.
+	NLS string
+
+	// Any error encountered probing a discovered device. This matches
+	// ErrProbeFailed with errors.Is.
 	Err error
+
+	// How the device was found, as set by ScanCtx. Zero otherwise.
+	FoundBy Mechanism
+
+	// How long requesting the device description took, whether or not it
+	// succeeded. This helps to identify slow devices. Zero if no request was
+	// made, such as for a location already probed.
+	ProbeDuration time.Duration
 }
 
 // DiscoverDevicesCtx attempts to find targets of the given type. This is
 // typically the entry-point for this package. searchTarget is typically a URN
 // in the form "urn:schemas-upnp-org:device:..." or
 // "urn:schemas-upnp-org:service:...". A single error is returned for errors
-// while attempting to send the query. An error or RootDevice is returned for
-// each discovered RootDevice.
-func DiscoverDevicesCtx(ctx context.Context, searchTarget string) ([]MaybeRootDevice, error) {
-	hc, hcCleanup, err := httpuClient()
+// while attempting to send the query from every network interface; failures on
+// only some interfaces are logged to any logger set by WithLogger. An error or
+// RootDevice is returned for each discovered RootDevice.
+func DiscoverDevicesCtx(ctx context.Context, searchTarget string, opts ...DiscoveryOption) ([]MaybeRootDevice, error) {
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	if err := o.checkSourcePort(); err != nil {
+		return nil, err
+	}
+	if o.cache != nil {
+		if cached := o.cache.Get(searchTarget); len(cached) > 0 {
+			if o.progress != nil {
+				o.progress(len(cached))
+			}
+			return cached, nil
+		}
+	}
+	hc, hcCleanup, err := httpuClient(o)
 	if err != nil {
 		return nil, err
 	}
@@ -91,32 +185,533 @@ func DiscoverDevicesCtx(ctx context.Context, searchTarget string) ([]MaybeRootDe
 
 	searchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
-	responses, err := ssdp.RawSearch(searchCtx, hc, string(searchTarget), 3)
+	unicastResponses := searchUnicastTargets(searchCtx, o, searchTarget)
+	responses, err := ssdp.RawSearch(searchCtx, hc, string(searchTarget), o.numSends, o.searchOptions()...)
+	if err != nil {
+		// Wait for the unicast searches to close their sockets.
+		cancel()
+		<-unicastResponses
+		return nil, sentinelError{ErrSearchSendFailed, err}
+	}
+	responses = mergeResponses(responses, <-unicastResponses)
+
+	results := probeResponses(ctx, o, o.filterSubnet(responses), nil, o.progress)
+	if o.validateTarget {
+		results = o.filterSearchTarget(results, searchTarget)
+	}
+	if o.cache != nil {
+		o.cache.Put(searchTarget, results)
+	}
+	return results, nil
+}
+
+// DiscoverMultiCtx is like DiscoverDevicesCtx, but searches for several
+// targets at once. All search requests share a single socket per interface and
+// a single wait for responses, so this takes no longer than searching for one
+// target. Results are keyed by each of searchTargets, and a device that
+// matches several targets is only probed once.
+//
+// Options apply as for DiscoverDevicesCtx, separately to each search target:
+// targets with devices in any cache set by WithCache are not searched for, and
+// WithUnicastTargets and WithSearchTargetValidation apply to each target that
+// is. WithProgress does not apply.
+func DiscoverMultiCtx(ctx context.Context, searchTargets []string, opts ...DiscoveryOption) (map[string][]MaybeRootDevice, error) {
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	if err := o.checkSourcePort(); err != nil {
+		return nil, err
+	}
+	results := make(map[string][]MaybeRootDevice, len(searchTargets))
+	var uncached []string
+	for _, searchTarget := range searchTargets {
+		if o.cache != nil {
+			if cached := o.cache.Get(searchTarget); len(cached) > 0 {
+				results[searchTarget] = cached
+				continue
+			}
+		}
+		uncached = append(uncached, searchTarget)
+	}
+	if len(uncached) == 0 {
+		return results, nil
+	}
+
+	responsesByTarget, err := searchMulti(ctx, o, uncached)
+	if err != nil {
+		return nil, err
+	}
+	probed := make(map[string]*RootDevice)
+	for _, searchTarget := range uncached {
+		targetResults := probeResponses(ctx, o, o.filterSubnet(responsesByTarget[searchTarget]), probed, nil)
+		if o.validateTarget {
+			targetResults = o.filterSearchTarget(targetResults, searchTarget)
+		}
+		if o.cache != nil {
+			o.cache.Put(searchTarget, targetResults)
+		}
+		results[searchTarget] = targetResults
+	}
+	return results, nil
+}
+
+// searchMulti sends multicast search requests for searchTargets, and to any
+// hosts set by WithUnicastTargets, and returns the responses keyed by each of
+// searchTargets.
+func searchMulti(ctx context.Context, o *discoveryOptions, searchTargets []string) (map[string][]*http.Response, error) {
+	hc, hcCleanup, err := httpuClient(o)
+	if err != nil {
+		return nil, err
+	}
+	defer hcCleanup()
+
+	searchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	unicastResponses := make([]<-chan []*http.Response, len(searchTargets))
+	for i, searchTarget := range searchTargets {
+		unicastResponses[i] = searchUnicastTargets(searchCtx, o, searchTarget)
+	}
+	responsesByTarget, err := ssdp.RawSearchMulti(searchCtx, hc, searchTargets, o.numSends, o.searchOptions()...)
+	if err != nil {
+		// Wait for the unicast searches to close their sockets.
+		cancel()
+		for _, responses := range unicastResponses {
+			<-responses
+		}
+		return nil, sentinelError{ErrSearchSendFailed, err}
+	}
+	for i, searchTarget := range searchTargets {
+		responsesByTarget[searchTarget] = mergeResponses(responsesByTarget[searchTarget], <-unicastResponses[i])
+	}
+	return responsesByTarget, nil
+}
+
+// DiscoverByUDNCtx searches for the device with the given UDN, such as to
+// re-locate a known device after its IP address has changed. The UDN may be
+// given with or without its "uuid:" prefix. A search targeted at the UDN is
+// sent together with an ssdp:all search, for devices that do not answer
+// targeted searches, and the first responding root device that contains a
+// device with the UDN is returned. An error matching ErrDeviceNotFound is
+// returned if no such device responds.
+//
+// Options apply as for DiscoverDevicesCtx with the UDN as the search target:
+// a device cached for it by WithCache is returned without searching, and the
+// device found is cached for it. WithProgress does not apply.
+func DiscoverByUDNCtx(ctx context.Context, udn string, opts ...DiscoveryOption) (*MaybeRootDevice, error) {
+	udn = normalizeUDN(udn)
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	if err := o.checkSourcePort(); err != nil {
+		return nil, err
+	}
+	if o.cache != nil {
+		for _, maybe := range o.cache.Get(udn) {
+			if rootHasUDN(maybe.Root, udn) {
+				maybe := maybe
+				return &maybe, nil
+			}
+		}
+	}
+
+	responsesByTarget, err := searchMulti(ctx, o, []string{udn, ssdp.SSDPAll})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only probe each location once, and only those whose USN names the UDN
+	// in response to ssdp:all.
+	seen := make(map[string]bool)
+	var responses []*http.Response
+	for _, target := range []string{udn, ssdp.SSDPAll} {
+		for _, response := range o.filterSubnet(responsesByTarget[target]) {
+			if target == ssdp.SSDPAll && !usnHasUDN(response.Header.Get("USN"), udn) {
+				continue
+			}
+			if loc, err := ssdp.Location(response.Header); err == nil && !seen[loc.String()] {
+				seen[loc.String()] = true
+				responses = append(responses, response)
+			}
+		}
+	}
+
+	results := probeResponses(ctx, o, responses, nil, nil)
+	if o.validateTarget {
+		results = o.filterSearchTarget(results, udn)
+	}
+	for _, maybe := range results {
+		if maybe.Err == nil && rootHasUDN(maybe.Root, udn) {
+			maybe := maybe
+			if o.cache != nil {
+				o.cache.Put(udn, []MaybeRootDevice{maybe})
+			}
+			return &maybe, nil
+		}
+	}
+	return nil, sentinelError{ErrDeviceNotFound, fmt.Errorf("no device with UDN %q responded", udn)}
+}
+
+// DiscoverExpectedCtx searches for searchTarget like DiscoverDevicesCtx, and
+// checks the responses against the expected device UDNs, such as for a health
+// check of known devices. Responses are matched to UDNs by the USN, which may
+// be given with or without its "uuid:" prefix. found holds the result for each
+// expected UDN that responded, keyed as given in expected, and may include
+// devices that responded but failed to be probed. missing lists, in the order
+// given, the expected UDNs that did not respond, in which case err matches
+// ErrDeviceNotFound. Other errors are as for DiscoverDevicesCtx.
+func DiscoverExpectedCtx(ctx context.Context, searchTarget string, expected []string, opts ...DiscoveryOption) (found map[string]MaybeRootDevice, missing []string, err error) {
+	results, err := DiscoverDevicesCtx(ctx, searchTarget, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	found = make(map[string]MaybeRootDevice)
+	for _, udn := range expected {
+		normalized := normalizeUDN(udn)
+		for _, maybe := range results {
+			if usnHasUDN(maybe.USN, normalized) {
+				// Prefer a successfully probed result, in case a device
+				// responded from more than one location.
+				if prev, ok := found[udn]; !ok || prev.Err != nil {
+					found[udn] = maybe
+				}
+			}
+		}
+		if _, ok := found[udn]; !ok {
+			missing = append(missing, udn)
+		}
+	}
+	if len(missing) > 0 {
+		err = sentinelError{ErrDeviceNotFound, fmt.Errorf("%d of %d expected devices did not respond: %s",
+			len(missing), len(expected), strings.Join(missing, ", "))}
+	}
+	return found, missing, err
+}
+
+// normalizeUDN returns udn with a "uuid:" prefix, adding it if missing.
+func normalizeUDN(udn string) string {
+	udn = strings.TrimSpace(udn)
+	if strings.HasPrefix(strings.ToLower(udn), "uuid:") {
+		udn = udn[len("uuid:"):]
+	}
+	return "uuid:" + udn
+}
+
+// usnHasUDN returns true if the SSDP unique service name usn is for the device
+// with the UDN.
+func usnHasUDN(usn, udn string) bool {
+	if len(usn) < len(udn) || !strings.EqualFold(usn[:len(udn)], udn) {
+		return false
+	}
+	return len(usn) == len(udn) || strings.HasPrefix(usn[len(udn):], "::")
+}
+
+// rootHasSearchTarget returns true if root, or any of its embedded devices, is
+// of the device type, has a service of the service type, or has the UDN given
+// by searchTarget. Other search targets, such as ssdp:all, match any root.
+func rootHasSearchTarget(root *RootDevice, searchTarget string) bool {
+	switch {
+	case strings.HasPrefix(searchTarget, "uuid:"):
+		return rootHasUDN(root, searchTarget)
+	case strings.Contains(searchTarget, ":device:"):
+		found := false
+		root.Device.VisitDevices(func(device *Device) {
+			if device.DeviceType == searchTarget {
+				found = true
+			}
+		})
+		return found
+	case strings.Contains(searchTarget, ":service:"):
+		return len(root.Device.FindService(searchTarget)) > 0
+	}
+	return true
+}
+
+// filterSearchTarget returns results without the successfully probed devices
+// that do not have searchTarget, for WithSearchTargetValidation.
+func (o *discoveryOptions) filterSearchTarget(results []MaybeRootDevice, searchTarget string) []MaybeRootDevice {
+	filtered := results[:0]
+	for i := range results {
+		maybe := &results[i]
+		if maybe.Err != nil {
+			filtered = append(filtered, *maybe)
+			continue
+		}
+		ok := rootHasSearchTarget(maybe.Root, searchTarget)
+		if o.validateReport != nil {
+			o.validateReport(maybe, ok)
+		}
+		if ok {
+			filtered = append(filtered, *maybe)
+		}
+	}
+	return filtered
+}
+
+// rootHasUDN returns true if root, or any of its embedded devices, has the UDN.
+func rootHasUDN(root *RootDevice, udn string) bool {
+	found := false
+	root.Device.VisitDevices(func(device *Device) {
+		if strings.EqualFold(strings.TrimSpace(device.UDN), udn) {
+			found = true
+		}
+	})
+	return found
+}
+
+// UnicastSearchCtx sends a search request for searchTarget directly to a
+// previously discovered device, using the search port that it advertised. This
+// is useful to re-check for a device on networks that restrict multicast.
+func UnicastSearchCtx(ctx context.Context, device *MaybeRootDevice, searchTarget string, opts ...DiscoveryOption) ([]MaybeRootDevice, error) {
+	if device.Location == nil {
+		return nil, errors.New("goupnp: device has no location to search")
+	}
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	return unicastSearch(ctx, device.Location.Hostname(), device.SearchPort, device.LocalAddr, searchTarget, o)
+}
+
+// unicastSearch sends a search request for searchTarget to host, and probes the
+// devices that respond. A zero port means ssdp.DefaultSearchPort, and a nil
+// localAddr means any local address.
+func unicastSearch(ctx context.Context, host string, port int, localAddr net.IP, searchTarget string, o *discoveryOptions) ([]MaybeRootDevice, error) {
+	searchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	responses, err := unicastSearchResponses(searchCtx, host, port, localAddr, searchTarget, o)
 	if err != nil {
 		return nil, err
 	}
+	return probeResponses(ctx, o, responses, nil, nil), nil
+}
+
+// searchUnicastTargets sends search requests for searchTarget to each of the
+// hosts given by WithUnicastTargets concurrently, until ctx is done. The
+// responses are sent on the returned channel once all searches are complete.
+// Failed searches are logged, as for multicast searches from some interfaces.
+func searchUnicastTargets(ctx context.Context, o *discoveryOptions, searchTarget string) <-chan []*http.Response {
+	done := make(chan []*http.Response, 1)
+	if len(o.unicastTargets) == 0 {
+		done <- nil
+		return done
+	}
+
+	results := make(chan []*http.Response, len(o.unicastTargets))
+	for _, target := range o.unicastTargets {
+		target := target
+		go func() {
+			host, port := target, 0
+			if h, p, err := net.SplitHostPort(target); err == nil {
+				host = h
+				port, _ = strconv.Atoi(p)
+			}
+			responses, err := unicastSearchResponses(ctx, host, port, nil, searchTarget, o)
+			if err != nil && o.logger != nil {
+				o.logger.Printf("goupnp: unicast search of %s failed: %v", target, err)
+			}
+			results <- responses
+		}()
+	}
+	go func() {
+		var all []*http.Response
+		for range o.unicastTargets {
+			all = append(all, <-results...)
+		}
+		done <- all
+	}()
+	return done
+}
+
+// mergeResponses appends the search responses in extra to responses, unless
+// they are from a device and location that is already present.
+func mergeResponses(responses, extra []*http.Response) []*http.Response {
+	if len(extra) == 0 {
+		return responses
+	}
+	seen := make(map[string]bool, len(responses))
+	key := func(response *http.Response) string {
+		return response.Header.Get("LOCATION") + "\x00" + response.Header.Get("USN")
+	}
+	for _, response := range responses {
+		seen[key(response)] = true
+	}
+	for _, response := range extra {
+		if k := key(response); !seen[k] {
+			seen[k] = true
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// unicastSearchResponses sends a search request for searchTarget to host, and
+// returns the responses received before ctx is done.
+func unicastSearchResponses(ctx context.Context, host string, port int, localAddr net.IP, searchTarget string, o *discoveryOptions) ([]*http.Response, error) {
+	if port == 0 {
+		port = ssdp.DefaultSearchPort
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	lAddr := "0.0.0.0"
+	if strings.Contains(host, ":") {
+		lAddr = "::"
+	}
+	if localAddr != nil {
+		lAddr = localAddr.String()
+	}
+	hc, err := httpu.NewHTTPUClientAddrPort(lAddr, o.sourcePort)
+	if err != nil {
+		return nil, ctxError(err, "creating HTTPU client for unicast search")
+	}
+	defer hc.Close()
+
+	responses, err := ssdp.RawUnicastSearch(ctx, hc, addr, searchTarget, o.numSends, o.searchOptions()...)
+	if err != nil {
+		return nil, sentinelError{ErrSearchSendFailed, err}
+	}
+	return responses, nil
+}
 
+// gatewayDescriptionPorts and gatewayDescriptionPaths are combined to form the
+// URLs probed by DeviceByGatewayCtx, covering the description URLs of common
+// router firmwares.
+var (
+	gatewayDescriptionPorts = []int{5000, 49000, 1900, 2869, 80, 1780}
+	gatewayDescriptionPaths = []string{
+		"/rootDesc.xml",
+		"/igd.xml",
+		"/gatedesc.xml",
+		"/igddesc.xml",
+		"/description.xml",
+		"/InternetGatewayDevice.xml",
+	}
+)
+
+// DeviceByGatewayCtx finds the root device of a gateway whose IP address is
+// already known, such as from the host's routing table, without relying on
+// multicast. If gatewayIP is nil, the host's default gateway is used, and an
+// error that matches ErrGatewayUnknown is returned if it cannot be determined.
+//
+// A search request is first sent directly to the gateway. If that finds
+// nothing, common description URLs on the gateway are probed, and the first
+// that parses is returned.
+func DeviceByGatewayCtx(ctx context.Context, gatewayIP net.IP, opts ...DiscoveryOption) (*RootDevice, error) {
+	if gatewayIP == nil {
+		var err error
+		if gatewayIP, err = defaultGateway(); err != nil {
+			return nil, sentinelError{ErrGatewayUnknown, err}
+		}
+	}
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+
+	searchErr := errors.New("no devices responded")
+	results, err := unicastSearch(ctx, gatewayIP.String(), 0, nil, ssdp.UPNPRootDevice, o)
+	if err != nil {
+		searchErr = err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			return result.Root, nil
+		}
+		searchErr = result.Err
+	}
+
+	var locs []*url.URL
+	for _, port := range gatewayDescriptionPorts {
+		for _, path := range gatewayDescriptionPaths {
+			locs = append(locs, &url.URL{
+				Scheme: "http",
+				Host:   net.JoinHostPort(gatewayIP.String(), strconv.Itoa(port)),
+				Path:   path,
+			})
+		}
+	}
+	root, err := probeDescriptionURLs(ctx, o, locs)
+	if err != nil {
+		return nil, ctxErrorf(err, "finding device at gateway %s (search: %v)", gatewayIP, searchErr)
+	}
+	return root, nil
+}
+
+// probeDescriptionURLs requests a root device from each of locs concurrently,
+// and returns the first to succeed.
+func probeDescriptionURLs(ctx context.Context, o *discoveryOptions, locs []*url.URL) (*RootDevice, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		root *RootDevice
+		err  error
+	}
+	results := make(chan result, len(locs))
+	for _, loc := range locs {
+		loc := loc
+		go func() {
+			root, err := deviceByURL(ctx, o, loc)
+			results <- result{root, err}
+		}()
+	}
+
+	err := errors.New("goupnp: no description URLs to probe")
+	for range locs {
+		r := <-results
+		if r.err == nil {
+			return r.root, nil
+		}
+		err = r.err
+	}
+	return nil, ctxError(err, "no device description found")
+}
+
+// probeResponses requests the root device described by each SSDP search
+// response, as configured by o. If probed is non-nil, it caches successfully probed devices by
+// location, and is consulted before making a request. If progress is non-nil,
+// it is called with the number of results completed after each one.
+func probeResponses(ctx context.Context, o *discoveryOptions, responses []*http.Response, probed map[string]*RootDevice, progress func(found int)) []MaybeRootDevice {
 	results := make([]MaybeRootDevice, len(responses))
 	for i, response := range responses {
 		maybe := &results[i]
 		maybe.USN = response.Header.Get("USN")
-		loc, err := response.Location()
-		if err != nil {
-			maybe.Err = ContextError{"unexpected bad location from search", err}
-			continue
-		}
-		maybe.Location = loc
-		if root, err := DeviceByURLCtx(ctx, loc); err != nil {
-			maybe.Err = err
-		} else {
-			maybe.Root = root
+		maybe.Server = response.Header.Get("SERVER")
+		maybe.SearchPort = ssdp.SearchPort(response.Header)
+		maybe.MaxAge = ssdp.MaxAge(response.Header)
+		maybe.Date = ssdp.Date(response.Header)
+		maybe.Ext = ssdp.HasExt(response.Header)
+		maybe.NLS = ssdp.NLS(response.Header)
+		// Unicast searches may be sent from any local address, which is
+		// no use as the LocalAddr.
+		if ip := net.ParseIP(response.Header.Get(httpu.LocalAddressHeader)); ip != nil && !ip.IsUnspecified() {
+			maybe.LocalAddr = o.localAddrPolicy.localAddr(ip)
 		}
-		if i := response.Header.Get(httpu.LocalAddressHeader); len(i) > 0 {
-			maybe.LocalAddr = net.ParseIP(i)
+		probeResponse(ctx, o, response, probed, maybe)
+		if progress != nil {
+			progress(i + 1)
 		}
 	}
+	return results
+}
 
-	return results, nil
+// probeResponse sets maybe from the root device described by the SSDP search
+// response, as for probeResponses.
+func probeResponse(ctx context.Context, o *discoveryOptions, response *http.Response, probed map[string]*RootDevice, maybe *MaybeRootDevice) {
+	loc, err := ssdp.Location(response.Header)
+	if err != nil {
+		maybe.Err = sentinelError{ErrProbeFailed, ContextError{"unexpected bad location from search", err}}
+		return
+	}
+	maybe.Location = loc
+	if root, ok := probed[loc.String()]; ok {
+		maybe.Root = root
+		return
+	}
+	start := time.Now()
+	root, err := deviceByURL(ctx, o, loc)
+	maybe.ProbeDuration = time.Since(start)
+	if err != nil {
+		maybe.Err = sentinelError{ErrProbeFailed, err}
+		return
+	}
+	maybe.Root = root
+	if probed != nil {
+		probed[loc.String()] = root
+	}
 }
 
 // DiscoverDevices is the legacy version of DiscoverDevicesCtx, but uses
@@ -125,30 +720,185 @@ func DiscoverDevices(searchTarget string) ([]MaybeRootDevice, error) {
 	return DiscoverDevicesCtx(context.Background(), searchTarget)
 }
 
-func DeviceByURLCtx(ctx context.Context, loc *url.URL) (*RootDevice, error) {
+// DeviceByURLCtx requests the root device description at loc. Of opts, only
+// those that affect fetching descriptions apply, such as WithMaxXMLBytes and
+// WithReachabilityCheck. The IPv6 zone of a link-local loc, such as
+// "http://[fe80::1%25eth0]:5000/desc.xml", is used to dial the device, and is
+// given to a URLBase in the description that names a link-local address
+// without one.
+func DeviceByURLCtx(ctx context.Context, loc *url.URL, opts ...DiscoveryOption) (*RootDevice, error) {
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	return deviceByURL(ctx, o, loc)
+}
+
+func deviceByURL(ctx context.Context, o *discoveryOptions, loc *url.URL) (*RootDevice, error) {
 	locStr := loc.String()
+	if o.dialCheck > 0 {
+		if err := checkReachable(ctx, o, loc); err != nil {
+			return nil, ContextError{fmt.Sprintf("error requesting root device details from %q", locStr), err}
+		}
+	}
 	root := new(RootDevice)
-	if err := requestXml(ctx, locStr, DeviceXMLNamespace, root); err != nil {
+	if err := requestXml(ctx, o.httpClient(), o.maxXMLBytes, locStr, DeviceXMLNamespace, root); err != nil {
 		return nil, ContextError{fmt.Sprintf("error requesting root device details from %q", locStr), err}
 	}
-	var urlBaseStr string
-	if root.URLBaseStr != "" {
-		urlBaseStr = root.URLBaseStr
-	} else {
-		urlBaseStr = locStr
-	}
-	urlBase, err := url.Parse(urlBaseStr)
-	if err != nil {
+	if err := root.Rehydrate(loc); err != nil {
 		return nil, ContextError{fmt.Sprintf("error parsing location URL %q", locStr), err}
 	}
-	root.SetURLBase(urlBase)
 	return root, nil
 }
 
+// checkReachable connects to the host and port of loc within o.dialCheck, for
+// WithReachabilityCheck.
+func checkReachable(ctx context.Context, o *discoveryOptions, loc *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, o.dialCheck)
+	defer cancel()
+
+	port := loc.Port()
+	if port == "" {
+		port = "80"
+		if loc.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(loc.Hostname(), port)
+	dial := o.dialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return sentinelError{ErrDeviceUnreachable, err}
+	}
+	conn.Close()
+	return nil
+}
+
 func DeviceByURL(loc *url.URL) (*RootDevice, error) {
 	return DeviceByURLCtx(context.Background(), loc)
 }
 
+// LocateServiceClientCtx requests the root device description at loc, and
+// returns a client for the service of the first of serviceTypes that any of
+// its devices has. Unlike DeviceByURLCtx, only the URLBase and services are
+// decoded from the description, and devices, icons and other elements are
+// skipped, which makes this cheaper for a client that only calls actions of a
+// known service, such as to poll a gateway's external IP address. The
+// RootDevice of the client therefore only has its URLBase set. An error
+// matching ErrDeviceNotFound is returned if the device has none of
+// serviceTypes.
+func LocateServiceClientCtx(ctx context.Context, loc *url.URL, serviceTypes []string, opts ...DiscoveryOption) (*ServiceClient, error) {
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	locStr := loc.String()
+	if o.dialCheck > 0 {
+		if err := checkReachable(ctx, o, loc); err != nil {
+			return nil, ContextError{fmt.Sprintf("error requesting root device details from %q", locStr), err}
+		}
+	}
+	locator := &serviceLocator{serviceTypes: serviceTypes}
+	if err := requestXml(ctx, o.httpClient(), o.maxXMLBytes, locStr, DeviceXMLNamespace, locator); err != nil {
+		return nil, ContextError{fmt.Sprintf("error requesting root device details from %q", locStr), err}
+	}
+	if locator.service == nil {
+		return nil, sentinelError{ErrDeviceNotFound, fmt.Errorf("no service of types %s at %q",
+			strings.Join(serviceTypes, ", "), locStr)}
+	}
+
+	urlBase := loc
+	if locator.urlBase != "" {
+		u, err := url.Parse(strings.TrimSpace(locator.urlBase))
+		if err != nil {
+			return nil, fmt.Errorf("goupnp: error parsing URLBase %q: %v", locator.urlBase, err)
+		}
+		addLocationZone(u, loc)
+		urlBase = u
+	}
+	root := new(RootDevice)
+	root.SetURLBase(urlBase)
+	srv := locator.service
+	srv.SetURLBase(urlBase)
+	return &ServiceClient{
+		SOAPClient: srv.NewSOAPClient(o.soapOptions()...),
+		RootDevice: root,
+		Location:   loc,
+		Service:    srv,
+		scpd:       new(scpdCache),
+	}, nil
+}
+
+// serviceLocator decodes the URLBase of a root device description, and the
+// most preferred of its services of serviceTypes, for LocateServiceClientCtx.
+type serviceLocator struct {
+	serviceTypes []string
+
+	urlBase string
+	service *Service
+	rank    int
+}
+
+func (l *serviceLocator) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.EndElement:
+			if token.Name == start.Name {
+				return nil
+			}
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "URLBase":
+				if err := d.DecodeElement(&l.urlBase, &token); err != nil {
+					return err
+				}
+			case "service":
+				var srv Service
+				if err := d.DecodeElement(&srv, &token); err != nil {
+					return err
+				}
+				l.offer(&srv)
+			case "iconList":
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// offer keeps srv if it is of a more preferred type than any service so far.
+func (l *serviceLocator) offer(srv *Service) {
+	for rank, serviceType := range l.serviceTypes {
+		if l.service != nil && rank >= l.rank {
+			return
+		}
+		if srv.ServiceType == serviceType {
+			l.service = srv
+			l.rank = rank
+			return
+		}
+	}
+}
+
+// ParseRootDevice parses a root device description that has already been
+// fetched, for example from a cache or a proxy. loc is the location that the
+// description was fetched from, and is used to resolve URLs within it if it
+// has no URLBase. The result is as would be returned by DeviceByURLCtx.
+func ParseRootDevice(data []byte, loc *url.URL) (*RootDevice, error) {
+	root := new(RootDevice)
+	if err := decodeXML(bytes.NewReader(data), DeviceXMLNamespace, root); err != nil {
+		return nil, ctxError(err, "error parsing root device details")
+	}
+	if err := root.Rehydrate(loc); err != nil {
+		return nil, ctxError(err, "error setting root device URLBase")
+	}
+	return root, nil
+}
+
 // CharsetReaderDefault specifies the charset reader used while decoding the output
 // from a UPnP server. It can be modified in an init function to allow for non-utf8 encodings,
 // but should not be changed after requesting clients.
@@ -158,7 +908,59 @@ var CharsetReaderDefault func(charset string, input io.Reader) (io.Reader, error
 // HTTPClient defaults the http.DefaultClient.  This may be overridden by the importing application.
 var HTTPClientDefault = http.DefaultClient
 
-func requestXml(ctx context.Context, url string, defaultSpace string, doc interface{}) error {
+// MaxXMLBytesDefault is the maximum size of an XML document (such as a device
+// description or SCPD) read from a device, to protect against hostile or
+// broken devices sending enormous responses. It can be modified in an init
+// function, or per call with WithMaxXMLBytes. Zero or less means unlimited.
+var MaxXMLBytesDefault int64 = 5 << 20
+
+// ErrXMLTooLarge is returned when an XML document read from a device exceeds
+// the size limit, see MaxXMLBytesDefault.
+var ErrXMLTooLarge = errors.New("goupnp: XML document exceeds size limit")
+
+// maxBytesReader reads from r, returning ErrXMLTooLarge if more than n bytes
+// would be read.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *maxBytesReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Reading exactly up to the limit is allowed, so check for EOF.
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, ErrXMLTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// RedirectError is returned for a request that was redirected, when
+// redirects are not followed (see WithFollowRedirects).
+type RedirectError struct {
+	// URL is the requested URL.
+	URL        string
+	StatusCode int
+	// Location is the URL that the request was redirected to.
+	Location *url.URL
+}
+
+func (err *RedirectError) Error() string {
+	return fmt.Sprintf("goupnp: request for %q was redirected (status %d) to %q", err.URL, err.StatusCode, err.Location)
+}
+
+// requestXml fetches the XML document at url and decodes it into doc, reading
+// at most maxBytes of it if maxBytes is positive. Elements without a namespace
+// are decoded as if in defaultSpace, which is the namespace of the document
+// type being fetched, such as DeviceXMLNamespace or scpd.SCPDXMLNamespace.
+func requestXml(ctx context.Context, client *http.Client, maxBytes int64, url string, defaultSpace string, doc interface{}) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -166,19 +968,33 @@ func requestXml(ctx context.Context, url string, defaultSpace string, doc interf
 	if err != nil {
 		return err
 	}
+	soap.SetRequestID(req)
 
-	resp, err := HTTPClientDefault.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if loc, err := resp.Location(); err == nil && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return &RedirectError{URL: url, StatusCode: resp.StatusCode, Location: loc}
+	}
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("goupnp: got response status %s from %q",
 			resp.Status, url)
 	}
 
-	decoder := xml.NewDecoder(resp.Body)
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		body = &maxBytesReader{r: body, n: maxBytes}
+	}
+	return decodeXML(body, defaultSpace, doc)
+}
+
+// decodeXML decodes an XML document from r into doc, using
+// CharsetReaderDefault for non-UTF-8 encodings.
+func decodeXML(r io.Reader, defaultSpace string, doc interface{}) error {
+	decoder := xml.NewDecoder(r)
 	decoder.DefaultSpace = defaultSpace
 	decoder.CharsetReader = CharsetReaderDefault
 
@@ -0,0 +1,503 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp/httpu"
+	"github.com/fsedano/goupnp/soap"
+)
+
+func TestProbeDescriptionURLs(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			w.Write([]byte(testDeviceXML))
+		case "/garbage.xml":
+			w.Write([]byte("<root>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	locs := func(paths ...string) []*url.URL {
+		var locs []*url.URL
+		for _, path := range paths {
+			loc, err := url.Parse(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			locs = append(locs, loc)
+		}
+		return locs
+	}
+	ctx := context.Background()
+
+	root, err := probeDescriptionURLs(ctx, newDiscoveryOptions(nil), locs("/igd.xml", "/garbage.xml", "/rootDesc.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Test Router", root.Device.FriendlyName; want != got {
+		t.Errorf("want device %q, got %q", want, got)
+	}
+	if want, got := srv.URL+"/ctl/L3F", root.Device.Services[0].ControlURL.URL.String(); want != got {
+		t.Errorf("want control URL %q, got %q", want, got)
+	}
+
+	if _, err := probeDescriptionURLs(ctx, newDiscoveryOptions(nil), locs("/igd.xml", "/garbage.xml")); err == nil {
+		t.Error("want error when no URL has a description, got nil")
+	}
+}
+
+func TestProbeResponsesErrors(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	noLocation := &http.Response{Header: http.Header{}}
+	notFound := &http.Response{Header: http.Header{"Location": []string{srv.URL + "/rootDesc.xml"}}}
+	var progress []int
+	results := probeResponses(context.Background(), newDiscoveryOptions(nil), []*http.Response{noLocation, notFound}, nil,
+		func(found int) { progress = append(progress, found) })
+	if want := []int{1, 2}; !reflect.DeepEqual(want, progress) {
+		t.Errorf("want progress %v for failed probes, got %v", want, progress)
+	}
+	for i, result := range results {
+		if !errors.Is(result.Err, ErrProbeFailed) {
+			t.Errorf("result #%d: want ErrProbeFailed, got %v", i, result.Err)
+		}
+	}
+	var ctxErr ContextError
+	if !errors.As(results[1].Err, &ctxErr) {
+		t.Errorf("want probe error to wrap a ContextError, got %T", results[1].Err)
+	}
+}
+
+func TestProbeResponsesRelativeLocation(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A device that leaves out its own address.
+	response := &http.Response{Header: http.Header{
+		"Location": []string{"http://:" + srvURL.Port() + "/rootDesc.xml"},
+	}}
+	response.Header.Set(httpu.RemoteAddressHeader, srvURL.Hostname())
+	results := probeResponses(context.Background(), newDiscoveryOptions(nil), []*http.Response{response}, nil, nil)
+	if err := results[0].Err; err != nil {
+		t.Fatal(err)
+	}
+	if want, got := srv.URL+"/rootDesc.xml", results[0].Location.String(); want != got {
+		t.Errorf("want location %q, got %q", want, got)
+	}
+}
+
+func TestProbeResponsesProbeDuration(t *testing.T) {
+	t.Parallel()
+	const delay = 50 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		if r.URL.Path != "/rootDesc.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+
+	response := func(path string) *http.Response {
+		return &http.Response{Header: http.Header{"Location": []string{srv.URL + path}}}
+	}
+	probed := make(map[string]*RootDevice)
+	results := probeResponses(context.Background(), newDiscoveryOptions(nil),
+		[]*http.Response{response("/rootDesc.xml"), response("/missing.xml"), response("/rootDesc.xml")}, probed, nil)
+	// Failed probes are timed too.
+	for i := 0; i < 2; i++ {
+		if got := results[i].ProbeDuration; got < delay {
+			t.Errorf("result #%d: want probe duration of at least %v, got %v", i, delay, got)
+		}
+	}
+	if results[2].ProbeDuration != 0 {
+		t.Errorf("want zero probe duration for an already probed location, got %v", results[2].ProbeDuration)
+	}
+}
+
+func TestProbeResponsesZonedLocation(t *testing.T) {
+	t.Parallel()
+	// The device names only its link-local address in its URLBase.
+	desc := strings.Replace(testDeviceXML, "</specVersion>",
+		"</specVersion><URLBase>http://[fe80::1]:5000/</URLBase>", 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(desc))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		location string
+		zone     string
+	}{
+		{"unescaped zone", "http://[fe80::1%eth0]:5000/rootDesc.xml", ""},
+		{"escaped zone", "http://[fe80::1%25eth0]:5000/rootDesc.xml", ""},
+		{"receiving interface", "http://[fe80::1]:5000/rootDesc.xml", "eth0"},
+	}
+	for _, test := range tests {
+		var dialed []string
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		}
+		response := &http.Response{Header: http.Header{"Location": []string{test.location}}}
+		response.Header.Set(httpu.RemoteAddressHeader, "fe80::1")
+		if test.zone != "" {
+			response.Header.Set(httpu.RemoteZoneHeader, test.zone)
+		}
+		o := newDiscoveryOptions([]DiscoveryOption{WithDialContext(dial)})
+		results := probeResponses(context.Background(), o, []*http.Response{response}, nil, nil)
+		if err := results[0].Err; err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want, got := "[fe80::1%eth0]:5000", strings.Join(dialed, ","); want != got {
+			t.Errorf("%s: want dialed %q, got %q", test.name, want, got)
+		}
+		srvs := results[0].Root.Device.FindService("urn:schemas-upnp-org:service:WANIPConnection:1")
+		if len(srvs) != 1 {
+			t.Fatalf("%s: want 1 service, got %d", test.name, len(srvs))
+		}
+		if want, got := "http://[fe80::1%25eth0]:5000/ctl/IPConn", srvs[0].ControlURL.URL.String(); want != got {
+			t.Errorf("%s: want control URL %q, got %q", test.name, want, got)
+		}
+	}
+}
+
+func TestLocateServiceClient(t *testing.T) {
+	t.Parallel()
+	desc := strings.Replace(testDeviceXML, "</specVersion>",
+		"</specVersion><URLBase>http://192.168.1.1:5000/</URLBase>", 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(desc))
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	const (
+		ipConn = "urn:schemas-upnp-org:service:WANIPConnection:1"
+		l3f    = "urn:schemas-upnp-org:service:Layer3Forwarding:1"
+	)
+	// The first of the types that the device has is chosen, even though the
+	// Layer3Forwarding service comes first in the description.
+	sc, err := LocateServiceClientCtx(ctx, loc, []string{"urn:schemas-upnp-org:service:WANIPConnection:2", ipConn, l3f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Service.ServiceType != ipConn {
+		t.Errorf("want service %s, got %s", ipConn, sc.Service.ServiceType)
+	}
+	if want, got := "http://192.168.1.1:5000/ctl/IPConn", sc.SOAPClient.EndpointURL.String(); want != got {
+		t.Errorf("want control URL %q, got %q", want, got)
+	}
+	if want, got := "http://192.168.1.1:5000/", sc.RootDevice.URLBase.String(); want != got {
+		t.Errorf("want URLBase %q, got %q", want, got)
+	}
+	if sc.Location != loc {
+		t.Errorf("want location %v, got %v", loc, sc.Location)
+	}
+
+	if _, err := LocateServiceClientCtx(ctx, loc, []string{"urn:schemas-upnp-org:service:WANPPPConnection:1"}); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("want ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestProbeResponsesHeaders(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+
+	location := []string{srv.URL + "/rootDesc.xml"}
+	responses := []*http.Response{
+		{Header: http.Header{
+			"Location": location,
+			"Date":     []string{"Mon, 12 Oct 2026 08:30:00 GMT"},
+			"Ext":      []string{""},
+		}},
+		{Header: http.Header{
+			"Location": location,
+			"Date":     []string{"yesterday"},
+		}},
+	}
+	results := probeResponses(context.Background(), newDiscoveryOptions(nil), responses, nil, nil)
+
+	if want := time.Date(2026, 10, 12, 8, 30, 0, 0, time.UTC); !results[0].Date.Equal(want) {
+		t.Errorf("want Date %v, got %v", want, results[0].Date)
+	}
+	if !results[0].Ext {
+		t.Error("want Ext for response with empty EXT header")
+	}
+	if !results[1].Date.IsZero() {
+		t.Errorf("want zero Date for malformed header, got %v", results[1].Date)
+	}
+	if results[1].Ext {
+		t.Error("want no Ext for response without EXT header")
+	}
+}
+
+func TestDeviceByURLRequestID(t *testing.T) {
+	t.Parallel()
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(soap.RequestIDHeader)
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := soap.ContextWithRequestID(context.Background(), "req-5678")
+	if _, err := DeviceByURLCtx(ctx, loc); err != nil {
+		t.Fatal(err)
+	}
+	if want := "req-5678"; got != want {
+		t.Errorf("want request ID %q, got %q", want, got)
+	}
+}
+
+func TestSentinelError(t *testing.T) {
+	t.Parallel()
+	err := sentinelError{ErrSearchSendFailed, ctxError(context.DeadlineExceeded, "sending")}
+	if !errors.Is(err, ErrSearchSendFailed) {
+		t.Error("want errors.Is(err, ErrSearchSendFailed)")
+	}
+	if errors.Is(err, ErrProbeFailed) {
+		t.Error("want !errors.Is(err, ErrProbeFailed)")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("want errors.Is(err, context.DeadlineExceeded)")
+	}
+	if want, got := "goupnp: search failed: sending: context deadline exceeded", err.Error(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDeviceByURLMaxXMLBytes(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := DeviceByURLCtx(ctx, loc, WithMaxXMLBytes(int64(len(testDeviceXML)))); err != nil {
+		t.Errorf("want success at exactly the limit, got %v", err)
+	}
+	if _, err := DeviceByURLCtx(ctx, loc, WithMaxXMLBytes(0)); err != nil {
+		t.Errorf("want success with no limit, got %v", err)
+	}
+	if _, err := DeviceByURLCtx(ctx, loc, WithMaxXMLBytes(100)); !errors.Is(err, ErrXMLTooLarge) {
+		t.Errorf("want ErrXMLTooLarge, got %v", err)
+	}
+}
+
+func TestDeviceByURLRedirects(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			http.Redirect(w, r, "/desc/root.xml", http.StatusFound)
+		case "/desc/root.xml":
+			w.Write([]byte(testDeviceXML))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := DeviceByURLCtx(ctx, loc); err != nil {
+		t.Errorf("want redirect followed by default, got %v", err)
+	}
+
+	_, err = DeviceByURLCtx(ctx, loc, WithFollowRedirects(false))
+	var redirectErr *RedirectError
+	if !errors.As(err, &redirectErr) {
+		t.Fatalf("want *RedirectError, got %v", err)
+	}
+	if want, got := srv.URL+"/desc/root.xml", redirectErr.Location.String(); want != got {
+		t.Errorf("want redirect location %q, got %q", want, got)
+	}
+	if redirectErr.StatusCode != http.StatusFound {
+		t.Errorf("want status %d, got %d", http.StatusFound, redirectErr.StatusCode)
+	}
+}
+
+func TestDeviceByURLReachabilityCheck(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeviceByURLCtx(ctx, loc, WithReachabilityCheck(time.Second)); err != nil {
+		t.Errorf("want reachable device, got %v", err)
+	}
+
+	// Find a port with nothing listening on it.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := &url.URL{Scheme: "http", Host: l.Addr().String(), Path: "/rootDesc.xml"}
+	l.Close()
+	_, err = DeviceByURLCtx(ctx, closed, WithReachabilityCheck(time.Second))
+	if !errors.Is(err, ErrDeviceUnreachable) {
+		t.Errorf("want ErrDeviceUnreachable, got %v", err)
+	}
+	_, err = DeviceByURLCtx(ctx, closed)
+	if err == nil || errors.Is(err, ErrDeviceUnreachable) {
+		t.Errorf("want request error without the check, got %v", err)
+	}
+}
+
+func TestDeviceByURLConnectTimeouts(t *testing.T) {
+	t.Parallel()
+	// The listener accepts connections, but never responds on them, so only
+	// the TLS handshake timeout ends a request.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	stalled := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	tests := []struct {
+		name string
+		loc  string
+		opts []DiscoveryOption
+	}{
+		{"dial", "http://device.invalid/rootDesc.xml",
+			[]DiscoveryOption{WithDialContext(stalled), WithDialTimeout(50 * time.Millisecond)}},
+		{"TLS handshake", "https://" + l.Addr().String() + "/rootDesc.xml",
+			[]DiscoveryOption{WithTLSHandshakeTimeout(50 * time.Millisecond)}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			loc, err := url.Parse(test.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			if _, err := DeviceByURLCtx(ctx, loc, test.opts...); err == nil {
+				t.Fatal("want error, got none")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("want request to fail quickly, took %v", elapsed)
+			}
+		})
+	}
+}
+
+// wrappedTransport is a RoundTripper that is not an *http.Transport, as
+// instrumentation libraries install as http.DefaultTransport.
+type wrappedTransport struct {
+	http.RoundTripper
+}
+
+func TestCloneTransport(t *testing.T) {
+	t.Parallel()
+	base := &http.Transport{MaxIdleConnsPerHost: 7}
+	if got := cloneTransport(base); got == base || got.MaxIdleConnsPerHost != 7 {
+		t.Errorf("want a copy of base, got %+v", got)
+	}
+	if got := cloneTransport(wrappedTransport{base}); got == nil || got == base {
+		t.Errorf("want a new Transport for a wrapped transport, got %v", got)
+	}
+}
+
+func TestDeviceByURLClosesIdleConnections(t *testing.T) {
+	t.Parallel()
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeviceByURLCtx(context.Background(), loc, WithDialTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// The connection is kept alive by the transport created for the request
+	// until it is closed, once the request is done.
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("want idle connection closed after DeviceByURLCtx returned")
+	}
+}
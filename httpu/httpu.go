@@ -1,7 +1,6 @@
 package httpu
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -9,7 +8,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -48,6 +49,28 @@ type ClientInterfaceCtx interface {
 	) ([]*http.Response, error)
 }
 
+// ClientInterfaceMultiCtx is an optional interface for clients that can send
+// several requests at once, and collect the responses to all of them within a
+// single wait.
+type ClientInterfaceMultiCtx interface {
+	// DoMultiWithContext sends all of reqs, and returns the responses that
+	// were received for any of them. The context and deadline of the first
+	// request govern how long to wait, and all requests must be sent to the
+	// same host. Otherwise the semantics are as for
+	// ClientInterfaceCtx.DoWithContext.
+	DoMultiWithContext(
+		reqs []*http.Request,
+		numSends int,
+	) ([]*http.Response, error)
+}
+
+// maxResponseBytes is the size of the buffer that responses are read into. Each
+// response is a single datagram, which is truncated (without error) if it does
+// not fit, so this allows for the largest possible UDP payload rather than the
+// size of typical responses. Some devices send responses with verbose headers
+// which are larger than a single Ethernet frame.
+const maxResponseBytes = 65535
+
 // HTTPUClient is a client for dealing with HTTPU (HTTP over UDP). Its typical
 // function is for HTTPMU, and particularly SSDP.
 type HTTPUClient struct {
@@ -57,6 +80,7 @@ type HTTPUClient struct {
 
 var _ ClientInterface = &HTTPUClient{}
 var _ ClientInterfaceCtx = &HTTPUClient{}
+var _ ClientInterfaceMultiCtx = &HTTPUClient{}
 
 // NewHTTPUClient creates a new HTTPUClient, opening up a new UDP socket for the
 // purpose.
@@ -71,17 +95,54 @@ func NewHTTPUClient() (*HTTPUClient, error) {
 // NewHTTPUClientAddr creates a new HTTPUClient which will broadcast packets
 // from the specified address, opening up a new UDP socket for the purpose
 func NewHTTPUClientAddr(addr string) (*HTTPUClient, error) {
+	return NewHTTPUClientAddrPort(addr, 0)
+}
+
+// NewHTTPUClientAddrPort creates a new HTTPUClient which will broadcast packets
+// from the specified address and UDP port, opening up a new UDP socket for the
+// purpose. A port of 0 picks an ephemeral port.
+func NewHTTPUClientAddrPort(addr string, port int) (*HTTPUClient, error) {
 	ip := net.ParseIP(addr)
 	if ip == nil {
 		return nil, errors.New("Invalid listening address")
 	}
-	conn, err := net.ListenPacket("udp", ip.String()+":0")
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
 	return &HTTPUClient{conn: conn}, nil
 }
 
+// SetMulticastLoopback sets whether multicast requests sent by the client are
+// looped back to the sending host (the IP_MULTICAST_LOOP socket option, or the
+// IPv6 equivalent). This must be enabled to discover servers running on the
+// same host, such as in tests or between containers sharing a network. Most
+// systems enable it by default.
+func (httpu *HTTPUClient) SetMulticastLoopback(enabled bool) error {
+	sc, ok := httpu.conn.(syscall.Conn)
+	if !ok {
+		return errors.New("httpu: connection does not support socket options")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ipv6 := false
+	if addr, ok := httpu.conn.LocalAddr().(*net.UDPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = setMulticastLoop(fd, ipv6, enabled)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("httpu: error setting multicast loopback: %w", sockErr)
+	}
+	return nil
+}
+
 // Close shuts down the client. The client will no longer be useful following
 // this.
 func (httpu *HTTPUClient) Close() error {
@@ -118,25 +179,31 @@ func (httpu *HTTPUClient) DoWithContext(
 	req *http.Request,
 	numSends int,
 ) ([]*http.Response, error) {
+	return httpu.DoMultiWithContext([]*http.Request{req}, numSends)
+}
+
+// DoMultiWithContext implements ClientInterfaceMultiCtx.DoMultiWithContext.
+//
+// Make sure to read the documentation on the ClientInterfaceCtx interface
+// regarding cancellation!
+func (httpu *HTTPUClient) DoMultiWithContext(
+	reqs []*http.Request,
+	numSends int,
+) ([]*http.Response, error) {
+	if len(reqs) == 0 {
+		return nil, errors.New("httpu: no requests to send")
+	}
+	req := reqs[0]
+
 	httpu.connLock.Lock()
 	defer httpu.connLock.Unlock()
 
-	// Create the request. This is a subset of what http.Request.Write does
-	// deliberately to avoid creating extra fields which may confuse some
-	// devices.
-	var requestBuf bytes.Buffer
-	method := req.Method
-	if method == "" {
-		method = "GET"
-	}
-	if _, err := fmt.Fprintf(&requestBuf, "%s %s HTTP/1.1\r\n", method, req.URL.RequestURI()); err != nil {
-		return nil, err
-	}
-	if err := req.Header.Write(&requestBuf); err != nil {
-		return nil, err
-	}
-	if _, err := requestBuf.Write([]byte{'\r', '\n'}); err != nil {
-		return nil, err
+	requestBufs := make([][]byte, len(reqs))
+	for i, r := range reqs {
+		var err error
+		if requestBufs[i], err = encodeRequest(r); err != nil {
+			return nil, err
+		}
 	}
 
 	destAddr, err := net.ResolveUDPAddr("udp", req.Host)
@@ -144,13 +211,12 @@ func (httpu *HTTPUClient) DoWithContext(
 		return nil, err
 	}
 
-	// Handle context deadline/timeout
+	// Handle context deadline/timeout. Without one, clear any deadline left
+	// in the past by a previous cancelled request.
 	ctx := req.Context()
-	deadline, ok := ctx.Deadline()
-	if ok {
-		if err = httpu.conn.SetDeadline(deadline); err != nil {
-			return nil, err
-		}
+	deadline, _ := ctx.Deadline()
+	if err = httpu.conn.SetDeadline(deadline); err != nil {
+		return nil, err
 	}
 
 	// Handle context cancelation
@@ -165,23 +231,24 @@ func (httpu *HTTPUClient) DoWithContext(
 		}
 	}()
 
-	// Send request.
-	for i := 0; i < numSends; i++ {
-		if n, err := httpu.conn.WriteTo(requestBuf.Bytes(), destAddr); err != nil {
-			return nil, err
-		} else if n < len(requestBuf.Bytes()) {
-			return nil, fmt.Errorf("httpu: wrote %d bytes rather than full %d in request",
-				n, len(requestBuf.Bytes()))
+	// Send requests, stopping early if the context is cancelled.
+	for i := 0; i < numSends && ctx.Err() == nil; i++ {
+		for _, requestBuf := range requestBufs {
+			if n, err := httpu.conn.WriteTo(requestBuf, destAddr); err != nil {
+				return nil, err
+			} else if n < len(requestBuf) {
+				return nil, fmt.Errorf("httpu: wrote %d bytes rather than full %d in request",
+					n, len(requestBuf))
+			}
 		}
 		time.Sleep(5 * time.Millisecond)
 	}
 
 	// Await responses until timeout.
 	var responses []*http.Response
-	responseBytes := make([]byte, 2048)
+	responseBytes := make([]byte, maxResponseBytes)
 	for {
-		// 2048 bytes should be sufficient for most networks.
-		n, _, err := httpu.conn.ReadFrom(responseBytes)
+		n, from, err := httpu.conn.ReadFrom(responseBytes)
 		if err != nil {
 			if err, ok := err.(net.Error); ok {
 				if err.Timeout() {
@@ -197,7 +264,7 @@ func (httpu *HTTPUClient) DoWithContext(
 		}
 
 		// Parse response.
-		response, err := http.ReadResponse(bufio.NewReader(bytes.NewBuffer(responseBytes[:n])), req)
+		response, err := ParseResponse(responseBytes[:n], req)
 		if err != nil {
 			log.Printf("httpu: error while parsing response: %v", err)
 			continue
@@ -207,6 +274,12 @@ func (httpu *HTTPUClient) DoWithContext(
 		if a, ok := httpu.conn.LocalAddr().(*net.UDPAddr); ok {
 			response.Header.Add(LocalAddressHeader, a.IP.String())
 		}
+		if a, ok := from.(*net.UDPAddr); ok {
+			response.Header.Add(RemoteAddressHeader, a.IP.String())
+			if a.Zone != "" {
+				response.Header.Add(RemoteZoneHeader, a.Zone)
+			}
+		}
 
 		responses = append(responses, response)
 	}
@@ -215,4 +288,34 @@ func (httpu *HTTPUClient) DoWithContext(
 	return responses, nil
 }
 
+// encodeRequest creates the wire form of the request. This is a subset of what
+// http.Request.Write does deliberately to avoid creating extra fields which may
+// confuse some devices.
+func encodeRequest(req *http.Request) ([]byte, error) {
+	var requestBuf bytes.Buffer
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	if _, err := fmt.Fprintf(&requestBuf, "%s %s HTTP/1.1\r\n", method, req.URL.RequestURI()); err != nil {
+		return nil, err
+	}
+	if err := req.Header.Write(&requestBuf); err != nil {
+		return nil, err
+	}
+	if _, err := requestBuf.Write([]byte{'\r', '\n'}); err != nil {
+		return nil, err
+	}
+	return requestBuf.Bytes(), nil
+}
+
 const LocalAddressHeader = "goupnp-local-address"
+
+// RemoteAddressHeader is set on received responses to the IP address that they
+// were sent from.
+const RemoteAddressHeader = "goupnp-remote-address"
+
+// RemoteZoneHeader is set on received responses to the IPv6 zone (interface)
+// of the address that they were sent from, if it has one, as for a link-local
+// address.
+const RemoteZoneHeader = "goupnp-remote-zone"
@@ -0,0 +1,194 @@
+package httpu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPUClientLargeResponse(t *testing.T) {
+	t.Parallel()
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	// A response with a header block several times larger than the old
+	// buffer, with the Location header last so truncation would lose it.
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nEXT:\r\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&resp, "X-VENDOR-%03d: %s\r\n", i, strings.Repeat("v", 60))
+	}
+	resp.WriteString("LOCATION: http://192.0.2.1:5000/rootDesc.xml\r\n\r\n")
+	if resp.Len() < 3*2048 {
+		t.Fatalf("test response is only %d bytes", resp.Len())
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		_, from, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		server.WriteTo([]byte(resp.String()), from)
+	}()
+
+	client, err := NewHTTPUClientAddr("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req := (&http.Request{
+		Method: "M-SEARCH",
+		URL:    &url.URL{Opaque: "*"},
+		Host:   server.LocalAddr().String(),
+		Header: http.Header{"MAN": {`"ssdp:discover"`}},
+	}).WithContext(ctx)
+	responses, err := client.DoWithContext(req, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("want 1 response, got %d", len(responses))
+	}
+	if want, got := "http://192.0.2.1:5000/rootDesc.xml", responses[0].Header.Get("LOCATION"); want != got {
+		t.Errorf("want location %q, got %q", want, got)
+	}
+	if want, got := strings.Repeat("v", 60), responses[0].Header.Get("X-VENDOR-099"); want != got {
+		t.Errorf("want last vendor header %q, got %q", want, got)
+	}
+}
+
+func TestHTTPUClientReuseAfterCancel(t *testing.T) {
+	t.Parallel()
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, from, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.WriteTo([]byte("HTTP/1.1 200 OK\r\nLOCATION: http://192.0.2.1:5000/rootDesc.xml\r\n\r\n"), from)
+		}
+	}()
+
+	client, err := NewHTTPUClientAddr("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	newRequest := func(ctx context.Context) *http.Request {
+		return (&http.Request{
+			Method: "M-SEARCH",
+			URL:    &url.URL{Opaque: "*"},
+			Host:   server.LocalAddr().String(),
+			Header: http.Header{"MAN": {`"ssdp:discover"`}},
+		}).WithContext(ctx)
+	}
+
+	// A cancelled request returns promptly.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := client.DoWithContext(newRequest(ctx), 3); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want cancelled request to return promptly, took %v", elapsed)
+	}
+
+	// A later request without a deadline is not affected by the deadline
+	// used to cancel the first.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	responses, err := client.DoWithContext(newRequest(ctx), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 {
+		t.Errorf("want 1 response, got %d", len(responses))
+	}
+}
+
+func TestHTTPUClientDoMulti(t *testing.T) {
+	t.Parallel()
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	received := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+			if err != nil {
+				continue
+			}
+			st := req.Header.Get("ST")
+			received <- st
+			server.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: "+st+"\r\nLOCATION: http://192.0.2.1:5000/rootDesc.xml\r\n\r\n"), from)
+		}
+	}()
+
+	client, err := NewHTTPUClientAddr("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	const timeout = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var reqs []*http.Request
+	for _, st := range []string{"urn:test:a", "urn:test:b"} {
+		reqs = append(reqs, (&http.Request{
+			Method: "M-SEARCH",
+			URL:    &url.URL{Opaque: "*"},
+			Host:   server.LocalAddr().String(),
+			Header: http.Header{"MAN": {`"ssdp:discover"`}, "ST": {st}},
+		}).WithContext(ctx))
+	}
+	start := time.Now()
+	responses, err := client.DoMultiWithContext(reqs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Errorf("want responses awaited until the %v deadline, took %v", timeout, elapsed)
+	}
+
+	// Each request is sent numSends times, and every response is returned.
+	counts := make(map[string]int)
+	for len(received) > 0 {
+		counts[<-received]++
+	}
+	if counts["urn:test:a"] != 2 || counts["urn:test:b"] != 2 || len(counts) != 2 {
+		t.Errorf("want each request sent twice, got %v", counts)
+	}
+	if len(responses) != 4 {
+		t.Errorf("want 4 responses, got %d", len(responses))
+	}
+
+	if _, err := client.DoMultiWithContext(nil, 1); err == nil {
+		t.Error("want error for no requests, got nil")
+	}
+}
@@ -1,7 +1,11 @@
 package httpu
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -73,9 +77,12 @@ func (mc *MultiClient) sendRequests(
 type MultiClientCtx struct {
 	// The HTTPU clients to delegate to.
 	delegates []ClientInterfaceCtx
+	// Called for each delegate failure, if failures are tolerated.
+	errorHandler func(delegate ClientInterfaceCtx, err error)
 }
 
 var _ ClientInterfaceCtx = &MultiClientCtx{}
+var _ ClientInterfaceMultiCtx = &MultiClientCtx{}
 
 // NewMultiClient creates a new MultiClient that delegates to all the given
 // clients.
@@ -85,6 +92,78 @@ func NewMultiClientCtx(delegates []ClientInterfaceCtx) *MultiClientCtx {
 	}
 }
 
+// SetErrorHandler makes the client tolerate failures of some of its delegates,
+// e.g where one network interface is down. Each failure is passed to handler,
+// and a request only fails if every delegate fails, with an error that
+// describes each failure. By default, the first failure of any delegate
+// cancels the request to the others, and is returned.
+func (mc *MultiClientCtx) SetErrorHandler(handler func(delegate ClientInterfaceCtx, err error)) {
+	mc.errorHandler = handler
+}
+
+// allFailedError is returned when every delegate of a tolerant MultiClientCtx
+// failed.
+type allFailedError struct {
+	errs []error
+}
+
+func (err *allFailedError) Error() string {
+	msgs := make([]string, len(err.errs))
+	for i, e := range err.errs {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("httpu: all %d requests failed: %s", len(err.errs), strings.Join(msgs, "; "))
+}
+
+func (err *allFailedError) Unwrap() error {
+	return err.errs[0]
+}
+
+// delegateTasks runs tasks that send requests to delegates, applying the error
+// handler if one is set.
+type delegateTasks struct {
+	mc      *MultiClientCtx
+	results chan<- []*http.Response
+	group   errgroup.Group
+
+	mu        sync.Mutex
+	errs      []error
+	succeeded bool
+}
+
+// goDo runs do in a task for delegate d, and sends its responses to results.
+func (t *delegateTasks) goDo(d ClientInterfaceCtx, do func() ([]*http.Response, error)) {
+	t.group.Go(func() error {
+		responses, err := do()
+		if err != nil {
+			if t.mc.errorHandler == nil {
+				return err
+			}
+			t.mc.errorHandler(d, err)
+			t.mu.Lock()
+			t.errs = append(t.errs, err)
+			t.mu.Unlock()
+			return nil
+		}
+		t.mu.Lock()
+		t.succeeded = true
+		t.mu.Unlock()
+		t.results <- responses
+		return nil
+	})
+}
+
+// wait waits for all tasks, and returns the error for the request.
+func (t *delegateTasks) wait() error {
+	if err := t.group.Wait(); err != nil {
+		return err
+	}
+	if !t.succeeded && len(t.errs) > 0 {
+		return &allFailedError{t.errs}
+	}
+	return nil
+}
+
 // DoWithContext implements ClientInterfaceCtx.DoWithContext.
 func (mc *MultiClientCtx) DoWithContext(
 	req *http.Request,
@@ -111,22 +190,75 @@ func (mc *MultiClientCtx) DoWithContext(
 	return responses, tasks.Wait()
 }
 
+// DoMultiWithContext implements ClientInterfaceMultiCtx.DoMultiWithContext.
+// Delegates that do not implement ClientInterfaceMultiCtx are sent each
+// request concurrently via DoWithContext instead.
+func (mc *MultiClientCtx) DoMultiWithContext(
+	reqs []*http.Request,
+	numSends int,
+) ([]*http.Response, error) {
+	if len(reqs) == 0 {
+		return nil, errors.New("httpu: no requests to send")
+	}
+	tasks, ctx := errgroup.WithContext(reqs[0].Context())
+	ctxReqs := make([]*http.Request, len(reqs))
+	for i, req := range reqs {
+		ctxReqs[i] = req.WithContext(ctx) // so we cancel if the errgroup errors
+	}
+	results := make(chan []*http.Response)
+
+	// For each client, send the requests to it and collect results.
+	tasks.Go(func() error {
+		defer close(results)
+		return mc.sendMultiRequestsCtx(results, ctxReqs, numSends)
+	})
+
+	var responses []*http.Response
+	tasks.Go(func() error {
+		for rs := range results {
+			responses = append(responses, rs...)
+		}
+		return nil
+	})
+
+	return responses, tasks.Wait()
+}
+
+func (mc *MultiClientCtx) sendMultiRequestsCtx(
+	results chan<- []*http.Response,
+	reqs []*http.Request,
+	numSends int,
+) error {
+	tasks := &delegateTasks{mc: mc, results: results}
+	for _, d := range mc.delegates {
+		d := d // copy for closure
+		if md, ok := d.(ClientInterfaceMultiCtx); ok {
+			tasks.goDo(d, func() ([]*http.Response, error) {
+				return md.DoMultiWithContext(reqs, numSends)
+			})
+			continue
+		}
+		for _, req := range reqs {
+			req := req // copy for closure
+			tasks.goDo(d, func() ([]*http.Response, error) {
+				return d.DoWithContext(req, numSends)
+			})
+		}
+	}
+	return tasks.wait()
+}
+
 func (mc *MultiClientCtx) sendRequestsCtx(
 	results chan<- []*http.Response,
 	req *http.Request,
 	numSends int,
 ) error {
-	tasks := &errgroup.Group{}
+	tasks := &delegateTasks{mc: mc, results: results}
 	for _, d := range mc.delegates {
 		d := d // copy for closure
-		tasks.Go(func() error {
-			responses, err := d.DoWithContext(req, numSends)
-			if err != nil {
-				return err
-			}
-			results <- responses
-			return nil
+		tasks.goDo(d, func() ([]*http.Response, error) {
+			return d.DoWithContext(req, numSends)
 		})
 	}
-	return tasks.Wait()
+	return tasks.wait()
 }
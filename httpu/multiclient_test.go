@@ -0,0 +1,89 @@
+package httpu
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeClient responds to every request with a single response, or fails with
+// err.
+type fakeClient struct {
+	err error
+}
+
+func (c *fakeClient) DoWithContext(req *http.Request, numSends int) ([]*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []*http.Response{{StatusCode: 200}}, nil
+}
+
+func newTestRequest() *http.Request {
+	return &http.Request{Method: "M-SEARCH", URL: &url.URL{Opaque: "*"}, Host: "239.255.255.250:1900"}
+}
+
+func TestMultiClientCtxErrorHandler(t *testing.T) {
+	t.Parallel()
+	errDown := errors.New("network is down")
+	tests := []struct {
+		name          string
+		delegates     []ClientInterfaceCtx
+		wantResponses int
+		wantFailures  int
+		wantErr       bool
+	}{
+		{"all good", []ClientInterfaceCtx{&fakeClient{}, &fakeClient{}}, 2, 0, false},
+		{"mixed", []ClientInterfaceCtx{&fakeClient{}, &fakeClient{err: errDown}, &fakeClient{}}, 2, 1, false},
+		{"all failing", []ClientInterfaceCtx{&fakeClient{err: errDown}, &fakeClient{err: errDown}}, 0, 2, true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			var failed []ClientInterfaceCtx
+			mc := NewMultiClientCtx(test.delegates)
+			mc.SetErrorHandler(func(delegate ClientInterfaceCtx, err error) {
+				mu.Lock()
+				failed = append(failed, delegate)
+				mu.Unlock()
+			})
+
+			for _, multi := range []bool{false, true} {
+				failed = nil
+				var responses []*http.Response
+				var err error
+				if multi {
+					responses, err = mc.DoMultiWithContext([]*http.Request{newTestRequest()}, 1)
+				} else {
+					responses, err = mc.DoWithContext(newTestRequest(), 1)
+				}
+				if test.wantErr {
+					if !errors.Is(err, errDown) {
+						t.Errorf("multi=%t: want error wrapping %v, got %v", multi, errDown, err)
+					}
+				} else if err != nil {
+					t.Errorf("multi=%t: %v", multi, err)
+				}
+				if len(responses) != test.wantResponses {
+					t.Errorf("multi=%t: want %d responses, got %d", multi, test.wantResponses, len(responses))
+				}
+				if len(failed) != test.wantFailures {
+					t.Errorf("multi=%t: want %d failures reported, got %d", multi, test.wantFailures, len(failed))
+				}
+			}
+		})
+	}
+}
+
+func TestMultiClientCtxFailsFastByDefault(t *testing.T) {
+	t.Parallel()
+	errDown := errors.New("network is down")
+	mc := NewMultiClientCtx([]ClientInterfaceCtx{&fakeClient{}, &fakeClient{err: errDown}})
+	if _, err := mc.DoWithContext(newTestRequest(), 1); err != errDown {
+		t.Errorf("want %v, got %v", errDown, err)
+	}
+}
@@ -0,0 +1,32 @@
+package httpu
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrMalformedResponse is returned by ParseResponse for messages that are not
+// HTTP responses.
+var ErrMalformedResponse = errors.New("httpu: malformed response")
+
+// ParseResponse parses an HTTPU response to req from a received datagram,
+// working around the quirks of devices described by fixQuirks. Datagrams from
+// buggy or hostile peers can contain anything, so this returns an error
+// matching ErrMalformedResponse for anything that is not a well-formed
+// response, rather than a partially parsed one.
+func ParseResponse(msg []byte, req *http.Request) (*http.Response, error) {
+	if len(bytes.TrimSpace(msg)) == 0 {
+		return nil, fmt.Errorf("%w: empty message", ErrMalformedResponse)
+	}
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(fixQuirks(msg))), req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+	if response.ProtoMajor != 1 {
+		return nil, fmt.Errorf("%w: unsupported protocol %q", ErrMalformedResponse, response.Proto)
+	}
+	return response, nil
+}
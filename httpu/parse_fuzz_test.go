@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package httpu
+
+import (
+	"errors"
+	"testing"
+)
+
+func FuzzParseResponse(f *testing.F) {
+	f.Add([]byte("HTTP/1.1 200 OK\r\nLOCATION: http://192.0.2.1:5000/rootDesc.xml\r\nUSN: uuid:test\r\n\r\n"))
+	f.Add([]byte("HTTP/1.1 200 OK \r\nLOCATION : http://[::1\r\nCACHE-CONTROL: max-age=x\r\n\r\n"))
+	f.Add([]byte("HTTP/1.1 200\r\n\r\n"))
+	f.Add([]byte("NOTIFY * HTTP/1.1\r\n\r\n"))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		response, err := ParseResponse(msg, nil)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedResponse) {
+				t.Fatalf("want ErrMalformedResponse, got %v", err)
+			}
+			return
+		}
+		// The fields used by discovery must be safe to extract.
+		response.Location()
+		response.Header.Get("USN")
+		if response.Header == nil {
+			t.Fatal("want non-nil Header for parsed response")
+		}
+	})
+}
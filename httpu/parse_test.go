@@ -0,0 +1,40 @@
+package httpu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	t.Parallel()
+	// A router that adds a trailing space after the status line, and spaces
+	// around a header name.
+	msg := "HTTP/1.1 200 OK \r\n" +
+		"CACHE-CONTROL: max-age=1800\r\n" +
+		"LOCATION : http://192.0.2.1:5000/rootDesc.xml\r\n" +
+		"USN: uuid:test::upnp:rootdevice\r\n\r\n"
+	response, err := ParseResponse([]byte(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Errorf("want status 200, got %d", response.StatusCode)
+	}
+	if want, got := "http://192.0.2.1:5000/rootDesc.xml", response.Header.Get("Location"); want != got {
+		t.Errorf("want Location %q, got %q", want, got)
+	}
+
+	for _, msg := range []string{
+		"",
+		"\r\n\r\n",
+		"garbage",
+		"M-SEARCH * HTTP/1.1\r\nST: ssdp:all\r\n\r\n",
+		"HTTP/2.0 200 OK\r\n\r\n",
+		"HTTP/1.1 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nLOCATION\r\n\r\n",
+	} {
+		if _, err := ParseResponse([]byte(msg), nil); !errors.Is(err, ErrMalformedResponse) {
+			t.Errorf("%q: want ErrMalformedResponse, got %v", msg, err)
+		}
+	}
+}
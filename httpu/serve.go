@@ -17,8 +17,22 @@ const (
 var (
 	trailingWhitespaceRx = regexp.MustCompile(" +\r\n")
 	crlf                 = []byte("\r\n")
+	// headerNameRx matches the name of a header line that is followed by
+	// whitespace before its colon.
+	headerNameRx = regexp.MustCompile(`(?m)^([^ \t:\r\n][^:\r\n]*?)[ \t]+:`)
 )
 
+// fixQuirks works around malformed responses sent by some devices, so that they
+// can be parsed by the http package. This removes trailing whitespace from
+// lines (at least one router adds a trailing space after "HTTP/1.1"), and
+// whitespace between header names and their colon (e.g "LOCATION :
+// http://..."). Lines that start with whitespace continue the value of the
+// previous header, and are left unchanged.
+func fixQuirks(msg []byte) []byte {
+	msg = trailingWhitespaceRx.ReplaceAllLiteral(msg, crlf)
+	return headerNameRx.ReplaceAll(msg, []byte("$1:"))
+}
+
 // Handler is the interface by which received HTTPU messages are passed to
 // handling code.
 type Handler interface {
@@ -0,0 +1,50 @@
+package httpu
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestFixQuirks(t *testing.T) {
+	t.Parallel()
+	msg := "HTTP/1.1 200 OK \r\n" +
+		"CACHE-CONTROL: max-age=1800\r\n" +
+		"LOCATION : http://192.168.1.1:5000/rootDesc.xml\r\n" +
+		"ST:urn:schemas-upnp-org:device:InternetGatewayDevice:1 \r\n" +
+		"USN\t:\tuuid:1234::upnp:rootdevice\r\n" +
+		// A folded header, whose continuation lines are left as they are.
+		"SERVER: Linux/3.4\r\n" +
+		" UPnP/1.0 :\r\n" +
+		"\tMiniUPnPd/2.1\r\n" +
+		"EXT:\r\n" +
+		"\r\n"
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(fixQuirks([]byte(msg)))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := response.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://192.168.1.1:5000/rootDesc.xml"; loc.String() != want {
+		t.Errorf("want location %q, got %q", want, loc)
+	}
+	want := map[string]string{
+		"ST":     "urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+		"USN":    "uuid:1234::upnp:rootdevice",
+		"SERVER": "Linux/3.4 UPnP/1.0 : MiniUPnPd/2.1",
+	}
+	for name, value := range want {
+		if got := response.Header.Get(name); got != value {
+			t.Errorf("want %s %q, got %q", name, value, got)
+		}
+	}
+	if _, ok := response.Header["Upnp/1.0"]; ok {
+		t.Error("want continuation line not parsed as a header")
+	}
+	if _, ok := response.Header["Ext"]; !ok {
+		t.Error("want EXT header kept")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package httpu
+
+import "errors"
+
+func setMulticastLoop(fd uintptr, ipv6 bool, enabled bool) error {
+	return errors.New("httpu: multicast loopback control is not supported on this platform")
+}
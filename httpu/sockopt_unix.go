@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package httpu
+
+import (
+	"runtime"
+	"syscall"
+)
+
+func setMulticastLoop(fd uintptr, ipv6 bool, enabled bool) error {
+	v := 0
+	if enabled {
+		v = 1
+	}
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, v)
+	}
+	switch runtime.GOOS {
+	case "netbsd", "openbsd":
+		// These only accept a single byte for the IPv4 option.
+		return syscall.SetsockoptByte(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, byte(v))
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, v)
+}
@@ -0,0 +1,14 @@
+package httpu
+
+import "syscall"
+
+func setMulticastLoop(fd uintptr, ipv6 bool, enabled bool) error {
+	v := 0
+	if enabled {
+		v = 1
+	}
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, v)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, v)
+}
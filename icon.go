@@ -0,0 +1,163 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fsedano/goupnp/soap"
+)
+
+// DefaultIconTimeout is the time allowed for each attempt to fetch an icon
+// when the given context has no deadline. Icons are served by the same
+// embedded web servers as descriptions, which can be slow to respond.
+const DefaultIconTimeout = 5 * time.Second
+
+// MaxIconBytesDefault is the maximum size of an icon fetched from a device. It
+// can be modified in an init function. Zero or less means unlimited.
+var MaxIconBytesDefault int64 = 1 << 20
+
+// ErrIconTooLarge is returned when an icon exceeds MaxIconBytesDefault.
+var ErrIconTooLarge = errors.New("goupnp: icon exceeds size limit")
+
+// iconAttempts is the number of times to try fetching an icon, and
+// iconRetryDelay the time to wait between attempts.
+var (
+	iconAttempts   = 2
+	iconRetryDelay = 250 * time.Millisecond
+)
+
+// BestIcon returns the icon of the device that is closest to size pixels
+// square, preferring the smallest icon at least that large, and otherwise the
+// largest. nil is returned if the device has no icons with a URL.
+func (device *Device) BestIcon(size int32) *Icon {
+	var best *Icon
+	for i := range device.Icons {
+		icon := &device.Icons[i]
+		if !icon.URL.Ok {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = icon
+		case icon.minSide() >= size:
+			if best.minSide() < size || icon.minSide() < best.minSide() {
+				best = icon
+			}
+		case best.minSide() < size && icon.minSide() > best.minSide():
+			best = icon
+		}
+	}
+	return best
+}
+
+// minSide returns the smaller of the icon's width and height.
+func (icon *Icon) minSide() int32 {
+	if icon.Width < icon.Height {
+		return icon.Width
+	}
+	return icon.Height
+}
+
+// FetchIconCtx fetches the image data of icon. A failed request is retried
+// once, unless the device responded with a client error.
+func FetchIconCtx(ctx context.Context, icon *Icon) ([]byte, error) {
+	if !icon.URL.Ok {
+		return nil, errors.New("goupnp: bad/missing icon URL, or no URLBase has been set")
+	}
+	var err error
+	for attempt := 0; attempt < iconAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctxErrorf(ctx.Err(), "fetching icon %q", icon.URL.Str)
+			case <-time.After(iconRetryDelay):
+			}
+		}
+		var data []byte
+		var retry bool
+		if data, retry, err = fetchIcon(ctx, icon.URL.URL.String()); err == nil {
+			return data, nil
+		} else if !retry {
+			break
+		}
+	}
+	return nil, ctxErrorf(err, "fetching icon %q", icon.URL.Str)
+}
+
+// fetchIcon makes a single request for the icon at url, and reports whether a
+// failure may succeed if retried.
+func fetchIcon(ctx context.Context, url string) (data []byte, retry bool, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultIconTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	soap.SetRequestID(req)
+
+	resp, err := HTTPClientDefault.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("goupnp: got response status %s from %q",
+			resp.Status, url)
+	}
+	var body io.Reader = resp.Body
+	if MaxIconBytesDefault > 0 {
+		body = io.LimitReader(body, MaxIconBytesDefault+1)
+	}
+	if data, err = ioutil.ReadAll(body); err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	if MaxIconBytesDefault > 0 && int64(len(data)) > MaxIconBytesDefault {
+		return nil, false, ErrIconTooLarge
+	}
+	return data, false, nil
+}
+
+// IconCache holds icons fetched by FetchIconCtx, keyed by URL, so that device
+// lists that are redrawn do not fetch them again. An IconCache is safe for
+// concurrent use.
+type IconCache struct {
+	mu    sync.Mutex
+	icons map[string][]byte
+}
+
+// NewIconCache creates an empty IconCache.
+func NewIconCache() *IconCache {
+	return &IconCache{icons: make(map[string][]byte)}
+}
+
+// FetchIconCtx returns the image data of icon from the cache, or fetches it
+// with FetchIconCtx and adds it to the cache. Failures are not cached.
+func (cache *IconCache) FetchIconCtx(ctx context.Context, icon *Icon) ([]byte, error) {
+	key := icon.URL.URL.String()
+	cache.mu.Lock()
+	data, ok := cache.icons[key]
+	cache.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := FetchIconCtx(ctx, icon)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.icons[key] = data
+	cache.mu.Unlock()
+	return data, nil
+}
@@ -0,0 +1,100 @@
+package goupnp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBestIcon(t *testing.T) {
+	t.Parallel()
+	newIcon := func(width, height int32) Icon {
+		return Icon{Width: width, Height: height, URL: URLField{Ok: true}}
+	}
+	device := &Device{Icons: []Icon{
+		{Width: 64, Height: 64}, // no URL
+		newIcon(16, 16),
+		newIcon(120, 120),
+		newIcon(48, 48),
+		newIcon(32, 32),
+	}}
+	tests := []struct {
+		size int32
+		want int32
+	}{
+		{1, 16},
+		{16, 16},
+		{20, 32},
+		{48, 48},
+		{64, 120},
+		{500, 120},
+	}
+	for _, test := range tests {
+		if got := device.BestIcon(test.size); got == nil || got.Width != test.want {
+			t.Errorf("size %d: want icon %d, got %+v", test.size, test.want, got)
+		}
+	}
+	if icon := (&Device{}).BestIcon(16); icon != nil {
+		t.Errorf("want no icon, got %+v", icon)
+	}
+}
+
+func TestFetchIcon(t *testing.T) {
+	t.Parallel()
+	png := []byte("\x89PNG\r\n\x1a\nicon")
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/flaky.png":
+			if n == 1 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(png)
+		case "/large.png":
+			w.Write(bytes.Repeat([]byte{0}, int(MaxIconBytesDefault)+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	newIcon := func(path string) *Icon {
+		u, err := url.Parse(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Icon{URL: URLField{URL: *u, Ok: true, Str: path}}
+	}
+	ctx := context.Background()
+
+	cache := NewIconCache()
+	for i := 0; i < 2; i++ {
+		data, err := cache.FetchIconCtx(ctx, newIcon("/flaky.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(png, data) {
+			t.Errorf("want icon %q, got %q", png, data)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("want one retried request, and none for the cached icon, got %d requests", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	if _, err := FetchIconCtx(ctx, newIcon("/missing.png")); err == nil {
+		t.Error("want error for missing icon, got nil")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("want no retry for missing icon, got %d requests", n)
+	}
+	if _, err := FetchIconCtx(ctx, newIcon("/large.png")); !errors.Is(err, ErrIconTooLarge) {
+		t.Errorf("want ErrIconTooLarge, got %v", err)
+	}
+}
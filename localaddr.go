@@ -0,0 +1,100 @@
+package goupnp
+
+import (
+	"net"
+)
+
+// LocalAddrPolicy controls the address reported as the LocalAddr of
+// discovered devices, and so used by clients created from them. Searches are
+// only sent over IPv4, so by default LocalAddr is the IPv4 address that the
+// device was discovered from. The zero value is the default.
+//
+// Go does not report whether an IPv6 address is deprecated or temporary, so
+// such addresses are candidates like any other.
+type LocalAddrPolicy struct {
+	// PreferIPv6 reports an IPv6 address of the interface that the device was
+	// discovered on, if it has one, instead of the IPv4 address. This is the
+	// address that an IPv6 pinhole must be opened to. Global addresses are
+	// chosen in preference to link-local ones.
+	PreferIPv6 bool
+
+	// AllowLinkLocal allows PreferIPv6 to report a link-local IPv6 address, if
+	// the interface has no global one.
+	AllowLinkLocal bool
+}
+
+// WithLocalAddrPolicy sets the policy for choosing the LocalAddr of
+// discovered devices.
+func WithLocalAddrPolicy(policy LocalAddrPolicy) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.localAddrPolicy = policy
+	}
+}
+
+// localAddr returns the address to report for a device discovered from
+// searchAddr, according to the policy.
+func (policy LocalAddrPolicy) localAddr(searchAddr net.IP) net.IP {
+	if !policy.PreferIPv6 || searchAddr == nil {
+		return searchAddr
+	}
+	ifaceAddrs, err := interfaceAddrsOf(searchAddr)
+	if err != nil {
+		return searchAddr
+	}
+	return policy.selectAddr(searchAddr, ifaceAddrs)
+}
+
+// selectAddr chooses between searchAddr and the other addresses of its
+// interface.
+func (policy LocalAddrPolicy) selectAddr(searchAddr net.IP, ifaceAddrs []net.IP) net.IP {
+	if !policy.PreferIPv6 {
+		return searchAddr
+	}
+	var linkLocal net.IP
+	for _, ip := range ifaceAddrs {
+		if ip.To4() != nil {
+			continue
+		}
+		switch {
+		case ip.IsLinkLocalUnicast():
+			if linkLocal == nil {
+				linkLocal = ip
+			}
+		case ip.IsGlobalUnicast():
+			return ip
+		}
+	}
+	if policy.AllowLinkLocal && linkLocal != nil {
+		return linkLocal
+	}
+	return searchAddr
+}
+
+// interfaceAddrsOf returns the addresses of the interface that has the address
+// ip.
+func interfaceAddrsOf(ip net.IP) ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, ctxError(err, "requesting host interfaces")
+	}
+	for _, iface := range ifaces {
+		netAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		var ips []net.IP
+		found := false
+		for _, netAddr := range netAddrs {
+			addr, ok := netAddr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ips = append(ips, addr.IP)
+			found = found || addr.IP.Equal(ip)
+		}
+		if found {
+			return ips, nil
+		}
+	}
+	return nil, nil
+}
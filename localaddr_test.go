@@ -0,0 +1,32 @@
+package goupnp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLocalAddrPolicySelectAddr(t *testing.T) {
+	t.Parallel()
+	searchAddr := net.ParseIP("192.168.1.10")
+	linkLocal := net.ParseIP("fe80::1")
+	global := net.ParseIP("2001:db8::10")
+
+	tests := []struct {
+		name       string
+		policy     LocalAddrPolicy
+		ifaceAddrs []net.IP
+		want       net.IP
+	}{
+		{"default", LocalAddrPolicy{}, []net.IP{searchAddr, linkLocal, global}, searchAddr},
+		{"prefer IPv6", LocalAddrPolicy{PreferIPv6: true}, []net.IP{searchAddr, linkLocal, global}, global},
+		{"prefer global over link-local", LocalAddrPolicy{PreferIPv6: true, AllowLinkLocal: true}, []net.IP{searchAddr, linkLocal, global}, global},
+		{"no global", LocalAddrPolicy{PreferIPv6: true}, []net.IP{searchAddr, linkLocal}, searchAddr},
+		{"allow link-local", LocalAddrPolicy{PreferIPv6: true, AllowLinkLocal: true}, []net.IP{searchAddr, linkLocal}, linkLocal},
+		{"IPv4 only", LocalAddrPolicy{PreferIPv6: true, AllowLinkLocal: true}, []net.IP{searchAddr}, searchAddr},
+	}
+	for _, test := range tests {
+		if got := test.policy.selectAddr(searchAddr, test.ifaceAddrs); !got.Equal(test.want) {
+			t.Errorf("%s: want %v, got %v", test.name, test.want, got)
+		}
+	}
+}
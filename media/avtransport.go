@@ -0,0 +1,134 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// InstanceID is the virtual instance ID to use with renderers that do not
+	// support multiple simultaneous streams, which is the case for most.
+	InstanceID uint32 = 0
+
+	// NormalSpeed is the Play speed for normal forward playback.
+	NormalSpeed = "1"
+)
+
+// PlayURICtx sets the renderer's current URI to uri, along with its DIDL-Lite
+// metadata (which may be empty), and starts playing it at normal speed.
+func PlayURICtx(ctx context.Context, t AVTransport, uri, metadata string) error {
+	if err := t.SetAVTransportURICtx(ctx, InstanceID, uri, metadata); err != nil {
+		return fmt.Errorf("media: setting URI %q: %w", uri, err)
+	}
+	if err := t.PlayCtx(ctx, InstanceID, NormalSpeed); err != nil {
+		return fmt.Errorf("media: playing %q: %w", uri, err)
+	}
+	return nil
+}
+
+// PositionInfo is the position of the renderer within the current track.
+type PositionInfo struct {
+	// Track is the sequence number of the current track, or 0 if there is no
+	// track.
+	Track uint32
+	// TrackDuration is the duration of the current track, or 0 if it is
+	// unknown.
+	TrackDuration time.Duration
+	// TrackMetaData is the DIDL-Lite metadata of the current track.
+	TrackMetaData string
+	// TrackURI is the URI of the current track.
+	TrackURI string
+	// RelTime is the position within the current track, and AbsTime the
+	// position within the whole media. Either is 0 if the renderer does not
+	// report it.
+	RelTime time.Duration
+	AbsTime time.Duration
+}
+
+// GetPositionInfoCtx returns the position of the renderer within the current
+// track.
+func GetPositionInfoCtx(ctx context.Context, t AVTransport) (PositionInfo, error) {
+	track, trackDuration, metadata, uri, relTime, absTime, _, _, err := t.GetPositionInfoCtx(ctx, InstanceID)
+	if err != nil {
+		return PositionInfo{}, err
+	}
+	info := PositionInfo{
+		Track:         track,
+		TrackMetaData: metadata,
+		TrackURI:      uri,
+	}
+	for _, d := range []struct {
+		s   string
+		dst *time.Duration
+	}{
+		{trackDuration, &info.TrackDuration},
+		{relTime, &info.RelTime},
+		{absTime, &info.AbsTime},
+	} {
+		if *d.dst, err = ParseDuration(d.s); err != nil {
+			return PositionInfo{}, err
+		}
+	}
+	return info, nil
+}
+
+// ParseDuration parses an AVTransport time value of the form H+:MM:SS[.F+] or
+// H+:MM:SS[.F0/F1], as used for track durations and positions. An empty value,
+// or "NOT_IMPLEMENTED" (as sent by renderers that do not track the value),
+// gives a zero duration.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "NOT_IMPLEMENTED" {
+		return 0, nil
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("media: invalid duration %q", s)
+	}
+	secs, frac := fields[2], ""
+	if i := strings.IndexByte(secs, '.'); i >= 0 {
+		secs, frac = secs[:i], secs[i+1:]
+	}
+	var parts [3]int
+	for i, field := range []string{fields[0], fields[1], secs} {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || (i > 0 && (len(field) != 2 || n > 59)) {
+			return 0, fmt.Errorf("media: invalid duration %q", s)
+		}
+		parts[i] = n
+	}
+	d := time.Duration(parts[0])*time.Hour + time.Duration(parts[1])*time.Minute + time.Duration(parts[2])*time.Second
+	if frac != "" {
+		f, err := parseFraction(frac)
+		if err != nil {
+			return 0, fmt.Errorf("media: invalid duration %q", s)
+		}
+		d += time.Duration(f * float64(time.Second))
+	}
+	return d, nil
+}
+
+// parseFraction parses the fractional seconds of a duration, either as
+// decimal digits (F+) or as a fraction (F0/F1).
+func parseFraction(s string) (float64, error) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, err := strconv.ParseUint(s[:i], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		den, err := strconv.ParseUint(s[i+1:], 10, 32)
+		if err != nil || den == 0 || num >= den {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+		return float64(num) / float64(den), nil
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+	}
+	return strconv.ParseFloat("0."+s, 64)
+}
@@ -0,0 +1,106 @@
+package media
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+func TestParseDuration(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"0:00:00", 0, false},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"123:59:59", 123*time.Hour + 59*time.Minute + 59*time.Second, false},
+		{"0:03:25.500", 3*time.Minute + 25*time.Second + 500*time.Millisecond, false},
+		{"0:00:01.1/4", time.Second + 250*time.Millisecond, false},
+		{" 0:00:10 ", 10 * time.Second, false},
+		{"NOT_IMPLEMENTED", 0, false},
+		{"", 0, false},
+		{"3:25", 0, true},
+		{"0:60:00", 0, true},
+		{"0:0:00", 0, true},
+		{"-1:00:00", 0, true},
+		{"0:00:01.x", 0, true},
+		{"0:00:01.4/3", 0, true},
+		{"0:00:01.1/0", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: want error, got %v", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+		} else if got != test.want {
+			t.Errorf("%q: want %v, got %v", test.s, test.want, got)
+		}
+	}
+}
+
+func TestAVTransport(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var actions []testAction
+	sc := newTestServiceClient(t, av1.URN_AVTransport_1, func(action testAction) map[string]string {
+		mu.Lock()
+		actions = append(actions, action)
+		mu.Unlock()
+		if action.Name != "GetPositionInfo" {
+			return nil
+		}
+		return map[string]string{
+			"Track":         "1",
+			"TrackDuration": "0:03:25",
+			"TrackMetaData": "",
+			"TrackURI":      "http://192.0.2.5/song.mp3",
+			"RelTime":       "0:01:02.5",
+			"AbsTime":       "NOT_IMPLEMENTED",
+			"RelCount":      "2147483647",
+			"AbsCount":      "2147483647",
+		}
+	})
+	transport := &av1.AVTransport1{ServiceClient: sc}
+	ctx := context.Background()
+
+	if err := PlayURICtx(ctx, transport, "http://192.0.2.5/song.mp3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.PauseCtx(ctx, InstanceID); err != nil {
+		t.Fatal(err)
+	}
+	info, err := GetPositionInfoCtx(ctx, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PositionInfo{
+		Track:         1,
+		TrackDuration: 3*time.Minute + 25*time.Second,
+		TrackURI:      "http://192.0.2.5/song.mp3",
+		RelTime:       time.Minute + 2500*time.Millisecond,
+	}
+	if info != want {
+		t.Errorf("want %+v, got %+v", want, info)
+	}
+
+	wantActions := []testAction{
+		{"SetAVTransportURI", map[string]string{"InstanceID": "0", "CurrentURI": "http://192.0.2.5/song.mp3", "CurrentURIMetaData": ""}},
+		{"Play", map[string]string{"InstanceID": "0", "Speed": "1"}},
+		{"Pause", map[string]string{"InstanceID": "0"}},
+		{"GetPositionInfo", map[string]string{"InstanceID": "0"}},
+	}
+	if !reflect.DeepEqual(wantActions, actions) {
+		t.Errorf("want actions %+v, got %+v", wantActions, actions)
+	}
+}
@@ -0,0 +1,146 @@
+package media
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+// ContentDirectory is the Browse method common to the ContentDirectory1,
+// ContentDirectory2 and ContentDirectory3 clients in av1. Any of those clients
+// can be used where a ContentDirectory is required.
+type ContentDirectory interface {
+	BrowseCtx(
+		ctx context.Context,
+		ObjectID string,
+		BrowseFlag string,
+		Filter string,
+		StartingIndex uint32,
+		RequestedCount uint32,
+		SortCriteria string,
+	) (Result string, NumberReturned uint32, TotalMatches uint32, UpdateID uint32, err error)
+
+	GetServiceClient() *goupnp.ServiceClient
+}
+
+var (
+	_ ContentDirectory = &av1.ContentDirectory1{}
+	_ ContentDirectory = &av1.ContentDirectory2{}
+	_ ContentDirectory = &av1.ContentDirectory3{}
+)
+
+// contentDirectoryURNs are the ContentDirectory service types, in order of
+// preference.
+var contentDirectoryURNs = []string{av1.URN_ContentDirectory_3, av1.URN_ContentDirectory_2, av1.URN_ContentDirectory_1}
+
+// DiscoverContentDirectoriesCtx discovers all ContentDirectory services
+// (typically one per media server) on the network in a single search, and
+// returns clients for them, newest service version first. errors and err are
+// as for DiscoverAVTransportsCtx.
+func DiscoverContentDirectoriesCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (clients []ContentDirectory, errors []error, err error) {
+	var genericClients []goupnp.ServiceClient
+	if genericClients, errors, err = discoverServiceClients(ctx, contentDirectoryURNs, opts); err != nil {
+		return
+	}
+	for _, gc := range genericClients {
+		switch gc.Service.ServiceType {
+		case av1.URN_ContentDirectory_3:
+			clients = append(clients, &av1.ContentDirectory3{ServiceClient: gc})
+		case av1.URN_ContentDirectory_2:
+			clients = append(clients, &av1.ContentDirectory2{ServiceClient: gc})
+		case av1.URN_ContentDirectory_1:
+			clients = append(clients, &av1.ContentDirectory1{ServiceClient: gc})
+		}
+	}
+	return
+}
+
+const (
+	// RootObjectID is the ID of the root container of a ContentDirectory.
+	RootObjectID = "0"
+
+	// BrowseMetadata and BrowseDirectChildren are the values of the Browse
+	// action's BrowseFlag argument, which browse the object itself and its
+	// children respectively.
+	BrowseMetadata       = "BrowseMetadata"
+	BrowseDirectChildren = "BrowseDirectChildren"
+
+	// DefaultPageSize is the number of children requested per Browse action by
+	// BrowseAllCtx.
+	DefaultPageSize = 100
+)
+
+// BrowseResult is a page of the children of a container.
+type BrowseResult struct {
+	*DIDLLite
+	// NumberReturned is the number of objects in the page, and TotalMatches
+	// the total number of children of the container (or 0 if the server
+	// cannot tell).
+	NumberReturned uint32
+	TotalMatches   uint32
+	// UpdateID changes whenever the container is modified.
+	UpdateID uint32
+}
+
+// BrowseChildrenCtx returns a page of the children of the container with the
+// given ID, of (at most) count objects starting from index start. A count of
+// zero requests all of the remaining children, although servers may return
+// fewer.
+func BrowseChildrenCtx(ctx context.Context, cd ContentDirectory, objectID string, start, count uint32) (*BrowseResult, error) {
+	result, returned, total, updateID, err := cd.BrowseCtx(ctx, objectID, BrowseDirectChildren, "*", start, count, "")
+	if err != nil {
+		return nil, err
+	}
+	didl, err := ParseDIDLLite(result)
+	if err != nil {
+		return nil, err
+	}
+	return &BrowseResult{
+		DIDLLite:       didl,
+		NumberReturned: returned,
+		TotalMatches:   total,
+		UpdateID:       updateID,
+	}, nil
+}
+
+// BrowseAllCtx returns all of the children of the container with the given
+// ID, fetching them DefaultPageSize at a time.
+func BrowseAllCtx(ctx context.Context, cd ContentDirectory, objectID string) (*DIDLLite, error) {
+	all := new(DIDLLite)
+	var start uint32
+	for {
+		page, err := BrowseChildrenCtx(ctx, cd, objectID, start, DefaultPageSize)
+		if err != nil {
+			return nil, err
+		}
+		all.Containers = append(all.Containers, page.Containers...)
+		all.Items = append(all.Items, page.Items...)
+		// Some servers report NumberReturned inconsistently with the result,
+		// so count what was actually returned.
+		n := uint32(len(page.Containers) + len(page.Items))
+		start += n
+		if n == 0 || (page.TotalMatches != 0 && start >= page.TotalMatches) {
+			return all, nil
+		}
+	}
+}
+
+// BrowseMetadataCtx returns the object with the given ID, which is either a
+// container or an item.
+func BrowseMetadataCtx(ctx context.Context, cd ContentDirectory, objectID string) (*DIDLLite, error) {
+	result, _, _, _, err := cd.BrowseCtx(ctx, objectID, BrowseMetadata, "*", 0, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	didl, err := ParseDIDLLite(result)
+	if err != nil {
+		return nil, err
+	}
+	if len(didl.Containers)+len(didl.Items) != 1 {
+		return nil, fmt.Errorf("media: browsing metadata of %q returned %d objects, not 1",
+			objectID, len(didl.Containers)+len(didl.Items))
+	}
+	return didl, nil
+}
@@ -0,0 +1,73 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+func TestBrowse(t *testing.T) {
+	t.Parallel()
+	const numChildren = 250
+	sc := newTestServiceClient(t, av1.URN_ContentDirectory_1, func(action testAction) map[string]string {
+		var didl strings.Builder
+		didl.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/">`)
+		var returned int
+		if action.Args["BrowseFlag"] == BrowseMetadata {
+			didl.WriteString(`<container id="0" parentID="-1"><title>Root</title></container>`)
+			returned = 1
+		} else {
+			start, _ := strconv.Atoi(action.Args["StartingIndex"])
+			count, _ := strconv.Atoi(action.Args["RequestedCount"])
+			for i := start; i < numChildren && i < start+count; i++ {
+				fmt.Fprintf(&didl, `<item id="%d" parentID="0"><title>Track %d</title></item>`, i, i)
+				returned++
+			}
+		}
+		didl.WriteString(`</DIDL-Lite>`)
+		return map[string]string{
+			"Result":         didl.String(),
+			"NumberReturned": strconv.Itoa(returned),
+			"TotalMatches":   strconv.Itoa(numChildren),
+			"UpdateID":       "7",
+		}
+	})
+	cd := &av1.ContentDirectory1{ServiceClient: sc}
+	ctx := context.Background()
+
+	page, err := BrowseChildrenCtx(ctx, cd, RootObjectID, 240, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.NumberReturned != 10 || page.TotalMatches != numChildren || page.UpdateID != 7 {
+		t.Errorf("want 10 of %d returned with UpdateID 7, got %+v", numChildren, page)
+	}
+	if len(page.Items) != 10 || page.Items[0].ID != "240" {
+		t.Errorf("want items 240 to 249, got %+v", page.Items)
+	}
+
+	all, err := BrowseAllCtx(ctx, cd, RootObjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Items) != numChildren {
+		t.Fatalf("want %d items, got %d", numChildren, len(all.Items))
+	}
+	for i, item := range all.Items {
+		if want := fmt.Sprintf("Track %d", i); item.Title != want {
+			t.Errorf("item #%d: want %q, got %q", i, want, item.Title)
+		}
+	}
+
+	meta, err := BrowseMetadataCtx(ctx, cd, RootObjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Containers) != 1 || meta.Containers[0].Title != "Root" {
+		t.Errorf("want root container, got %+v", meta)
+	}
+}
@@ -0,0 +1,171 @@
+package media
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/fsedano/goupnp"
+)
+
+// DIDLLiteNamespace is the XML namespace of DIDL-Lite documents, which
+// describe the objects of a ContentDirectory.
+const DIDLLiteNamespace = "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"
+
+// DIDLLite is a DIDL-Lite document, as returned by Browse and as used for the
+// metadata of an AVTransport URI.
+type DIDLLite struct {
+	XMLName    xml.Name    `xml:"DIDL-Lite"`
+	Containers []Container `xml:"container"`
+	Items      []Item      `xml:"item"`
+}
+
+// Object holds the properties common to items and containers. Properties are
+// matched by their local name, as servers are inconsistent in declaring the
+// dc and upnp namespaces.
+type Object struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted bool   `xml:"restricted,attr"`
+
+	// Title is the dc:title of the object.
+	Title string `xml:"title"`
+	// Class is the upnp:class of the object, e.g. "object.item.audioItem.musicTrack"
+	// or "object.container.album.musicAlbum".
+	Class string `xml:"class"`
+
+	Creator     string     `xml:"creator"`
+	Artist      string     `xml:"artist"`
+	Album       string     `xml:"album"`
+	Genre       string     `xml:"genre"`
+	Date        string     `xml:"date"`
+	AlbumArtURI string     `xml:"albumArtURI"`
+	Resources   []Resource `xml:"res"`
+}
+
+// IsClass reports whether the object is of the given class, or is derived from
+// it. For example, a "object.item.audioItem.musicTrack" is an
+// "object.item.audioItem".
+func (obj *Object) IsClass(class string) bool {
+	return obj.Class == class || strings.HasPrefix(obj.Class, class+".")
+}
+
+// Container is a DIDL-Lite container, such as a folder or an album.
+type Container struct {
+	Object
+	// ChildCount is the number of children of the container, if reported.
+	ChildCount uint32 `xml:"childCount,attr"`
+	Searchable bool   `xml:"searchable,attr"`
+}
+
+// Item is a DIDL-Lite item, such as a track or a photo.
+type Item struct {
+	Object
+	// RefID is the ID of the item that this item refers to, if any.
+	RefID string `xml:"refID,attr"`
+}
+
+// Resource is a resource of an object, typically a URL from which its media
+// can be fetched in some format.
+type Resource struct {
+	URL string `xml:",chardata"`
+	// ProtocolInfo describes the transport and format of the resource, e.g.
+	// "http-get:*:audio/mpeg:*", see ParseProtocolInfo.
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         uint64 `xml:"size,attr"`
+	// Duration is the duration of the media, see ParseDuration.
+	Duration   string `xml:"duration,attr"`
+	Bitrate    uint32 `xml:"bitrate,attr"`
+	Resolution string `xml:"resolution,attr"`
+}
+
+// MimeType returns the content format of ProtocolInfo, or "" if it does not
+// give one.
+func (res *Resource) MimeType() string {
+	info, err := ParseProtocolInfo(res.ProtocolInfo)
+	if err != nil || info.ContentFormat == "*" {
+		return ""
+	}
+	return info.ContentFormat
+}
+
+// ProtocolInfo is the parsed protocolInfo of a resource, which has four
+// colon-separated fields. Unspecified fields are "*".
+type ProtocolInfo struct {
+	// Protocol is how the resource is transported, e.g. "http-get" or
+	// "rtsp-rtp-udp".
+	Protocol string
+	// Network is the network that the protocol applies to, which is "*" for
+	// HTTP.
+	Network string
+	// ContentFormat is the MIME type of the resource for HTTP, e.g.
+	// "audio/mpeg".
+	ContentFormat string
+	// AdditionalInfo is specific to the protocol. For DLNA content, it holds
+	// the parameters returned by DLNAParams.
+	AdditionalInfo string
+}
+
+// ParseProtocolInfo parses a protocolInfo value, such as
+// "http-get:*:audio/mpeg:DLNA.ORG_PN=MP3;DLNA.ORG_OP=01".
+func ParseProtocolInfo(s string) (ProtocolInfo, error) {
+	fields := strings.SplitN(strings.TrimSpace(s), ":", 4)
+	if len(fields) != 4 {
+		return ProtocolInfo{}, fmt.Errorf("media: invalid protocolInfo %q", s)
+	}
+	return ProtocolInfo{
+		Protocol:       fields[0],
+		Network:        fields[1],
+		ContentFormat:  fields[2],
+		AdditionalInfo: fields[3],
+	}, nil
+}
+
+// String returns the protocolInfo value that info was parsed from.
+func (info ProtocolInfo) String() string {
+	return info.Protocol + ":" + info.Network + ":" + info.ContentFormat + ":" + info.AdditionalInfo
+}
+
+// DLNAParams returns the semicolon-separated name=value parameters of
+// AdditionalInfo, such as DLNA.ORG_PN (the media profile) and DLNA.ORG_OP
+// (the seek operations supported), or nil if there are none.
+func (info ProtocolInfo) DLNAParams() map[string]string {
+	if info.AdditionalInfo == "*" || info.AdditionalInfo == "" {
+		return nil
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(info.AdditionalInfo, ";") {
+		if i := strings.IndexByte(param, '='); i > 0 {
+			params[strings.TrimSpace(param[:i])] = strings.TrimSpace(param[i+1:])
+		}
+	}
+	return params
+}
+
+// ParseDIDLLite decodes a DIDL-Lite document, such as the Result of a Browse
+// action, which is sent as (escaped) text within the SOAP response. As for
+// device descriptions, goupnp.CharsetReaderDefault is used for documents that
+// are not UTF-8.
+func ParseDIDLLite(s string) (*DIDLLite, error) {
+	didl := new(DIDLLite)
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	decoder.CharsetReader = goupnp.CharsetReaderDefault
+	if err := decoder.Decode(didl); err != nil {
+		return nil, fmt.Errorf("media: error decoding DIDL-Lite: %v", err)
+	}
+	for i := range didl.Containers {
+		trimResources(didl.Containers[i].Resources)
+	}
+	for i := range didl.Items {
+		trimResources(didl.Items[i].Resources)
+	}
+	return didl, nil
+}
+
+// trimResources trims the whitespace that servers commonly include around
+// resource URLs.
+func trimResources(resources []Resource) {
+	for i := range resources {
+		resources[i].URL = strings.TrimSpace(resources[i].URL)
+	}
+}
@@ -0,0 +1,135 @@
+package media
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testDIDL = `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"
+		xmlns:dc="http://purl.org/dc/elements/1.1/"
+		xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">
+	<container id="1$4" parentID="1" restricted="1" childCount="12" searchable="1">
+		<dc:title>Albums</dc:title>
+		<upnp:class>object.container.storageFolder</upnp:class>
+	</container>
+	<item id="1$4$7" parentID="1$4" restricted="true">
+		<dc:title>Song &amp; Dance</dc:title>
+		<dc:creator>The Band</dc:creator>
+		<upnp:artist>The Band</upnp:artist>
+		<upnp:album>Greatest</upnp:album>
+		<upnp:class>object.item.audioItem.musicTrack</upnp:class>
+		<upnp:albumArtURI>http://192.0.2.5/art/7.jpg</upnp:albumArtURI>
+		<res protocolInfo="http-get:*:audio/mpeg:*" size="4012345" duration="0:03:25.000" bitrate="16000">
+			http://192.0.2.5/media/7.mp3
+		</res>
+		<res protocolInfo="http-get:*:audio/x-flac:*">http://192.0.2.5/media/7.flac</res>
+	</item>
+</DIDL-Lite>`
+
+func TestParseDIDLLite(t *testing.T) {
+	t.Parallel()
+	didl, err := ParseDIDLLite(testDIDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantContainers := []Container{{
+		Object: Object{
+			ID:         "1$4",
+			ParentID:   "1",
+			Restricted: true,
+			Title:      "Albums",
+			Class:      "object.container.storageFolder",
+		},
+		ChildCount: 12,
+		Searchable: true,
+	}}
+	if !reflect.DeepEqual(wantContainers, didl.Containers) {
+		t.Errorf("want containers %+v, got %+v", wantContainers, didl.Containers)
+	}
+
+	wantItems := []Item{{
+		Object: Object{
+			ID:          "1$4$7",
+			ParentID:    "1$4",
+			Restricted:  true,
+			Title:       "Song & Dance",
+			Class:       "object.item.audioItem.musicTrack",
+			Creator:     "The Band",
+			Artist:      "The Band",
+			Album:       "Greatest",
+			AlbumArtURI: "http://192.0.2.5/art/7.jpg",
+			Resources: []Resource{
+				{
+					URL:          "http://192.0.2.5/media/7.mp3",
+					ProtocolInfo: "http-get:*:audio/mpeg:*",
+					Size:         4012345,
+					Duration:     "0:03:25.000",
+					Bitrate:      16000,
+				},
+				{
+					URL:          "http://192.0.2.5/media/7.flac",
+					ProtocolInfo: "http-get:*:audio/x-flac:*",
+				},
+			},
+		},
+	}}
+	if !reflect.DeepEqual(wantItems, didl.Items) {
+		t.Errorf("want items %+v, got %+v", wantItems, didl.Items)
+	}
+
+	item := &didl.Items[0]
+	if !item.IsClass("object.item.audioItem") || item.IsClass("object.item.audio") {
+		t.Errorf("IsClass gave wrong results for %q", item.Class)
+	}
+	if want, got := "audio/mpeg", item.Resources[0].MimeType(); want != got {
+		t.Errorf("want MIME type %q, got %q", want, got)
+	}
+
+	if _, err := ParseDIDLLite("<DIDL-Lite><item>"); err == nil {
+		t.Error("want error for truncated DIDL-Lite, got nil")
+	}
+}
+
+func TestParseProtocolInfo(t *testing.T) {
+	t.Parallel()
+	const s = "http-get:*:audio/mpeg:DLNA.ORG_PN=MP3;DLNA.ORG_OP=01;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+	info, err := ParseProtocolInfo(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProtocolInfo{
+		Protocol:       "http-get",
+		Network:        "*",
+		ContentFormat:  "audio/mpeg",
+		AdditionalInfo: "DLNA.ORG_PN=MP3;DLNA.ORG_OP=01;DLNA.ORG_FLAGS=01700000000000000000000000000000",
+	}
+	if want != info {
+		t.Errorf("want %+v, got %+v", want, info)
+	}
+	if got := info.String(); got != s {
+		t.Errorf("want String %q, got %q", s, got)
+	}
+	wantParams := map[string]string{
+		"DLNA.ORG_PN":    "MP3",
+		"DLNA.ORG_OP":    "01",
+		"DLNA.ORG_FLAGS": "01700000000000000000000000000000",
+	}
+	if got := info.DLNAParams(); !reflect.DeepEqual(wantParams, got) {
+		t.Errorf("want DLNA params %v, got %v", wantParams, got)
+	}
+
+	info, err = ParseProtocolInfo("http-get:*:video/mp4:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params := info.DLNAParams(); params != nil {
+		t.Errorf("want no DLNA params, got %v", params)
+	}
+
+	for _, s := range []string{"", "http-get:*:audio/mpeg", "garbage"} {
+		if _, err := ParseProtocolInfo(s); err == nil {
+			t.Errorf("%q: want error, got nil", s)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package media provides helpers for controlling UPnP AV (DLNA) media
+// renderers and servers, built on the clients in
+// github.com/fsedano/goupnp/dcps/av1.
+package media
+
+import (
+	"context"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+// AVTransport is the set of methods common to the AVTransport1 and
+// AVTransport2 clients in av1. Either client can be used where an AVTransport
+// is required.
+type AVTransport interface {
+	SetAVTransportURICtx(
+		ctx context.Context,
+		InstanceID uint32,
+		CurrentURI string,
+		CurrentURIMetaData string,
+	) (err error)
+
+	PlayCtx(
+		ctx context.Context,
+		InstanceID uint32,
+		Speed string,
+	) (err error)
+
+	PauseCtx(
+		ctx context.Context,
+		InstanceID uint32,
+	) (err error)
+
+	StopCtx(
+		ctx context.Context,
+		InstanceID uint32,
+	) (err error)
+
+	GetPositionInfoCtx(
+		ctx context.Context,
+		InstanceID uint32,
+	) (Track uint32, TrackDuration string, TrackMetaData string, TrackURI string, RelTime string, AbsTime string, RelCount int32, AbsCount int32, err error)
+
+	GetServiceClient() *goupnp.ServiceClient
+}
+
+var (
+	_ AVTransport = &av1.AVTransport1{}
+	_ AVTransport = &av1.AVTransport2{}
+)
+
+// avTransportURNs are the AVTransport service types, in order of preference.
+var avTransportURNs = []string{av1.URN_AVTransport_2, av1.URN_AVTransport_1}
+
+// DiscoverAVTransportsCtx discovers all AVTransport services (typically one
+// per media renderer) on the network in a single search, and returns clients
+// for them. AVTransport2 clients are returned before AVTransport1 clients.
+// errors will contain an error for any devices that replied but which could
+// not be queried, and err will be set if the discovery process failed
+// outright.
+func DiscoverAVTransportsCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (clients []AVTransport, errors []error, err error) {
+	var genericClients []goupnp.ServiceClient
+	if genericClients, errors, err = discoverServiceClients(ctx, avTransportURNs, opts); err != nil {
+		return
+	}
+	for _, gc := range genericClients {
+		switch gc.Service.ServiceType {
+		case av1.URN_AVTransport_2:
+			clients = append(clients, &av1.AVTransport2{ServiceClient: gc})
+		case av1.URN_AVTransport_1:
+			clients = append(clients, &av1.AVTransport1{ServiceClient: gc})
+		}
+	}
+	return
+}
+
+// discoverServiceClients discovers the services of the given types in a single
+// search, and returns clients for them ordered by service type as in urns.
+func discoverServiceClients(ctx context.Context, urns []string, opts []goupnp.DiscoveryOption) (clients []goupnp.ServiceClient, errors []error, err error) {
+	var byURN map[string][]goupnp.MaybeRootDevice
+	if byURN, err = goupnp.DiscoverMultiCtx(ctx, urns, opts...); err != nil {
+		return
+	}
+
+	for _, urn := range urns {
+		for _, maybe := range byURN[urn] {
+			urnClients, err := goupnp.NewServiceClientsFromMaybeRootDevice(&maybe, urn, opts...)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+			clients = append(clients, urnClients...)
+		}
+	}
+	return
+}
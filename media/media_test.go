@@ -0,0 +1,113 @@
+package media
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fsedano/goupnp"
+)
+
+const testRendererXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+		<friendlyName>Test Renderer</friendlyName>
+		<UDN>uuid:renderer</UDN>
+		<serviceList>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:AVTransport:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:AVTransport</serviceId>
+				<controlURL>/ctl/AVTransport</controlURL>
+			</service>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:RenderingControl:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:RenderingControl</serviceId>
+				<controlURL>/ctl/RenderingControl</controlURL>
+			</service>
+			<service>
+				<serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+				<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+				<controlURL>/ctl/ContentDirectory</controlURL>
+			</service>
+		</serviceList>
+	</device>
+</root>`
+
+// testAction is a SOAP action received by a test device.
+type testAction struct {
+	Name string
+	Args map[string]string
+}
+
+// newTestServiceClient starts a device that answers every action with the
+// response arguments returned by respond, and returns a client for its service
+// of type urn.
+func newTestServiceClient(t *testing.T, urn string, respond func(action testAction) map[string]string) goupnp.ServiceClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action, err := decodeTestAction(r)
+		if err != nil {
+			t.Errorf("decoding action: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var body strings.Builder
+		body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`)
+		body.WriteString(`<u:` + action.Name + `Response xmlns:u="` + urn + `">`)
+		for name, value := range respond(action) {
+			body.WriteString("<" + name + ">")
+			xml.EscapeText(&body, []byte(value))
+			body.WriteString("</" + name + ">")
+		}
+		body.WriteString(`</u:` + action.Name + `Response></s:Body></s:Envelope>`)
+		w.Write([]byte(body.String()))
+	}))
+	t.Cleanup(srv.Close)
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := goupnp.ParseRootDevice([]byte(testRendererXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := goupnp.NewServiceClientsFromRootDevice(root, loc, urn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clients[0]
+}
+
+// decodeTestAction decodes the action and its arguments from a SOAP request.
+func decodeTestAction(r *http.Request) (testAction, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return testAction{}, err
+	}
+	var env struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return testAction{}, err
+	}
+	action := testAction{Name: env.Body.Action.XMLName.Local, Args: make(map[string]string)}
+	for _, arg := range env.Body.Action.Args {
+		action.Args[arg.XMLName.Local] = arg.Value
+	}
+	return action, nil
+}
@@ -0,0 +1,132 @@
+package media
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fsedano/goupnp"
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+// RenderingControl is the set of methods common to the RenderingControl1 and
+// RenderingControl2 clients in av1. Either client can be used where a
+// RenderingControl is required.
+type RenderingControl interface {
+	GetVolumeCtx(
+		ctx context.Context,
+		InstanceID uint32,
+		Channel string,
+	) (CurrentVolume uint16, err error)
+
+	SetVolumeCtx(
+		ctx context.Context,
+		InstanceID uint32,
+		Channel string,
+		DesiredVolume uint16,
+	) (err error)
+
+	GetMuteCtx(
+		ctx context.Context,
+		InstanceID uint32,
+		Channel string,
+	) (CurrentMute bool, err error)
+
+	SetMuteCtx(
+		ctx context.Context,
+		InstanceID uint32,
+		Channel string,
+		DesiredMute bool,
+	) (err error)
+
+	GetServiceClient() *goupnp.ServiceClient
+}
+
+var (
+	_ RenderingControl = &av1.RenderingControl1{}
+	_ RenderingControl = &av1.RenderingControl2{}
+)
+
+// renderingControlURNs are the RenderingControl service types, in order of
+// preference.
+var renderingControlURNs = []string{av1.URN_RenderingControl_2, av1.URN_RenderingControl_1}
+
+// DiscoverRenderingControlsCtx discovers all RenderingControl services on the
+// network in a single search, and returns clients for them. RenderingControl2
+// clients are returned before RenderingControl1 clients. errors and err are as
+// for DiscoverAVTransportsCtx.
+func DiscoverRenderingControlsCtx(ctx context.Context, opts ...goupnp.DiscoveryOption) (clients []RenderingControl, errors []error, err error) {
+	var genericClients []goupnp.ServiceClient
+	if genericClients, errors, err = discoverServiceClients(ctx, renderingControlURNs, opts); err != nil {
+		return
+	}
+	for _, gc := range genericClients {
+		switch gc.Service.ServiceType {
+		case av1.URN_RenderingControl_2:
+			clients = append(clients, &av1.RenderingControl2{ServiceClient: gc})
+		case av1.URN_RenderingControl_1:
+			clients = append(clients, &av1.RenderingControl1{ServiceClient: gc})
+		}
+	}
+	return
+}
+
+// Channel is an audio channel of a renderer, as used for volume and mute
+// control. Renderers may also support vendor-defined channels.
+type Channel string
+
+const (
+	// ChannelMaster is the master volume, which applies to all channels.
+	ChannelMaster Channel = "Master"
+	// ChannelLF is the left front channel.
+	ChannelLF Channel = "LF"
+	// ChannelRF is the right front channel.
+	ChannelRF Channel = "RF"
+)
+
+// MaxVolume is the maximum volume. The specification allows renderers to use
+// any range starting at zero, but 0 to 100 is all but universal, and what
+// controllers assume.
+const MaxVolume = 100
+
+// channelOrMaster returns ch, or ChannelMaster if ch is empty.
+func channelOrMaster(ch Channel) string {
+	if ch == "" {
+		return string(ChannelMaster)
+	}
+	return string(ch)
+}
+
+// GetVolumeCtx returns the volume of the channel, between 0 and MaxVolume. An
+// empty channel means ChannelMaster.
+func GetVolumeCtx(ctx context.Context, rc RenderingControl, ch Channel) (uint16, error) {
+	volume, err := rc.GetVolumeCtx(ctx, InstanceID, channelOrMaster(ch))
+	if err != nil {
+		return 0, err
+	}
+	if volume > MaxVolume {
+		return 0, fmt.Errorf("media: renderer reported volume %d for channel %s, outside of 0-%d",
+			volume, channelOrMaster(ch), MaxVolume)
+	}
+	return volume, nil
+}
+
+// SetVolumeCtx sets the volume of the channel, which must be between 0 and
+// MaxVolume. An empty channel means ChannelMaster.
+func SetVolumeCtx(ctx context.Context, rc RenderingControl, ch Channel, volume uint16) error {
+	if volume > MaxVolume {
+		return fmt.Errorf("media: volume %d is outside of 0-%d", volume, MaxVolume)
+	}
+	return rc.SetVolumeCtx(ctx, InstanceID, channelOrMaster(ch), volume)
+}
+
+// GetMuteCtx reports whether the channel is muted. An empty channel means
+// ChannelMaster.
+func GetMuteCtx(ctx context.Context, rc RenderingControl, ch Channel) (bool, error) {
+	return rc.GetMuteCtx(ctx, InstanceID, channelOrMaster(ch))
+}
+
+// SetMuteCtx mutes or unmutes the channel. An empty channel means
+// ChannelMaster.
+func SetMuteCtx(ctx context.Context, rc RenderingControl, ch Channel, mute bool) error {
+	return rc.SetMuteCtx(ctx, InstanceID, channelOrMaster(ch), mute)
+}
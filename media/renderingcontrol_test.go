@@ -0,0 +1,63 @@
+package media
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/fsedano/goupnp/dcps/av1"
+)
+
+func TestRenderingControl(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	volumes := map[string]string{"Master": "30", "LF": "250"}
+	mutes := map[string]string{}
+	sc := newTestServiceClient(t, av1.URN_RenderingControl_1, func(action testAction) map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		ch := action.Args["Channel"]
+		switch action.Name {
+		case "GetVolume":
+			return map[string]string{"CurrentVolume": volumes[ch]}
+		case "SetVolume":
+			volumes[ch] = action.Args["DesiredVolume"]
+		case "GetMute":
+			return map[string]string{"CurrentMute": mutes[ch]}
+		case "SetMute":
+			mutes[ch] = action.Args["DesiredMute"]
+		}
+		return nil
+	})
+	rc := &av1.RenderingControl1{ServiceClient: sc}
+	ctx := context.Background()
+
+	if got, err := GetVolumeCtx(ctx, rc, ""); err != nil {
+		t.Fatal(err)
+	} else if got != 30 {
+		t.Errorf("want master volume 30, got %d", got)
+	}
+	if got, err := GetVolumeCtx(ctx, rc, ChannelLF); err == nil {
+		t.Errorf("want error for out of range volume, got %d", got)
+	}
+	if err := SetVolumeCtx(ctx, rc, ChannelRF, 101); err == nil {
+		t.Error("want error setting volume 101, got nil")
+	}
+	if err := SetVolumeCtx(ctx, rc, ChannelRF, MaxVolume); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetVolumeCtx(ctx, rc, ChannelRF); err != nil {
+		t.Fatal(err)
+	} else if got != MaxVolume {
+		t.Errorf("want RF volume %d, got %d", MaxVolume, got)
+	}
+
+	if err := SetMuteCtx(ctx, rc, ChannelMaster, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetMuteCtx(ctx, rc, ""); err != nil {
+		t.Fatal(err)
+	} else if !got {
+		t.Error("want master muted, got unmuted")
+	}
+}
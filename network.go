@@ -10,22 +10,46 @@ import (
 // httpuClient creates a HTTPU client that multiplexes to all multicast-capable
 // IPv4 addresses on the host. Returns a function to clean up once the client is
 // no longer required.
-func httpuClient() (httpu.ClientInterfaceCtx, func(), error) {
+func httpuClient(opts *discoveryOptions) (*httpu.MultiClientCtx, func(), error) {
 	addrs, err := localIPv4MCastAddrs()
 	if err != nil {
 		return nil, nil, ctxError(err, "requesting host IPv4 addresses")
 	}
+	return httpuClientForAddrs(opts, addrs)
+}
+
+// httpuClientForAddrs creates a HTTPU client that multiplexes to each of addrs.
+// Addresses that a client cannot be set up for, such as those of an interface
+// that has gone down, are skipped (and logged, if opts has a logger), so that
+// they do not prevent searching from the others. An error wrapping
+// ErrNoMulticastInterface is returned if no address can be used. Likewise,
+// searches only fail if sending from every address fails.
+func httpuClientForAddrs(opts *discoveryOptions, addrs []string) (*httpu.MultiClientCtx, func(), error) {
+	if len(addrs) == 0 {
+		return nil, nil, ErrNoMulticastInterface
+	}
 
 	closers := make([]io.Closer, 0, len(addrs))
 	delegates := make([]httpu.ClientInterfaceCtx, 0, len(addrs))
+	delegateAddrs := make(map[httpu.ClientInterfaceCtx]string, len(addrs))
+	var firstErr error
 	for _, addr := range addrs {
-		c, err := httpu.NewHTTPUClientAddr(addr)
+		c, err := newHTTPUClient(opts, addr)
 		if err != nil {
-			return nil, nil, ctxErrorf(err,
-				"creating HTTPU client for address %s", addr)
+			if opts.logger != nil {
+				opts.logger.Printf("goupnp: skipping address %s for search: %v", addr, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 		closers = append(closers, c)
 		delegates = append(delegates, c)
+		delegateAddrs[c] = addr
+	}
+	if len(delegates) == 0 {
+		return nil, nil, sentinelError{ErrNoMulticastInterface, firstErr}
 	}
 
 	closer := func() {
@@ -34,7 +58,29 @@ func httpuClient() (httpu.ClientInterfaceCtx, func(), error) {
 		}
 	}
 
-	return httpu.NewMultiClientCtx(delegates), closer, nil
+	mc := httpu.NewMultiClientCtx(delegates)
+	// Search from the other addresses if sending from some fails.
+	mc.SetErrorHandler(func(delegate httpu.ClientInterfaceCtx, err error) {
+		if opts.logger != nil {
+			opts.logger.Printf("goupnp: search from address %s failed: %v", delegateAddrs[delegate], err)
+		}
+	})
+	return mc, closer, nil
+}
+
+// newHTTPUClient creates a HTTPU client for the local address addr.
+func newHTTPUClient(opts *discoveryOptions, addr string) (*httpu.HTTPUClient, error) {
+	c, err := httpu.NewHTTPUClientAddrPort(addr, opts.sourcePort)
+	if err != nil {
+		return nil, ctxErrorf(err,
+			"creating HTTPU client for address %s", addr)
+	}
+	if err := c.SetMulticastLoopback(opts.mcastLoop); err != nil && !opts.mcastLoop {
+		c.Close()
+		return nil, ctxErrorf(err,
+			"disabling multicast loopback for address %s", addr)
+	}
+	return c, nil
 }
 
 // localIPv2MCastAddrs returns the set of IPv4 addresses on multicast-able
@@ -0,0 +1,34 @@
+package goupnp
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestHTTPUClientForAddrsSkipsFailures(t *testing.T) {
+	t.Parallel()
+	// 203.0.113.123 is a documentation address that is not assigned to the host,
+	// so binding to it fails as for an interface that has gone down.
+	var logged bytes.Buffer
+	o := newDiscoveryOptions([]DiscoveryOption{WithLogger(log.New(&logged, "", 0))})
+
+	hc, cleanup, err := httpuClientForAddrs(o, []string{"203.0.113.123", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if hc == nil {
+		t.Fatal("want client, got nil")
+	}
+	if want := "skipping address 203.0.113.123"; !strings.Contains(logged.String(), want) {
+		t.Errorf("want log containing %q, got %q", want, logged.String())
+	}
+
+	_, _, err = httpuClientForAddrs(newDiscoveryOptions(nil), []string{"203.0.113.123", "203.0.113.124"})
+	if !errors.Is(err, ErrNoMulticastInterface) {
+		t.Errorf("want ErrNoMulticastInterface when no address can be used, got %v", err)
+	}
+}
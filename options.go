@@ -0,0 +1,399 @@
+package goupnp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fsedano/goupnp/soap"
+	"github.com/fsedano/goupnp/ssdp"
+)
+
+// DiscoveryOption is the type for optional configuration of discovery, as
+// performed by DiscoverDevicesCtx and related functions.
+type DiscoveryOption func(*discoveryOptions)
+
+type discoveryOptions struct {
+	sourcePort  int
+	numSends    int
+	http1Only   bool
+	cache       *DiscoveryCache
+	maxXMLBytes int64
+	mcastLoop   bool
+	mcastGroup  string
+	unquotedMAN bool
+	logger      *log.Logger
+	noRedirects bool
+	progress    func(found int)
+	dialCheck   time.Duration
+	sameSubnet  bool
+	subnetMask  net.IPMask
+
+	validateTarget bool
+	validateReport func(device *MaybeRootDevice, ok bool)
+
+	unicastTargets []string
+
+	localAddrPolicy LocalAddrPolicy
+
+	transport   http.RoundTripper
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	retryPolicy *soap.RetryPolicy
+	actionCache *soap.ActionCache
+
+	dialTimeout         time.Duration
+	tlsHandshakeTimeout time.Duration
+	// roundTripper is the transport for requests to devices, which is set
+	// from the other options by newDiscoveryOptions. Nil means the default.
+	roundTripper http.RoundTripper
+	// ownTransport is roundTripper if newDiscoveryOptions created it.
+	ownTransport *http.Transport
+}
+
+func newDiscoveryOptions(opts []DiscoveryOption) *discoveryOptions {
+	o := &discoveryOptions{
+		numSends:    3,
+		maxXMLBytes: MaxXMLBytesDefault,
+		mcastLoop:   true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	switch {
+	case o.transport != nil:
+		o.roundTripper = o.transport
+	case o.dialContext != nil || o.dialTimeout > 0 || o.tlsHandshakeTimeout > 0:
+		// Create a transport for each discovery, rather than each request,
+		// so that connections to devices are reused.
+		base := http.DefaultTransport
+		if o.http1Only {
+			base = soap.HTTP1Transport
+		}
+		t := cloneTransport(base)
+		if dial := o.dialContext; o.dialTimeout > 0 {
+			if dial == nil {
+				dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+			}
+			t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, o.dialTimeout)
+				defer cancel()
+				return dial(ctx, network, addr)
+			}
+		} else if dial != nil {
+			t.DialContext = dial
+		}
+		if o.tlsHandshakeTimeout > 0 {
+			t.TLSHandshakeTimeout = o.tlsHandshakeTimeout
+		}
+		o.roundTripper = t
+		o.ownTransport = t
+	case o.http1Only:
+		o.roundTripper = soap.HTTP1Transport
+	}
+	return o
+}
+
+// cloneTransport returns a copy of base, or a new Transport if base is not an
+// *http.Transport, as when an application has replaced http.DefaultTransport
+// with an instrumenting wrapper.
+func cloneTransport(base http.RoundTripper) *http.Transport {
+	if t, ok := base.(*http.Transport); ok {
+		return t.Clone()
+	}
+	return &http.Transport{}
+}
+
+// closeIdleConnections closes the idle connections of any transport that
+// newDiscoveryOptions created, once the discovery using it has finished, so
+// that they are not kept alive after it is no longer used.
+func (o *discoveryOptions) closeIdleConnections() {
+	if o.ownTransport != nil {
+		o.ownTransport.CloseIdleConnections()
+	}
+}
+
+// WithSourcePort sets the local UDP port that search requests are sent from,
+// and so the port that responses must be sent to. This can help where
+// firewalls only pass responses to a specific port, such as the SSDP port
+// 1900. The default picks an ephemeral port.
+//
+// Each search socket binds the port, so this cannot be combined with
+// WithUnicastTargets, whose sockets would conflict with those of the multicast
+// search; DiscoverDevicesCtx, DiscoverMultiCtx, DiscoverByUDNCtx and ScanCtx
+// return an error if both are given.
+func WithSourcePort(port int) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.sourcePort = port
+	}
+}
+
+// WithSearchSends sets the number of times that each M-SEARCH request is sent.
+// Sending more than once helps on lossy networks, as SSDP runs over UDP. Fewer
+// sends are slightly faster on a quiet network. The default is 3.
+//
+// All sends happen before waiting for responses, so this does not lengthen the
+// time spent waiting. Devices commonly respond to every request they receive,
+// but duplicate responses (with the same location and USN) are discarded, so
+// each device is still only reported and probed once. Values less than 1
+// leave the default.
+func WithSearchSends(numSends int) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		if numSends > 0 {
+			o.numSends = numSends
+		}
+	}
+}
+
+// WithHTTP1Only fetches device descriptions over a transport that never
+// attempts HTTP/2, for embedded devices which advertise HTTP/2 but do not
+// implement it reliably. Clients created by NewServiceClientsCtx also use such
+// a transport for SOAP requests, as by soap.WithHTTP1Only. The default uses
+// HTTPClientDefault.
+func WithHTTP1Only() DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.http1Only = true
+	}
+}
+
+// WithCache makes DiscoverDevicesCtx, DiscoverMultiCtx and DiscoverByUDNCtx
+// return unexpired devices from cache for the search target instead of
+// searching the network. If there are none, the network is searched and the
+// devices found are added to cache.
+func WithCache(cache *DiscoveryCache) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.cache = cache
+	}
+}
+
+// WithMaxXMLBytes overrides MaxXMLBytesDefault as the maximum size of device
+// descriptions that are fetched. Zero or less means unlimited.
+func WithMaxXMLBytes(n int64) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.maxXMLBytes = n
+	}
+}
+
+// WithFollowRedirects sets whether redirects are followed when requesting
+// device descriptions, which they are by default. When disabled, a redirect is
+// returned as a *RedirectError instead, so that the caller can decide whether
+// to trust its Location; a captive portal, for example, may redirect requests
+// for a device's description to its login page.
+func WithFollowRedirects(follow bool) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.noRedirects = !follow
+	}
+}
+
+// WithProgress calls progress as each device found by DiscoverDevicesCtx has
+// been probed, with the number of devices found so far, e.g. so that a long
+// ssdp:all search can show its progress. Devices that could not be probed are
+// counted. progress is called once with the number of devices returned from
+// any cache set by WithCache.
+func WithProgress(progress func(found int)) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.progress = progress
+	}
+}
+
+// WithSearchTargetValidation makes DiscoverDevicesCtx, DiscoverMultiCtx and
+// DiscoverByUDNCtx check that each device that they probe actually has the
+// search target: a device of the device type, a service of the service type,
+// or a device with the UDN. Devices that do not are removed from the results.
+// This filters out devices that respond to searches for targets that they do
+// not expose, such as those that answer every search as if it were ssdp:all.
+// Devices that could not be probed are kept, with their error.
+//
+// If report is non-nil, it is called with each probed device and whether it
+// has the search target, before the results are returned.
+func WithSearchTargetValidation(report func(device *MaybeRootDevice, ok bool)) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.validateTarget = true
+		o.validateReport = report
+	}
+}
+
+// WithReachabilityCheck makes a TCP connection to the host of each device
+// description URL, allowing it timeout, before requesting the description.
+// Devices that advertise stale addresses then fail quickly with an error
+// matching ErrDeviceUnreachable, rather than after the request times out. Zero,
+// the default, disables the check.
+func WithReachabilityCheck(timeout time.Duration) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.dialCheck = timeout
+	}
+}
+
+// WithUnicastTargets makes DiscoverDevicesCtx, DiscoverMultiCtx and
+// DiscoverByUDNCtx also send their search requests directly to each of hosts,
+// in the form "host" or "host:port" where port is the search port
+// (ssdp.DefaultSearchPort by default), for networks where multicast is
+// unreliable. The searches run at the same time as the multicast search, and
+// devices that respond to both are only returned once. Failures to search
+// hosts are logged to any logger set by WithLogger, and do not fail the
+// discovery.
+func WithUnicastTargets(hosts ...string) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.unicastTargets = hosts
+	}
+}
+
+// WithMulticastLoopback sets whether search requests are looped back to the
+// local host, see httpu.HTTPUClient.SetMulticastLoopback. This is needed to
+// discover devices running on the same host, and defaults to enabled. Failure
+// to enable it is ignored, so as not to prevent discovery of other devices.
+func WithMulticastLoopback(enabled bool) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.mcastLoop = enabled
+	}
+}
+
+// WithMulticastGroup sends search requests to the multicast group at addr, in
+// the form "host:port", instead of the standard SSDP group
+// 239.255.255.250:1900. See ssdp.WithMulticastGroup.
+func WithMulticastGroup(addr string) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.mcastGroup = addr
+	}
+}
+
+// WithUnquotedMAN sends search requests with an unquoted MAN header, for
+// nonconforming devices that ignore the quoted form that the specification
+// requires. See ssdp.WithUnquotedMAN.
+func WithUnquotedMAN() DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.unquotedMAN = true
+	}
+}
+
+// WithLogger logs problems that discovery works around, such as network
+// interfaces that cannot be searched from, to logger, along with actions
+// retried by the SOAP clients of discovered services. The default does not log
+// them.
+func WithLogger(logger *log.Logger) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.logger = logger
+	}
+}
+
+// WithDialContext makes requests to devices, for their descriptions and SOAP
+// actions, dial connections with dial, e.g to pin them to an interface or send
+// them through a tunnel. Otherwise, the transport is as for the default or
+// WithHTTP1Only.
+//
+// Search requests are not affected, so the LocalAddr of discovered devices is
+// still the address that they were found from, which may not be the address
+// that dial connects from. Use an address that the device can reach when
+// passing LocalAddr to the device, e.g as the internal client of a port
+// mapping.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.dialContext = dial
+	}
+}
+
+// WithDialTimeout limits the time allowed to connect to devices, for requests
+// for their descriptions and SOAP actions, so that a device which does not
+// accept the connection fails before the whole request times out. This
+// applies to any dial set by WithDialContext. The default is the 30 second
+// limit of http.DefaultTransport.
+func WithDialTimeout(timeout time.Duration) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithTLSHandshakeTimeout limits the time allowed for the TLS handshake with
+// devices served over HTTPS, after the connection has been made, as for
+// WithDialTimeout. The default is the 10 second limit of
+// http.DefaultTransport.
+func WithTLSHandshakeTimeout(timeout time.Duration) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.tlsHandshakeTimeout = timeout
+	}
+}
+
+// WithTransport makes requests to devices, for their descriptions and SOAP
+// actions, over transport. This takes precedence over WithHTTP1Only,
+// WithDialContext, WithDialTimeout and WithTLSHandshakeTimeout. As for
+// WithDialContext, LocalAddr is not affected.
+func WithTransport(transport http.RoundTripper) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.transport = transport
+	}
+}
+
+// WithRetryPolicy makes clients created by NewServiceClientsCtx retry actions
+// that fail with transient faults, see soap.WithRetryPolicy.
+func WithRetryPolicy(policy soap.RetryPolicy) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithActionCache makes clients created by NewServiceClientsCtx answer
+// read-only actions from cache while their responses are fresh, see
+// soap.WithActionCache.
+func WithActionCache(cache *soap.ActionCache) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.actionCache = cache
+	}
+}
+
+// searchOptions returns the options for SSDP searches.
+func (o *discoveryOptions) searchOptions() []ssdp.SearchOption {
+	var opts []ssdp.SearchOption
+	if o.mcastGroup != "" {
+		opts = append(opts, ssdp.WithMulticastGroup(o.mcastGroup))
+	}
+	if o.unquotedMAN {
+		opts = append(opts, ssdp.WithUnquotedMAN())
+	}
+	return opts
+}
+
+// httpClient returns the client to fetch device descriptions with.
+func (o *discoveryOptions) httpClient() *http.Client {
+	if o.roundTripper == nil && !o.noRedirects {
+		return HTTPClientDefault
+	}
+	client := *HTTPClientDefault
+	if o.roundTripper != nil {
+		client.Transport = o.roundTripper
+	}
+	if o.noRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return &client
+}
+
+// checkSourcePort returns an error if a source port is set along with unicast
+// targets, see WithSourcePort.
+func (o *discoveryOptions) checkSourcePort() error {
+	if o.sourcePort != 0 && len(o.unicastTargets) > 0 {
+		return errors.New("goupnp: WithSourcePort cannot be combined with WithUnicastTargets")
+	}
+	return nil
+}
+
+// soapOptions returns the options for SOAP clients of discovered services.
+func (o *discoveryOptions) soapOptions() []soap.Option {
+	var opts []soap.Option
+	if o.roundTripper != nil {
+		opts = append(opts, soap.WithTransport(o.roundTripper))
+	}
+	if o.retryPolicy != nil {
+		opts = append(opts, soap.WithRetryPolicy(*o.retryPolicy))
+	}
+	if o.logger != nil {
+		opts = append(opts, soap.WithLogger(o.logger))
+	}
+	if o.actionCache != nil {
+		opts = append(opts, soap.WithActionCache(o.actionCache))
+	}
+	return opts
+}
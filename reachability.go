@@ -0,0 +1,123 @@
+package goupnp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// SortByReachability sorts devices (in place) so that those most likely to be
+// the device that the host should talk to come first. This is intended for
+// when several devices respond to a search, e.g a mesh network with several
+// access points that each claim to be an internet gateway.
+//
+// Devices are ordered by:
+//
+// 1. Successfully probed devices before those with an error.
+// 2. Devices at the host's default gateway address (where that can be
+// determined on this platform).
+// 3. Devices on the same subnet as preferIP. If preferIP is nil, the
+// LocalAddr that each device was discovered from is used instead.
+//
+// Otherwise the original order of devices is preserved.
+func SortByReachability(devices []MaybeRootDevice, preferIP net.IP) {
+	gateway, _ := defaultGateway()
+	localNets := localIPNets()
+	score := func(d *MaybeRootDevice) int {
+		if d.Err != nil || d.Location == nil {
+			return 0
+		}
+		s := 1
+		host := net.ParseIP(d.Location.Hostname())
+		if host == nil {
+			return s
+		}
+		if gateway != nil && gateway.Equal(host) {
+			s += 4
+		}
+		localIP := preferIP
+		if localIP == nil {
+			localIP = d.LocalAddr
+		}
+		if localIP != nil && sameSubnet(localNets, localIP, host) {
+			s += 2
+		}
+		return s
+	}
+
+	scores := make([]int, len(devices))
+	order := make([]int, len(devices))
+	for i := range devices {
+		scores[i] = score(&devices[i])
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	sorted := make([]MaybeRootDevice, len(devices))
+	for i, o := range order {
+		sorted[i] = devices[o]
+	}
+	copy(devices, sorted)
+}
+
+// localIPNets returns the networks of all addresses on the host's interfaces.
+func localIPNets() []*net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// sameSubnet reports whether remote is within the network of localIP, as found
+// in localNets. If localIP is not on any of localNets, a /24 (IPv4) or /64
+// (IPv6) network is assumed.
+func sameSubnet(localNets []*net.IPNet, localIP, remote net.IP) bool {
+	for _, n := range localNets {
+		if n.IP.Equal(localIP) {
+			return n.Contains(remote)
+		}
+	}
+	mask := net.CIDRMask(64, 128)
+	if localIP.To4() != nil {
+		mask = net.CIDRMask(24, 32)
+	}
+	return localIP.Mask(mask).Equal(remote.Mask(mask))
+}
+
+// ErrGatewayUnknown is returned by MaybeRootDevice.IsDefaultGateway when it
+// cannot be determined whether the device is the host's default gateway.
+var ErrGatewayUnknown = errors.New("goupnp: default gateway unknown")
+
+// IsDefaultGateway reports whether the device, at the host of its Location, is
+// the host's default gateway, i.e the router that the host sends traffic for
+// the internet through. This is useful to avoid mapping ports on an IGD that
+// responded to a search but that the host does not route through.
+//
+// The IPv4 default gateway is read from the routing table, which is only
+// supported on Linux. An error that matches ErrGatewayUnknown is returned on
+// other platforms, if the host has no IPv4 default route, or if the host of the
+// Location is not an IPv4 address.
+func (maybe *MaybeRootDevice) IsDefaultGateway() (bool, error) {
+	var host string
+	if maybe.Location != nil {
+		host = maybe.Location.Hostname()
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return false, sentinelError{ErrGatewayUnknown, fmt.Errorf("location host %q is not an IPv4 address", host)}
+	}
+	gateway, err := defaultGateway()
+	if err != nil {
+		return false, sentinelError{ErrGatewayUnknown, err}
+	}
+	return gateway.Equal(ip), nil
+}
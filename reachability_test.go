@@ -0,0 +1,79 @@
+package goupnp
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSortByReachability(t *testing.T) {
+	t.Parallel()
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	devices := []MaybeRootDevice{
+		{USN: "error", Location: mustURL("http://192.0.2.1:5000/"), Err: errors.New("probe failed")},
+		{USN: "other-subnet", Location: mustURL("http://198.51.100.1:5000/"), Root: &RootDevice{}},
+		{USN: "same-subnet", Location: mustURL("http://192.0.2.1:5000/"), Root: &RootDevice{}},
+		{USN: "no-location", Root: &RootDevice{}},
+	}
+	SortByReachability(devices, net.ParseIP("192.0.2.99"))
+
+	var got []string
+	for _, d := range devices {
+		got = append(got, d.USN)
+	}
+	want := "same-subnet,other-subnet,error,no-location"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Bad order\nwant: %s\n got: %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestIsDefaultGateway(t *testing.T) {
+	t.Parallel()
+	gateway, gatewayErr := defaultGateway()
+	var otherErr error
+	if gatewayErr != nil {
+		otherErr = ErrGatewayUnknown
+	}
+	tests := []struct {
+		name     string
+		location string
+		want     bool
+		wantErr  error
+	}{
+		{"no location", "", false, ErrGatewayUnknown},
+		{"hostname", "http://router.lan:5000/rootDesc.xml", false, ErrGatewayUnknown},
+		{"IPv6", "http://[2001:db8::1]:5000/rootDesc.xml", false, ErrGatewayUnknown},
+		{"other IPv4", "http://198.51.100.7:5000/rootDesc.xml", false, otherErr},
+	}
+	if gateway != nil {
+		tests = append(tests, tests[len(tests)-1])
+		tests[len(tests)-1].name = "gateway"
+		tests[len(tests)-1].location = "http://" + gateway.String() + ":5000/rootDesc.xml"
+		tests[len(tests)-1].want = true
+	}
+	for _, test := range tests {
+		maybe := &MaybeRootDevice{}
+		if test.location != "" {
+			loc, err := url.Parse(test.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			maybe.Location = loc
+		}
+		got, err := maybe.IsDefaultGateway()
+		if test.wantErr == nil && err != nil || !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: want error %v, got %v", test.name, test.wantErr, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: want %t, got %t", test.name, test.want, got)
+		}
+	}
+}
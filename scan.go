@@ -0,0 +1,246 @@
+package goupnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsedano/goupnp/httpu"
+	"github.com/fsedano/goupnp/ssdp"
+)
+
+// Mechanism is a set of the ways in which ScanCtx found a device.
+type Mechanism uint8
+
+const (
+	// FoundBySearch is set for devices that responded to the search request.
+	FoundBySearch Mechanism = 1 << iota
+	// FoundByNotify is set for devices that announced themselves with an
+	// ssdp:alive notification.
+	FoundByNotify
+)
+
+func (m Mechanism) String() string {
+	var names []string
+	if m&FoundBySearch != 0 {
+		names = append(names, "search")
+	}
+	if m&FoundByNotify != 0 {
+		names = append(names, "notify")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "+")
+}
+
+// ssdpMulticastGroup is the standard SSDP multicast group.
+const ssdpMulticastGroup = "239.255.255.250:1900"
+
+// ScanCtx finds devices of searchTarget as DiscoverDevicesCtx does, but also
+// listens for the notifications that devices send periodically to announce
+// themselves, for the whole of window (the scan takes at least as long as
+// the search, which is two seconds). This finds devices that are slow to
+// respond to searches, or do not respond at all, at the cost of waiting for
+// them to announce themselves. Devices found by both are only returned once,
+// by USN, and the mechanisms that found each are given by FoundBy.
+//
+// Notifications are listened for on each multicast-capable IPv4 interface, on
+// the group given by WithMulticastGroup or the standard SSDP group. Failures
+// to listen on interfaces are logged to any logger set by WithLogger, and do
+// not fail the scan. WithCache does not apply.
+func ScanCtx(ctx context.Context, searchTarget string, window time.Duration, opts ...DiscoveryOption) ([]MaybeRootDevice, error) {
+	o := newDiscoveryOptions(opts)
+	defer o.closeIdleConnections()
+	if err := o.checkSourcePort(); err != nil {
+		return nil, err
+	}
+	windowCtx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	notifies := listenNotifies(windowCtx, o, searchTarget)
+
+	hc, hcCleanup, err := httpuClient(o)
+	if err != nil {
+		cancel()
+		<-notifies
+		return nil, err
+	}
+	defer hcCleanup()
+	searchCtx, cancelSearch := context.WithTimeout(ctx, 2*time.Second)
+	defer cancelSearch()
+	unicastResponses := searchUnicastTargets(searchCtx, o, searchTarget)
+	responses, err := ssdp.RawSearch(searchCtx, hc, searchTarget, o.numSends, o.searchOptions()...)
+	if err != nil {
+		cancel()
+		<-unicastResponses
+		<-notifies
+		return nil, sentinelError{ErrSearchSendFailed, err}
+	}
+	responses = mergeResponses(responses, <-unicastResponses)
+
+	foundBy := make(map[string]Mechanism, len(responses))
+	for _, response := range responses {
+		foundBy[response.Header.Get("USN")] |= FoundBySearch
+	}
+	for _, notify := range <-notifies {
+		usn := notify.Header.Get("USN")
+		if foundBy[usn] == 0 {
+			responses = append(responses, notify)
+		}
+		foundBy[usn] |= FoundByNotify
+	}
+
+	results := probeResponses(ctx, o, o.filterSubnet(responses), nil, o.progress)
+	for i := range results {
+		results[i].FoundBy = foundBy[results[i].USN]
+	}
+	return results, nil
+}
+
+// listenNotifies listens for ssdp:alive notifications for searchTarget until
+// ctx is done. The notifications are sent on the returned channel, as search
+// responses, with only the latest from each USN.
+func listenNotifies(ctx context.Context, o *discoveryOptions, searchTarget string) <-chan []*http.Response {
+	done := make(chan []*http.Response, 1)
+	groupAddr := o.mcastGroup
+	if groupAddr == "" {
+		groupAddr = ssdpMulticastGroup
+	}
+	conns, localNets, err := listenMulticastGroup(o, groupAddr)
+	if err != nil {
+		if o.logger != nil {
+			o.logger.Printf("goupnp: not listening for notifications: %v", err)
+		}
+		done <- nil
+		return done
+	}
+
+	var mu sync.Mutex
+	var order []string
+	byUSN := make(map[string]*http.Response)
+	var wg sync.WaitGroup
+	// On some platforms, such as Linux, each of conns receives the group's
+	// datagrams from every interface, so the interface that a notification
+	// arrived on is not known from the connection. The local address is
+	// instead that of the joined interface on the sender's network, if any.
+	handler := httpu.HandlerFunc(func(r *http.Request) {
+		if r.Method != "NOTIFY" || r.Header.Get("NTS") != "ssdp:alive" {
+			return
+		}
+		if nt := r.Header.Get("NT"); searchTarget != ssdp.SSDPAll && nt != searchTarget {
+			return
+		}
+		usn := r.Header.Get("USN")
+		if usn == "" || r.Header.Get("LOCATION") == "" {
+			return
+		}
+		response := &http.Response{Header: r.Header}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			response.Header.Set(httpu.RemoteAddressHeader, host)
+			if local := localAddrOn(localNets, net.ParseIP(host)); local != nil {
+				response.Header.Set(httpu.LocalAddressHeader, local.String())
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if byUSN[usn] == nil {
+			order = append(order, usn)
+		}
+		byUSN[usn] = response
+	})
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn net.PacketConn) {
+			defer wg.Done()
+			httpu.Serve(conn, handler)
+		}(conn)
+	}
+
+	go func() {
+		<-ctx.Done()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		responses := make([]*http.Response, 0, len(order))
+		for _, usn := range order {
+			responses = append(responses, byUSN[usn])
+		}
+		done <- responses
+	}()
+	return done
+}
+
+// listenMulticastGroup joins the multicast group at groupAddr on each
+// multicast-capable IPv4 interface, and returns the connections along with
+// the IPv4 network of each interface. Interfaces that cannot join are skipped,
+// and logged if o has a logger. An error is returned if none can join.
+func listenMulticastGroup(o *discoveryOptions, groupAddr string) (conns []net.PacketConn, localNets []*net.IPNet, err error) {
+	group, err := net.ResolveUDPAddr("udp4", groupAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, ctxError(err, "requesting host interfaces")
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		localNet := interfaceIPv4Net(&iface)
+		if localNet == nil {
+			continue
+		}
+		iface := iface
+		conn, err := net.ListenMulticastUDP("udp4", &iface, group)
+		if err != nil {
+			if o.logger != nil {
+				o.logger.Printf("goupnp: skipping interface %s for notifications: %v", iface.Name, err)
+			}
+			continue
+		}
+		conns = append(conns, conn)
+		localNets = append(localNets, localNet)
+	}
+	if len(conns) == 0 {
+		return nil, nil, fmt.Errorf("goupnp: no interface could join multicast group %s", groupAddr)
+	}
+	return conns, localNets, nil
+}
+
+// interfaceIPv4Net returns the network of the first IPv4 address of iface, or
+// nil if it has none.
+func interfaceIPv4Net(iface *net.Interface) *net.IPNet {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet
+		}
+	}
+	return nil
+}
+
+// localAddrOn returns the address of the first of localNets that contains
+// remote, or nil if none does.
+func localAddrOn(localNets []*net.IPNet, remote net.IP) net.IP {
+	if remote == nil {
+		return nil
+	}
+	for _, n := range localNets {
+		if n.Contains(remote) {
+			return n.IP
+		}
+	}
+	return nil
+}
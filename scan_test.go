@@ -0,0 +1,129 @@
+package goupnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startTestNotifier sends ssdp:alive notifications for each of the NT and USN
+// pairs in nts to the multicast group, with location, until the test ends.
+func startTestNotifier(t *testing.T, group *net.UDPAddr, location string, nts map[string]string) {
+	t.Helper()
+	conn, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		t.Skipf("cannot send to multicast group %v: %v", group, err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		conn.Close()
+	})
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			for nt, usn := range nts {
+				conn.Write([]byte(fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+					"HOST: %s\r\n"+
+					"CACHE-CONTROL: max-age=1800\r\n"+
+					"LOCATION: %s\r\n"+
+					"NT: %s\r\n"+
+					"NTS: ssdp:alive\r\n"+
+					"USN: %s\r\n\r\n", group, location, nt, usn)))
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDeviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	const st = "urn:goupnp-test:device:Scan:1"
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 7), Port: 19007}
+	startTestResponder(t, group, st, srv.URL+"/rootDesc.xml")
+	startTestNotifier(t, group, srv.URL+"/rootDesc.xml", map[string]string{
+		st:                               "uuid:test::" + st,
+		"urn:goupnp-test:device:Other:1": "uuid:other::urn:goupnp-test:device:Other:1",
+	})
+	startTestNotifier(t, group, srv.URL+"/silent.xml", map[string]string{
+		st: "uuid:silent::" + st,
+	})
+
+	devices, err := ScanCtx(context.Background(), st, time.Second, WithMulticastGroup(group.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Mechanism)
+	for _, d := range devices {
+		if _, ok := got[d.USN]; ok {
+			t.Errorf("device %s returned more than once", d.USN)
+		}
+		got[d.USN] = d.FoundBy
+	}
+	want := map[string]Mechanism{
+		"uuid:test::" + st:   FoundBySearch | FoundByNotify,
+		"uuid:silent::" + st: FoundByNotify,
+	}
+	if len(got) != len(want) {
+		t.Errorf("want devices %v, got %v", want, got)
+	}
+	for usn, mechanism := range want {
+		if got[usn] != mechanism {
+			t.Errorf("%s: want found by %v, got %v", usn, mechanism, got[usn])
+		}
+	}
+}
+
+func TestMechanismString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		m    Mechanism
+		want string
+	}{
+		{0, "none"},
+		{FoundBySearch, "search"},
+		{FoundByNotify, "notify"},
+		{FoundBySearch | FoundByNotify, "search+notify"},
+	}
+	for _, test := range tests {
+		if got := test.m.String(); got != test.want {
+			t.Errorf("Mechanism(%d): want %q, got %q", test.m, test.want, got)
+		}
+	}
+}
+
+func TestLocalAddrOn(t *testing.T) {
+	t.Parallel()
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	lan.IP = net.IPv4(192, 168, 1, 10)
+	_, vpn, _ := net.ParseCIDR("10.8.0.0/16")
+	vpn.IP = net.IPv4(10, 8, 0, 2)
+	localNets := []*net.IPNet{lan, vpn}
+	tests := []struct {
+		remote string
+		want   net.IP
+	}{
+		{"192.168.1.1", lan.IP},
+		{"10.8.3.4", vpn.IP},
+		{"172.16.0.1", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		if got := localAddrOn(localNets, net.ParseIP(test.remote)); !got.Equal(test.want) {
+			t.Errorf("%q: want %v, got %v", test.remote, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package scpd
+
+import (
+	"sort"
+)
+
+// SCPDDiff describes how the actions of one SCPD differ from another, as
+// returned by DiffSCPD. Names are sorted.
+type SCPDDiff struct {
+	// AddedActions are the names of actions only in the second SCPD.
+	AddedActions []string
+	// RemovedActions are the names of actions only in the first SCPD.
+	RemovedActions []string
+	// ChangedActions are the actions in both SCPDs whose arguments differ.
+	ChangedActions []ActionDiff
+}
+
+// IsEmpty reports whether the SCPDs had the same actions and arguments.
+func (d *SCPDDiff) IsEmpty() bool {
+	return len(d.AddedActions) == 0 && len(d.RemovedActions) == 0 && len(d.ChangedActions) == 0
+}
+
+// ActionDiff describes how the arguments of an action differ between two
+// SCPDs. Arguments are matched by name.
+type ActionDiff struct {
+	Name string
+	// AddedArguments are the arguments only in the second SCPD.
+	AddedArguments []Argument
+	// RemovedArguments are the arguments only in the first SCPD.
+	RemovedArguments []Argument
+	// ChangedArguments are the arguments in both SCPDs that differ in their
+	// direction, related state variable, or retval.
+	ChangedArguments []ArgumentChange
+	// Reordered is set if the arguments that are in both SCPDs are in a
+	// different order. Some devices require arguments in the order of their
+	// SCPD.
+	Reordered bool
+}
+
+// ArgumentChange is an argument that differs between two SCPDs.
+type ArgumentChange struct {
+	From, To Argument
+}
+
+// DiffSCPD compares the actions of a and b, e.g to check the compatibility of
+// two devices or firmware versions. Both are compared as given, so call Clean
+// on them first if their whitespace may differ.
+func DiffSCPD(a, b *SCPD) SCPDDiff {
+	var diff SCPDDiff
+	for _, actionA := range a.OrderedActions() {
+		actionB := b.GetAction(actionA.Name)
+		if actionB == nil {
+			diff.RemovedActions = append(diff.RemovedActions, actionA.Name)
+			continue
+		}
+		if d := diffAction(&actionA, actionB); d != nil {
+			diff.ChangedActions = append(diff.ChangedActions, *d)
+		}
+	}
+	for _, actionB := range b.OrderedActions() {
+		if a.GetAction(actionB.Name) == nil {
+			diff.AddedActions = append(diff.AddedActions, actionB.Name)
+		}
+	}
+	return diff
+}
+
+// diffAction returns the differences between the arguments of a and b, or nil
+// if there are none.
+func diffAction(a, b *Action) *ActionDiff {
+	d := &ActionDiff{Name: a.Name}
+	argsB := make(map[string]Argument, len(b.Arguments))
+	for _, arg := range b.Arguments {
+		argsB[arg.Name] = arg
+	}
+	argsA := make(map[string]bool, len(a.Arguments))
+	var commonA, commonB []string
+	for _, argA := range a.Arguments {
+		argsA[argA.Name] = true
+		argB, ok := argsB[argA.Name]
+		if !ok {
+			d.RemovedArguments = append(d.RemovedArguments, argA)
+			continue
+		}
+		commonA = append(commonA, argA.Name)
+		if argA != argB {
+			d.ChangedArguments = append(d.ChangedArguments, ArgumentChange{argA, argB})
+		}
+	}
+	for _, argB := range b.Arguments {
+		if !argsA[argB.Name] {
+			d.AddedArguments = append(d.AddedArguments, argB)
+		} else {
+			commonB = append(commonB, argB.Name)
+		}
+	}
+	for i := range commonA {
+		if commonA[i] != commonB[i] {
+			d.Reordered = true
+			break
+		}
+	}
+
+	if len(d.AddedArguments) == 0 && len(d.RemovedArguments) == 0 && len(d.ChangedArguments) == 0 && !d.Reordered {
+		return nil
+	}
+	sortArguments(d.AddedArguments)
+	sortArguments(d.RemovedArguments)
+	sort.SliceStable(d.ChangedArguments, func(i, j int) bool {
+		return d.ChangedArguments[i].From.Name < d.ChangedArguments[j].From.Name
+	})
+	return d
+}
+
+func sortArguments(args []Argument) {
+	sort.SliceStable(args, func(i, j int) bool {
+		return args[i].Name < args[j].Name
+	})
+}
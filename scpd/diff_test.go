@@ -0,0 +1,65 @@
+package scpd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSCPD(t *testing.T) {
+	t.Parallel()
+	a := &SCPD{Actions: []Action{
+		{Name: "GetExternalIPAddress", Arguments: []Argument{
+			{Name: "NewExternalIPAddress", Direction: "out", RelatedStateVariable: "ExternalIPAddress"},
+		}},
+		{Name: "DeletePortMapping", Arguments: []Argument{
+			{Name: "NewRemoteHost", Direction: "in", RelatedStateVariable: "RemoteHost"},
+			{Name: "NewExternalPort", Direction: "in", RelatedStateVariable: "ExternalPort"},
+			{Name: "NewProtocol", Direction: "in", RelatedStateVariable: "PortMappingProtocol"},
+		}},
+		{Name: "GetStatusInfo", Arguments: []Argument{
+			{Name: "NewConnectionStatus", Direction: "out", RelatedStateVariable: "ConnectionStatus"},
+			{Name: "NewUptime", Direction: "out", RelatedStateVariable: "Uptime"},
+		}},
+		{Name: "ForceTermination"},
+	}}
+	b := &SCPD{Actions: []Action{
+		{Name: "GetExternalIPAddress", Arguments: []Argument{
+			{Name: "NewExternalIPAddress", Direction: "out", RelatedStateVariable: "ExternalIPAddress"},
+		}},
+		{Name: "DeletePortMapping", Arguments: []Argument{
+			{Name: "NewExternalPort", Direction: "in", RelatedStateVariable: "ExternalPort"},
+			{Name: "NewRemoteHost", Direction: "in", RelatedStateVariable: "RemoteHost"},
+			{Name: "NewProtocol", Direction: "in", RelatedStateVariable: "PortMappingProtocol"},
+		}},
+		{Name: "GetStatusInfo", Arguments: []Argument{
+			{Name: "NewConnectionStatus", Direction: "out", RelatedStateVariable: "X_ConnectionStatus"},
+			{Name: "NewLastConnectionError", Direction: "out", RelatedStateVariable: "LastConnectionError"},
+		}},
+		{Name: "AddAnyPortMapping"},
+	}}
+
+	want := SCPDDiff{
+		AddedActions:   []string{"AddAnyPortMapping"},
+		RemovedActions: []string{"ForceTermination"},
+		ChangedActions: []ActionDiff{
+			{Name: "DeletePortMapping", Reordered: true},
+			{
+				Name:             "GetStatusInfo",
+				AddedArguments:   []Argument{{Name: "NewLastConnectionError", Direction: "out", RelatedStateVariable: "LastConnectionError"}},
+				RemovedArguments: []Argument{{Name: "NewUptime", Direction: "out", RelatedStateVariable: "Uptime"}},
+				ChangedArguments: []ArgumentChange{{
+					From: Argument{Name: "NewConnectionStatus", Direction: "out", RelatedStateVariable: "ConnectionStatus"},
+					To:   Argument{Name: "NewConnectionStatus", Direction: "out", RelatedStateVariable: "X_ConnectionStatus"},
+				}},
+			},
+		},
+	}
+	got := DiffSCPD(a, b)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want diff:\n%+v\ngot:\n%+v", want, got)
+	}
+
+	if d := DiffSCPD(a, a); !d.IsEmpty() {
+		t.Errorf("want empty diff of an SCPD with itself, got %+v", d)
+	}
+}
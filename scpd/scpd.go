@@ -2,8 +2,12 @@ package scpd
 
 import (
 	"encoding/xml"
+	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -46,6 +50,16 @@ func (scpd *SCPD) OrderedActions() []Action {
 	return actions
 }
 
+// OrderedStateVariables returns the state variables of the service, sorted by
+// name.
+func (scpd *SCPD) OrderedStateVariables() []StateVariable {
+	vars := append([]StateVariable{}, scpd.StateVariables...)
+	sort.SliceStable(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
 func (scpd *SCPD) GetStateVariable(variable string) *StateVariable {
 	for i := range scpd.StateVariables {
 		v := &scpd.StateVariables[i]
@@ -137,6 +151,85 @@ type StateVariable struct {
 	DefaultValue      string             `xml:"defaultValue"`
 	AllowedValueRange *AllowedValueRange `xml:"allowedValueRange"`
 	AllowedValues     []string           `xml:"allowedValueList>allowedValue"`
+	// MinLength and MaxLength limit the number of characters in string
+	// values. They are not part of the UPnP schema, but some vendors' SCPDs
+	// include them.
+	MinLength string `xml:"minLength"`
+	MaxLength string `xml:"maxLength"`
+}
+
+// IsEvented reports whether changes to the variable are sent to event
+// subscribers. The specification defaults this to true if unspecified.
+func (v *StateVariable) IsEvented() bool {
+	return v.SendEvents != "no"
+}
+
+// IsMulticast reports whether changes to the variable are multicast as well as
+// sent to event subscribers.
+func (v *StateVariable) IsMulticast() bool {
+	return v.Multicast == "yes"
+}
+
+// AllowsValue reports whether value is permitted by the variable's allowed
+// value list or range, if it has either. Range checks only apply to numeric
+// values, and a range that cannot be parsed allows any value.
+func (v *StateVariable) AllowsValue(value string) bool {
+	if len(v.AllowedValues) > 0 {
+		for _, allowed := range v.AllowedValues {
+			if value == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	if v.AllowedValueRange != nil {
+		return v.AllowedValueRange.allows(value)
+	}
+	return true
+}
+
+// ConstraintError is returned by CheckValue for a value that the state
+// variable does not allow.
+type ConstraintError struct {
+	Variable string
+	// Constraint is the constraint that was not met, such as "maxLength 32"
+	// or "allowedValueList".
+	Constraint string
+	Value      string
+}
+
+func (err *ConstraintError) Error() string {
+	return fmt.Sprintf("scpd: value %q of %s does not satisfy %s", err.Value, err.Variable, err.Constraint)
+}
+
+// CheckValue returns a *ConstraintError if value is not allowed by the
+// variable's allowed value list, or its minLength or maxLength, or is not a
+// single character for the char data type. Lengths are counted in characters,
+// and lengths that cannot be parsed are ignored. Unlike AllowsValue, allowed
+// value ranges are not checked.
+func (v *StateVariable) CheckValue(value string) error {
+	fail := func(constraint string) error {
+		return &ConstraintError{Variable: v.Name, Constraint: constraint, Value: value}
+	}
+	n := utf8.RuneCountInString(value)
+	if v.DataType.Name == "char" && n != 1 {
+		return fail("dataType char")
+	}
+	if min, err := strconv.Atoi(v.MinLength); err == nil && n < min {
+		return fail("minLength " + v.MinLength)
+	}
+	if max, err := strconv.Atoi(v.MaxLength); err == nil && n > max {
+		return fail("maxLength " + v.MaxLength)
+	}
+	if len(v.AllowedValues) > 0 {
+		for _, allowed := range v.AllowedValues {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fail("allowedValueList")
+	}
+	return nil
 }
 
 func (v *StateVariable) clean() {
@@ -151,6 +244,8 @@ func (v *StateVariable) clean() {
 	for i := range v.AllowedValues {
 		cleanWhitespace(&v.AllowedValues[i])
 	}
+	cleanWhitespace(&v.MinLength)
+	cleanWhitespace(&v.MaxLength)
 }
 
 type AllowedValueRange struct {
@@ -159,6 +254,25 @@ type AllowedValueRange struct {
 	Step    string `xml:"step"`
 }
 
+// allows reports whether value is a number within the range, and a multiple
+// of the step from the minimum, if given.
+func (r *AllowedValueRange) allows(value string) bool {
+	min, errMin := strconv.ParseFloat(r.Minimum, 64)
+	max, errMax := strconv.ParseFloat(r.Maximum, 64)
+	if errMin != nil || errMax != nil {
+		return true
+	}
+	x, err := strconv.ParseFloat(value, 64)
+	if err != nil || x < min || x > max {
+		return false
+	}
+	if step, err := strconv.ParseFloat(r.Step, 64); err == nil && step > 0 {
+		n := (x - min) / step
+		return n == math.Trunc(n)
+	}
+	return true
+}
+
 func (r *AllowedValueRange) clean() {
 	cleanWhitespace(&r.Minimum)
 	cleanWhitespace(&r.Maximum)
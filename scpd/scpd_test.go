@@ -0,0 +1,173 @@
+package scpd
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+// testSCPD is an excerpt of the WANIPConnection SCPD of a miniupnpd router,
+// with stray whitespace as found in some devices.
+const testSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<actionList>
+		<action>
+			<name>GetExternalIPAddress</name>
+			<argumentList>
+				<argument>
+					<name>NewExternalIPAddress</name>
+					<direction>out</direction>
+					<relatedStateVariable>ExternalIPAddress</relatedStateVariable>
+				</argument>
+			</argumentList>
+		</action>
+	</actionList>
+	<serviceStateTable>
+		<stateVariable sendEvents="no">
+			<name>ConnectionType</name>
+			<dataType>string</dataType>
+			<defaultValue>Unconfigured</defaultValue>
+		</stateVariable>
+		<stateVariable sendEvents="yes">
+			<name>ConnectionStatus</name>
+			<dataType>string</dataType>
+			<allowedValueList>
+				<allowedValue>Unconfigured</allowedValue>
+				<allowedValue>Connected</allowedValue>
+				<allowedValue> Disconnected </allowedValue>
+			</allowedValueList>
+		</stateVariable>
+		<stateVariable sendEvents="no">
+			<name>ExternalPort</name>
+			<dataType>ui2</dataType>
+		</stateVariable>
+		<stateVariable>
+			<name>ExternalIPAddress</name>
+			<dataType>string</dataType>
+		</stateVariable>
+		<stateVariable sendEvents="no">
+			<name>PortMappingLeaseDuration</name>
+			<dataType>ui4</dataType>
+			<allowedValueRange>
+				<minimum>0</minimum>
+				<maximum>604800</maximum>
+				<step>60</step>
+			</allowedValueRange>
+		</stateVariable>
+	</serviceStateTable>
+</scpd>`
+
+func parseTestSCPD(t *testing.T) *SCPD {
+	t.Helper()
+	s := new(SCPD)
+	if err := xml.Unmarshal([]byte(testSCPD), s); err != nil {
+		t.Fatal(err)
+	}
+	s.Clean()
+	return s
+}
+
+func TestStateVariables(t *testing.T) {
+	t.Parallel()
+	s := parseTestSCPD(t)
+
+	var names []string
+	for _, v := range s.OrderedStateVariables() {
+		names = append(names, v.Name)
+	}
+	wantNames := []string{"ConnectionStatus", "ConnectionType", "ExternalIPAddress", "ExternalPort", "PortMappingLeaseDuration"}
+	if !reflect.DeepEqual(wantNames, names) {
+		t.Errorf("want %v, got %v", wantNames, names)
+	}
+
+	status := s.GetStateVariable("ConnectionStatus")
+	if status == nil {
+		t.Fatal("want ConnectionStatus, got nil")
+	}
+	if want := []string{"Unconfigured", "Connected", "Disconnected"}; !reflect.DeepEqual(want, status.AllowedValues) {
+		t.Errorf("want allowed values %v, got %v", want, status.AllowedValues)
+	}
+	if connType := s.GetStateVariable("ConnectionType"); connType.DefaultValue != "Unconfigured" || connType.DataType.Name != "string" {
+		t.Errorf("bad ConnectionType: %+v", connType)
+	}
+	lease := s.GetStateVariable("PortMappingLeaseDuration")
+	if want := (&AllowedValueRange{Minimum: "0", Maximum: "604800", Step: "60"}); !reflect.DeepEqual(want, lease.AllowedValueRange) {
+		t.Errorf("want range %+v, got %+v", want, lease.AllowedValueRange)
+	}
+
+	evented := map[string]bool{
+		"ConnectionStatus":  true,
+		"ConnectionType":    false,
+		"ExternalIPAddress": true, // Unspecified defaults to evented.
+	}
+	for name, want := range evented {
+		if got := s.GetStateVariable(name).IsEvented(); got != want {
+			t.Errorf("%s: want IsEvented()=%t, got %t", name, want, got)
+		}
+	}
+}
+
+func TestStateVariableAllowsValue(t *testing.T) {
+	t.Parallel()
+	s := parseTestSCPD(t)
+	tests := []struct {
+		variable, value string
+		want            bool
+	}{
+		{"ConnectionStatus", "Connected", true},
+		{"ConnectionStatus", "Disconnected", true},
+		{"ConnectionStatus", "Connecting", false},
+		{"PortMappingLeaseDuration", "0", true},
+		{"PortMappingLeaseDuration", "3600", true},
+		{"PortMappingLeaseDuration", "3601", false},
+		{"PortMappingLeaseDuration", "604860", false},
+		{"PortMappingLeaseDuration", "-60", false},
+		{"PortMappingLeaseDuration", "forever", false},
+		{"ExternalPort", "anything", true},
+	}
+	for _, test := range tests {
+		if got := s.GetStateVariable(test.variable).AllowsValue(test.value); got != test.want {
+			t.Errorf("%s.AllowsValue(%q): want %t, got %t", test.variable, test.value, test.want, got)
+		}
+	}
+}
+
+func TestStateVariableCheckValue(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		v              StateVariable
+		value          string
+		wantConstraint string
+	}{
+		{"within maxLength", StateVariable{MaxLength: "4"}, "abcd", ""},
+		{"over maxLength", StateVariable{MaxLength: "4"}, "abcde", "maxLength 4"},
+		{"maxLength counts characters", StateVariable{MaxLength: "2"}, "\u00e9\u00e9", ""},
+		{"under minLength", StateVariable{MinLength: "1"}, "", "minLength 1"},
+		{"unparsable length", StateVariable{MaxLength: "many"}, "abcde", ""},
+		{"char", StateVariable{DataType: DataType{Name: "char"}}, "x", ""},
+		{"not a char", StateVariable{DataType: DataType{Name: "char"}}, "xy", "dataType char"},
+		{"allowed value", StateVariable{AllowedValues: []string{"TCP", "UDP"}}, "UDP", ""},
+		{"disallowed value", StateVariable{AllowedValues: []string{"TCP", "UDP"}}, "tcp", "allowedValueList"},
+		{"ranges ignored", StateVariable{AllowedValueRange: &AllowedValueRange{Minimum: "0", Maximum: "1"}}, "2", ""},
+	}
+	for _, test := range tests {
+		test.v.Name = "Var"
+		err := test.v.CheckValue(test.value)
+		if test.wantConstraint == "" {
+			if err != nil {
+				t.Errorf("%s: want nil, got %v", test.name, err)
+			}
+			continue
+		}
+		cerr, ok := err.(*ConstraintError)
+		if !ok {
+			t.Errorf("%s: want *ConstraintError, got %v", test.name, err)
+			continue
+		}
+		if want := (ConstraintError{Variable: "Var", Constraint: test.wantConstraint, Value: test.value}); *cerr != want {
+			t.Errorf("%s: want %+v, got %+v", test.name, want, *cerr)
+		}
+	}
+}
@@ -2,17 +2,19 @@ package goupnp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"strings"
 
 	"github.com/fsedano/goupnp/soap"
 )
 
 // ServiceClient is a SOAP client, root device and the service for the SOAP
 // client rolled into one value. The root device, location, and service are
-// intended to be informational. Location can be used to later recreate a
-// ServiceClient with NewServiceClientByURL if the service is still present;
+// intended to be informational. ConnectionInfo can be used to later recreate a
+// ServiceClient with RestoreServiceClient if the service is still present,
 // bypassing the discovery process.
 type ServiceClient struct {
 	SOAPClient *soap.SOAPClient
@@ -20,26 +22,24 @@ type ServiceClient struct {
 	Location   *url.URL
 	Service    *Service
 	localAddr  net.IP
+	scpd       *scpdCache
 }
 
 // NewServiceClientsCtx discovers services, and returns clients for them. err will
 // report any error with the discovery process (blocking any device/service
-// discovery), errors reports errors on a per-root-device basis.
-func NewServiceClientsCtx(ctx context.Context, searchTarget string) (clients []ServiceClient, errors []error, err error) {
+// discovery), errors reports errors on a per-root-device basis. opts are
+// passed to DiscoverDevicesCtx.
+func NewServiceClientsCtx(ctx context.Context, searchTarget string, opts ...DiscoveryOption) (clients []ServiceClient, errors []error, err error) {
+	o := newDiscoveryOptions(opts)
 	var maybeRootDevices []MaybeRootDevice
-	if maybeRootDevices, err = DiscoverDevicesCtx(ctx, searchTarget); err != nil {
+	if maybeRootDevices, err = DiscoverDevicesCtx(ctx, searchTarget, opts...); err != nil {
 		return
 	}
 
 	clients = make([]ServiceClient, 0, len(maybeRootDevices))
 
 	for _, maybeRootDevice := range maybeRootDevices {
-		if maybeRootDevice.Err != nil {
-			errors = append(errors, maybeRootDevice.Err)
-			continue
-		}
-
-		deviceClients, err := newServiceClientsFromRootDevice(maybeRootDevice.Root, maybeRootDevice.Location, searchTarget, maybeRootDevice.LocalAddr)
+		deviceClients, err := newServiceClientsFromMaybeRootDevice(&maybeRootDevice, searchTarget, o.soapOptions()...)
 		if err != nil {
 			errors = append(errors, err)
 			continue
@@ -50,6 +50,52 @@ func NewServiceClientsCtx(ctx context.Context, searchTarget string) (clients []S
 	return
 }
 
+// FirstServiceClientCtx discovers devices with any of the given service types,
+// and returns a client for the first that is found, preferring service types
+// in the order given. This suits services with several versions or
+// alternatives, e.g WANIPConnection:2, WANIPConnection:1 and
+// WANPPPConnection:1 for a gateway. All of the types are searched for at once,
+// as by DiscoverMultiCtx. If none are found, the errors for each device are
+// combined, or an error matching ErrDeviceNotFound is returned if no devices
+// responded.
+func FirstServiceClientCtx(ctx context.Context, searchTargets ...string) (ServiceClient, error) {
+	if len(searchTargets) == 0 {
+		return ServiceClient{}, errors.New("goupnp: no search targets given")
+	}
+	byTarget, err := DiscoverMultiCtx(ctx, searchTargets)
+	if err != nil {
+		return ServiceClient{}, err
+	}
+	return firstServiceClient(byTarget, searchTargets)
+}
+
+// firstServiceClient returns a client for the first of searchTargets found in
+// the devices discovered for it, for FirstServiceClientCtx.
+func firstServiceClient(byTarget map[string][]MaybeRootDevice, searchTargets []string) (ServiceClient, error) {
+	var errs []string
+	var firstErr error
+	for _, searchTarget := range searchTargets {
+		for _, maybe := range byTarget[searchTarget] {
+			clients, err := newServiceClientsFromMaybeRootDevice(&maybe, searchTarget)
+			if err == nil {
+				return clients[0], nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			} else {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if firstErr == nil {
+		return ServiceClient{}, sentinelError{ErrDeviceNotFound, fmt.Errorf("no devices with any of %q responded", searchTargets)}
+	}
+	if len(errs) == 0 {
+		return ServiceClient{}, firstErr
+	}
+	return ServiceClient{}, fmt.Errorf("%w; %s", firstErr, strings.Join(errs, "; "))
+}
+
 // NewServiceClients is the legacy version of NewServiceClientsCtx, but uses
 // context.Background() as the context.
 func NewServiceClients(searchTarget string) (clients []ServiceClient, errors []error, err error) {
@@ -79,11 +125,30 @@ func NewServiceClientsFromRootDevice(rootDevice *RootDevice, loc *url.URL, searc
 	return newServiceClientsFromRootDevice(rootDevice, loc, searchTarget, nil)
 }
 
+// NewServiceClientsFromMaybeRootDevice creates client(s) for the given service
+// URN, in a root device found by DiscoverDevicesCtx. Unlike
+// NewServiceClientsFromRootDevice, the returned clients retain the LocalAddr
+// that the device was discovered from. The error from probing the device is
+// returned if there was one. Of opts, only those that configure SOAP clients
+// apply, such as WithTransport and WithRetryPolicy, as for
+// NewServiceClientsCtx.
+func NewServiceClientsFromMaybeRootDevice(maybe *MaybeRootDevice, searchTarget string, opts ...DiscoveryOption) ([]ServiceClient, error) {
+	return newServiceClientsFromMaybeRootDevice(maybe, searchTarget, newDiscoveryOptions(opts).soapOptions()...)
+}
+
+func newServiceClientsFromMaybeRootDevice(maybe *MaybeRootDevice, searchTarget string, soapOpts ...soap.Option) ([]ServiceClient, error) {
+	if maybe.Err != nil {
+		return nil, maybe.Err
+	}
+	return newServiceClientsFromRootDevice(maybe.Root, maybe.Location, searchTarget, maybe.LocalAddr, soapOpts...)
+}
+
 func newServiceClientsFromRootDevice(
 	rootDevice *RootDevice,
 	loc *url.URL,
 	searchTarget string,
 	lAddr net.IP,
+	soapOpts ...soap.Option,
 ) ([]ServiceClient, error) {
 	device := &rootDevice.Device
 	srvs := device.FindService(searchTarget)
@@ -95,11 +160,12 @@ func newServiceClientsFromRootDevice(
 	clients := make([]ServiceClient, 0, len(srvs))
 	for _, srv := range srvs {
 		clients = append(clients, ServiceClient{
-			SOAPClient: srv.NewSOAPClient(),
+			SOAPClient: srv.NewSOAPClient(soapOpts...),
 			RootDevice: rootDevice,
 			Location:   loc,
 			Service:    srv,
 			localAddr:  lAddr,
+			scpd:       new(scpdCache),
 		})
 	}
 	return clients, nil
@@ -116,3 +182,116 @@ func (client *ServiceClient) GetServiceClient() *ServiceClient {
 func (client *ServiceClient) LocalAddr() net.IP {
 	return client.localAddr
 }
+
+// InternalClientIP returns the local IP address that the gateway of the client
+// can reach the host at, as needed for the NewInternalClient argument of
+// AddPortMapping. This is LocalAddr, if known, and otherwise the address that
+// the host would send packets to the client's control URL from. An error is
+// returned if neither is a unicast address that the gateway could reach, e.g
+// a loopback address for a gateway on another host.
+func (client *ServiceClient) InternalClientIP() (string, error) {
+	host := client.SOAPClient.EndpointURL.Hostname()
+	if host == "" {
+		return "", errors.New("goupnp: service client has no control URL host")
+	}
+	gatewayIP := net.ParseIP(host)
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		gatewayIP = net.ParseIP(host[:i])
+	}
+	if usableInternalIP(client.localAddr, gatewayIP) {
+		return client.localAddr.String(), nil
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return "", ctxErrorf(err, "finding local address to reach %q", host)
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	if !usableInternalIP(ip, gatewayIP) {
+		return "", fmt.Errorf("goupnp: no usable local address to reach %q, got %v", host, ip)
+	}
+	return ip.String(), nil
+}
+
+// usableInternalIP reports whether ip is a unicast address that the gateway at
+// gatewayIP (if known) could reach. Loopback addresses are only usable by a
+// gateway on the same host.
+func usableInternalIP(ip, gatewayIP net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	if ip.IsLoopback() {
+		return gatewayIP != nil && gatewayIP.IsLoopback()
+	}
+	return ip.IsGlobalUnicast() || ip.IsLinkLocalUnicast()
+}
+
+// ServiceClientRef identifies the service of a ServiceClient, so that the
+// client can be recreated by RestoreServiceClient without discovery, for
+// example by a daemon after it restarts. It can be encoded to and decoded
+// from JSON.
+type ServiceClientRef struct {
+	// Location is the URL of the root device description.
+	Location string `json:"location"`
+	// UDN is the UDN of the root device, which identifies it if a different
+	// device is later found at the same location.
+	UDN         string `json:"UDN"`
+	ServiceType string `json:"serviceType"`
+	ServiceID   string `json:"serviceId,omitempty"`
+	// LocalAddr is the address that the device was discovered from, if known.
+	LocalAddr net.IP `json:"localAddr,omitempty"`
+}
+
+// ConnectionInfo returns the reference needed to recreate the client with
+// RestoreServiceClient.
+func (client *ServiceClient) ConnectionInfo() ServiceClientRef {
+	ref := ServiceClientRef{
+		UDN:         client.RootDevice.Device.UDN,
+		ServiceType: client.Service.ServiceType,
+		ServiceID:   client.Service.ServiceId,
+		LocalAddr:   client.localAddr,
+	}
+	if client.Location != nil {
+		ref.Location = client.Location.String()
+	}
+	return ref
+}
+
+// ErrServiceGone is returned by RestoreServiceClient when the device at the
+// referenced location no longer has the service, or is a different device.
+var ErrServiceGone = errors.New("goupnp: referenced service no longer exists")
+
+// RestoreServiceClient recreates the client for the service identified by
+// ref, fetching the device description from its location again. The service
+// is found by its ID and type, and ErrServiceGone is returned (wrapped) if the
+// device no longer has it, or if a device with a different UDN is now at the
+// location.
+func RestoreServiceClient(ctx context.Context, ref ServiceClientRef) (ServiceClient, error) {
+	loc, err := url.Parse(ref.Location)
+	if err != nil {
+		return ServiceClient{}, fmt.Errorf("goupnp: error parsing service location %q: %v", ref.Location, err)
+	}
+	root, err := DeviceByURLCtx(ctx, loc)
+	if err != nil {
+		return ServiceClient{}, err
+	}
+	if ref.UDN != "" && root.Device.UDN != ref.UDN {
+		return ServiceClient{}, sentinelError{ErrServiceGone,
+			fmt.Errorf("device at %q has UDN %q, not %q", ref.Location, root.Device.UDN, ref.UDN)}
+	}
+	clients, err := newServiceClientsFromRootDevice(root, loc, ref.ServiceType, ref.LocalAddr)
+	if err != nil {
+		return ServiceClient{}, sentinelError{ErrServiceGone, err}
+	}
+	if ref.ServiceID == "" {
+		return clients[0], nil
+	}
+	for _, client := range clients {
+		if client.Service.ServiceId == ref.ServiceID {
+			return client, nil
+		}
+	}
+	return ServiceClient{}, sentinelError{ErrServiceGone,
+		fmt.Errorf("device at %q has no %s service with ID %q", ref.Location, ref.ServiceType, ref.ServiceID)}
+}
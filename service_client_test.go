@@ -0,0 +1,193 @@
+package goupnp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsedano/goupnp/soap"
+)
+
+func TestRestoreServiceClient(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	deviceXML := testDeviceXML
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(deviceXML))
+	}))
+	t.Cleanup(srv.Close)
+	setDeviceXML := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		deviceXML = s
+	}
+
+	loc, err := url.Parse(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	clients, err := NewServiceClientsFromMaybeRootDevice(&MaybeRootDevice{
+		Root:      root,
+		Location:  loc,
+		LocalAddr: net.ParseIP("192.168.1.10"),
+	}, "urn:schemas-upnp-org:service:WANIPConnection:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reference survives encoding, as it would be stored.
+	data, err := json.Marshal(clients[0].ConnectionInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ref ServiceClientRef
+	if err := json.Unmarshal(data, &ref); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RestoreServiceClient(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := clients[0].Service.ControlURL.URL, restored.Service.ControlURL.URL; want != got {
+		t.Errorf("want control URL %v, got %v", &want, &got)
+	}
+	if want, got := clients[0].SOAPClient.EndpointURL, restored.SOAPClient.EndpointURL; want != got {
+		t.Errorf("want SOAP endpoint %v, got %v", &want, &got)
+	}
+	if !restored.LocalAddr().Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("want LocalAddr 192.168.1.10, got %v", restored.LocalAddr())
+	}
+
+	tests := []struct {
+		name      string
+		deviceXML string
+	}{
+		{"service removed", strings.Replace(testDeviceXML, "urn:upnp-org:serviceId:WANIPConn1", "urn:upnp-org:serviceId:WANIPConn2", 1)},
+		{"different device", strings.Replace(testDeviceXML, "uuid:11111111-2222-3333-4444-555555555555", "uuid:other", 1)},
+	}
+	for _, test := range tests {
+		setDeviceXML(test.deviceXML)
+		if _, err := RestoreServiceClient(ctx, ref); !errors.Is(err, ErrServiceGone) {
+			t.Errorf("%s: want ErrServiceGone, got %v", test.name, err)
+		}
+	}
+}
+
+func TestFirstServiceClient(t *testing.T) {
+	t.Parallel()
+	loc, err := url.Parse("http://192.0.2.1:5000/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ParseRootDevice([]byte(testDeviceXML), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		ipConn2  = "urn:schemas-upnp-org:service:WANIPConnection:2"
+		ipConn1  = "urn:schemas-upnp-org:service:WANIPConnection:1"
+		pppConn1 = "urn:schemas-upnp-org:service:WANPPPConnection:1"
+	)
+	byTarget := map[string][]MaybeRootDevice{
+		ipConn2: {{Location: loc, Err: ErrProbeFailed}},
+		ipConn1: {{Location: loc, Root: root}},
+	}
+
+	client, err := firstServiceClient(byTarget, []string{ipConn2, ipConn1, pppConn1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ipConn1, client.Service.ServiceType; want != got {
+		t.Errorf("want service %q, got %q", want, got)
+	}
+
+	// The device found for IP connections has no PPP connection service.
+	byTarget[pppConn1] = byTarget[ipConn1]
+	_, err = firstServiceClient(byTarget, []string{ipConn2, pppConn1})
+	if !errors.Is(err, ErrProbeFailed) || !strings.Contains(err.Error(), pppConn1) {
+		t.Errorf("want combined errors, got %v", err)
+	}
+	if _, err := firstServiceClient(byTarget, []string{"urn:schemas-upnp-org:service:Layer3Forwarding:1"}); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("want ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestServiceClientInternalClientIP(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		localAddr string
+		endpoint  string
+		want      string
+	}{
+		{"192.168.1.10", "http://192.168.1.1:5000/ctl/IPConn", "192.168.1.10"},
+		{"fe80::10", "http://[fe80::1%25eth0]:5000/ctl/IPConn", "fe80::10"},
+		// Unusable or unknown local addresses fall back to the route to the
+		// gateway.
+		{"", "http://127.0.0.1:5000/ctl/IPConn", "127.0.0.1"},
+		{"0.0.0.0", "http://127.0.0.1:5000/ctl/IPConn", "127.0.0.1"},
+		{"239.255.255.250", "http://127.0.0.1:5000/ctl/IPConn", "127.0.0.1"},
+		{"", "http:///ctl/IPConn", ""},
+	}
+	for _, test := range tests {
+		endpoint, err := url.Parse(test.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &ServiceClient{
+			SOAPClient: soap.NewSOAPClient(*endpoint),
+			localAddr:  net.ParseIP(test.localAddr),
+		}
+		got, err := client.InternalClientIP()
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q via %q: want error, got %q", test.localAddr, test.endpoint, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q via %q: %v", test.localAddr, test.endpoint, err)
+		} else if got != test.want {
+			t.Errorf("%q via %q: want %q, got %q", test.localAddr, test.endpoint, test.want, got)
+		}
+	}
+}
+
+func TestUsableInternalIP(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		ip, gateway string
+		want        bool
+	}{
+		{"192.168.1.10", "192.168.1.1", true},
+		{"fe80::10", "fe80::1", true},
+		{"2001:db8::10", "", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "192.168.1.1", false},
+		{"127.0.0.1", "", false},
+		{"0.0.0.0", "192.168.1.1", false},
+		{"::", "fe80::1", false},
+		{"239.255.255.250", "192.168.1.1", false},
+		{"255.255.255.255", "192.168.1.1", false},
+		{"", "192.168.1.1", false},
+	}
+	for _, test := range tests {
+		if got := usableInternalIP(net.ParseIP(test.ip), net.ParseIP(test.gateway)); got != test.want {
+			t.Errorf("usableInternalIP(%q, %q): want %t, got %t", test.ip, test.gateway, test.want, got)
+		}
+	}
+}
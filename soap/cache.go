@@ -0,0 +1,84 @@
+package soap
+
+import (
+	"sync"
+	"time"
+)
+
+// ActionCache holds the responses to read-only actions for a short time, so
+// that polling an action such as GetExternalIPAddress does not send a request
+// to the device each time. Only the actions named when the cache is created
+// are cached, keyed by the client's endpoint, the action and its arguments.
+// Faults and other errors are not cached. An ActionCache is safe for
+// concurrent use, and may be shared between clients.
+type ActionCache struct {
+	ttl     time.Duration
+	actions map[string]bool
+	// now returns the current time, and is replaced in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]actionCacheEntry
+}
+
+type actionCacheEntry struct {
+	rawAction []byte
+	expires   time.Time
+}
+
+// NewActionCache creates a cache of the responses to the named actions, which
+// are kept for ttl. Only actions without side effects should be named.
+func NewActionCache(ttl time.Duration, actionNames ...string) *ActionCache {
+	actions := make(map[string]bool, len(actionNames))
+	for _, name := range actionNames {
+		actions[name] = true
+	}
+	return &ActionCache{
+		ttl:     ttl,
+		actions: actions,
+		now:     time.Now,
+		entries: make(map[string]actionCacheEntry),
+	}
+}
+
+// WithActionCache makes the client answer the actions named by cache from it
+// where possible, and add the responses to them to it. By default, responses
+// are not cached.
+func WithActionCache(cache *ActionCache) Option {
+	return func(client *SOAPClient) {
+		client.actionCache = cache
+	}
+}
+
+// Clear removes all responses from the cache, e.g after an action that
+// changes the results of the cached actions.
+func (cache *ActionCache) Clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = make(map[string]actionCacheEntry)
+}
+
+// get returns the unexpired response to the request with key, if any.
+func (cache *ActionCache) get(key string) ([]byte, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok || !cache.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.rawAction, true
+}
+
+// put adds the response to the request with key, and removes any expired
+// responses.
+func (cache *ActionCache) put(key string, rawAction []byte) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	now := cache.now()
+	for k, entry := range cache.entries {
+		if !now.Before(entry.expires) {
+			delete(cache.entries, k)
+		}
+	}
+	cache.entries[key] = actionCacheEntry{rawAction: rawAction, expires: now.Add(cache.ttl)}
+}
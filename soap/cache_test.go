@@ -0,0 +1,64 @@
+package soap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestActionCache(t *testing.T) {
+	t.Parallel()
+	url, requests := newFlakyServer(t, 501, 0)
+	cache := NewActionCache(time.Minute, "myaction")
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+	client := NewSOAPClient(*url, WithActionCache(cache))
+	ctx := context.Background()
+
+	type In struct{ Arg string }
+	call := func(action, arg string, wantRequests int) {
+		t.Helper()
+		out := &struct{ Foo string }{}
+		if err := client.PerformActionCtx(ctx, "mynamespace", action, &In{arg}, out); err != nil {
+			t.Fatal(err)
+		}
+		if want := "bar"; out.Foo != want {
+			t.Errorf("want %q, got %q", want, out.Foo)
+		}
+		if got := requests(); got != wantRequests {
+			t.Errorf("%s(%q): want %d requests, got %d", action, arg, wantRequests, got)
+		}
+	}
+
+	call("myaction", "a", 1)
+	call("myaction", "a", 1) // Cached.
+	call("myaction", "b", 2) // Different arguments.
+	call("myaction", "b", 2)
+	call("otheraction", "a", 3) // Not cacheable.
+	call("otheraction", "a", 4)
+	now = now.Add(time.Minute)
+	call("myaction", "a", 5) // Expired.
+	call("myaction", "a", 5)
+	cache.Clear()
+	call("myaction", "a", 6)
+}
+
+func TestActionCacheFault(t *testing.T) {
+	t.Parallel()
+	url, requests := newFlakyServer(t, 501, 1)
+	client := NewSOAPClient(*url, WithActionCache(NewActionCache(time.Minute, "myaction")))
+	ctx := context.Background()
+
+	out := &struct{ Foo string }{}
+	if err := client.PerformActionCtx(ctx, "mynamespace", "myaction", nil, out); err == nil {
+		t.Fatal("want fault, got none")
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.PerformActionCtx(ctx, "mynamespace", "myaction", nil, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, got := 2, requests(); want != got {
+		t.Errorf("want %d requests, as faults are not cached, got %d", want, got)
+	}
+}
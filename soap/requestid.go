@@ -0,0 +1,35 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader is the header that a request ID from ContextWithRequestID is
+// sent in. This applies to SOAP requests, and to the other requests made to
+// devices by github.com/fsedano/goupnp. It may be changed before making any
+// requests.
+var RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context that carries id, which is sent in the
+// RequestIDHeader of requests made to devices with the context, e.g to
+// correlate them with the traces of the application.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// SetRequestID sets the RequestIDHeader of req to the request ID carried by its
+// context, if any.
+func SetRequestID(req *http.Request) {
+	if id, ok := RequestIDFromContext(req.Context()); ok {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
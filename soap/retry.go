@@ -0,0 +1,89 @@
+package soap
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy configures the retrying of actions that fail with a SOAP fault
+// which is known to be transient for some devices. Other errors, including
+// HTTP errors, are never retried.
+type RetryPolicy struct {
+	// FaultCodes are the UPnP error codes of faults that are retried.
+	FaultCodes []int
+	// MaxAttempts is the most times an action is performed, including the
+	// first attempt.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, which doubles before each
+	// further retry.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy retries the faults that some routers return under load,
+// but not on retry: 501 (Action Failed).
+var DefaultRetryPolicy = RetryPolicy{
+	FaultCodes:  []int{501},
+	MaxAttempts: 3,
+	Backoff:     100 * time.Millisecond,
+}
+
+// WithRetryPolicy makes the client retry actions as described by policy.
+// Each attempt is limited separately by WithDefaultTimeout, but all attempts
+// are limited by the deadline of the context passed to PerformActionCtx. By
+// default, actions are not retried.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(client *SOAPClient) {
+		client.retryPolicy = policy
+	}
+}
+
+// Retries reports whether policy retries actions that fail with err.
+func (policy RetryPolicy) Retries(err error) bool {
+	var fault *SOAPFaultError
+	if !errors.As(err, &fault) {
+		return false
+	}
+	for _, code := range policy.FaultCodes {
+		if fault.Detail.UPnPError.Errorcode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls perform until it succeeds, or fails with an error that the
+// policy does not retry, or the attempts are used up. onRetry, if not nil, is
+// called before each retry with the error and the delay before retrying.
+func (policy RetryPolicy) retry(ctx context.Context, perform func() error, onRetry func(err error, backoff time.Duration)) error {
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := perform()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retries(err) {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(err, backoff)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// retry performs the named action with the client's retry policy, logging
+// retries to the client's logger.
+func (client *SOAPClient) retry(ctx context.Context, actionName string, perform func() error) error {
+	var onRetry func(err error, backoff time.Duration)
+	if client.logger != nil {
+		onRetry = func(err error, backoff time.Duration) {
+			client.logger.Printf("soap: action %s failed, retrying in %v: %v", actionName, backoff, err)
+		}
+	}
+	return client.retryPolicy.retry(ctx, perform, onRetry)
+}
@@ -0,0 +1,223 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func upnpFaultResponse(code int) string {
+	return fmt.Sprintf(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>`+
+		`</UPnPError></detail></s:Fault></s:Body></s:Envelope>`, code)
+}
+
+// newFlakyServer starts a server that fails the first failures requests with
+// the UPnP fault code, and counts the requests made to it.
+func newFlakyServer(t *testing.T, code, failures int) (*url.URL, func() int) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(upnpFaultResponse(code)))
+			return
+		}
+		w.Write([]byte(testActionResponse))
+	}))
+	t.Cleanup(srv.Close)
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+	policy := RetryPolicy{FaultCodes: []int{501}, MaxAttempts: 3, Backoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		code         int
+		failures     int
+		wantErr      bool
+		wantRequests int
+	}{
+		{"transient", 501, 1, false, 2},
+		{"persistent", 501, 5, true, 3},
+		{"not retried", 401, 1, true, 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			url, requests := newFlakyServer(t, test.code, test.failures)
+			client := NewSOAPClient(*url, WithRetryPolicy(policy))
+			out := &struct{ Foo string }{}
+			err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, out)
+			if test.wantErr {
+				var fault *SOAPFaultError
+				if !errors.As(err, &fault) || fault.Detail.UPnPError.Errorcode != test.code {
+					t.Errorf("want fault %d, got %v", test.code, err)
+				}
+			} else if err != nil {
+				t.Errorf("want success, got %v", err)
+			} else if out.Foo != "bar" {
+				t.Errorf("want output %q, got %q", "bar", out.Foo)
+			}
+			if got := requests(); got != test.wantRequests {
+				t.Errorf("want %d requests, got %d", test.wantRequests, got)
+			}
+		})
+	}
+}
+
+func TestNoRetryByDefault(t *testing.T) {
+	t.Parallel()
+	url, requests := newFlakyServer(t, 501, 1)
+	client := NewSOAPClient(*url)
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err == nil {
+		t.Error("want fault, got nil")
+	}
+	if got := requests(); got != 1 {
+		t.Errorf("want 1 request, got %d", got)
+	}
+}
+
+func TestRetryLogging(t *testing.T) {
+	t.Parallel()
+	url, _ := newFlakyServer(t, 501, 1)
+	var buf bytes.Buffer
+	client := NewSOAPClient(*url,
+		WithRetryPolicy(RetryPolicy{FaultCodes: []int{501}, MaxAttempts: 2, Backoff: time.Millisecond}),
+		WithLogger(log.New(&buf, "", 0)))
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "action myaction failed, retrying in 1ms") {
+		t.Errorf("want retry logged, got %q", got)
+	}
+}
+
+// newIdleClosingServer starts a server that answers only the first request on
+// each connection, and closes the connection without responding to any further
+// request, as devices do that close idle connections early. If closeAll, every
+// request is closed without a response. It counts the requests made to it.
+func newIdleClosingServer(t *testing.T, closeAll bool) (*url.URL, func() int) {
+	var mu sync.Mutex
+	requests := 0
+	seen := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		reused := seen[r.RemoteAddr]
+		seen[r.RemoteAddr] = true
+		mu.Unlock()
+		if closeAll || reused {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		w.Write([]byte(testActionResponse))
+	}))
+	t.Cleanup(srv.Close)
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestNoReplayClosedIdleConnection(t *testing.T) {
+	t.Parallel()
+	url, requests := newIdleClosingServer(t, false)
+	client := NewSOAPClient(*url)
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The second action reaches the device before the connection is closed,
+	// so it must not be sent again, as the device may have performed it.
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err == nil {
+		t.Error("want error, got nil")
+	}
+	if got := requests(); got != 2 {
+		t.Errorf("want 2 requests, got %d", got)
+	}
+}
+
+// getBodyTransport records the result of GetBody for each request.
+type getBodyTransport struct {
+	bodies []string
+}
+
+func (tr *getBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.GetBody == nil {
+		return nil, errors.New("no GetBody")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	tr.bodies = append(tr.bodies, string(b))
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(testActionResponse)),
+		Request:    req,
+	}, nil
+}
+
+func TestRequestGetBody(t *testing.T) {
+	t.Parallel()
+	tr := &getBodyTransport{}
+	client := NewSOAPClient(url.URL{Scheme: "http", Host: "192.0.2.1"}, WithTransport(tr))
+	want := []byte("<request/>")
+	if _, err := client.PerformRawActionCtx(context.Background(), "mynamespace", "myaction", want); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.bodies) != 1 || tr.bodies[0] != string(want) {
+		t.Errorf("want GetBody to return %q, got %q", want, tr.bodies)
+	}
+}
+
+func TestNoRetryClosedNewConnection(t *testing.T) {
+	t.Parallel()
+	url, requests := newIdleClosingServer(t, true)
+	client := NewSOAPClient(*url)
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err == nil {
+		t.Error("want error, got nil")
+	}
+	if got := requests(); got != 1 {
+		t.Errorf("want 1 request, got %d", got)
+	}
+}
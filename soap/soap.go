@@ -3,83 +3,450 @@
 package soap
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
+	"time"
 )
 
 const (
-	soapEncodingStyle = "http://schemas.xmlsoap.org/soap/encoding/"
-	soapPrefix        = xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
-	soapSuffix        = `</s:Body></s:Envelope>`
+	soapEncodingStyle     = "http://schemas.xmlsoap.org/soap/encoding/"
+	soapEnvelopeNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soapEnvelopeStart     = xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`
+	soapPrefix            = soapEnvelopeStart + `<s:Body>`
+	soapSuffix            = `</s:Body></s:Envelope>`
 )
 
+// maxDrainBytes is the most of an unread response body that is read before
+// closing it, so that the connection can be reused for the next request.
+const maxDrainBytes = 64 << 10
+
+// SOAPClient performs SOAP actions against a single endpoint. Connections are
+// kept alive between requests, so a sequence of actions, such as enumerating
+// port mappings, is sent over one connection to devices that allow it. The
+// default transport keeps up to http.DefaultMaxIdleConnsPerHost idle
+// connections per host; to tune this, e.g for concurrent actions, pass an
+// *http.Transport with a different MaxIdleConnsPerHost to WithTransport. A
+// request is retried once on a new connection if the device closed a
+// kept-alive connection before any of the request was written to it, but not
+// once the request was written, since the device may have performed it.
 type SOAPClient struct {
 	EndpointURL url.URL
 	HTTPClient  http.Client
+
+	soapActionFormat SOAPActionFormatFunc
+	envelopeHeader   EnvelopeHeaderFunc
+	contentType      string
+	exchangeHook     ExchangeHook
+	defaultTimeout   time.Duration
+	retryPolicy      RetryPolicy
+	logger           *log.Logger
+	actionCache      *ActionCache
+}
+
+// Option is the type for optional configuration of a SOAPClient.
+type Option func(*SOAPClient)
+
+// SOAPActionFormatFunc produces the value of the SOAPACTION header for the
+// given action.
+type SOAPActionFormatFunc func(actionNamespace, actionName string) string
+
+// QuotedSOAPAction formats the SOAPACTION header value as required by the UPnP
+// specification, i.e `"urn:...#actionName"`. This is the default.
+func QuotedSOAPAction(actionNamespace, actionName string) string {
+	return `"` + actionNamespace + "#" + actionName + `"`
+}
+
+// UnquotedSOAPAction formats the SOAPACTION header value without the
+// surrounding quotes, i.e `urn:...#actionName`. Some non-standard services
+// require this form.
+func UnquotedSOAPAction(actionNamespace, actionName string) string {
+	return actionNamespace + "#" + actionName
+}
+
+// WithSOAPActionFormat overrides how the SOAPACTION header value is formatted
+// for services that reject the spec-compliant QuotedSOAPAction form.
+func WithSOAPActionFormat(format SOAPActionFormatFunc) Option {
+	return func(client *SOAPClient) {
+		client.soapActionFormat = format
+	}
+}
+
+// EnvelopeHeaderFunc produces the contents of the SOAP Header element of the
+// request envelope for the given action, which must be well-formed XML, e.g
+// WS-Addressing elements. No Header element is sent if it returns nil.
+type EnvelopeHeaderFunc func(actionNamespace, actionName string) []byte
+
+// WithEnvelopeHeader adds a SOAP Header element, with the contents produced by
+// header, to the envelope of each request before its Body, for near-UPnP SOAP
+// services that require one. UPnP services do not use SOAP headers, so none is
+// sent by default. Requests sent with PerformRawActionCtx are not affected.
+func WithEnvelopeHeader(header EnvelopeHeaderFunc) Option {
+	return func(client *SOAPClient) {
+		client.envelopeHeader = header
+	}
+}
+
+// DefaultContentType is the Content-Type header value of SOAP requests, as
+// recommended by the UPnP Device Architecture.
+const DefaultContentType = `text/xml; charset="utf-8"`
+
+// WithContentType overrides DefaultContentType as the Content-Type header
+// value of requests, for devices that reject it, e.g those that require the
+// charset parameter to be unquoted.
+func WithContentType(contentType string) Option {
+	return func(client *SOAPClient) {
+		client.contentType = contentType
+	}
+}
+
+// ExchangeHook is called after a SOAP request with the request body that was
+// sent, and the response body that was received (nil if there was no
+// response). The response body is after any gzip content encoding has been
+// decoded. action is in the form "actionNamespace#actionName".
+type ExchangeHook func(reqBody, respBody []byte, action string)
+
+// WithExchangeHook sets a function to be called after each SOAP request with
+// copies of the bodies sent and received, for example to debug
+// interoperability with a device. The hook may retain or modify the copies.
+// When no hook is set, no copies are made, and the response body is decoded
+// without being buffered.
+func WithExchangeHook(hook ExchangeHook) Option {
+	return func(client *SOAPClient) {
+		client.exchangeHook = hook
+	}
+}
+
+// WithDefaultTimeout limits each action to the given duration when the context
+// passed to PerformActionCtx has no deadline, including calls to
+// PerformAction. A deadline on the context always takes precedence, whether it
+// is longer or shorter. By default, there is no limit other than any set on
+// HTTPClient.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(client *SOAPClient) {
+		client.defaultTimeout = timeout
+	}
+}
+
+// HTTP1Transport is a transport with the same settings as
+// http.DefaultTransport, except that it never attempts HTTP/2.
+var HTTP1Transport http.RoundTripper = newHTTP1Transport()
+
+func newHTTP1Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = false
+	// A non-nil, empty map disables HTTP/2 over TLS.
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	return t
+}
+
+// WithHTTP1Only makes the client send requests over HTTP1Transport, for
+// devices which are unreliable with HTTP/2. This replaces any Transport
+// previously set on HTTPClient.
+func WithHTTP1Only() Option {
+	return func(client *SOAPClient) {
+		client.HTTPClient.Transport = HTTP1Transport
+	}
 }
 
-func NewSOAPClient(endpointURL url.URL) *SOAPClient {
-	return &SOAPClient{
+// WithTransport makes the client send requests over transport, e.g to control
+// how connections to the device are dialed. This replaces any Transport
+// previously set on HTTPClient.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(client *SOAPClient) {
+		client.HTTPClient.Transport = transport
+	}
+}
+
+// WithHTTPClient makes the client send requests with a copy of httpClient,
+// e.g to set its Timeout or CheckRedirect. This replaces any Transport set by
+// previous options, so should be given before them.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *SOAPClient) {
+		client.HTTPClient = *httpClient
+	}
+}
+
+// WithLogger makes the client log actions that are retried (see
+// WithRetryPolicy) to logger. By default, nothing is logged.
+func WithLogger(logger *log.Logger) Option {
+	return func(client *SOAPClient) {
+		client.logger = logger
+	}
+}
+
+// NewSOAPClient creates a client for the given endpoint, with the given
+// options applied.
+func NewSOAPClient(endpointURL url.URL, opts ...Option) *SOAPClient {
+	client := &SOAPClient{
 		EndpointURL: endpointURL,
 	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
 }
 
 // PerformSOAPAction makes a SOAP request, with the given action.
 // inAction and outAction must both be pointers to structs with string fields
 // only.
 func (client *SOAPClient) PerformActionCtx(ctx context.Context, actionNamespace, actionName string, inAction interface{}, outAction interface{}) error {
-	requestBytes, err := encodeRequestAction(actionNamespace, actionName, inAction)
+	var header []byte
+	if client.envelopeHeader != nil {
+		header = client.envelopeHeader(actionNamespace, actionName)
+	}
+	requestBytes, err := encodeRequestAction(actionNamespace, actionName, inAction, header)
+	if err != nil {
+		return err
+	}
+
+	var cacheKey string
+	if cache := client.actionCache; cache != nil && cache.actions[actionName] {
+		cacheKey = client.EndpointURL.String() + "\x00" + string(requestBytes)
+		if rawAction, ok := cache.get(cacheKey); ok {
+			return unmarshalOutAction(rawAction, outAction)
+		}
+	}
+
+	var rawAction []byte
+	err = client.retry(ctx, actionName, func() (err error) {
+		rawAction, err = client.performAction(ctx, actionNamespace, actionName, requestBytes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if cacheKey != "" {
+		client.actionCache.put(cacheKey, rawAction)
+	}
+	return unmarshalOutAction(rawAction, outAction)
+}
+
+// unmarshalOutAction decodes the action element of a response into outAction,
+// unless it is nil.
+func unmarshalOutAction(rawAction []byte, outAction interface{}) error {
+	if outAction == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(rawAction, outAction); err != nil {
+		return fmt.Errorf("goupnp: error unmarshalling out action: %v, %v", err, rawAction)
+	}
+	return nil
+}
+
+// performAction sends the request for the action, and returns the action
+// element of the response.
+func (client *SOAPClient) performAction(ctx context.Context, actionNamespace, actionName string, requestBytes []byte) ([]byte, error) {
+	response, responseBody, done, err := client.send(ctx, actionNamespace, actionName, requestBytes)
 	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if response.StatusCode != 200 && response.ContentLength == 0 {
+		return nil, fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+	}
+
+	responseEnv := newSOAPEnvelope()
+	decoder := xml.NewDecoder(responseBody)
+	if err := decoder.Decode(responseEnv); err != nil {
+		if err == io.EOF && response.StatusCode != 200 {
+			// Chunked or connection-close responses have no Content-Length,
+			// so an empty error response is only found when reading it.
+			return nil, fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+		}
+		return nil, fmt.Errorf("goupnp: error decoding response body: %v", err)
+	}
+	// Read any trailing data through to EOF, so that the remainder of a
+	// chunked body is consumed and the connection may be reused.
+	if _, err := io.Copy(ioutil.Discard, responseBody); err != nil {
+		return nil, fmt.Errorf("goupnp: error reading response body: %v", err)
+	}
+	if err := responseEnv.checkNamespaces(); err != nil {
+		return nil, err
+	}
+
+	if responseEnv.Body.Fault != nil {
+		return nil, responseEnv.Body.Fault
+	} else if response.StatusCode != 200 {
+		return nil, fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+	}
+	return responseEnv.Body.RawAction, nil
+}
+
+// PerformRawActionCtx sends requestBytes, which must be a complete SOAP
+// envelope, as a request for the given action, and returns the response body
+// without decoding it. This is for services whose requests or responses cannot
+// be expressed with PerformActionCtx. A *SOAPFaultError is still returned if
+// the response is a SOAP fault, as is an error for other HTTP errors.
+func (client *SOAPClient) PerformRawActionCtx(ctx context.Context, actionNamespace, actionName string, requestBytes []byte) ([]byte, error) {
+	var responseBytes []byte
+	err := client.retry(ctx, actionName, func() (err error) {
+		responseBytes, err = client.performRawAction(ctx, actionNamespace, actionName, requestBytes)
 		return err
+	})
+	return responseBytes, err
+}
+
+func (client *SOAPClient) performRawAction(ctx context.Context, actionNamespace, actionName string, requestBytes []byte) ([]byte, error) {
+	response, responseBody, done, err := client.send(ctx, actionNamespace, actionName, requestBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	responseBytes, err := ioutil.ReadAll(responseBody)
+	if err != nil {
+		return nil, fmt.Errorf("goupnp: error reading response body: %v", err)
+	}
+	// The response body need not be an envelope that can be decoded, but if it
+	// is, report any fault in it.
+	responseEnv := newSOAPEnvelope()
+	if err := xml.Unmarshal(responseBytes, responseEnv); err == nil &&
+		responseEnv.checkNamespaces() == nil && responseEnv.Body.Fault != nil {
+		return nil, responseEnv.Body.Fault
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+	}
+	return responseBytes, nil
+}
+
+// send posts requestBytes to the endpoint as a request for the given action.
+// The returned body should be read instead of response.Body, and done must be
+// called once the caller has finished with it.
+func (client *SOAPClient) send(ctx context.Context, actionNamespace, actionName string, requestBytes []byte) (response *http.Response, body io.Reader, done func(), err error) {
+	soapActionFormat := client.soapActionFormat
+	if soapActionFormat == nil {
+		soapActionFormat = QuotedSOAPAction
+	}
+	contentType := client.contentType
+	if contentType == "" {
+		contentType = DefaultContentType
 	}
 
+	header := http.Header{
+		"SOAPACTION":   []string{soapActionFormat(actionNamespace, actionName)},
+		"CONTENT-TYPE": []string{contentType},
+		// Some devices compress responses. Asking for gzip explicitly
+		// means it is always decompressed by decodeContent, including
+		// where the Transport would not do so.
+		"Accept-Encoding": []string{"gzip"},
+	}
+	cancel := func() {}
+	if _, ok := ctx.Deadline(); !ok && client.defaultTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, client.defaultTimeout)
+	}
 	req := &http.Request{
 		Method: "POST",
 		URL:    &client.EndpointURL,
-		Header: http.Header{
-			"SOAPACTION":   []string{`"` + actionNamespace + "#" + actionName + `"`},
-			"CONTENT-TYPE": []string{"text/xml; charset=\"utf-8\""},
+		Header: header,
+		Body:   ioutil.NopCloser(bytes.NewReader(requestBytes)),
+		// GetBody lets the Transport retry the request on a new connection if
+		// a kept-alive connection turns out to have been closed by the device
+		// before any of the request was written. It does not retry a request
+		// that was written, since the device may have performed the action.
+		GetBody: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(requestBytes)), nil
 		},
-		Body: ioutil.NopCloser(bytes.NewBuffer(requestBytes)),
 		// Set ContentLength to avoid chunked encoding - some servers might not support it.
 		ContentLength: int64(len(requestBytes)),
 	}
 	req = req.WithContext(ctx)
-	response, err := client.HTTPClient.Do(req)
+	SetRequestID(req)
+	response, err = client.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("goupnp: error performing SOAP HTTP request: %v", err)
+		cancel()
+		if client.exchangeHook != nil {
+			client.exchangeHook(copyBytes(requestBytes), nil, actionNamespace+"#"+actionName)
+		}
+		return nil, nil, nil, fmt.Errorf("goupnp: error performing SOAP HTTP request: %v", err)
 	}
-	defer response.Body.Close()
-	if response.StatusCode != 200 && response.ContentLength == 0 {
-		return fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+	done = func() {
+		// A response that is closed without being read to EOF closes the
+		// connection, rather than returning it to be reused.
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxDrainBytes))
+		response.Body.Close()
+		cancel()
 	}
 
-	responseEnv := newSOAPEnvelope()
-	decoder := xml.NewDecoder(response.Body)
-	if err := decoder.Decode(responseEnv); err != nil {
-		return fmt.Errorf("goupnp: error decoding response body: %v", err)
+	body, err = decodeContent(response.Body)
+	if err != nil {
+		done()
+		return nil, nil, nil, err
 	}
-
-	if responseEnv.Body.Fault != nil {
-		return responseEnv.Body.Fault
-	} else if response.StatusCode != 200 {
-		return fmt.Errorf("goupnp: SOAP request got HTTP %s", response.Status)
+	if client.exchangeHook != nil {
+		responseBytes, err := ioutil.ReadAll(body)
+		client.exchangeHook(copyBytes(requestBytes), copyBytes(responseBytes), actionNamespace+"#"+actionName)
+		if err != nil {
+			done()
+			return nil, nil, nil, fmt.Errorf("goupnp: error reading response body: %v", err)
+		}
+		body = bytes.NewReader(responseBytes)
 	}
+	return response, body, done, nil
+}
 
-	if outAction != nil {
-		if err := xml.Unmarshal(responseEnv.Body.RawAction, outAction); err != nil {
-			return fmt.Errorf("goupnp: error unmarshalling out action: %v, %v", err, responseEnv.Body.RawAction)
+// copyBytes returns a copy of b, for passing to an ExchangeHook.
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// gzipMagic is the header that starts gzip data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// utf8BOM is the byte order mark that some devices send before XML.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// decodeContent returns a reader of the decompressed content of body if it is
+// gzipped, or of body itself otherwise. The content is sniffed rather than
+// trusting the Content-Encoding header, as some devices send gzip without it,
+// and XML cannot start with the gzip header. Any byte order mark and
+// whitespace before the content, as sent by some cheap firmwares, is skipped.
+func decodeContent(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("goupnp: error decompressing response body: %v", err)
 		}
+		br = bufio.NewReader(zr)
 	}
+	skipLeadingSpace(br)
+	return br, nil
+}
 
-	return nil
+// skipLeadingSpace discards byte order marks and XML whitespace from the start
+// of br.
+func skipLeadingSpace(br *bufio.Reader) {
+	for {
+		if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+			br.Discard(len(utf8BOM))
+			continue
+		}
+		c, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			br.Discard(1)
+		default:
+			return
+		}
+	}
 }
 
 // PerformAction is the legacy version of PerformActionCtx, which uses
@@ -99,10 +466,21 @@ func newSOAPEnvelope() *soapEnvelope {
 // containing the given action. Experiments with one router have shown that it
 // 500s for requests where the outer default xmlns is set to the SOAP
 // namespace, and then reassigning the default namespace within that to the
-// service namespace. Hand-coding the outer XML to work-around this.
-func encodeRequestAction(actionNamespace, actionName string, inAction interface{}) ([]byte, error) {
+// service namespace. Hand-coding the outer XML to work-around this. If header
+// is non-nil, it is written as the contents of a Header element.
+func encodeRequestAction(actionNamespace, actionName string, inAction interface{}, header []byte) ([]byte, error) {
 	requestBuf := new(bytes.Buffer)
-	requestBuf.WriteString(soapPrefix)
+	if header == nil {
+		requestBuf.WriteString(soapPrefix)
+	} else {
+		if err := checkWellFormed(header); err != nil {
+			return nil, fmt.Errorf("goupnp: invalid SOAP envelope header: %v", err)
+		}
+		requestBuf.WriteString(soapEnvelopeStart)
+		requestBuf.WriteString(`<s:Header>`)
+		requestBuf.Write(header)
+		requestBuf.WriteString(`</s:Header><s:Body>`)
+	}
 	requestBuf.WriteString(`<u:`)
 	xml.EscapeText(requestBuf, []byte(actionName))
 	requestBuf.WriteString(` xmlns:u="`)
@@ -120,6 +498,33 @@ func encodeRequestAction(actionNamespace, actionName string, inAction interface{
 	return requestBuf.Bytes(), nil
 }
 
+// checkWellFormed returns an error if data is not a well-formed sequence of
+// XML content, such that it can be placed within an element.
+func checkWellFormed(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.ProcInst:
+			return errors.New("unexpected processing instruction")
+		}
+	}
+	if depth != 0 {
+		return errors.New("unclosed element")
+	}
+	return nil
+}
+
 func encodeRequestArgs(w *bytes.Buffer, inAction interface{}) error {
 	in := reflect.Indirect(reflect.ValueOf(inAction))
 	if in.Kind() != reflect.Struct {
@@ -156,10 +561,12 @@ func encodeRequestArgs(w *bytes.Buffer, inAction interface{}) error {
 	return nil
 }
 
-var xmlCharRx = regexp.MustCompile("[<>&]")
+var xmlCharRx = regexp.MustCompile(`[<>&\r\x00-\x08\x0b\x0c\x0e-\x1f]`)
 
 // escapeXMLText is used by generated code to escape text in XML, but only
-// escaping the characters `<`, `>`, and `&`.
+// escaping the characters `<`, `>`, and `&`, along with carriage returns
+// (which XML parsers would otherwise normalize away). Control characters that
+// are not allowed in XML are replaced with U+FFFD, as by xml.EscapeText.
 //
 // This is provided in order to work around SOAP server implementations that
 // fail to decode XML correctly, specifically failing to decode `"`, `'`. Note
@@ -177,17 +584,33 @@ func replaceEntity(s string) string {
 		return "&gt;"
 	case "&":
 		return "&amp;"
+	case "\r":
+		return "&#xD;"
 	}
-	return s
+	return "\uFFFD"
 }
 
+// soapEnvelope matches the Envelope and Body elements by local name only, as
+// some devices omit the SOAP namespace. checkNamespaces rejects any namespace
+// other than the SOAP namespace.
 type soapEnvelope struct {
-	XMLName       xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	XMLName       xml.Name `xml:"Envelope"`
 	EncodingStyle string   `xml:"http://schemas.xmlsoap.org/soap/envelope/ encodingStyle,attr"`
-	Body          soapBody `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	Body          soapBody `xml:"Body"`
+}
+
+func (env *soapEnvelope) checkNamespaces() error {
+	for _, name := range []xml.Name{env.XMLName, env.Body.XMLName} {
+		if name.Space != "" && name.Space != soapEnvelopeNamespace {
+			return fmt.Errorf("goupnp: SOAP response element %s has unexpected namespace %q",
+				name.Local, name.Space)
+		}
+	}
+	return nil
 }
 
 type soapBody struct {
+	XMLName   xml.Name
 	Fault     *SOAPFaultError `xml:"Fault"`
 	RawAction []byte          `xml:",innerxml"`
 }
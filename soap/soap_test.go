@@ -1,14 +1,23 @@
 package soap
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type capturingRoundTripper struct {
@@ -89,6 +98,42 @@ func TestActionInputs(t *testing.T) {
 	}
 }
 
+func TestActionInputsRoundTrip(t *testing.T) {
+	t.Parallel()
+	type In struct {
+		NewRemoteHost             string
+		NewPortMappingDescription string
+	}
+	values := []string{
+		"Tom & Jerry's <server>",
+		`say "hi" & bye`,
+		"a < b > c",
+		"&amp; already escaped",
+		"line 1\r\nline 2",
+	}
+	for _, value := range values {
+		value := value
+		t.Run(value, func(t *testing.T) {
+			t.Parallel()
+			body, err := encodeRequestAction("mynamespace", "myaction", &In{value, value}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var env struct {
+				Body struct {
+					Action In `xml:"mynamespace myaction"`
+				}
+			}
+			if err := xml.Unmarshal(body, &env); err != nil {
+				t.Fatalf("want valid XML, got %v in %s", err, body)
+			}
+			if want, got := (In{value, value}), env.Body.Action; want != got {
+				t.Errorf("want %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
 func TestUPnPError(t *testing.T) {
 	t.Parallel()
 	url, err := url.Parse("http://example.com/soap")
@@ -159,6 +204,728 @@ func TestUPnPError(t *testing.T) {
 	}
 }
 
+func TestUnprefixedResponse(t *testing.T) {
+	t.Parallel()
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"no namespace", `
+			<Envelope>
+				<Body>
+					<myactionResponse>
+						<A>valueA</A>
+						<B>valueB</B>
+					</myactionResponse>
+				</Body>
+			</Envelope>`, false},
+		{"default namespace", `
+			<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/">
+				<Body>
+					<myactionResponse xmlns="mynamespace">
+						<A>valueA</A>
+						<B>valueB</B>
+					</myactionResponse>
+				</Body>
+			</Envelope>`, false},
+		{"wrong namespace", `
+			<Envelope xmlns="urn:not-soap">
+				<Body>
+					<myactionResponse/>
+				</Body>
+			</Envelope>`, true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			rt := &capturingRoundTripper{
+				resp: &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(test.body)),
+				},
+			}
+			client := SOAPClient{
+				EndpointURL: *url,
+				HTTPClient: http.Client{
+					Transport: rt,
+				},
+			}
+
+			type Out struct {
+				A string
+				B string
+			}
+			gotOut := Out{}
+			err := client.PerformAction("mynamespace", "myaction", nil, &gotOut)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantOut := Out{"valueA", "valueB"}
+			if !reflect.DeepEqual(wantOut, gotOut) {
+				t.Errorf("Bad output\nwant: %+v\n got: %+v", wantOut, gotOut)
+			}
+		})
+	}
+}
+
+func TestSOAPActionHeader(t *testing.T) {
+	t.Parallel()
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, `"mynamespace#myaction"`},
+		{"quoted", []Option{WithSOAPActionFormat(QuotedSOAPAction)}, `"mynamespace#myaction"`},
+		{"unquoted", []Option{WithSOAPActionFormat(UnquotedSOAPAction)}, `mynamespace#myaction`},
+		{"custom", []Option{WithSOAPActionFormat(func(ns, name string) string {
+			return name
+		})}, `myaction`},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			rt := &capturingRoundTripper{
+				resp: &http.Response{
+					StatusCode: 200,
+					Body: ioutil.NopCloser(bytes.NewBufferString(`
+						<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+							<s:Body><u:myactionResponse xmlns:u="mynamespace"/></s:Body>
+						</s:Envelope>
+					`)),
+				},
+			}
+			client := NewSOAPClient(*url, test.opts...)
+			client.HTTPClient.Transport = rt
+
+			if err := client.PerformAction("mynamespace", "myaction", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			got := rt.capturedReq.Header["SOAPACTION"]
+			if len(got) != 1 || got[0] != test.want {
+				t.Errorf("Bad SOAPACTION header\nwant: %q\n got: %q", test.want, got)
+			}
+			var wire bytes.Buffer
+			if err := rt.capturedReq.Header.Write(&wire); err != nil {
+				t.Fatal(err)
+			}
+			if wantLine := "SOAPACTION: " + test.want + "\r\n"; !strings.Contains(wire.String(), wantLine) {
+				t.Errorf("Bad SOAPACTION header on the wire\nwant line: %q\n got: %q", wantLine, wire.String())
+			}
+		})
+	}
+}
+
+func TestContentTypeHeader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, `text/xml; charset="utf-8"`},
+		{"unquoted", []Option{WithContentType("text/xml; charset=utf-8")}, `text/xml; charset=utf-8`},
+		{"no charset", []Option{WithContentType("text/xml")}, `text/xml`},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header["Content-Type"]
+				w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+					<s:Body><u:myactionResponse xmlns:u="mynamespace"/></s:Body>
+				</s:Envelope>`))
+			}))
+			t.Cleanup(srv.Close)
+			url, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := NewSOAPClient(*url, test.opts...)
+			if err := client.PerformAction("mynamespace", "myaction", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != test.want {
+				t.Errorf("Bad Content-Type header\nwant: %q\n got: %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExchangeHook(t *testing.T) {
+	t.Parallel()
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody := `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<s:Body><u:myactionResponse xmlns:u="mynamespace"><A>valueA</A></u:myactionResponse></s:Body>` +
+		`</s:Envelope>`
+	rt := &capturingRoundTripper{
+		resp: &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(respBody)),
+		},
+	}
+
+	var calls int
+	var gotReq, gotResp []byte
+	var gotAction string
+	client := NewSOAPClient(*url, WithExchangeHook(func(reqBody, respBody []byte, action string) {
+		calls++
+		// The hook is given copies, which it may retain and modify.
+		gotReq = reqBody
+		gotResp = append([]byte(nil), respBody...)
+		for i := range respBody {
+			respBody[i] = 'x'
+		}
+		gotAction = action
+	}))
+	client.HTTPClient.Transport = rt
+
+	type In struct {
+		Foo string
+	}
+	type Out struct {
+		A string
+	}
+	out := Out{}
+	if err := client.PerformAction("mynamespace", "myaction", &In{"foo"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("want hook called once, got %d calls", calls)
+	}
+	wantReq := soapPrefix + `<u:myaction xmlns:u="mynamespace"><Foo>foo</Foo></u:myaction>` + soapSuffix
+	if string(gotReq) != wantReq {
+		t.Errorf("Bad request body\nwant: %q\n got: %q", wantReq, gotReq)
+	}
+	if string(gotResp) != respBody {
+		t.Errorf("Bad response body\nwant: %q\n got: %q", respBody, gotResp)
+	}
+	if want := "mynamespace#myaction"; gotAction != want {
+		t.Errorf("Bad action\nwant: %q\n got: %q", want, gotAction)
+	}
+	// The response must still be decoded after being captured.
+	if out.A != "valueA" {
+		t.Errorf("Bad output\nwant: %q\n got: %q", "valueA", out.A)
+	}
+}
+
+func TestEnvelopeHeader(t *testing.T) {
+	t.Parallel()
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody := `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<s:Body><u:myactionResponse xmlns:u="mynamespace"></u:myactionResponse></s:Body>` +
+		`</s:Envelope>`
+	header := func(actionNamespace, actionName string) []byte {
+		return []byte(`<wsa:Action xmlns:wsa="http://www.w3.org/2005/08/addressing">` +
+			actionNamespace + "#" + actionName + `</wsa:Action>`)
+	}
+	tests := []struct {
+		name   string
+		header EnvelopeHeaderFunc
+		want   string
+	}{
+		{"default", nil,
+			soapPrefix + `<u:myaction xmlns:u="mynamespace"></u:myaction>` + soapSuffix},
+		{"header", header,
+			soapEnvelopeStart + `<s:Header><wsa:Action xmlns:wsa="http://www.w3.org/2005/08/addressing">` +
+				`mynamespace#myaction</wsa:Action></s:Header>` +
+				`<s:Body><u:myaction xmlns:u="mynamespace"></u:myaction>` + soapSuffix},
+		{"empty header", func(string, string) []byte { return []byte{} },
+			soapEnvelopeStart + `<s:Header></s:Header>` +
+				`<s:Body><u:myaction xmlns:u="mynamespace"></u:myaction>` + soapSuffix},
+	}
+	for _, test := range tests {
+		var gotReq []byte
+		rt := &capturingRoundTripper{
+			resp: &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(respBody)),
+			},
+		}
+		opts := []Option{WithExchangeHook(func(reqBody, respBody []byte, action string) {
+			gotReq = append([]byte(nil), reqBody...)
+		})}
+		if test.header != nil {
+			opts = append(opts, WithEnvelopeHeader(test.header))
+		}
+		client := NewSOAPClient(*url, opts...)
+		client.HTTPClient.Transport = rt
+		if err := client.PerformAction("mynamespace", "myaction", nil, nil); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(gotReq) != test.want {
+			t.Errorf("%s: bad request body\nwant: %q\n got: %q", test.name, test.want, gotReq)
+		}
+	}
+
+	for _, invalid := range []string{`<wsa:Action>`, `</wsa:Action>`, `<a><b></a>`, `<?xml version="1.0"?><a/>`} {
+		client := NewSOAPClient(*url, WithEnvelopeHeader(func(string, string) []byte { return []byte(invalid) }))
+		client.HTTPClient.Transport = &capturingRoundTripper{}
+		if err := client.PerformAction("mynamespace", "myaction", nil, nil); err == nil {
+			t.Errorf("%q: want error for invalid header, got nil", invalid)
+		}
+	}
+}
+
+// TestConnectionCloseResponse tests responses without a Content-Length, from
+// a server that closes the connection after writing.
+func TestConnectionCloseResponse(t *testing.T) {
+	t.Parallel()
+	envelope := `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<s:Body><u:myactionResponse xmlns:u="mynamespace"><A>valueA</A></u:myactionResponse></s:Body>` +
+		`</s:Envelope>`
+	tests := []struct {
+		name    string
+		resp    string
+		wantErr string
+	}{
+		{
+			name: "connection close",
+			resp: "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + envelope,
+		},
+		{
+			name: "chunked",
+			resp: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+				fmt.Sprintf("%x\r\n%s\r\n", len(envelope[:50]), envelope[:50]) +
+				fmt.Sprintf("%x\r\n%s\r\n", len(envelope[50:]), envelope[50:]) +
+				"0\r\n\r\n",
+		},
+		{
+			name:    "empty error",
+			resp:    "HTTP/1.1 500 Internal Server Error\r\nConnection: close\r\n\r\n",
+			wantErr: "HTTP 500",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				conn.Write([]byte(test.resp))
+			}()
+
+			url, err := url.Parse("http://" + l.Addr().String() + "/soap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewSOAPClient(*url)
+
+			type Out struct {
+				A string
+			}
+			out := Out{}
+			err = client.PerformAction("mynamespace", "myaction", nil, &out)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (Out{"valueA"}); want != out {
+				t.Errorf("Bad output\nwant: %+v\n got: %+v", want, out)
+			}
+		})
+	}
+}
+
+func TestHTTP1Transport(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Trust the test server's certificate, leaving other settings as is.
+	tr := HTTP1Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	client := http.Client{Transport: tr}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	proto, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "HTTP/1.1", string(proto); want != got {
+		t.Errorf("want protocol %s, got %s", want, got)
+	}
+
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if soapClient := NewSOAPClient(*url, WithHTTP1Only()); soapClient.HTTPClient.Transport != HTTP1Transport {
+		t.Errorf("want WithHTTP1Only to set HTTP1Transport, got %v", soapClient.HTTPClient.Transport)
+	}
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewSOAPClient(*url, WithDefaultTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	err = client.PerformAction("mynamespace", "myaction", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("want deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want action to time out quickly, took %v", elapsed)
+	}
+
+	// An explicit deadline on the context takes precedence.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	client.PerformActionCtx(ctx, "mynamespace", "myaction", nil, nil)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("want context deadline to override default timeout, took %v", elapsed)
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	t.Parallel()
+	rt := &capturingRoundTripper{
+		resp: &http.Response{
+			StatusCode:    200,
+			ContentLength: int64(len(testActionResponse)),
+			Body:          ioutil.NopCloser(strings.NewReader(testActionResponse)),
+		},
+	}
+	url, err := url.Parse("http://example.com/soap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &http.Client{Transport: rt, Timeout: time.Minute}
+	client := NewSOAPClient(*url, WithHTTPClient(httpClient))
+	if client.HTTPClient.Timeout != time.Minute {
+		t.Errorf("want HTTP client timeout %v, got %v", time.Minute, client.HTTPClient.Timeout)
+	}
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rt.capturedReq == nil {
+		t.Error("want request sent with the given HTTP client, got none")
+	}
+}
+
+func TestPerformRawAction(t *testing.T) {
+	t.Parallel()
+	const request = `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<s:Body><u:X_Custom xmlns:u="urn:vendor:service:X:1"><Blob><a/><b/></Blob></u:X_Custom></s:Body></s:Envelope>`
+	const response = `<?xml version="1.0"?><Reply><Item>1</Item><Item>2</Item></Reply>`
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		switch r.Header.Get("SOAPACTION") {
+		case `"urn:vendor:service:X:1#X_Custom"`:
+			w.Write([]byte(response))
+		case `"urn:vendor:service:X:1#X_Fault"`:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>` +
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>` +
+				`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>401</errorCode>` +
+				`<errorDescription>Invalid Action</errorDescription></UPnPError></detail>` +
+				`</s:Fault></s:Body></s:Envelope>`))
+		default:
+			http.Error(w, "bad action", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewSOAPClient(*url)
+	ctx := context.Background()
+
+	got, err := client.PerformRawActionCtx(ctx, "urn:vendor:service:X:1", "X_Custom", []byte(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != response {
+		t.Errorf("want response %q, got %q", response, got)
+	}
+	if string(gotBody) != request {
+		t.Errorf("want request %q, got %q", request, gotBody)
+	}
+
+	_, err = client.PerformRawActionCtx(ctx, "urn:vendor:service:X:1", "X_Fault", []byte(request))
+	var fault *SOAPFaultError
+	if !errors.As(err, &fault) || fault.Detail.UPnPError.Errorcode != 401 {
+		t.Errorf("want SOAP fault 401, got %v", err)
+	}
+
+	if _, err := client.PerformRawActionCtx(ctx, "urn:vendor:service:X:1", "X_Other", []byte(request)); err == nil {
+		t.Error("want error for HTTP 400, got nil")
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	t.Parallel()
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+		`<u:myactionResponse xmlns:u="mynamespace"><Foo>compressed</Foo></u:myactionResponse>` +
+		`</s:Body></s:Envelope>`))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+	}{
+		{"labelled", "gzip"},
+		{"mislabelled", ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if want, got := "gzip", r.Header.Get("Accept-Encoding"); want != got {
+					t.Errorf("want Accept-Encoding %q, got %q", want, got)
+				}
+				if test.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				w.Write(gzipped.Bytes())
+			}))
+			defer srv.Close()
+			url, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewSOAPClient(*url)
+
+			out := &struct{ Foo string }{}
+			if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, out); err != nil {
+				t.Fatal(err)
+			}
+			if want := "compressed"; out.Foo != want {
+				t.Errorf("want %q, got %q", want, out.Foo)
+			}
+		})
+	}
+}
+
+func TestLeadingBOMResponse(t *testing.T) {
+	t.Parallel()
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("\ufeff\r\n" + testActionResponse))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"BOM", "\ufeff" + testActionResponse},
+		{"BOM and declaration", "\ufeff<?xml version=\"1.0\"?>\n" + testActionResponse},
+		{"blank lines", "\r\n\r\n \t<?xml version=\"1.0\"?>\n" + testActionResponse},
+		{"BOM and blank lines", "\ufeff\n\n" + testActionResponse},
+		{"gzipped", gzipped.String()},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(test.body))
+			}))
+			t.Cleanup(srv.Close)
+			url, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewSOAPClient(*url)
+			ctx := context.Background()
+
+			out := &struct{ Foo string }{}
+			if err := client.PerformActionCtx(ctx, "mynamespace", "myaction", nil, out); err != nil {
+				t.Fatal(err)
+			}
+			if want := "bar"; out.Foo != want {
+				t.Errorf("want %q, got %q", want, out.Foo)
+			}
+			raw, err := client.PerformRawActionCtx(ctx, "mynamespace", "myaction", []byte(testActionResponse))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(raw, []byte("<")) {
+				t.Errorf("want raw response to start with the XML, got %q", raw)
+			}
+		})
+	}
+}
+
+const testActionResponse = `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+	`<u:myactionResponse xmlns:u="mynamespace"><Foo>bar</Foo></u:myactionResponse>` +
+	`</s:Body></s:Envelope>`
+
+// newCountingServer starts a server that responds to every SOAP request with
+// status and body, and counts the connections made to it.
+func newCountingServer(t testing.TB, status int, body string) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte(body + strings.Repeat(" ", 1024)))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return conns
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"success", http.StatusOK, testActionResponse},
+		{"error", http.StatusInternalServerError, testActionResponse},
+		{"garbage", http.StatusOK, "<html>" + strings.Repeat("not SOAP", 1024)},
+	}
+	for _, test := range tests {
+		srv, conns := newCountingServer(t, test.status, test.body)
+		defer srv.Close()
+		url, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewSOAPClient(*url)
+		for i := 0; i < 10; i++ {
+			client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil)
+		}
+		if got := conns(); got != 1 {
+			t.Errorf("%s: want 1 connection for sequential requests, got %d", test.name, got)
+		}
+	}
+}
+
+func BenchmarkSequentialActions(b *testing.B) {
+	srv, conns := newCountingServer(b, http.StatusOK, testActionResponse)
+	defer srv.Close()
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	client := NewSOAPClient(*url)
+	out := &struct{ Foo string }{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		w.Write([]byte(testActionResponse))
+	}))
+	defer srv.Close()
+	url, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewSOAPClient(*url)
+
+	ctx := ContextWithRequestID(context.Background(), "req-1234")
+	if err := client.PerformActionCtx(ctx, "mynamespace", "myaction", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PerformActionCtx(context.Background(), "mynamespace", "myaction", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"req-1234", ""}; !reflect.DeepEqual(want, got) {
+		t.Errorf("want request IDs %q, got %q", want, got)
+	}
+}
+
 func TestEscapeXMLText(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -169,6 +936,8 @@ func TestEscapeXMLText(t *testing.T) {
 		{"abc123", "abc123"},
 		{"<foo>&", "&lt;foo&gt;&amp;"},
 		{"\"foo'", "\"foo'"},
+		{"a\r\nb\tc", "a&#xD;\nb\tc"},
+		{"a\x00b\x1bc", "a\uFFFDb\uFFFDc"},
 	}
 	for _, test := range tests {
 		test := test
@@ -53,6 +53,10 @@ type Update struct {
 	// does not modify the Entry value - any updates are replaced with a new
 	// Entry value.
 	Entry *Entry
+	// Set for EventAlive if the service was already known, and its NLS has
+	// changed, which means that the device has rebooted and any state held
+	// about it (such as event subscriptions) should be reconciled.
+	Rebooted bool
 }
 
 type Entry struct {
@@ -76,6 +80,13 @@ type Entry struct {
 
 	SearchPort uint16
 
+	// The OPT header, declaring the namespace of the NLS header. Empty for UPnP
+	// 1.0 devices, which do not send it.
+	Opt string
+	// The network location signature, which changes each time the device
+	// boots. Empty if the device did not send one.
+	NLS string
+
 	// When the last update was received for this entry identified by this USN.
 	LastUpdate time.Time
 	// When the last update's cached values are advised to expire.
@@ -121,6 +132,8 @@ func newEntryFromRequest(r *http.Request) (*Entry, error) {
 		BootID:      bootID,
 		ConfigID:    configID,
 		SearchPort:  uint16(searchPort),
+		Opt:         r.Header.Get("OPT"),
+		NLS:         NLS(r.Header),
 		LastUpdate:  now,
 		CacheExpiry: now.Add(expiryDuration),
 	}, nil
@@ -258,6 +271,7 @@ func (reg *Registry) handleNTSAlive(r *http.Request) error {
 	}
 
 	reg.lock.Lock()
+	prev := reg.byUSN[entry.USN]
 	reg.byUSN[entry.USN] = entry
 	reg.lock.Unlock()
 
@@ -265,6 +279,7 @@ func (reg *Registry) handleNTSAlive(r *http.Request) error {
 		USN:       entry.USN,
 		EventType: EventAlive,
 		Entry:     entry,
+		Rebooted:  prev != nil && prev.NLS != "" && entry.NLS != "" && prev.NLS != entry.NLS,
 	})
 
 	return nil
@@ -0,0 +1,53 @@
+package ssdp
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func newTestNotify(usn, opt, nls string) *http.Request {
+	header := http.Header{
+		"Cache-Control": []string{"max-age=1800"},
+		"Location":      []string{"http://192.168.1.1:5000/rootDesc.xml"},
+		"Nt":            []string{"upnp:rootdevice"},
+		"Nts":           []string{ntsAlive},
+		"Usn":           []string{usn},
+	}
+	if opt != "" {
+		header.Set("OPT", opt)
+		header.Set("01-NLS", nls)
+	}
+	return &http.Request{Method: methodNotify, URL: &url.URL{Opaque: "*"}, Header: header}
+}
+
+func TestRegistryNLS(t *testing.T) {
+	t.Parallel()
+	const usn = "uuid:1234::upnp:rootdevice"
+	const opt = `"http://schemas.upnp.org/upnp/1/0/"; ns=01`
+	reg := NewRegistry()
+	updates := make(chan Update, 10)
+	reg.AddListener(updates)
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		wantNLS      string
+		wantRebooted bool
+	}{
+		{"first", newTestNotify(usn, opt, "b9200ebb-736d-4b93-bf03-835149d13983"), "b9200ebb-736d-4b93-bf03-835149d13983", false},
+		{"repeat", newTestNotify(usn, opt, "b9200ebb-736d-4b93-bf03-835149d13983"), "b9200ebb-736d-4b93-bf03-835149d13983", false},
+		{"reboot", newTestNotify(usn, opt, "1f2fe3a4-2b0c-4fd7-a0c1-0d6e86b8a3c1"), "1f2fe3a4-2b0c-4fd7-a0c1-0d6e86b8a3c1", true},
+		{"UPnP 1.0", newTestNotify(usn, "", ""), "", false},
+	}
+	for _, test := range tests {
+		reg.ServeMessage(test.req)
+		u := <-updates
+		if u.Entry.NLS != test.wantNLS {
+			t.Errorf("%s: want NLS %q, got %q", test.name, test.wantNLS, u.Entry.NLS)
+		}
+		if u.Rebooted != test.wantRebooted {
+			t.Errorf("%s: want Rebooted=%t, got %t", test.name, test.wantRebooted, u.Rebooted)
+		}
+	}
+}
@@ -3,22 +3,34 @@ package ssdp
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/fsedano/goupnp/httpu"
 )
 
 const (
-	ssdpDiscover   = `"ssdp:discover"`
-	ntsAlive       = `ssdp:alive`
-	ntsByebye      = `ssdp:byebye`
-	ntsUpdate      = `ssdp:update`
-	ssdpUDP4Addr   = "239.255.255.250:1900"
-	ssdpSearchPort = 1900
-	methodSearch   = "M-SEARCH"
-	methodNotify   = "NOTIFY"
+	ssdpDiscover         = `"ssdp:discover"`
+	ssdpDiscoverUnquoted = `ssdp:discover`
+	ntsAlive             = `ssdp:alive`
+	ntsByebye            = `ssdp:byebye`
+	ntsUpdate            = `ssdp:update`
+	ssdpUDP4Addr         = "239.255.255.250:1900"
+	ssdpSearchPort       = 1900
+	methodSearch         = "M-SEARCH"
+	methodNotify         = "NOTIFY"
+
+	// DefaultSearchPort is the port that devices listen on for unicast search
+	// requests, unless they advertise otherwise in the SEARCHPORT.UPNP.ORG
+	// header.
+	DefaultSearchPort = ssdpSearchPort
 
 	// SSDPAll is a value for searchTarget that searches for all devices and services.
 	SSDPAll = "ssdp:all"
@@ -44,6 +56,80 @@ type HTTPUClientCtx interface {
 	) ([]*http.Response, error)
 }
 
+// HTTPUClientMultiCtx is an optional interface that can be used to send
+// several search requests at once, sharing the time spent waiting for
+// responses.
+type HTTPUClientMultiCtx interface {
+	DoMultiWithContext(
+		reqs []*http.Request,
+		numSends int,
+	) ([]*http.Response, error)
+}
+
+// SearchOption is the type for optional configuration of RawSearch and
+// RawSearchMulti.
+type SearchOption func(*searchOptions)
+
+type searchOptions struct {
+	groupAddr   string
+	unquotedMAN bool
+}
+
+func newSearchOptions(opts []SearchOption) *searchOptions {
+	o := &searchOptions{groupAddr: ssdpUDP4Addr}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMulticastGroup sends search requests to the multicast group at addr, in
+// the form "host:port", instead of the standard SSDP group
+// 239.255.255.250:1900. This is for testing with a private group, and for
+// networks where devices use a non-standard group. The search fails if addr is
+// not a multicast IPv4 address.
+func WithMulticastGroup(addr string) SearchOption {
+	return func(o *searchOptions) {
+		o.groupAddr = addr
+	}
+}
+
+// WithUnquotedMAN sends the MAN header of search requests as ssdp:discover,
+// rather than the quoted "ssdp:discover" that the UDA specification requires.
+// Most devices accept either, but some with minimal SSDP stacks compare the
+// header literally against the unquoted form, and ignore searches that follow
+// the specification. Only use this when such a device is known to be present,
+// as conforming devices may equally ignore the unquoted form.
+func WithUnquotedMAN() SearchOption {
+	return func(o *searchOptions) {
+		o.unquotedMAN = true
+	}
+}
+
+// man returns the MAN header value for search requests.
+func (o *searchOptions) man() string {
+	if o.unquotedMAN {
+		return ssdpDiscoverUnquoted
+	}
+	return ssdpDiscover
+}
+
+// checkMulticastGroup returns an error if addr is not a multicast IPv4
+// address and port.
+func checkMulticastGroup(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("ssdp: invalid multicast group %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+		return fmt.Errorf("ssdp: %q is not a multicast IPv4 address", host)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("ssdp: invalid multicast group port %q", port)
+	}
+	return nil
+}
+
 // SSDPRawSearchCtx performs a fairly raw SSDP search request, and returns the
 // unique response(s) that it receives. Each response has the requested
 // searchTarget, a USN, and a valid location. maxWaitSeconds states how long to
@@ -58,7 +144,7 @@ func SSDPRawSearchCtx(
 	maxWaitSeconds int,
 	numSends int,
 ) ([]*http.Response, error) {
-	req, err := prepareRequest(ctx, searchTarget, maxWaitSeconds)
+	req, err := prepareRequest(ctx, newSearchOptions(nil), searchTarget, maxWaitSeconds)
 	if err != nil {
 		return nil, err
 	}
@@ -84,25 +170,95 @@ func RawSearch(
 	httpu HTTPUClientCtx,
 	searchTarget string,
 	numSends int,
+	opts ...SearchOption,
 ) ([]*http.Response, error) {
-	// We need a timeout value to include in the SSDP request; get it by
-	// checking the deadline on the context.
-	var maxWaitSeconds int
-	if deadline, ok := ctx.Deadline(); ok {
-		maxWaitSeconds = int(deadline.Sub(time.Now()) / time.Second)
-	} else {
-		// Pick a default timeout of 3 seconds if none was provided.
-		maxWaitSeconds = 3
+	ctx, maxWaitSeconds, cancel := searchWait(ctx)
+	defer cancel()
 
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(maxWaitSeconds)*time.Second)
-		defer cancel()
+	o := newSearchOptions(opts)
+	req, err := prepareRequest(ctx, o, searchTarget, maxWaitSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	allResponses, err := httpu.DoWithContext(req, numSends)
+	if err != nil {
+		return nil, err
+	}
+	return processSSDPResponses(searchTarget, allResponses)
+}
+
+// RawSearchMulti is like RawSearch, but sends a search request for each of
+// searchTargets over the same client, and waits for responses to all of them
+// at once. Responses are returned bucketed by the search target that they
+// matched. A response to "ssdp:all" or "upnp:rootdevice" cannot be told apart
+// from other responses, so such targets receive every response.
+func RawSearchMulti(
+	ctx context.Context,
+	httpu HTTPUClientMultiCtx,
+	searchTargets []string,
+	numSends int,
+	opts ...SearchOption,
+) (map[string][]*http.Response, error) {
+	ctx, maxWaitSeconds, cancel := searchWait(ctx)
+	defer cancel()
+
+	o := newSearchOptions(opts)
+	reqs := make([]*http.Request, 0, len(searchTargets))
+	for _, searchTarget := range searchTargets {
+		req, err := prepareRequest(ctx, o, searchTarget, maxWaitSeconds)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
 	}
 
-	req, err := prepareRequest(ctx, searchTarget, maxWaitSeconds)
+	allResponses, err := httpu.DoMultiWithContext(reqs, numSends)
 	if err != nil {
 		return nil, err
 	}
+	results := make(map[string][]*http.Response, len(searchTargets))
+	for _, searchTarget := range searchTargets {
+		if _, done := results[searchTarget]; done {
+			continue
+		}
+		if results[searchTarget], err = processSSDPResponses(searchTarget, allResponses); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// RawUnicastSearch performs an SSDP search request sent directly to a single
+// device, rather than multicast. addr is the "host:port" of the device's
+// search port, see SearchPort. Otherwise this behaves as RawSearch does, and
+// any multicast group set by opts is ignored.
+func RawUnicastSearch(
+	ctx context.Context,
+	httpu HTTPUClientCtx,
+	addr string,
+	searchTarget string,
+	numSends int,
+	opts ...SearchOption,
+) ([]*http.Response, error) {
+	ctx, _, cancel := searchWait(ctx)
+	defer cancel()
+
+	o := newSearchOptions(opts)
+
+	req := (&http.Request{
+		Method: methodSearch,
+		Host:   addr,
+		URL:    &url.URL{Opaque: "*"},
+		Header: http.Header{
+			// Putting headers in here avoids them being title-cased.
+			// (The UPnP discovery protocol uses case-sensitive headers)
+			// MX is not used for unicast search.
+			"HOST": []string{addr},
+			"MAN":  []string{o.man()},
+			"ST":   []string{searchTarget},
+		},
+	}).WithContext(ctx)
 
 	allResponses, err := httpu.DoWithContext(req, numSends)
 	if err != nil {
@@ -111,24 +267,197 @@ func RawSearch(
 	return processSSDPResponses(searchTarget, allResponses)
 }
 
+// Location returns the URL of the device description given by the LOCATION
+// header of a search response, as for http.Response.Location. Devices that
+// send a relative URL, or one with no host such as "http://:5000/desc.xml",
+// have it resolved against the address that the response was sent from, as
+// set in the httpu.RemoteAddressHeader header; port 80 is assumed if no port
+// is given.
+//
+// IPv6 zones are kept, whether or not the '%' before them is escaped as
+// required in URLs, e.g "http://[fe80::1%eth0]:5000/desc.xml". A link-local
+// address without a zone is given that of the address that the response was
+// sent from, as set in the httpu.RemoteZoneHeader header, so that it can be
+// dialed.
+func Location(header http.Header) (*url.URL, error) {
+	value := strings.TrimSpace(header.Get("LOCATION"))
+	if value == "" {
+		return nil, http.ErrNoLocation
+	}
+	loc, err := url.Parse(escapeZone(value))
+	if err != nil {
+		return nil, err
+	}
+	zone := header.Get(httpu.RemoteZoneHeader)
+	if loc.Scheme != "" && loc.Hostname() != "" {
+		addZone(loc, zone)
+		return loc, nil
+	}
+	if loc.Scheme == "" && loc.Host != "" {
+		// A scheme-relative URL, e.g "//192.0.2.1:5000/desc.xml".
+		loc.Scheme = "http"
+		if loc.Hostname() != "" {
+			addZone(loc, zone)
+			return loc, nil
+		}
+	}
+	remote := net.ParseIP(header.Get(httpu.RemoteAddressHeader))
+	if remote == nil {
+		return nil, fmt.Errorf("ssdp: relative LOCATION %q from unknown address", value)
+	}
+	host := remote.String()
+	if zone != "" && remote.IsLinkLocalUnicast() {
+		host += "%" + zone
+	}
+	if port := loc.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if remote.To4() == nil {
+		host = "[" + host + "]"
+	}
+	if loc.Scheme != "" {
+		// A URL with no host, e.g "http://:5000/desc.xml".
+		loc.Host = host
+		return loc, nil
+	}
+	base := &url.URL{Scheme: "http", Host: host, Path: "/"}
+	return base.ResolveReference(loc), nil
+}
+
+// escapeZone escapes the '%' before the zone of an IPv6 address in the host of
+// rawURL, as url.Parse requires, if it is not already.
+func escapeZone(rawURL string) string {
+	start := strings.Index(rawURL, "[")
+	if start < 0 {
+		return rawURL
+	}
+	end := strings.Index(rawURL[start:], "]")
+	if end < 0 {
+		return rawURL
+	}
+	end += start
+	i := strings.Index(rawURL[start:end], "%")
+	if i < 0 || strings.HasPrefix(rawURL[start+i:end], "%25") {
+		return rawURL
+	}
+	i += start
+	return rawURL[:i] + "%25" + rawURL[i+1:]
+}
+
+// addZone sets the IPv6 zone of the host of loc to zone, if the host is a
+// link-local address without one.
+func addZone(loc *url.URL, zone string) {
+	if zone == "" {
+		return
+	}
+	ip := net.ParseIP(loc.Hostname())
+	if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return
+	}
+	host := ip.String() + "%" + zone
+	if port := loc.Port(); port != "" {
+		loc.Host = net.JoinHostPort(host, port)
+	} else {
+		loc.Host = "[" + host + "]"
+	}
+}
+
+// SearchPort returns the port that a device accepts unicast search requests
+// on, as advertised in the SEARCHPORT.UPNP.ORG header of its search response
+// or notification. DefaultSearchPort is returned if the header is missing or
+// invalid.
+func SearchPort(header http.Header) int {
+	port, err := parseUpnpIntHeader(header, "SEARCHPORT.UPNP.ORG", ssdpSearchPort)
+	if err != nil || port < 1 || port > 65535 {
+		return ssdpSearchPort
+	}
+	return int(port)
+}
+
+// MaxAge returns the duration for which a search response or notification
+// remains valid, from the max-age directive of its CACHE-CONTROL header. Zero
+// is returned if the header is missing or invalid.
+func MaxAge(header http.Header) time.Duration {
+	maxAge, err := parseCacheControlMaxAge(header.Get("CACHE-CONTROL"))
+	if err != nil || maxAge < 0 {
+		return 0
+	}
+	return maxAge
+}
+
+// Date returns the time at which a search response was generated, from its
+// DATE header. The zero time is returned if the header is missing or invalid.
+func Date(header http.Header) time.Time {
+	date, err := http.ParseTime(header.Get("DATE"))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// HasExt reports whether a search response includes the EXT header, which
+// confirms that the device understood the MAN header of the search. The header
+// is required, but has no value, and is omitted by some devices.
+func HasExt(header http.Header) bool {
+	_, ok := header[http.CanonicalHeaderKey("EXT")]
+	return ok
+}
+
+// optNamespaceRx matches the namespace prefix declared by an OPT header, e.g
+// `"http://schemas.upnp.org/upnp/1/0/"; ns=01`.
+var optNamespaceRx = regexp.MustCompile(`;\s*ns\s*=\s*([0-9A-Za-z]+)`)
+
+// NLS returns the network location signature of a search response or
+// notification, which identifies the current boot of the device. The NLS header
+// is prefixed by the namespace declared in the OPT header, which is almost
+// always "01-NLS". An empty string is returned if either header is missing, as
+// for UPnP 1.0 devices.
+func NLS(header http.Header) string {
+	opt := header.Get("OPT")
+	if opt == "" {
+		return ""
+	}
+	ns := "01"
+	if m := optNamespaceRx.FindStringSubmatch(opt); m != nil {
+		ns = m[1]
+	}
+	return header.Get(ns + "-NLS")
+}
+
+// searchWait determines the max wait time to include in SSDP requests from
+// the deadline on ctx. If ctx has no deadline, then a default deadline of 3
+// seconds is applied to the returned context.
+func searchWait(ctx context.Context) (context.Context, int, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return ctx, int(deadline.Sub(time.Now()) / time.Second), func() {}
+	}
+	// Pick a default timeout of 3 seconds if none was provided.
+	maxWaitSeconds := 3
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(maxWaitSeconds)*time.Second)
+	return ctx, maxWaitSeconds, cancel
+}
+
 // prepareRequest checks the provided parameters and constructs a SSDP search
-// request to be sent.
-func prepareRequest(ctx context.Context, searchTarget string, maxWaitSeconds int) (*http.Request, error) {
+// request to be sent to the multicast group set by o.
+func prepareRequest(ctx context.Context, o *searchOptions, searchTarget string, maxWaitSeconds int) (*http.Request, error) {
 	if maxWaitSeconds < 1 {
 		return nil, errors.New("ssdp: request timeout must be at least 1s")
 	}
+	groupAddr := o.groupAddr
+	if err := checkMulticastGroup(groupAddr); err != nil {
+		return nil, err
+	}
 
 	req := (&http.Request{
 		Method: methodSearch,
 		// TODO: Support both IPv4 and IPv6.
-		Host: ssdpUDP4Addr,
+		Host: groupAddr,
 		URL:  &url.URL{Opaque: "*"},
 		Header: http.Header{
 			// Putting headers in here avoids them being title-cased.
 			// (The UPnP discovery protocol uses case-sensitive headers)
-			"HOST": []string{ssdpUDP4Addr},
+			"HOST": []string{groupAddr},
 			"MX":   []string{strconv.FormatInt(int64(maxWaitSeconds), 10)},
-			"MAN":  []string{ssdpDiscover},
+			"MAN":  []string{o.man()},
 			"ST":   []string{searchTarget},
 		},
 	}).WithContext(ctx)
@@ -152,7 +481,7 @@ func processSSDPResponses(
 			continue
 		}
 		usn := response.Header.Get("USN")
-		loc, err := response.Location()
+		loc, err := Location(response.Header)
 		if err != nil {
 			// No usable location in search response - discard.
 			continue
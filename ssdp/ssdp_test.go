@@ -0,0 +1,236 @@
+package ssdp
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsedano/goupnp/httpu"
+)
+
+func TestLocation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		location string
+		remote   string
+		zone     string
+		want     string
+	}{
+		{"http://192.0.2.1:5000/rootDesc.xml", "192.0.2.9", "", "http://192.0.2.1:5000/rootDesc.xml"},
+		{" http://192.0.2.1:5000/rootDesc.xml ", "", "", "http://192.0.2.1:5000/rootDesc.xml"},
+		{"/rootDesc.xml", "192.0.2.1", "", "http://192.0.2.1/rootDesc.xml"},
+		{"rootDesc.xml", "192.0.2.1", "", "http://192.0.2.1/rootDesc.xml"},
+		{"//:5000/rootDesc.xml", "192.0.2.1", "", "http://192.0.2.1:5000/rootDesc.xml"},
+		{"//192.0.2.1:5000/rootDesc.xml", "192.0.2.9", "", "http://192.0.2.1:5000/rootDesc.xml"},
+		{"http://:5000/rootDesc.xml", "192.0.2.1", "", "http://192.0.2.1:5000/rootDesc.xml"},
+		{"/rootDesc.xml", "fe80::1", "", "http://[fe80::1]/rootDesc.xml"},
+		{"http://:5000/rootDesc.xml", "fe80::1", "", "http://[fe80::1]:5000/rootDesc.xml"},
+		{"http://[fe80::1%eth0]:5000/rootDesc.xml", "fe80::1", "", "http://[fe80::1%25eth0]:5000/rootDesc.xml"},
+		{"http://[fe80::1%25eth0]:5000/rootDesc.xml", "fe80::1", "eth1", "http://[fe80::1%25eth0]:5000/rootDesc.xml"},
+		{"http://[fe80::1]:5000/rootDesc.xml", "fe80::1", "eth0", "http://[fe80::1%25eth0]:5000/rootDesc.xml"},
+		{"http://[fe80::1]/rootDesc.xml", "fe80::1", "eth0", "http://[fe80::1%25eth0]/rootDesc.xml"},
+		{"http://[2001:db8::1]:5000/rootDesc.xml", "fe80::1", "eth0", "http://[2001:db8::1]:5000/rootDesc.xml"},
+		{"/rootDesc.xml", "fe80::1", "eth0", "http://[fe80::1%25eth0]/rootDesc.xml"},
+		{"http://:5000/rootDesc.xml", "fe80::1", "eth0", "http://[fe80::1%25eth0]:5000/rootDesc.xml"},
+	}
+	for _, test := range tests {
+		header := http.Header{"Location": []string{test.location}}
+		if test.remote != "" {
+			header.Set(httpu.RemoteAddressHeader, test.remote)
+		}
+		if test.zone != "" {
+			header.Set(httpu.RemoteZoneHeader, test.zone)
+		}
+		loc, err := Location(header)
+		if err != nil {
+			t.Errorf("%q from %q: %v", test.location, test.remote, err)
+			continue
+		}
+		if got := loc.String(); got != test.want {
+			t.Errorf("%q from %q: want %q, got %q", test.location, test.remote, test.want, got)
+		}
+	}
+
+	for _, header := range []http.Header{
+		{},
+		{"Location": []string{"/rootDesc.xml"}},
+		{"Location": []string{"http://192.0.2.1:5000/%zz"}},
+	} {
+		if loc, err := Location(header); err == nil {
+			t.Errorf("%v: want error, got %v", header, loc)
+		}
+	}
+}
+
+// captureClient records the requests that it is asked to send, and receives no
+// responses.
+type captureClient struct {
+	reqs []*http.Request
+}
+
+func (c *captureClient) DoWithContext(req *http.Request, numSends int) ([]*http.Response, error) {
+	c.reqs = append(c.reqs, req)
+	return nil, nil
+}
+
+func TestSearchMAN(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts []SearchOption
+		want string
+	}{
+		{"default", nil, `"ssdp:discover"`},
+		{"unquoted", []SearchOption{WithUnquotedMAN()}, `ssdp:discover`},
+	}
+	for _, test := range tests {
+		req, err := prepareRequest(context.Background(), newSearchOptions(test.opts), SSDPAll, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header["MAN"]; len(got) != 1 || got[0] != test.want {
+			t.Errorf("%s: want multicast MAN %s, got %q", test.name, test.want, got)
+		}
+
+		var client captureClient
+		if _, err := RawUnicastSearch(context.Background(), &client, "192.0.2.1:1900", SSDPAll, 1, test.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if got := client.reqs[0].Header["MAN"]; len(got) != 1 || got[0] != test.want {
+			t.Errorf("%s: want unicast MAN %s, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+// multiClient records the requests that it is asked to send, and receives
+// responses.
+type multiClient struct {
+	reqs      []*http.Request
+	numSends  int
+	responses []*http.Response
+}
+
+func (c *multiClient) DoMultiWithContext(reqs []*http.Request, numSends int) ([]*http.Response, error) {
+	c.reqs = append(c.reqs, reqs...)
+	c.numSends = numSends
+	return c.responses, nil
+}
+
+func TestRawSearchMulti(t *testing.T) {
+	t.Parallel()
+	response := func(st, usn, loc string) *http.Response {
+		return &http.Response{StatusCode: 200, Header: http.Header{
+			"St":       {st},
+			"Usn":      {usn},
+			"Location": {loc},
+		}}
+	}
+	const (
+		stA = "urn:test:device:A:1"
+		stB = "urn:test:device:B:1"
+	)
+	client := &multiClient{responses: []*http.Response{
+		response(stA, "uuid:1::"+stA, "http://192.0.2.1/desc.xml"),
+		response(stB, "uuid:1::"+stB, "http://192.0.2.1/desc.xml"),
+		// A duplicate from a repeated send.
+		response(stA, "uuid:1::"+stA, "http://192.0.2.1/desc.xml"),
+		response(stA, "uuid:2::"+stA, "http://192.0.2.2/desc.xml"),
+		response("urn:test:device:Other:1", "uuid:3::urn:test:device:Other:1", "http://192.0.2.3/desc.xml"),
+		// No usable location.
+		response(stB, "uuid:4::"+stB, ""),
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	byTarget, err := RawSearchMulti(ctx, client, []string{stA, stB, SSDPAll}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sts []string
+	for _, req := range client.reqs {
+		sts = append(sts, req.Header["ST"]...)
+	}
+	if want := []string{stA, stB, SSDPAll}; !reflect.DeepEqual(want, sts) {
+		t.Errorf("want one request per target %v, got %v", want, sts)
+	}
+	if client.numSends != 3 {
+		t.Errorf("want numSends 3, got %d", client.numSends)
+	}
+
+	wantUSNs := map[string][]string{
+		stA:     {"uuid:1::" + stA, "uuid:2::" + stA},
+		stB:     {"uuid:1::" + stB},
+		SSDPAll: {"uuid:1::" + stA, "uuid:1::" + stB, "uuid:2::" + stA, "uuid:3::urn:test:device:Other:1"},
+	}
+	if len(byTarget) != len(wantUSNs) {
+		t.Errorf("want %d targets, got %d", len(wantUSNs), len(byTarget))
+	}
+	for st, want := range wantUSNs {
+		var got []string
+		for _, r := range byTarget[st] {
+			got = append(got, r.Header.Get("USN"))
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want USNs %v, got %v", st, want, got)
+		}
+	}
+
+	// Less than a second to wait is rejected before anything is sent.
+	shortCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	client.reqs = nil
+	if _, err := RawSearchMulti(shortCtx, client, []string{stA, stB}, 1); err == nil {
+		t.Error("want error for sub-second timeout, got nil")
+	}
+	if len(client.reqs) != 0 {
+		t.Errorf("want no requests sent, got %d", len(client.reqs))
+	}
+}
+
+func TestSearchPort(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", DefaultSearchPort},
+		{"1901", 1901},
+		{"65535", 65535},
+		{"0", DefaultSearchPort},
+		{"65536", DefaultSearchPort},
+		{"-1", DefaultSearchPort},
+		{"port", DefaultSearchPort},
+		{"99999999999", DefaultSearchPort},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.value != "" {
+			header.Set("SEARCHPORT.UPNP.ORG", test.value)
+		}
+		if got := SearchPort(header); got != test.want {
+			t.Errorf("SEARCHPORT.UPNP.ORG %q: want %d, got %d", test.value, test.want, got)
+		}
+	}
+}
+
+func TestRawUnicastSearchRequest(t *testing.T) {
+	t.Parallel()
+	var client captureClient
+	const addr = "192.0.2.1:1901"
+	if _, err := RawUnicastSearch(context.Background(), &client, addr, SSDPAll, 1,
+		WithMulticastGroup("239.255.77.1:19001")); err != nil {
+		t.Fatal(err)
+	}
+	req := client.reqs[0]
+	if req.Host != addr {
+		t.Errorf("want request sent to %s, got %s", addr, req.Host)
+	}
+	if got := req.Header["HOST"]; len(got) != 1 || got[0] != addr {
+		t.Errorf("want HOST %s, got %q", addr, got)
+	}
+	if got, ok := req.Header["MX"]; ok {
+		t.Errorf("want no MX for unicast search, got %q", got)
+	}
+}
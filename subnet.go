@@ -0,0 +1,77 @@
+package goupnp
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/fsedano/goupnp/httpu"
+)
+
+// WithSameSubnet makes discovery ignore devices that respond from outside the
+// subnet of the local address that they were found from, without probing
+// them. This is for networks that route multicast between subnets, where
+// devices on other subnets may respond to searches, but cannot be reached to
+// request their descriptions. The subnet is that of the local address with
+// mask, or if mask is nil, that of the local address's interface.
+//
+// Devices are kept if their subnet cannot be determined, e.g because the
+// local address is unknown.
+func WithSameSubnet(mask net.IPMask) DiscoveryOption {
+	return func(o *discoveryOptions) {
+		o.sameSubnet = true
+		o.subnetMask = mask
+	}
+}
+
+// filterSubnet returns the responses to probe, removing those sent from
+// outside the local subnet if WithSameSubnet was given.
+func (o *discoveryOptions) filterSubnet(responses []*http.Response) []*http.Response {
+	if !o.sameSubnet {
+		return responses
+	}
+	var filtered []*http.Response
+	for _, response := range responses {
+		local := net.ParseIP(response.Header.Get(httpu.LocalAddressHeader))
+		remote := net.ParseIP(response.Header.Get(httpu.RemoteAddressHeader))
+		if inSubnet(local, remote, o.subnetMask) {
+			filtered = append(filtered, response)
+		}
+	}
+	return filtered
+}
+
+// inSubnet reports whether remote is in the subnet of local with mask, or of
+// local's interface if mask is nil. It also reports true if this cannot be
+// determined.
+func inSubnet(local, remote net.IP, mask net.IPMask) bool {
+	if local == nil || remote == nil || local.IsUnspecified() {
+		return true
+	}
+	if mask == nil {
+		ipNet := interfaceNetOf(local)
+		if ipNet == nil {
+			return true
+		}
+		mask = ipNet.Mask
+	}
+	localNet := local.Mask(mask)
+	if localNet == nil {
+		return true
+	}
+	return localNet.Equal(remote.Mask(mask))
+}
+
+// interfaceNetOf returns the network of the interface address ip, or nil if no
+// interface has the address.
+func interfaceNetOf(ip net.IP) *net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return ipNet
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package goupnp
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/fsedano/goupnp/httpu"
+)
+
+func TestInSubnet(t *testing.T) {
+	t.Parallel()
+	mask24 := net.CIDRMask(24, 32)
+	tests := []struct {
+		name          string
+		local, remote string
+		mask          net.IPMask
+		want          bool
+	}{
+		{"same subnet", "192.168.1.10", "192.168.1.1", mask24, true},
+		{"other subnet", "192.168.1.10", "192.168.2.1", mask24, false},
+		{"wider mask", "192.168.1.10", "192.168.2.1", net.CIDRMask(16, 32), true},
+		{"unknown local", "", "192.168.2.1", mask24, true},
+		{"unspecified local", "0.0.0.0", "192.168.2.1", mask24, true},
+		{"mask of other family", "2001:db8::1", "2001:db9::1", mask24, true},
+		// The loopback interface has 127.0.0.1/8.
+		{"interface mask", "127.0.0.1", "127.1.2.3", nil, true},
+		{"outside interface mask", "127.0.0.1", "192.0.2.1", nil, false},
+		{"no interface", "192.0.2.200", "198.51.100.1", nil, true},
+	}
+	for _, test := range tests {
+		if got := inSubnet(net.ParseIP(test.local), net.ParseIP(test.remote), test.mask); got != test.want {
+			t.Errorf("%s: want %t, got %t", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFilterSubnet(t *testing.T) {
+	t.Parallel()
+	newResponse := func(local, remote string) *http.Response {
+		header := http.Header{}
+		header.Set(httpu.LocalAddressHeader, local)
+		header.Set(httpu.RemoteAddressHeader, remote)
+		return &http.Response{Header: header}
+	}
+	near := newResponse("192.168.1.10", "192.168.1.1")
+	far := newResponse("192.168.1.10", "10.0.0.1")
+	responses := []*http.Response{near, far}
+
+	if got := newDiscoveryOptions(nil).filterSubnet(responses); len(got) != 2 {
+		t.Errorf("want all responses by default, got %d", len(got))
+	}
+	o := newDiscoveryOptions([]DiscoveryOption{WithSameSubnet(net.CIDRMask(24, 32))})
+	if got := o.filterSubnet(responses); len(got) != 1 || got[0] != near {
+		t.Errorf("want only the response from the same subnet, got %d", len(got))
+	}
+}
@@ -60,7 +60,34 @@ func NewSendAction(serviceType, actionName string, args any) *Action {
 	}
 }
 
-var stringType = reflect.TypeOf("")
+// ExtraArgsReceiver may be implemented by a struct used as Action.Args when
+// reading an action, to receive any argument elements that have no
+// corresponding field in the struct. Some devices return arguments beyond those
+// in the specification.
+type ExtraArgsReceiver interface {
+	SetExtraArg(name, value string)
+}
+
+// ExtraArgs can be embedded in a struct used as Action.Args to collect any
+// unknown arguments when reading an action. It is ignored when writing.
+type ExtraArgs struct {
+	Extra map[string]string `xml:"-"`
+}
+
+var _ ExtraArgsReceiver = &ExtraArgs{}
+
+// SetExtraArg implements ExtraArgsReceiver.
+func (ea *ExtraArgs) SetExtraArg(name, value string) {
+	if ea.Extra == nil {
+		ea.Extra = make(map[string]string)
+	}
+	ea.Extra[name] = value
+}
+
+var (
+	stringType    = reflect.TypeOf("")
+	extraArgsType = reflect.TypeOf(ExtraArgs{})
+)
 
 var _ xml.Marshaler = &Action{}
 
@@ -124,6 +151,9 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	argsType := argsValue.Type()
 	switch argsType.Kind() {
 	case reflect.Struct:
+		if extra, ok := a.Args.(ExtraArgsReceiver); ok {
+			return unmarshalStructWithExtra(d, argsValue, extra)
+		}
 		return d.DecodeElement(a.Args, &start)
 	case reflect.Map:
 		keyType := argsType.Key()
@@ -152,11 +182,7 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 						"SOAP action arg does not support attributes, got %v",
 						token.Attr)
 				}
-				if token.Name.Space != "" {
-					return fmt.Errorf(
-						"SOAP action arg does not support non-empty namespace, got %q",
-						token.Name.Space)
-				}
+				// Args are matched by local name, regardless of namespace.
 				key := reflect.ValueOf(token.Name.Local).Convert(keyType)
 				value := reflect.New(valueType)
 				if err := d.DecodeElement(value.Interface(), &token); err != nil {
@@ -191,6 +217,62 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	}
 }
 
+// unmarshalStructWithExtra decodes the argument elements inside the current
+// element into the fields of argsValue, passing any elements that have no
+// matching field to extra.
+func unmarshalStructWithExtra(d *xml.Decoder, argsValue reflect.Value, extra ExtraArgsReceiver) error {
+	fields := argFields(argsValue.Type())
+	for {
+		untypedToken, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := untypedToken.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			if index, ok := fields[token.Name.Local]; ok {
+				field := argsValue.FieldByIndex(index).Addr().Interface()
+				if err := d.DecodeElement(field, &token); err != nil {
+					return fmt.Errorf(
+						"SOAP action arg %q errored while decoding: %w", token.Name.Local, err)
+				}
+				continue
+			}
+			var value string
+			if err := d.DecodeElement(&value, &token); err != nil {
+				return fmt.Errorf(
+					"SOAP action extra arg %q errored while decoding: %w", token.Name.Local, err)
+			}
+			extra.SetExtraArg(token.Name.Local, value)
+		}
+	}
+}
+
+// argFields maps the XML element names of the fields of an args struct type to
+// their field index.
+func argFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type == extraArgsType || f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("xml"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = f.Index
+	}
+	return fields
+}
+
 // Various "constant" bytes used in the written envelope.
 var (
 	envOpen  = []byte(xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
@@ -224,6 +306,10 @@ func Write(w io.Writer, action *Action) error {
 
 // Read unmarshals a SOAP envelope from the reader. Errors can either be from
 // the reader, XML decoding, or a *Fault.
+//
+// Read tolerates envelopes where the SOAP elements, the action element or its
+// arguments are missing namespaces or use a default namespace, as produced by
+// some devices. Elements are then matched by local name.
 func Read(r io.Reader, action *Action) error {
 	env := envelope{
 		Body: body{
@@ -236,6 +322,12 @@ func Read(r io.Reader, action *Action) error {
 	if err != nil {
 		return err
 	}
+	for _, name := range []xml.Name{env.XMLName, env.Body.XMLName} {
+		if name.Space != "" && name.Space != soapNamespace {
+			return fmt.Errorf("SOAP envelope element %s has unexpected namespace %q",
+				name.Local, name.Space)
+		}
+	}
 
 	if env.Body.Fault != nil {
 		return env.Body.Fault
@@ -244,13 +336,19 @@ func Read(r io.Reader, action *Action) error {
 	return nil
 }
 
+const soapNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// envelope is used to read envelopes. Elements are matched by local name
+// only, as some devices omit the SOAP namespace. Read checks that any
+// namespace present is the SOAP namespace.
 type envelope struct {
-	XMLName       xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	XMLName       xml.Name `xml:"Envelope"`
 	EncodingStyle string   `xml:"http://schemas.xmlsoap.org/soap/envelope/ encodingStyle,attr"`
-	Body          body     `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	Body          body     `xml:"Body"`
 }
 
 type body struct {
-	Fault  *Fault  `xml:"Fault"`
-	Action *Action `xml:",any"`
+	XMLName xml.Name
+	Fault   *Fault  `xml:"Fault"`
+	Action  *Action `xml:",any"`
 }
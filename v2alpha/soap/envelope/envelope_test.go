@@ -43,6 +43,22 @@ func TestWriteRead(t *testing.T) {
 			},
 			map[string]string{},
 		},
+		{
+			"structSpecialChars",
+			&testStructArgs{
+				Foo: `Tom & Jerry's <server>`,
+				Bar: `say "hi" & bye`,
+			},
+			&testStructArgs{},
+		},
+		{
+			"mapSpecialChars",
+			map[string]string{
+				"Foo": `Tom & Jerry's <server>`,
+				"Bar": `say "hi" & bye`,
+			},
+			map[string]string{},
+		},
 		{
 			"mapUI2",
 			map[string]types.UI2{
@@ -186,3 +202,106 @@ func TestFault(t *testing.T) {
 		})
 	}
 }
+
+type testExtraArgs struct {
+	ExtraArgs
+	Foo string
+	Bar string `xml:"bar"`
+}
+
+// TestReadExtraArgs tests reading an envelope with arguments beyond those in
+// the args struct.
+func TestReadExtraArgs(t *testing.T) {
+	env := []byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>
+<u:FakeAction xmlns:u="urn:schemas-upnp-org:service:FakeService:1">
+<Foo>foo-1</Foo>
+<bar>bar-2</bar>
+<X_VendorExtra>extra-3</X_VendorExtra>
+</u:FakeAction>
+</s:Body> </s:Envelope>`)
+
+	t.Run("collected", func(t *testing.T) {
+		argsOut := &testExtraArgs{}
+		if err := Read(bytes.NewBuffer(env), NewRecvAction(argsOut)); err != nil {
+			t.Fatalf("Read want success, got err=%v", err)
+		}
+		wantArgsOut := &testExtraArgs{
+			ExtraArgs: ExtraArgs{Extra: map[string]string{"X_VendorExtra": "extra-3"}},
+			Foo:       "foo-1",
+			Bar:       "bar-2",
+		}
+		if diff := cmp.Diff(wantArgsOut, argsOut); diff != "" {
+			t.Errorf("want argsOut=%+v, got %+v\ndiff:\n%s", wantArgsOut, argsOut, diff)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		argsOut := &testStructArgs{}
+		if err := Read(bytes.NewBuffer(env), NewRecvAction(argsOut)); err != nil {
+			t.Fatalf("Read want success, got err=%v", err)
+		}
+		wantArgsOut := &testStructArgs{Foo: "foo-1"}
+		if diff := cmp.Diff(wantArgsOut, argsOut); diff != "" {
+			t.Errorf("want argsOut=%+v, got %+v\ndiff:\n%s", wantArgsOut, argsOut, diff)
+		}
+	})
+}
+
+// TestReadNoNamespace tests reading envelopes from devices that omit or
+// default the namespaces of elements.
+func TestReadNoNamespace(t *testing.T) {
+	envs := []struct {
+		name string
+		env  string
+	}{
+		{"unprefixed", `<Envelope><Body>
+<FakeActionResponse>
+<Foo>foo-1</Foo>
+<Bar>bar-2</Bar>
+</FakeActionResponse>
+</Body></Envelope>`},
+		{"default namespaces", `<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/"><Body>
+<FakeActionResponse xmlns="urn:schemas-upnp-org:service:FakeService:1">
+<Foo>foo-1</Foo>
+<Bar>bar-2</Bar>
+</FakeActionResponse>
+</Body></Envelope>`},
+		{"prefixed args", `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:FakeActionResponse xmlns:u="urn:schemas-upnp-org:service:FakeService:1">
+<u:Foo>foo-1</u:Foo>
+<u:Bar>bar-2</u:Bar>
+</u:FakeActionResponse>
+</s:Body></s:Envelope>`},
+	}
+
+	for _, env := range envs {
+		env := env // copy for closure
+		t.Run(env.name+"/struct", func(t *testing.T) {
+			argsOut := &testStructArgs{}
+			if err := Read(bytes.NewBufferString(env.env), NewRecvAction(argsOut)); err != nil {
+				t.Fatalf("Read want success, got err=%v", err)
+			}
+			wantArgsOut := &testStructArgs{Foo: "foo-1", Bar: "bar-2"}
+			if diff := cmp.Diff(wantArgsOut, argsOut); diff != "" {
+				t.Errorf("want argsOut=%+v, got %+v\ndiff:\n%s", wantArgsOut, argsOut, diff)
+			}
+		})
+		t.Run(env.name+"/map", func(t *testing.T) {
+			argsOut := map[string]string{}
+			if err := Read(bytes.NewBufferString(env.env), NewRecvAction(argsOut)); err != nil {
+				t.Fatalf("Read want success, got err=%v", err)
+			}
+			wantArgsOut := map[string]string{"Foo": "foo-1", "Bar": "bar-2"}
+			if diff := cmp.Diff(wantArgsOut, argsOut); diff != "" {
+				t.Errorf("want argsOut=%+v, got %+v\ndiff:\n%s", wantArgsOut, argsOut, diff)
+			}
+		})
+	}
+
+	t.Run("wrong namespace", func(t *testing.T) {
+		env := `<Envelope xmlns="urn:not-soap"><Body><FakeActionResponse/></Body></Envelope>`
+		if err := Read(bytes.NewBufferString(env), NewRecvAction(&testStructArgs{})); err == nil {
+			t.Error("Read want error, got success")
+		}
+	})
+}